@@ -0,0 +1,57 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+// auditCommand lists the locked packages affected by the advisories of the
+// feed, failing if there are any
+func auditCommand(ctx context.Context, dir, advisories string) int {
+	locks, err := jsonnetfile.Load(lockPath(dir))
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	feed, err := pkg.LoadAdvisories(ctx, advisories)
+	kingpin.FatalIfError(err, "failed to load advisories")
+
+	findings, err := pkg.Audit(locks.Dependencies, feed)
+	kingpin.FatalIfError(err, "failed to audit dependencies")
+	if len(findings) == 0 {
+		fmt.Println("no known vulnerable versions locked")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tVULNERABLE\tSEVERITY\tID")
+	for _, f := range findings {
+		severity := f.Severity
+		if severity == "" {
+			severity = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Package, f.Version, f.Vulnerable, severity, f.ID)
+	}
+	kingpin.FatalIfError(w.Flush(), "")
+
+	return 1
+}
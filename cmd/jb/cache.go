@@ -0,0 +1,93 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+func cacheInfoCommand(dir, jsonnetHome string) int {
+	stats, err := pkg.CacheStats(filepath.Join(dir, jsonnetHome, ".cache"))
+	if os.IsNotExist(err) {
+		fmt.Println("cache is empty")
+		return 0
+	}
+	kingpin.FatalIfError(err, "failed to inspect cache")
+
+	// biggest entries first, as these are the interesting ones for pruning
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Size > stats[j].Size
+	})
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tSIZE\tLAST ACCESS")
+	for _, s := range stats {
+		total += s.Size
+		name := s.Name
+		if name == pkg.UnknownCacheEntry {
+			name = fmt.Sprintf("%s (%s)", name, s.Dir)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, s.Version, humanSize(s.Size), s.LastAccess.Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%s\t\n", humanSize(total))
+	kingpin.FatalIfError(w.Flush(), "")
+
+	return 0
+}
+
+func cachePruneCommand(dir, jsonnetHome string, maxBytes int64) int {
+	// without a lockfile, nothing is locked
+	locks, err := jsonnetfile.Load(lockPath(dir))
+	if !os.IsNotExist(err) {
+		kingpin.FatalIfError(err, "failed to load lockfile")
+	}
+
+	removed, err := pkg.PruneCacheToSize(filepath.Join(dir, jsonnetHome, ".cache"), maxBytes, locks.Dependencies)
+	if os.IsNotExist(err) {
+		fmt.Println("cache is empty")
+		return 0
+	}
+	kingpin.FatalIfError(err, "failed to prune cache")
+	for _, s := range removed {
+		color.Magenta("PRUNE %s@%s (%s)", s.Name, s.Version, humanSize(s.Size))
+	}
+
+	return 0
+}
+
+// humanSize formats a size in bytes using binary units
+func humanSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+func cleanCommand(dir, jsonnetHome string, legacyOnly bool) int {
+	if !legacyOnly {
+		kingpin.Fatalf("only --legacy-only is supported for now. `jb install` cleans vendor as a whole")
+	}
+
+	locks, err := jsonnetfile.Load(lockPath(dir))
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	removed, err := pkg.PruneLegacyLinks(filepath.Join(dir, jsonnetHome), locks.Dependencies)
+	kingpin.FatalIfError(err, "failed to prune legacy symlinks")
+	for _, r := range removed {
+		color.Magenta("CLEAN %s", r)
+	}
+
+	jsf, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	if err == nil && jsf.LegacyImports && len(removed) > 0 {
+		color.Yellow("WARN: legacyImports is enabled in %s, the next `jb install` recreates the legacy symlinks", jsonnetfile.File)
+	}
+
+	return 0
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+func diffCommand(dir, jsonnetHome string, asJSON bool) int {
+	jsonnetFile, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	kingpin.FatalIfError(err, "failed to load jsonnetfile")
+
+	lockFile, err := jsonnetfile.Load(lockPath(dir))
+	if !os.IsNotExist(err) {
+		kingpin.FatalIfError(err, "failed to load lockfile")
+	}
+
+	diffs, err := pkg.DiffVendor(jsonnetFile, filepath.Join(dir, jsonnetHome), lockFile.Dependencies, apiTokenOptions()...)
+	kingpin.FatalIfError(err, "failed to compute the changes to vendor")
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		kingpin.FatalIfError(enc.Encode(diffs), "")
+		return 0
+	}
+	writeDiffMarkdown(os.Stdout, diffs)
+	return 0
+}
+
+// writeDiffMarkdown renders the diffs as markdown, e.g. for a review comment
+func writeDiffMarkdown(w io.Writer, diffs []pkg.PackageDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "No changes to vendor.")
+		return
+	}
+	for _, d := range diffs {
+		switch {
+		case d.OldVersion == "":
+			fmt.Fprintf(w, "### %s (added at `%s`)\n\n", d.Name, d.NewVersion)
+		case d.NewVersion == "":
+			fmt.Fprintf(w, "### %s (removed)\n\n", d.Name)
+		case d.OldVersion != d.NewVersion:
+			fmt.Fprintf(w, "### %s (`%s` → `%s`)\n\n", d.Name, d.OldVersion, d.NewVersion)
+		default:
+			fmt.Fprintf(w, "### %s\n\n", d.Name)
+		}
+		for _, f := range d.Added {
+			fmt.Fprintf(w, "- added `%s`\n", f)
+		}
+		for _, f := range d.Modified {
+			fmt.Fprintf(w, "- modified `%s`\n", f)
+		}
+		for _, f := range d.Removed {
+			fmt.Fprintf(w, "- removed `%s`\n", f)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
 	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
 )
@@ -37,6 +38,7 @@ func initCommand(dir string) int {
 	s := v1.New()
 	// TODO: disable them by default eventually
 	// s.LegacyImports = false
+	s.Resolver = pkg.LatestResolver
 
 	contents, err := json.MarshalIndent(s, "", "  ")
 	kingpin.FatalIfError(err, "formatting jsonnetfile contents as json")
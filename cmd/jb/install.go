@@ -15,7 +15,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -31,7 +33,24 @@ import (
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
 )
 
-func installCommand(dir, jsonnetHome string, uris []string, single bool, legacyName string) int {
+// defaultAllowedLicenses are allowed by --license-check unless told otherwise
+var defaultAllowedLicenses = []string{"Apache-2.0", "MIT", "BSD-2-Clause", "BSD-3-Clause", "ISC", "MPL-2.0", "Unlicense"}
+
+// licenseCheckOption returns the option for --license-check
+func licenseCheckOption(allowed []string, unlicensed string) pkg.Option {
+	if len(allowed) == 0 {
+		allowed = defaultAllowedLicenses
+	}
+	policy := pkg.UnlicensedWarn
+	if unlicensed == "fail" {
+		policy = pkg.UnlicensedFail
+	}
+	return pkg.WithLicenseCheck(allowed, policy)
+}
+
+// installCommand installs the jsonnetfile, with the packages of uris added.
+// A dry run leaves the disk untouched and fails if anything would change.
+func installCommand(ctx context.Context, dir, jsonnetHome string, uris []string, single bool, legacyName string, dryRun bool, opts ...pkg.Option) int {
 	if dir == "" {
 		dir = "."
 	}
@@ -42,7 +61,7 @@ func installCommand(dir, jsonnetHome string, uris []string, single bool, legacyN
 	jsonnetFile, err := jsonnetfile.Unmarshal(jbfilebytes)
 	kingpin.FatalIfError(err, "")
 
-	jblockfilebytes, err := ioutil.ReadFile(filepath.Join(dir, jsonnetfile.LockFile))
+	jblockfilebytes, err := ioutil.ReadFile(lockPath(dir))
 	if !os.IsNotExist(err) {
 		kingpin.FatalIfError(err, "failed to load lockfile")
 	}
@@ -50,9 +69,11 @@ func installCommand(dir, jsonnetHome string, uris []string, single bool, legacyN
 	lockFile, err := jsonnetfile.Unmarshal(jblockfilebytes)
 	kingpin.FatalIfError(err, "")
 
-	kingpin.FatalIfError(
-		os.MkdirAll(filepath.Join(dir, jsonnetHome, ".cache"), os.ModePerm),
-		"creating vendor folder")
+	if !dryRun {
+		kingpin.FatalIfError(
+			os.MkdirAll(filepath.Join(dir, jsonnetHome, ".cache"), os.ModePerm),
+			"creating vendor folder")
+	}
 
 	if len(uris) > 1 && legacyName != "" {
 		log.Fatal("Cannot use --legacy-name with mutliple uris")
@@ -82,10 +103,42 @@ func installCommand(dir, jsonnetHome string, uris []string, single bool, legacyN
 		}
 	}
 
+	if dryRun {
+		opts = append(opts, pkg.WithDryRun(true))
+	} else {
+		kingpin.FatalIfError(
+			os.MkdirAll(filepath.Dir(lockPath(dir)), os.ModePerm),
+			"creating lockfile folder")
+	}
+
 	jsonnetPkgHomeDir := filepath.Join(dir, jsonnetHome)
-	locked, err := pkg.Ensure(jsonnetFile, jsonnetPkgHomeDir, lockFile.Dependencies)
+	result, err := pkg.EnsureWithResult(ctx, jsonnetFile, jsonnetPkgHomeDir, lockFile.Dependencies, append(apiTokenOptions(), opts...)...)
+	var partial *pkg.PartialInstallError
+	if errors.As(err, &partial) && !dryRun {
+		// lock the packages installed so far, so the next install resumes
+		for _, k := range partial.Installed.Keys() {
+			d, _ := partial.Installed.Get(k)
+			lockFile.Dependencies.Set(k, d)
+		}
+		kingpin.FatalIfError(
+			writeChangedJsonnetFile(jblockfilebytes, &v1.JsonnetFile{Dependencies: lockFile.Dependencies}, lockPath(dir)),
+			"updating jsonnetfile.lock.json")
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		return 130
+	}
 	kingpin.FatalIfError(err, "failed to install packages")
 
+	if dryRun {
+		writeDiffMarkdown(os.Stdout, result.Diffs)
+		printSummary(result)
+		if result.Changed() || len(result.Diffs) > 0 {
+			return 1
+		}
+		return 0
+	}
+
 	pkg.CleanLegacyName(jsonnetFile.Dependencies)
 
 	kingpin.FatalIfError(
@@ -93,12 +146,22 @@ func installCommand(dir, jsonnetHome string, uris []string, single bool, legacyN
 		"updating jsonnetfile.json")
 
 	kingpin.FatalIfError(
-		writeChangedJsonnetFile(jblockfilebytes, &v1.JsonnetFile{Dependencies: locked}, filepath.Join(dir, jsonnetfile.LockFile)),
+		writeChangedJsonnetFile(jblockfilebytes, &v1.JsonnetFile{Dependencies: result.Locks}, lockPath(dir)),
 		"updating jsonnetfile.lock.json")
 
+	printSummary(result)
 	return 0
 }
 
+// printSummary prints how many packages an install changed
+func printSummary(r *pkg.EnsureResult) {
+	if !r.Changed() {
+		fmt.Printf("vendor is up to date, %d packages installed\n", len(r.Reused))
+		return
+	}
+	fmt.Printf("%d added (%s), %d reused, %d removed\n", len(r.Added), humanSize(r.BytesWritten), len(r.Reused), len(r.Removed))
+}
+
 func depEqual(d1, d2 deps.Dependency) bool {
 	name := d1.Name() == d2.Name()
 	version := d1.Version == d2.Version
@@ -18,6 +18,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -105,7 +106,7 @@ func testInstallCommandWithJsonnetHome(t *testing.T, jsonnetHome string) {
 			jsonnetFileContent(t, jsonnetfile.File, []byte(initContents))
 
 			// install something, check it writes only if required, etc.
-			installCommand("", jsonnetHome, tc.URIs, tc.single, "")
+			installCommand(context.TODO(), "", jsonnetHome, tc.URIs, tc.single, "", false)
 			jsonnetFileContent(t, jsonnetfile.File, tc.ExpectedJsonnetFile)
 			if tc.ExpectedJsonnetLockFile != nil {
 				jsonnetFileContent(t, jsonnetfile.LockFile, tc.ExpectedJsonnetLockFile)
@@ -226,7 +227,7 @@ func TestInstallTransitive(t *testing.T) {
 		subDirB: jsonnetFileWithFrozenLib(frozenLibSecondCommit, ""),
 	})
 
-	require.Equal(t, 0, installCommand(baseDir, "vendor", nil, false, ""))
+	require.Equal(t, 0, installCommand(context.TODO(), baseDir, "vendor", nil, false, "", false))
 
 	lockCheckFrozenLibVersion(t, filepath.Join(baseDir, "jsonnetfile.lock.json"), frozenLibFirstCommit)
 	require.NoError(t, os.RemoveAll(filepath.Join(baseDir, "jsonnetfile.lock.json")))
@@ -237,11 +238,35 @@ func TestInstallTransitive(t *testing.T) {
 		subDirB: jsonnetFileWithFrozenLib(frozenLibFirstCommit, ""),
 	})
 
-	require.Equal(t, 0, installCommand(baseDir, "vendor", nil, false, ""))
+	require.Equal(t, 0, installCommand(context.TODO(), baseDir, "vendor", nil, false, "", false))
 
 	lockCheckFrozenLibVersion(t, filepath.Join(baseDir, "jsonnetfile.lock.json"), frozenLibSecondCommit)
 }
 
+func TestInstallLockLocation(t *testing.T) {
+	baseDir := t.TempDir()
+	lib := filepath.Join(baseDir, "lib")
+	writeDepFileTree(t, map[string]v1.JsonnetFile{
+		baseDir: {Dependencies: addDependencies(deps.NewOrdered(), localDependency(lib))},
+		lib:     v1.New(),
+	})
+
+	lockLocation = "build/"
+	defer func() { lockLocation = "" }()
+	require.Equal(t, 0, installCommand(context.TODO(), baseDir, "vendor", nil, false, "", false))
+
+	assert.NoFileExists(t, filepath.Join(baseDir, jsonnetfile.LockFile))
+	locks, err := jsonnetfile.Load(filepath.Join(baseDir, "build", jsonnetfile.LockFile))
+	require.NoError(t, err)
+	d, ok := locks.Dependencies.Get("lib")
+	require.True(t, ok)
+	assert.Equal(t, lib, d.Source.LocalSource.Directory)
+
+	// the lock is found again by the next install
+	require.Equal(t, 0, installCommand(context.TODO(), baseDir, "vendor", nil, false, "", false))
+	assert.NoFileExists(t, filepath.Join(baseDir, jsonnetfile.LockFile))
+}
+
 func lockCheckFrozenLibVersion(t *testing.T, lockPath, version string) {
 	t.Helper()
 
@@ -0,0 +1,49 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+func lockLintCommand(dir string) int {
+	locks, err := jsonnetfile.Load(lockPath(dir))
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	findings := pkg.LintLock(locks.Dependencies)
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if len(findings) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func lockFixCommand(dir string) int {
+	path := lockPath(dir)
+	locks, err := jsonnetfile.Load(path)
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	locks.Dependencies = pkg.NormalizeLock(locks.Dependencies)
+	kingpin.FatalIfError(writeJSONFile(path, locks), "failed to write lockfile")
+
+	return 0
+}
@@ -15,8 +15,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 
 	"github.com/fatih/color"
@@ -24,17 +26,97 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
 )
 
 const (
-	installActionName = "install"
-	updateActionName  = "update"
-	initActionName    = "init"
-	rewriteActionName = "rewrite"
+	installActionName  = "install"
+	updateActionName   = "update"
+	initActionName     = "init"
+	rewriteActionName  = "rewrite"
+	cacheActionName    = "cache"
+	cleanActionName    = "clean"
+	outdatedActionName = "outdated"
+	lockActionName     = "lock"
+	staleActionName    = "stale"
+	diffActionName     = "diff"
+	statusActionName   = "status"
+	auditActionName    = "audit"
 )
 
 var version = "dev"
 
+// lockLocation is where the lockfile is kept, as set by --lockfile
+var lockLocation string
+
+// outputMode is how messages of concurrent downloads are printed, as set by
+// --output
+var outputMode string
+
+// outputOption returns the option printing messages according to
+// outputMode. Unless asked for, output is only ordered if stdout is not a
+// terminal, like in CI.
+func outputOption() pkg.Option {
+	switch outputMode {
+	case "ordered":
+		return pkg.WithOutputMode(pkg.OutputOrdered)
+	case "stream":
+		return pkg.WithOutputMode(pkg.OutputStream)
+	}
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		return pkg.WithOutputMode(pkg.OutputOrdered)
+	}
+	return pkg.WithOutputMode(pkg.OutputStream)
+}
+
+// constraintsOptions returns the option pinning versions according to the
+// constraints file at path, or the ConstraintsFile in dir if there is one
+func constraintsOptions(dir, path string) []pkg.Option {
+	if path == "" {
+		path = filepath.Join(dir, pkg.ConstraintsFile)
+		if ok, _ := jsonnetfile.Exists(path); !ok {
+			return nil
+		}
+	}
+	c, err := pkg.LoadConstraints(path)
+	kingpin.FatalIfError(err, "failed to load constraints")
+	return []pkg.Option{pkg.WithConstraints(c)}
+}
+
+// registryOptions returns the option resolving registry sources with the
+// index at location, a file or URL
+func registryOptions(ctx context.Context, location string) []pkg.Option {
+	if location == "" {
+		return nil
+	}
+	index, err := pkg.LoadIndex(ctx, location)
+	kingpin.FatalIfError(err, "failed to load registry index")
+	return []pkg.Option{pkg.WithRegistry(index)}
+}
+
+// lockPath returns the path of the lockfile of the jsonnetfile in dir
+func lockPath(dir string) string {
+	return jsonnetfile.LockPath(dir, lockLocation)
+}
+
+// apiTokenEnv are the environment variables holding the API tokens of hosts
+var apiTokenEnv = map[string]string{
+	pkg.APIHostGitHub: "GITHUB_TOKEN",
+	pkg.APIHostGitLab: "GITLAB_TOKEN",
+}
+
+// apiTokenOptions returns the options listing tags using the APIs of the
+// hosts a token is set for in the environment
+func apiTokenOptions() []pkg.Option {
+	opts := []pkg.Option{}
+	for host, env := range apiTokenEnv {
+		if token := os.Getenv(env); token != "" {
+			opts = append(opts, pkg.WithAPIToken(host, token))
+		}
+	}
+	return opts
+}
+
 func main() {
 	os.Exit(Main())
 }
@@ -53,6 +135,10 @@ func Main() int {
 		Default("vendor").StringVar(&cfg.JsonnetHome)
 	a.Flag("quiet", "Suppress any output from git command.").
 		Short('q').BoolVar(&pkg.GitQuiet)
+	a.Flag("lockfile", "Where to keep the lockfile, relative to the jsonnetfile. Directories hold a "+jsonnetfile.LockFile+".").
+		StringVar(&lockLocation)
+	a.Flag("output", "How messages of concurrent downloads are printed: as they happen (stream) or grouped by package once done (ordered). auto orders them unless stdout is a terminal.").
+		Default("auto").EnumVar(&outputMode, "auto", "stream", "ordered")
 
 	initCmd := a.Command(initActionName, "Initialize a new empty jsonnetfile")
 
@@ -60,12 +146,49 @@ func Main() int {
 	installCmdURIs := installCmd.Arg("uris", "URIs to packages to install, URLs or file paths").Strings()
 	installCmdSingle := installCmd.Flag("single", "install package without dependencies").Short('1').Bool()
 	installCmdLegacyName := installCmd.Flag("legacy-name", "set legacy name").String()
+	installCmdLicenseCheck := installCmd.Flag("license-check", "fail if the license of a package is not allowed").Bool()
+	installCmdAllowLicenses := installCmd.Flag("allow-license", "SPDX identifier of a license allowed by --license-check, repeatable. Defaults to common permissive licenses").Strings()
+	installCmdUnlicensed := installCmd.Flag("unlicensed", "what --license-check does about packages without a recognized license").Default("warn").Enum("warn", "fail")
+	installCmdConstraints := installCmd.Flag("constraints", "file pinning versions of the jsonnetfile. Defaults to "+pkg.ConstraintsFile+", if present").String()
+	installCmdRegistry := installCmd.Flag("registry", "file or URL of the index resolving registry sources").String()
+	installCmdDebugResolution := installCmd.Flag("debug-resolution", "trace every step of the resolution to stderr").Bool()
+	installCmdFast := installCmd.Flag("fast", "only download missing packages, trusting present ones without verifying their checksums").Bool()
+	installCmdDryRun := installCmd.Flag("dry-run", "report how vendor would change without changing anything, failing if it would").Bool()
+	installCmdSymlinkFallback := installCmd.Flag("symlink-fallback", "copy packages into vendor if they can't be symlinked, instead of failing").Bool()
 
 	updateCmd := a.Command(updateActionName, "Update all or specific dependencies.")
 	updateCmdURIs := updateCmd.Arg("uris", "URIs to packages to update, URLs or file paths").Strings()
+	updateCmdConstraints := updateCmd.Flag("constraints", "file pinning versions of the jsonnetfile. Defaults to "+pkg.ConstraintsFile+", if present").String()
+	updateCmdRegistry := updateCmd.Flag("registry", "file or URL of the index resolving registry sources").String()
 
 	rewriteCmd := a.Command(rewriteActionName, "Automatically rewrite legacy imports to absolute ones")
 
+	cleanCmd := a.Command(cleanActionName, "Remove stale files from vendor")
+	cleanCmdLegacyOnly := cleanCmd.Flag("legacy-only", "only remove symlinks of legacy names").Bool()
+
+	outdatedCmd := a.Command(outdatedActionName, "List dependencies with newer versions available")
+	outdatedCmdAll := outdatedCmd.Flag("all", "list up to date dependencies as well").Bool()
+
+	auditCmd := a.Command(auditActionName, "List locked dependencies at versions with known vulnerabilities")
+	auditCmdAdvisories := auditCmd.Flag("advisories", "file or URL of the advisory feed").Required().String()
+
+	staleCmd := a.Command(staleActionName, "List dependencies by the age of their locked commit, oldest first")
+
+	diffCmd := a.Command(diffActionName, "Show how the files in vendor would change by installing, without changing vendor")
+	diffCmdJSON := diffCmd.Flag("json", "print the changes as JSON instead of markdown").Bool()
+
+	statusCmd := a.Command(statusActionName, "List vendored files modified after the lockfile, a quick heuristic for hand edits")
+	statusCmdVerify := statusCmd.Flag("verify", "check the packages of modified files against their sums").Bool()
+
+	lockCmd := a.Command(lockActionName, "Check or normalize the lockfile")
+	lockLintCmd := lockCmd.Command("lint", "Report entries keeping the lockfile from being normalized")
+	lockFixCmd := lockCmd.Command("fix", "Normalize the lockfile")
+
+	cacheCmd := a.Command(cacheActionName, "Inspect the package cache")
+	cacheInfoCmd := cacheCmd.Command("info", "Show the size of all cache entries")
+	cachePruneCmd := cacheCmd.Command("prune", "Remove the least recently used cache entries, keeping the locked ones, until the cache fits the size budget")
+	cachePruneCmdMaxBytes := cachePruneCmd.Flag("max-bytes", "size budget of the cache in bytes").Required().Int64()
+
 	command, err := a.Parse(os.Args[1:])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Error parsing commandline arguments"))
@@ -80,17 +203,57 @@ func Main() int {
 
 	cfg.JsonnetHome = filepath.Clean(cfg.JsonnetHome)
 
+	// interrupting stops the downloads, vendor is recovered by Ensure
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	switch command {
 	case initCmd.FullCommand():
 		return initCommand(workdir)
 	case installCmd.FullCommand():
-		return installCommand(workdir, cfg.JsonnetHome, *installCmdURIs, *installCmdSingle, *installCmdLegacyName)
+		opts := append(constraintsOptions(workdir, *installCmdConstraints), registryOptions(ctx, *installCmdRegistry)...)
+		opts = append(opts, outputOption())
+		if *installCmdDebugResolution {
+			opts = append(opts, pkg.WithResolutionTrace(os.Stderr))
+		}
+		if *installCmdFast {
+			opts = append(opts, pkg.WithFast(true))
+		}
+		if *installCmdSymlinkFallback {
+			opts = append(opts, pkg.WithSymlinkFallback(true))
+		}
+		if *installCmdLicenseCheck {
+			opts = append(opts, licenseCheckOption(*installCmdAllowLicenses, *installCmdUnlicensed))
+		}
+		return installCommand(ctx, workdir, cfg.JsonnetHome, *installCmdURIs, *installCmdSingle, *installCmdLegacyName, *installCmdDryRun, opts...)
 	case updateCmd.FullCommand():
-		return updateCommand(workdir, cfg.JsonnetHome, *updateCmdURIs)
+		opts := append(constraintsOptions(workdir, *updateCmdConstraints), registryOptions(ctx, *updateCmdRegistry)...)
+		opts = append(opts, outputOption())
+		return updateCommand(ctx, workdir, cfg.JsonnetHome, *updateCmdURIs, opts...)
 	case rewriteCmd.FullCommand():
 		return rewriteCommand(workdir, cfg.JsonnetHome)
+	case cleanCmd.FullCommand():
+		return cleanCommand(workdir, cfg.JsonnetHome, *cleanCmdLegacyOnly)
+	case outdatedCmd.FullCommand():
+		return outdatedCommand(ctx, workdir, *outdatedCmdAll)
+	case auditCmd.FullCommand():
+		return auditCommand(ctx, workdir, *auditCmdAdvisories)
+	case staleCmd.FullCommand():
+		return staleCommand(ctx, workdir, cfg.JsonnetHome)
+	case diffCmd.FullCommand():
+		return diffCommand(workdir, cfg.JsonnetHome, *diffCmdJSON)
+	case statusCmd.FullCommand():
+		return statusCommand(workdir, cfg.JsonnetHome, *statusCmdVerify)
+	case lockLintCmd.FullCommand():
+		return lockLintCommand(workdir)
+	case lockFixCmd.FullCommand():
+		return lockFixCommand(workdir)
+	case cacheInfoCmd.FullCommand():
+		return cacheInfoCommand(workdir, cfg.JsonnetHome)
+	case cachePruneCmd.FullCommand():
+		return cachePruneCommand(workdir, cfg.JsonnetHome, *cachePruneCmdMaxBytes)
 	default:
-		installCommand(workdir, cfg.JsonnetHome, []string{}, false, "")
+		installCommand(ctx, workdir, cfg.JsonnetHome, []string{}, false, "", false)
 	}
 
 	return 0
@@ -0,0 +1,47 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+func outdatedCommand(ctx context.Context, dir string, all bool) int {
+	locks, err := jsonnetfile.Load(lockPath(dir))
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	infos, err := pkg.Outdated(ctx, locks.Dependencies, apiTokenOptions()...)
+	kingpin.FatalIfError(err, "failed to check for newer versions")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCURRENT\tLATEST")
+	for _, i := range infos {
+		if !i.Outdated && !all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", i.Name, i.Current, i.Latest)
+	}
+	kingpin.FatalIfError(w.Flush(), "")
+
+	return 0
+}
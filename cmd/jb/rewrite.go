@@ -14,8 +14,6 @@
 package main
 
 import (
-	"path/filepath"
-
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
@@ -23,7 +21,7 @@ import (
 )
 
 func rewriteCommand(dir, vendorDir string) int {
-	locks, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	locks, err := jsonnetfile.Load(lockPath(dir))
 	if err != nil {
 		kingpin.Fatalf("Failed to load lockFile: %s.\nThe locks are required to compute the new import names. Make sure to run `jb install` first.", err)
 	}
@@ -0,0 +1,50 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+func staleCommand(ctx context.Context, dir, jsonnetHome string) int {
+	locks, err := jsonnetfile.Load(lockPath(dir))
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	infos, err := pkg.Freshness(ctx, filepath.Join(dir, jsonnetHome), locks.Dependencies)
+	kingpin.FatalIfError(err, "failed to check the age of dependencies")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tDATE\tAGE")
+	for _, i := range infos {
+		version := i.Version
+		if len(version) > 12 {
+			version = version[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%dd\n", i.Name, version, i.Date.Format("2006-01-02"), int(i.Age/(24*time.Hour)))
+	}
+	kingpin.FatalIfError(w.Flush(), "")
+
+	return 0
+}
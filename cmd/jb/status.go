@@ -0,0 +1,63 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// statusCommand lists the vendored files modified after the lockfile. With
+// verify, the packages holding them are checked against their sums, so only
+// actual modifications fail.
+func statusCommand(dir, jsonnetHome string, verify bool) int {
+	path := lockPath(dir)
+	locks, err := jsonnetfile.Load(path)
+	kingpin.FatalIfError(err, "failed to load lockfile")
+	fi, err := os.Stat(path)
+	kingpin.FatalIfError(err, "failed to load lockfile")
+
+	vendorDir := filepath.Join(dir, jsonnetHome)
+	modified, err := pkg.ModifiedSince(vendorDir, locks.Dependencies, fi.ModTime())
+	kingpin.FatalIfError(err, "failed to check vendor for modifications")
+
+	suspicious := deps.NewOrdered()
+	for _, m := range modified {
+		fmt.Printf("M %s\n", m.Path)
+		d, _ := locks.Dependencies.Get(m.Package)
+		suspicious.Set(m.Package, d)
+	}
+	if len(modified) == 0 {
+		return 0
+	}
+	if !verify {
+		return 1
+	}
+
+	if err := pkg.VerifyParallel(vendorDir, suspicious, 0, false); err != nil {
+		color.Red("ERROR: %s", err)
+		return 1
+	}
+	fmt.Println("the packages of all modified files match their sums")
+	return 0
+}
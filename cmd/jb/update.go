@@ -15,9 +15,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
@@ -26,7 +29,7 @@ import (
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
 )
 
-func updateCommand(dir, jsonnetHome string, uris []string) int {
+func updateCommand(ctx context.Context, dir, jsonnetHome string, uris []string, opts ...pkg.Option) int {
 	if dir == "" {
 		dir = "."
 	}
@@ -35,7 +38,7 @@ func updateCommand(dir, jsonnetHome string, uris []string) int {
 	jsonnetFile, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
 	kingpin.FatalIfError(err, "failed to load jsonnetfile")
 
-	lockFile, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	lockFile, err := jsonnetfile.Load(lockPath(dir))
 	kingpin.FatalIfError(err, "failed to load lockfile")
 
 	kingpin.FatalIfError(
@@ -58,11 +61,18 @@ func updateCommand(dir, jsonnetHome string, uris []string) int {
 		locks = deps.NewOrdered()
 	}
 
-	newLocks, err := pkg.Ensure(jsonnetFile, filepath.Join(dir, jsonnetHome), locks)
+	newLocks, _, err := pkg.Ensure(ctx, jsonnetFile, filepath.Join(dir, jsonnetHome), locks, append(apiTokenOptions(), opts...)...)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		return 130
+	}
 	kingpin.FatalIfError(err, "updating")
 
 	kingpin.FatalIfError(
-		writeJSONFile(filepath.Join(dir, jsonnetfile.LockFile), v1.JsonnetFile{Dependencies: newLocks}),
+		os.MkdirAll(filepath.Dir(lockPath(dir)), os.ModePerm),
+		"creating lockfile folder")
+	kingpin.FatalIfError(
+		writeJSONFile(lockPath(dir), v1.JsonnetFile{Dependencies: newLocks}),
 		"updating jsonnetfile.lock.json")
 
 	return 0
@@ -0,0 +1,175 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveTarget returns the path the archive entry name is extracted to
+// below dst. Entries ending up outside of dst are rejected, either by name,
+// like "../x", or through a symlink extracted before.
+func archiveTarget(dst, name string) (string, error) {
+	target := filepath.Join(dst, name)
+	if !withinDir(dst, target) {
+		return "", fmt.Errorf("%w: %s", UnsafeArchive, name)
+	}
+
+	// whatever exists of the target already must resolve below dst
+	existing := target
+	for existing != dst && existing != filepath.Dir(existing) {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	root, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil || !withinDir(root, resolved) {
+		return "", fmt.Errorf("%w: %s", UnsafeArchive, name)
+	}
+	return target, nil
+}
+
+// archiveLink checks that the symlink extracted to target below dst points
+// to somewhere below dst as well
+func archiveLink(dst, target, link string) error {
+	if filepath.IsAbs(link) || !withinDir(dst, filepath.Join(filepath.Dir(target), link)) {
+		rel, _ := filepath.Rel(dst, target)
+		return fmt.Errorf("%w: %s -> %s", UnsafeArchive, filepath.ToSlash(rel), link)
+	}
+	return nil
+}
+
+// checkLinks fails with UnsafeArchive if a symlink below dir points outside
+// of it, like archiveLink does for archives, so a checkout can't be used to
+// get around it
+func checkLinks(dir string) error {
+	return filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil || e.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		link, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		return archiveLink(dir, p, link)
+	})
+}
+
+// withinDir returns whether p is dir or below it, lexically
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// extractZip extracts the zip file to dst, dropping the directory all of
+// the content is wrapped in, if any
+func extractZip(archive, dst string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+
+	top := zipTopDir(zr.File)
+	for _, f := range zr.File {
+		name := f.Name
+		if top != "" {
+			name = strings.TrimPrefix(name, top+"/")
+			if name == "" || name == top {
+				continue
+			}
+		}
+		target, err := archiveTarget(dst, name)
+		if err != nil {
+			return err
+		}
+		if err := extractZipEntry(dst, f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipTopDir returns the directory all entries of the zip file are below, if
+// any
+func zipTopDir(files []*zip.File) string {
+	top := ""
+	for _, f := range files {
+		first, _, nested := strings.Cut(f.Name, "/")
+		if !nested && !f.FileInfo().IsDir() {
+			return ""
+		}
+		if top != "" && first != top {
+			return ""
+		}
+		top = first
+	}
+	return top
+}
+
+func extractZipEntry(dst string, f *zip.File, target string) error {
+	mode := f.Mode()
+	if mode.IsDir() {
+		return os.MkdirAll(target, os.ModePerm)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// the content of a symlink is its target
+	if mode&os.ModeSymlink != 0 {
+		link, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if err := archiveLink(dst, target, string(link)); err != nil {
+			return err
+		}
+		return os.Symlink(string(link), target)
+	}
+
+	if !mode.IsRegular() {
+		return nil
+	}
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
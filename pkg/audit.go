@@ -0,0 +1,152 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// Advisory is a known vulnerability of a package
+type Advisory struct {
+	ID string `json:"id"`
+	// Vulnerable is a semver constraint matching the affected versions,
+	// e.g. ">=1.0.0 <1.4.2"
+	Vulnerable string `json:"vulnerable"`
+	// Severity is free form, e.g. "high". Optional.
+	Severity string `json:"severity,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+// AdvisorySource provides the advisories Audit checks the locks against
+type AdvisorySource interface {
+	// Advisories returns the advisories of the package name, as named in
+	// the lock
+	Advisories(name string) ([]Advisory, error)
+}
+
+// AdvisoryFeed is an AdvisorySource mapping package names to their
+// advisories
+type AdvisoryFeed map[string][]Advisory
+
+// Advisories implements AdvisorySource
+func (f AdvisoryFeed) Advisories(name string) ([]Advisory, error) {
+	return f[name], nil
+}
+
+// advisoryFile is the format of an advisory feed
+type advisoryFile struct {
+	Advisories AdvisoryFeed `json:"advisories"`
+}
+
+// LoadAdvisories reads an advisory feed from a file or a http(s) URL. Only
+// WithHTTPRetryPolicy and WithBandwidthLimit are relevant of the options.
+func LoadAdvisories(ctx context.Context, location string, opts ...Option) (AdvisoryFeed, error) {
+	var data []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		o := newOptions(opts)
+		resp, err := httpGet(ctx, nil, o.httpRetry, o.bandwidth, location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(location); err != nil {
+			return nil, err
+		}
+	}
+
+	var f advisoryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse advisory feed %s: %w", location, err)
+	}
+	return f.Advisories, nil
+}
+
+// AuditFinding is a locked package affected by an advisory
+type AuditFinding struct {
+	Package string
+	// Version is the locked version that matched
+	Version string
+	// Vulnerable is the range of the advisory the version is in
+	Vulnerable string
+	Severity   string
+	ID         string
+	Summary    string
+}
+
+// Audit checks the locked version of every package against the advisories
+// of source. Nothing is downloaded: git packages are only checked if they
+// are locked at a tag, as the lock records for constraints and tags asked
+// for. Pre-releases within a vulnerable range match as well.
+func Audit(locks *deps.Ordered, source AdvisorySource) ([]AuditFinding, error) {
+	findings := []AuditFinding{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		advisories, err := source.Advisories(d.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the advisories of %s: %w", d.Name(), err)
+		}
+		if len(advisories) == 0 {
+			continue
+		}
+
+		version := auditVersion(d)
+		v, ok := parseSemver(version)
+		if !ok {
+			continue
+		}
+		for _, a := range advisories {
+			c, err := parseSemverConstraint(a.Vulnerable)
+			if err != nil {
+				return nil, fmt.Errorf("advisory %s of %s: %w", a.ID, d.Name(), err)
+			}
+			if !c.matches(v, true) {
+				continue
+			}
+			findings = append(findings, AuditFinding{
+				Package:    d.Name(),
+				Version:    version,
+				Vulnerable: a.Vulnerable,
+				Severity:   a.Severity,
+				ID:         a.ID,
+				Summary:    a.Summary,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// auditVersion returns the semver version the package of the lock d is
+// installed at, if the lock tells
+func auditVersion(d deps.Dependency) string {
+	switch {
+	case strings.HasPrefix(d.Provenance, "tag:"):
+		return strings.TrimPrefix(d.Provenance, "tag:")
+	case d.Requested != "":
+		return d.Requested
+	}
+	return d.Version
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	feedPath := filepath.Join(t.TempDir(), "advisories.json")
+	require.NoError(t, os.WriteFile(feedPath, []byte(`{"advisories": {
+		"example.com/test/tagged": [
+			{"id": "JB-1", "vulnerable": ">=1.0.0 <1.2.0", "severity": "high", "summary": "bad"},
+			{"id": "JB-2", "vulnerable": "<1.0.0"}
+		],
+		"example.com/test/requested": [{"id": "JB-3", "vulnerable": "^2.0.0"}],
+		"example.com/test/commit": [{"id": "JB-4", "vulnerable": ">=0.0.0"}]
+	}}`), 0644))
+	feed, err := LoadAdvisories(context.TODO(), feedPath)
+	require.NoError(t, err)
+
+	tagged := testDep("tagged", "0b2ab31b77f0ede56b660850462ff279eadcd50c")
+	tagged.Provenance = "tag:v1.1.0-rc1"
+	requested := testDep("requested", "1e2ab31b77f0ede56b660850462ff279eadcd50c")
+	requested.Requested = "v1.9.0"
+	// nothing tells which version the commit is
+	commit := testDep("commit", "2f2ab31b77f0ede56b660850462ff279eadcd50c")
+
+	findings, err := Audit(orderedOf(tagged, requested, commit), feed)
+	require.NoError(t, err)
+	assert.Equal(t, []AuditFinding{{
+		Package:    "example.com/test/tagged",
+		Version:    "v1.1.0-rc1",
+		Vulnerable: ">=1.0.0 <1.2.0",
+		Severity:   "high",
+		ID:         "JB-1",
+		Summary:    "bad",
+	}}, findings)
+
+	_, err = Audit(orderedOf(tagged), AdvisoryFeed{tagged.Name(): {{ID: "JB-5", Vulnerable: "=>1.0.0"}}})
+	assert.ErrorContains(t, err, "advisory JB-5 of example.com/test/tagged")
+}
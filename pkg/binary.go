@@ -0,0 +1,141 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// BinaryPolicy controls what happens to binary files of a package
+type BinaryPolicy int
+
+const (
+	// BinaryAllow vendors binary files like any other file
+	BinaryAllow BinaryPolicy = iota
+	// BinaryReject fails the installation of packages containing binary
+	// files with BinaryContent
+	BinaryReject
+	// BinaryStrip removes binary files from packages before they are hashed
+	BinaryStrip
+)
+
+// sniffLen is the amount of bytes http.DetectContentType considers
+const sniffLen = 512
+
+// applyBinaryPolicy enforces the policy on the downloaded package at dir.
+// It must run before the package is hashed, so stripped files are not part of
+// the sum.
+func applyBinaryPolicy(ctx context.Context, p BinaryPolicy, name, dir string) error {
+	if p == BinaryAllow {
+		return nil
+	}
+
+	binaries, err := findBinaries(dir)
+	if err != nil {
+		return err
+	}
+	if len(binaries) == 0 {
+		return nil
+	}
+
+	if p == BinaryReject {
+		return fmt.Errorf("%w: package %s contains binary files: %s", BinaryContent, name, strings.Join(binaries, ", "))
+	}
+
+	for _, b := range binaries {
+		if err := os.Remove(filepath.Join(dir, b)); err != nil {
+			return err
+		}
+		logger(ctx).Strip(name, b)
+	}
+	return nil
+}
+
+// cachedBinaries returns whether the package of d in the cache entry cp has
+// binary files the policy doesn't allow. It may have been downloaded before
+// the policy was set, so it is downloaded again to apply it.
+func (o *options) cachedBinaries(d deps.Dependency, cp string) bool {
+	if o.binaryPolicy == BinaryAllow || d.Source.LocalSource != nil {
+		return false
+	}
+	binaries, err := findBinaries(filepath.Join(cp, d.Name()))
+	return err != nil || len(binaries) > 0
+}
+
+// findBinaries returns the paths relative to dir of all files whose content
+// is not detected as text
+func findBinaries(dir string) ([]string, error) {
+	binaries := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Mode()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		bin, err := isBinary(path)
+		if err != nil {
+			return err
+		}
+		if !bin {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		binaries = append(binaries, filepath.ToSlash(rel))
+		return nil
+	})
+	return binaries, err
+}
+
+func isBinary(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return isBinaryContent(buf[:n]), nil
+}
+
+// isBinaryContent returns whether data, of which only the first sniffLen
+// bytes are considered, is not detected as text. Empty data is text.
+func isBinaryContent(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	return !strings.HasPrefix(http.DetectContentType(data), "text/")
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func writeBinaryTestPackage(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"main.libsonnet":  []byte("{ a: 1 }"),
+		"empty.libsonnet": {},
+		"bin/tool":        {0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x00, 0x00},
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		require.NoError(t, os.WriteFile(p, content, 0644))
+	}
+	return dir
+}
+
+func TestApplyBinaryPolicy(t *testing.T) {
+	t.Run("allow", func(t *testing.T) {
+		dir := writeBinaryTestPackage(t)
+		assert.NoError(t, applyBinaryPolicy(context.TODO(), BinaryAllow, "foo", dir))
+		assert.FileExists(t, filepath.Join(dir, "bin/tool"))
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		dir := writeBinaryTestPackage(t)
+		err := applyBinaryPolicy(context.TODO(), BinaryReject, "foo", dir)
+		assert.ErrorIs(t, err, BinaryContent)
+		assert.EqualError(t, err, "binary content not allowed: package foo contains binary files: bin/tool")
+		assert.FileExists(t, filepath.Join(dir, "bin/tool"))
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		dir := writeBinaryTestPackage(t)
+		require.NoError(t, applyBinaryPolicy(context.TODO(), BinaryStrip, "foo", dir))
+		assert.NoFileExists(t, filepath.Join(dir, "bin/tool"))
+		assert.FileExists(t, filepath.Join(dir, "main.libsonnet"))
+
+		// the stripped tree hashes like a tree that never had the binary
+		stripped, err := hashDir(context.TODO(), dir, hashConfig{})
+		require.NoError(t, err)
+		require.NoError(t, applyBinaryPolicy(context.TODO(), BinaryReject, "foo", dir))
+		again, err := hashDir(context.TODO(), dir, hashConfig{})
+		require.NoError(t, err)
+		assert.Equal(t, stripped, again)
+	})
+}
+
+func TestEnsureBinaryPolicyCached(t *testing.T) {
+	r := newTestRepo(t, "binaries")
+	r.commit(map[string]string{"main.libsonnet": "{}", "bin/tool": "\x7fELF\x02\x01\x00\x00"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	// the policy applies to packages vendored before it was set as well
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithBinaryPolicy(BinaryReject))
+	assert.ErrorIs(t, err, BinaryContent)
+
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithBinaryPolicy(BinaryStrip))
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(vendorDir, r.src.Name(), "bin", "tool"))
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+}
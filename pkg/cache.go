@@ -0,0 +1,160 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// UnknownCacheEntry is the name of cache entries that can't be decoded
+const UnknownCacheEntry = "unknown"
+
+// CacheEntryStat describes a single entry of the download cache
+type CacheEntryStat struct {
+	// Dir is the name of the entry's directory inside the cache
+	Dir     string
+	Name    string
+	Version string
+	// Size is the total size of all files in bytes
+	Size int64
+	// LastAccess is the last time Ensure used or downloaded the entry
+	LastAccess time.Time
+}
+
+// CacheStats lists all entries of the cache directory (usually vendor/.cache)
+// together with their size. Entries not created by jb are listed with the
+// name UnknownCacheEntry.
+func CacheStats(cacheDir string) ([]CacheEntryStat, error) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]CacheEntryStat, 0, len(entries))
+	for _, e := range entries {
+		dir := filepath.Join(cacheDir, e.Name())
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		s := CacheEntryStat{Dir: e.Name(), Name: UnknownCacheEntry, Size: size, LastAccess: info.ModTime()}
+		if name, version, ok := decodeCacheEntry(cacheDir, e.Name()); ok {
+			s.Name, s.Version = name, version
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// PruneCacheToSize removes the least recently used entries of the cache
+// directory, until all of them together take at most maxBytes. Entries of
+// the packages locked in keep, which may be nil, are never removed, nor are
+// entries not created by jb, so the budget may not be met. It returns the
+// removed entries.
+func PruneCacheToSize(cacheDir string, maxBytes int64, keep *deps.Ordered) ([]CacheEntryStat, error) {
+	stats, err := CacheStats(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, s := range stats {
+		total += s.Size
+	}
+
+	// entries of a cache context carry a suffix
+	kept := func(s CacheEntryStat) bool {
+		if keep == nil {
+			return false
+		}
+		for _, k := range keep.Keys() {
+			d, _ := keep.Get(k)
+			dir := filepath.Base(cachePath("", d))
+			if s.Dir == dir || strings.HasPrefix(s.Dir, dir+"-") {
+				return true
+			}
+		}
+		return false
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].LastAccess.Before(stats[j].LastAccess)
+	})
+	removed := []CacheEntryStat{}
+	for _, s := range stats {
+		if total <= maxBytes {
+			break
+		}
+		if s.Name == UnknownCacheEntry || kept(s) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cacheDir, s.Dir)); err != nil {
+			return removed, err
+		}
+		total -= s.Size
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
+
+// decodeCacheEntry splits the name of a cache entry back into the package
+// name and version. As both may contain dashes, the name is the one the entry
+// actually holds a directory for.
+func decodeCacheEntry(cacheDir, entry string) (name, version string, ok bool) {
+	unescaped, err := url.PathUnescape(entry)
+	if err != nil {
+		return "", "", false
+	}
+	for i := strings.LastIndex(unescaped, "-"); i > 0; i = strings.LastIndex(unescaped[:i], "-") {
+		name := unescaped[:i]
+		if _, err := os.Lstat(filepath.Join(cacheDir, entry, name)); err == nil {
+			return name, unescaped[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// dirSize sums up the size of all files below dir, without following symlinks
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if i.Mode().IsRegular() {
+			size += i.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// touchCacheEntry records that the cache entry was used
+func touchCacheEntry(cp string) {
+	now := time.Now()
+	_ = os.Chtimes(cp, now, now)
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestCacheStats(t *testing.T) {
+	vendorDir := t.TempDir()
+	cacheDir := filepath.Join(vendorDir, ".cache")
+
+	vendorPackage(t, vendorDir, testDep("a", "v1.0.0-rc.1"), map[string]string{"a.libsonnet": "{}"})
+	vendorPackage(t, vendorDir, testDep("with-dash", "main"), map[string]string{"main.libsonnet": "{ a: 1 }", "lib/b.libsonnet": "{}"})
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "garbage"), os.ModePerm))
+
+	stats, err := CacheStats(cacheDir)
+	require.NoError(t, err)
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	require.Len(t, stats, 3)
+
+	assert.Equal(t, "example.com/test/a", stats[0].Name)
+	assert.Equal(t, "v1.0.0-rc.1", stats[0].Version)
+	assert.EqualValues(t, 2, stats[0].Size)
+
+	assert.Equal(t, "example.com/test/with-dash", stats[1].Name)
+	assert.Equal(t, "main", stats[1].Version)
+	assert.EqualValues(t, 10, stats[1].Size)
+	assert.False(t, stats[1].LastAccess.IsZero())
+
+	assert.Equal(t, UnknownCacheEntry, stats[2].Name)
+	assert.Equal(t, "garbage", stats[2].Dir)
+}
+
+func TestPruneCacheToSize(t *testing.T) {
+	vendorDir := t.TempDir()
+	cacheDir := filepath.Join(vendorDir, ".cache")
+
+	// a is the least recently used, d the most recently used entry
+	content := strings.Repeat("x", 100)
+	old := time.Now().Add(-time.Hour)
+	ds := []deps.Dependency{}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		d := vendorPackage(t, vendorDir, testDep(name, "v1"), map[string]string{"main.libsonnet": content})
+		access := old.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(cachePath(vendorDir, d), access, access))
+		ds = append(ds, d)
+	}
+
+	// a is locked, so b and c go instead
+	removed, err := PruneCacheToSize(cacheDir, 250, orderedOf(ds[0]))
+	require.NoError(t, err)
+	names := []string{}
+	for _, s := range removed {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{ds[1].Name(), ds[2].Name()}, names)
+
+	for i, d := range ds {
+		if i == 1 || i == 2 {
+			assert.NoDirExists(t, cachePath(vendorDir, d))
+		} else {
+			assert.DirExists(t, cachePath(vendorDir, d))
+		}
+	}
+
+	// within the budget, nothing is removed
+	removed, err = PruneCacheToSize(cacheDir, 250, nil)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+}
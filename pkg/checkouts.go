@@ -0,0 +1,95 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sharedCheckouts holds the trees of repositories fetched during a single
+// Ensure, so packages of different subdirs of the same commit are taken from
+// one fetch instead of fetching the repository for each of them
+type sharedCheckouts struct {
+	// parent is where the trees are kept
+	parent string
+
+	mu      sync.Mutex
+	dir     string
+	entries map[string]*sharedCheckout
+}
+
+type sharedCheckout struct {
+	once sync.Once
+	dir  string
+	sha  string
+	date time.Time
+	err  error
+}
+
+func newSharedCheckouts(parent string) *sharedCheckouts {
+	return &sharedCheckouts{parent: parent, entries: map[string]*sharedCheckout{}}
+}
+
+// get returns the tree for key with its commit and commit date, calling fetch
+// to fill an empty directory with it the first time it is asked for.
+// Concurrent callers of the same key wait for the first one.
+func (c *sharedCheckouts) get(key string, fetch func(dir string) (sha string, date time.Time, err error)) (string, string, time.Time, error) {
+	c.mu.Lock()
+	if c.dir == "" {
+		if err := os.MkdirAll(c.parent, os.ModePerm); err != nil {
+			c.mu.Unlock()
+			return "", "", time.Time{}, errors.Wrap(err, "failed to create checkouts dir")
+		}
+		dir, err := os.MkdirTemp(c.parent, ".checkouts-")
+		if err != nil {
+			c.mu.Unlock()
+			return "", "", time.Time{}, errors.Wrap(err, "failed to create checkouts dir")
+		}
+		c.dir = dir
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &sharedCheckout{dir: filepath.Join(c.dir, strconv.Itoa(len(c.entries)))}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		if e.err = os.MkdirAll(e.dir, os.ModePerm); e.err != nil {
+			return
+		}
+		e.sha, e.date, e.err = fetch(e.dir)
+	})
+	return e.dir, e.sha, e.date, e.err
+}
+
+// cleanup removes all trees
+func (c *sharedCheckouts) cleanup() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(c.dir)
+	c.dir = ""
+	c.entries = map[string]*sharedCheckout{}
+	return err
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// removeDirs removes the directories with at most concurrency removals at
+// once, defaulting to the number of CPUs. Directories below another one of
+// dirs go along with it. All errors are returned together.
+func removeDirs(ctx context.Context, dirs []string, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	work := make(chan string)
+	errs := make(chan error, len(dirs))
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range work {
+				if err := os.RemoveAll(dir); err != nil {
+					errs <- fmt.Errorf("failed to remove %s: %w", dir, err)
+					continue
+				}
+				logger(ctx).Clean(dir)
+			}
+		}()
+	}
+	for _, dir := range topmostDirs(dirs) {
+		work <- dir
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+
+	all := []error{}
+	for err := range errs {
+		all = append(all, err)
+	}
+	return errors.Join(all...)
+}
+
+// topmostDirs drops all dirs below another one of dirs, so no two removals
+// work on the same tree
+func topmostDirs(dirs []string) []string {
+	set := make(map[string]struct{}, len(dirs))
+	for _, dir := range dirs {
+		set[dir] = struct{}{}
+	}
+
+	top := []string{}
+	for _, dir := range dirs {
+		nested := false
+		for child, parent := dir, filepath.Dir(dir); parent != child && !nested; child, parent = parent, filepath.Dir(parent) {
+			_, nested = set[parent]
+		}
+		if !nested {
+			top = append(top, dir)
+		}
+	}
+	return top
+}
+
+// CleanupDecision explains what the cleanup of Ensure does with a directory
+// or symlink in vendor
+type CleanupDecision struct {
+	// Path is relative to vendor and slash separated
+	Path    string
+	Symlink bool
+	Remove  bool
+	// Reason is a human readable explanation, like the package the path
+	// belongs to
+	Reason string
+}
+
+// PlanCleanup explains which directories and symlinks below vendorDir the
+// cleanup of Ensure keeps and removes, without touching anything. Contents of
+// removed directories are not listed, as they go along with them. Legacy
+// links are assumed wanted, as with LegacyImports enabled. Only
+// WithVendorPrefix is relevant of the options.
+func PlanCleanup(vendorDir string, locks *deps.Ordered, opts ...Option) ([]CleanupDecision, error) {
+	o := newOptions(opts)
+
+	packages := map[string]string{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		packages[filepath.Join(vendorDir, o.vendorPrefix, d.Name())] = d.Name()
+	}
+	legacy := map[string]string{}
+	for _, l := range legacyLinks(locks, o.vendorPrefix) {
+		legacy[filepath.Join(vendorDir, l.legacyName)] = l.pkgName
+	}
+
+	decisions := []CleanupDecision{}
+	err := filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == vendorDir {
+			return nil
+		}
+		if path == filepath.Join(vendorDir, ".cache") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(vendorDir, path)
+		if err != nil {
+			return err
+		}
+		d := CleanupDecision{Path: filepath.ToSlash(rel), Symlink: i.Mode()&os.ModeSymlink != 0}
+
+		switch {
+		case d.Symlink:
+			if name, ok := packages[path]; ok {
+				d.Reason = "locked package " + name
+			} else if name, ok := legacy[path]; ok {
+				d.Reason = "legacy link to " + filepath.ToSlash(name)
+			} else {
+				d.Remove, d.Reason = true, "stale legacy link"
+			}
+		case !i.IsDir():
+			return nil
+		default:
+			name, ok := knownBy(locks, o.vendorPrefix, rel)
+			switch {
+			case !ok:
+				d.Remove, d.Reason = true, "not part of the lock"
+			case packages[path] == name:
+				d.Reason = "locked package " + name
+			case strings.HasPrefix(filepath.ToSlash(filepath.Join(o.vendorPrefix, name)), d.Path):
+				d.Reason = "parent of locked package " + name
+			default:
+				d.Reason = "inside locked package " + name
+			}
+		}
+
+		decisions = append(decisions, d)
+		if d.Remove && !d.Symlink {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestRemoveDirs(t *testing.T) {
+	dir := t.TempDir()
+	dirs := []string{}
+	for i := 0; i < 50; i++ {
+		d := filepath.Join(dir, fmt.Sprintf("stale-%d", i))
+		nested := filepath.Join(d, "lib")
+		require.NoError(t, os.MkdirAll(nested, os.ModePerm))
+		require.NoError(t, os.WriteFile(filepath.Join(nested, "main.libsonnet"), []byte("{}"), 0644))
+		// unknownDirs lists nested directories as well
+		dirs = append(dirs, d, nested)
+	}
+
+	require.NoError(t, removeDirs(context.TODO(), dirs, 4))
+	for _, d := range dirs {
+		assert.NoDirExists(t, d)
+	}
+
+	assert.Equal(t, []string{"a", "a-b", "c"}, topmostDirs([]string{"a", "a-b", "a/b", "a/b/c", "c", "a-b/d"}))
+}
+
+func TestRemoveDirsErrors(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		t.Skip("permissions are not enforced")
+	}
+
+	dir := t.TempDir()
+	locked := []string{filepath.Join(dir, "locked-a"), filepath.Join(dir, "locked-b")}
+	for _, d := range locked {
+		require.NoError(t, os.MkdirAll(filepath.Join(d, "sub"), os.ModePerm))
+		require.NoError(t, os.Chmod(d, 0500))
+		t.Cleanup(func() { os.Chmod(d, 0700) })
+	}
+	free := filepath.Join(dir, "free")
+	require.NoError(t, os.MkdirAll(free, os.ModePerm))
+
+	err := removeDirs(context.TODO(), append(locked, free), 2)
+	require.Error(t, err)
+	for _, d := range locked {
+		assert.Contains(t, err.Error(), d)
+	}
+	assert.NoDirExists(t, free)
+}
+
+func TestEnsureCleansManyStaleDirs(t *testing.T) {
+	r := newTestRepo(t, "kept")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	vendorDir := t.TempDir()
+	stale := []string{}
+	for i := 0; i < 20; i++ {
+		d := filepath.Join(vendorDir, "example.com", fmt.Sprintf("stale-%d", i))
+		require.NoError(t, os.MkdirAll(filepath.Join(d, "lib"), os.ModePerm))
+		stale = append(stale, d)
+	}
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithConcurrency(3))
+	require.NoError(t, err)
+	for _, d := range stale {
+		assert.NoDirExists(t, d)
+	}
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+}
+
+func TestPlanCleanup(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	b := testDep("b", "v1")
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, b.Name(), "lib"), os.ModePerm))
+	require.NoError(t, os.Symlink(a.Name(), filepath.Join(vendorDir, "a")))
+	require.NoError(t, os.Symlink(a.Name(), filepath.Join(vendorDir, "old")))
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, "example.com", "other", "lib"), os.ModePerm))
+
+	decisions, err := PlanCleanup(vendorDir, orderedOf(a, b))
+	require.NoError(t, err)
+	assert.Equal(t, []CleanupDecision{
+		{Path: "a", Symlink: true, Reason: "legacy link to example.com/test/a"},
+		{Path: "example.com", Reason: "parent of locked package example.com/test/a"},
+		{Path: "example.com/other", Remove: true, Reason: "not part of the lock"},
+		{Path: "example.com/test", Reason: "parent of locked package example.com/test/a"},
+		{Path: "example.com/test/a", Symlink: true, Reason: "locked package example.com/test/a"},
+		{Path: "example.com/test/b", Reason: "locked package example.com/test/b"},
+		{Path: "example.com/test/b/lib", Reason: "inside locked package example.com/test/b"},
+		{Path: "old", Symlink: true, Remove: true, Reason: "stale legacy link"},
+	}, decisions)
+
+	// nothing was touched
+	assert.DirExists(t, filepath.Join(vendorDir, "example.com", "other", "lib"))
+	_, err = os.Lstat(filepath.Join(vendorDir, "old"))
+	assert.NoError(t, err)
+}
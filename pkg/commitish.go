@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// UnresolvedCommitish is returned if a commit-ish expression names no commit
+// of the remote
+var UnresolvedCommitish = errors.New("commit-ish expression can't be resolved")
+
+// isCommitish returns whether the version is a commit-ish expression relative
+// to a ref, like "main~3" or "v1.0^{commit}". Ref names can't contain '~' or
+// '^', so there is no ambiguity, except for version specs like "~1.2", which
+// start with them.
+func isCommitish(version string) bool {
+	return strings.IndexAny(version, "~^") > 0 && !isSemverConstraint(version)
+}
+
+// splitCommitish splits a commit-ish expression into the ref it is relative
+// to and the rest, e.g. "main~3" into "main" and "~3"
+func splitCommitish(expr string) (base, suffix string) {
+	i := strings.IndexAny(expr, "~^")
+	return expr[:i], expr[i:]
+}
+
+// commitishDepth returns how much history of the base ref the suffix of a
+// commit-ish expression needs at least, e.g. 4 for "~3"
+func commitishDepth(suffix string) int {
+	depth := 1
+	for i := 0; i < len(suffix); i++ {
+		switch {
+		case suffix[i] == '^' && i+1 < len(suffix) && suffix[i+1] == '{':
+			// peeling like ^{commit} needs no history
+			if end := strings.IndexByte(suffix[i:], '}'); end >= 0 {
+				i += end
+			}
+		case suffix[i] == '~':
+			j := i + 1
+			for j < len(suffix) && suffix[j] >= '0' && suffix[j] <= '9' {
+				j++
+			}
+			n := 1
+			if j > i+1 {
+				n, _ = strconv.Atoi(suffix[i+1 : j])
+			}
+			depth += n
+			i = j - 1
+		case suffix[i] == '^':
+			depth++
+		}
+	}
+	return depth
+}
+
+// fetchCommitish fetches the base ref of the commit-ish expression into the
+// repository at dir and returns the full sha of the commit the expression
+// names. The base is fetched as shallow as the expression allows, the
+// complete history is fetched only if that is not enough.
+func (p *GitPackage) fetchCommitish(ctx context.Context, gitCmd func(args ...string) *exec.Cmd, dir, expr string) (string, error) {
+	base, suffix := splitCommitish(expr)
+	protocol := gitProtocolArgs(p.protocol())
+
+	depth := strconv.Itoa(commitishDepth(suffix))
+	if err := gitCmd(append(protocol, "fetch", "--tags", "--depth", depth, "origin", base)...).Run(); err != nil {
+		return "", fmt.Errorf("%w: '%s' of %s: fetching '%s' failed: %s", UnresolvedCommitish, expr, p.Source.Remote(), base, err)
+	}
+
+	revParse := func() (string, error) {
+		b := &bytes.Buffer{}
+		cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", "FETCH_HEAD"+suffix+"^{commit}")
+		cmd.Stdout = b
+		cmd.Dir = dir
+		err := cmd.Run()
+		return strings.TrimSpace(b.String()), err
+	}
+
+	sha, err := revParse()
+	if err != nil {
+		// the expression reaches beyond the shallow history
+		if err := gitCmd(append(protocol, "fetch", "--tags", "--unshallow", "origin", base)...).Run(); err != nil {
+			return "", fmt.Errorf("%w: '%s' of %s: deepening the history of '%s' failed: %s", UnresolvedCommitish, expr, p.Source.Remote(), base, err)
+		}
+		if sha, err = revParse(); err != nil {
+			return "", fmt.Errorf("%w: '%s' of %s names no commit", UnresolvedCommitish, expr, p.Source.Remote())
+		}
+	}
+	return sha, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestCommitishDepth(t *testing.T) {
+	for suffix, want := range map[string]int{
+		"~":         2,
+		"~3":        4,
+		"^":         2,
+		"^2":        2,
+		"~2^":       4,
+		"^{commit}": 1,
+		"~1^{tree}": 2,
+	} {
+		assert.Equal(t, want, commitishDepth(suffix), suffix)
+	}
+	assert.True(t, isCommitish("main~3"))
+	assert.True(t, isCommitish("v1.0^{commit}"))
+	assert.False(t, isCommitish("~1.2"))
+	assert.False(t, isCommitish("^1.0 || develop"))
+	assert.False(t, isCommitish("main"))
+}
+
+func TestEnsureCommitish(t *testing.T) {
+	r := newTestRepo(t, "relative")
+	first := r.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+	r.git("tag", "-a", "-m", "release", "v1.0")
+	second := r.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+	r.commit(map[string]string{"main.libsonnet": "{ v: 3 }"})
+
+	tests := []struct {
+		version, want, content string
+	}{
+		{version: "master~1", want: second, content: "{ v: 2 }"},
+		{version: "master~2", want: first, content: "{ v: 1 }"},
+		{version: "master^^", want: first, content: "{ v: 1 }"},
+		{version: "v1.0^{commit}", want: first, content: "{ v: 1 }"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			jsf := v1.New()
+			jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: tc.version})
+			vendorDir := t.TempDir()
+
+			locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+			require.NoError(t, err)
+			l, _ := locks.Get(r.src.Name())
+			assert.Equal(t, tc.want, l.Version)
+			b, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, string(b))
+		})
+	}
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master~5"})
+	_, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	assert.ErrorIs(t, err, UnresolvedCommitish)
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// SourceKind groups packages by how they are downloaded, so their
+// concurrency can be limited separately
+type SourceKind string
+
+const (
+	// SourceGit are git sources cloned by the git executable
+	SourceGit SourceKind = "git"
+	// SourceArchive are git sources downloaded as archives over HTTP,
+	// release assets and HTTP sources
+	SourceArchive SourceKind = "archive"
+	// SourceLocal are local sources
+	SourceLocal SourceKind = "local"
+)
+
+// sourceKind returns how the package of d is downloaded
+func (o *options) sourceKind(d deps.Dependency) SourceKind {
+	switch {
+	case d.Source.LocalSource != nil:
+		return SourceLocal
+	case d.Source.ReleaseSource != nil, d.Source.HTTPSource != nil, d.Source.GitSource != nil && o.gitBackend(d.Source.GitSource) == deps.GitBackendHTTP:
+		return SourceArchive
+	default:
+		return SourceGit
+	}
+}
+
+// downloadConcurrency returns the limit of concurrent downloads. Unless set,
+// it is a few per CPU, as downloads mostly wait on the network. Zero means
+// unlimited.
+func (o *options) downloadConcurrency() int {
+	switch {
+	case o.concurrency < 0:
+		return 0
+	case o.concurrency == 0:
+		return 4 * runtime.NumCPU()
+	}
+	return o.concurrency
+}
+
+// downloadSlots limits how many downloads run at once. Kinds with a limit of
+// their own don't count towards the global one.
+type downloadSlots struct {
+	global chan struct{}
+	kinds  map[SourceKind]chan struct{}
+}
+
+// newDownloadSlots returns the slots for the limits, zero meaning unlimited
+func newDownloadSlots(global int, kinds map[SourceKind]int) *downloadSlots {
+	s := &downloadSlots{kinds: map[SourceKind]chan struct{}{}}
+	if global > 0 {
+		s.global = make(chan struct{}, global)
+	}
+	for k, n := range kinds {
+		if n > 0 {
+			s.kinds[k] = make(chan struct{}, n)
+		}
+	}
+	return s
+}
+
+// acquire blocks until a download of the kind may start, or ctx is done. The
+// returned func must be called once the download is done.
+func (s *downloadSlots) acquire(ctx context.Context, kind SourceKind) (release func(), err error) {
+	sem, ok := s.kinds[kind]
+	if !ok {
+		sem = s.global
+	}
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestDownloadSlots(t *testing.T) {
+	o := newOptions([]Option{
+		WithConcurrency(3),
+		WithSourceConcurrency(SourceGit, 2),
+		WithSourceConcurrency(SourceArchive, 5),
+	})
+	slots := newDownloadSlots(o.concurrency, o.kindLimits)
+
+	var mu sync.Mutex
+	active := map[SourceKind]int{}
+	peak := map[SourceKind]int{}
+	// local has no limit of its own, so it is capped by the global one
+	caps := map[SourceKind]int{SourceGit: 2, SourceArchive: 5, SourceLocal: 3}
+
+	var wg sync.WaitGroup
+	for kind := range caps {
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(kind SourceKind) {
+				defer wg.Done()
+				release, err := slots.acquire(context.TODO(), kind)
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer release()
+
+				mu.Lock()
+				active[kind]++
+				if active[kind] > peak[kind] {
+					peak[kind] = active[kind]
+				}
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				active[kind]--
+				mu.Unlock()
+			}(kind)
+		}
+	}
+	wg.Wait()
+
+	for kind, limit := range caps {
+		assert.LessOrEqual(t, peak[kind], limit, kind)
+		assert.Greater(t, peak[kind], 0, kind)
+	}
+}
+
+func TestDownloadSlotsUnlimited(t *testing.T) {
+	slots := newDownloadSlots(0, nil)
+	// would block on the second acquire if limited to one
+	r1, err := slots.acquire(context.TODO(), SourceGit)
+	require.NoError(t, err)
+	r2, err := slots.acquire(context.TODO(), SourceGit)
+	require.NoError(t, err)
+	r1()
+	r2()
+}
+
+func TestDownloadSlotsCanceled(t *testing.T) {
+	slots := newDownloadSlots(1, nil)
+	release, err := slots.acquire(context.TODO(), SourceGit)
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = slots.acquire(ctx, SourceGit)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDownloadConcurrency(t *testing.T) {
+	assert.Equal(t, 4*runtime.NumCPU(), newOptions(nil).downloadConcurrency())
+	assert.Equal(t, 3, newOptions([]Option{WithConcurrency(3)}).downloadConcurrency())
+	assert.Equal(t, 0, newOptions([]Option{WithConcurrency(-1)}).downloadConcurrency())
+}
+
+func TestEnsureDeeperThanConcurrency(t *testing.T) {
+	// each package depends on the next one, so the tree is deeper than the
+	// single download slot
+	var repos []*testRepo
+	for _, name := range []string{"a", "b", "c", "d"} {
+		repos = append(repos, newTestRepo(t, name))
+	}
+	for i := len(repos) - 1; i >= 0; i-- {
+		files := map[string]string{"main.libsonnet": "{}"}
+		if i+1 < len(repos) {
+			files["jsonnetfile.json"] = jsonnetfileFor(repos[i+1])
+		}
+		repos[i].commit(files)
+	}
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(repos[0].src.Name(), deps.Dependency{Source: deps.Source{GitSource: repos[0].src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithConcurrency(1))
+	require.NoError(t, err)
+	assert.Len(t, locks.Keys(), len(repos))
+}
+
+func TestSourceKind(t *testing.T) {
+	o := newOptions([]Option{WithGitBackend(deps.GitBackendHTTP)})
+	git := testDep("a", "v1")
+	git.Source.GitSource.Host = "github.com"
+	assert.Equal(t, SourceArchive, o.sourceKind(git))
+	git.Source.GitSource.Backend = deps.GitBackendExec
+	assert.Equal(t, SourceGit, o.sourceKind(git))
+	assert.Equal(t, SourceLocal, o.sourceKind(deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "a"}}}))
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// VersionPolicy decides which version wins if a package is requested at
+// multiple versions. Locked packages are always installed at the version of
+// the lock, regardless of the policy.
+type VersionPolicy int
+
+const (
+	// VersionFirst picks the version requested first, walking the
+	// dependencies depth first in the order of the jsonnetfiles
+	VersionFirst VersionPolicy = iota
+	// VersionHighest picks the highest version, as ordered by
+	// DefaultVersionLess unless set otherwise using WithVersionLess
+	VersionHighest
+	// VersionConflictError fails with VersionMismatch instead of picking one
+	VersionConflictError
+)
+
+const (
+	// ResolverV1 is the original resolution behavior, where the first seen
+	// version of a package wins
+	ResolverV1 uint = 1
+	// ResolverV2 picks the highest semver version of a package
+	ResolverV2 uint = 2
+
+	// LatestResolver is the newest resolver version supported
+	LatestResolver = ResolverV2
+)
+
+// resolverVersionPolicy returns the VersionPolicy a resolver version of a
+// jsonnetfile uses unless told otherwise
+func resolverVersionPolicy(resolver uint) (VersionPolicy, error) {
+	switch resolver {
+	case 0, ResolverV1:
+		return VersionFirst, nil
+	case ResolverV2:
+		return VersionHighest, nil
+	}
+	return 0, fmt.Errorf("jsonnetfile requires resolver version %d, but only versions up to %d are supported. Please upgrade jb", resolver, LatestResolver)
+}
+
+// selectVersions walks all requested versions of the dependency graph and
+// returns the winning version of each package name. For VersionFirst, no
+// winners are returned, as linkDownloaded links the first version it sees
+// anyways. less orders the versions for VersionHighest and defaults to
+// DefaultVersionLess. The winners of conflicts are traced to t.
+func selectVersions(direct *deps.Ordered, downloaded map[packageRef]downloadedPackage, p VersionPolicy, less func(a, b string) bool, t *tracer) (map[string]string, error) {
+	if p == VersionFirst {
+		return nil, nil
+	}
+	if less == nil {
+		less = DefaultVersionLess
+	}
+
+	requested := map[string][]string{}
+	visited := map[packageRef]struct{}{}
+	names := []string{}
+
+	var walk func(list *deps.Ordered)
+	walk = func(list *deps.Ordered) {
+		for _, k := range list.Keys() {
+			d, _ := list.Get(k)
+			ref := packageRef{name: d.Name(), version: d.Version}
+			if _, ok := visited[ref]; ok {
+				continue
+			}
+			visited[ref] = struct{}{}
+			if _, ok := requested[ref.name]; !ok {
+				names = append(names, ref.name)
+			}
+			requested[ref.name] = append(requested[ref.name], ref.version)
+
+			if dl, ok := downloaded[ref]; ok && dl.jsf != nil {
+				walk(dl.jsf.Dependencies)
+			}
+		}
+	}
+	walk(direct)
+
+	winners := make(map[string]string, len(requested))
+	conflicts := []string{}
+	for _, name := range names {
+		versions := requested[name]
+		winners[name] = versions[0]
+		if len(versions) == 1 {
+			continue
+		}
+
+		if p == VersionConflictError {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", name, strings.Join(versions, ", ")))
+			continue
+		}
+		winners[name] = highestVersion(versions, less)
+		t.printf("conflict %s: %s, %s wins", name, strings.Join(versions, ", "), winners[name])
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("%w:\n  %s", VersionMismatch, strings.Join(conflicts, "\n  "))
+	}
+	return winners, nil
+}
+
+// highestVersion returns the highest of the versions. Of equal ones, the
+// first wins.
+func highestVersion(versions []string, less func(a, b string) bool) string {
+	best := versions[0]
+	for _, v := range versions[1:] {
+		if less(best, v) {
+			best = v
+		}
+	}
+	return best
+}
+
+// DefaultVersionLess orders semver versions by their precedence, and by
+// their build metadata if that is equal. Versions that are not semver are
+// lower than ones that are and compared lexically among themselves.
+func DefaultVersionLess(a, b string) bool {
+	av, aOk := parseSemver(a)
+	bv, bOk := parseSemver(b)
+	switch {
+	case aOk && bOk:
+		return bv.newer(av)
+	case aOk != bOk:
+		return bOk
+	default:
+		return a < b
+	}
+}
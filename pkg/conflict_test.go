@@ -0,0 +1,104 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestSelectVersions(t *testing.T) {
+	a, b, d, e := testDep("a", "v1"), testDep("b", "v1"), testDep("d", "v1"), testDep("e", "v1")
+	c1, c2, cMaster := testDep("c", "v1.2.0"), testDep("c", "v1.10.0"), testDep("c", "master")
+	downloaded := map[packageRef]downloadedPackage{
+		{name: a.Name(), version: "v1"}:           {lock: a, jsf: &v1.JsonnetFile{Dependencies: orderedOf(cMaster)}},
+		{name: b.Name(), version: "v1"}:           {lock: b, jsf: &v1.JsonnetFile{Dependencies: orderedOf(c1, d)}},
+		{name: e.Name(), version: "v1"}:           {lock: e, jsf: &v1.JsonnetFile{Dependencies: orderedOf(c2)}},
+		{name: c1.Name(), version: "v1.2.0"}:      {lock: c1},
+		{name: c2.Name(), version: "v1.10.0"}:     {lock: c2},
+		{name: cMaster.Name(), version: "master"}: {lock: cMaster},
+		{name: d.Name(), version: "v1"}:           {lock: d},
+	}
+	direct := orderedOf(a, b, e)
+
+	// lexically lowest first
+	reversed := func(a, b string) bool { return a > b }
+
+	tests := []struct {
+		name    string
+		policy  VersionPolicy
+		less    func(a, b string) bool
+		winners map[string]string
+		err     bool
+	}{
+		{name: "first", policy: VersionFirst},
+		{name: "highest", policy: VersionHighest, winners: map[string]string{a.Name(): "v1", b.Name(): "v1", c1.Name(): "v1.10.0", d.Name(): "v1", e.Name(): "v1"}},
+		{name: "custom", policy: VersionHighest, less: reversed, winners: map[string]string{a.Name(): "v1", b.Name(): "v1", c1.Name(): "master", d.Name(): "v1", e.Name(): "v1"}},
+		{name: "error", policy: VersionConflictError, err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// the outcome must not depend on anything but the graph
+			for i := 0; i < 10; i++ {
+				winners, err := selectVersions(direct, downloaded, tc.policy, tc.less, nil)
+				if tc.err {
+					require.ErrorIs(t, err, VersionMismatch)
+					assert.Contains(t, err.Error(), "example.com/test/c (master, v1.2.0, v1.10.0)")
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tc.winners, winners)
+			}
+		})
+	}
+}
+
+func TestDefaultVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{a: "v1.2.0", b: "v1.10.0", less: true},
+		{a: "v1.10.0", b: "v1.2.0"},
+		{a: "v1.0.0-rc.1", b: "v1.0.0", less: true},
+		{a: "master", b: "v0.1.0", less: true},
+		{a: "v0.1.0", b: "master"},
+		{a: "release-2", b: "release-3", less: true},
+		{a: "release-3", b: "release-3"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.less, DefaultVersionLess(tc.a, tc.b), "%s < %s", tc.a, tc.b)
+	}
+}
+
+func TestEnsureResolverVersion(t *testing.T) {
+	jsf := v1.New()
+	jsf.Resolver = LatestResolver + 1
+	_, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	assert.ErrorContains(t, err, "requires resolver version")
+
+	for resolver, policy := range map[uint]VersionPolicy{0: VersionFirst, ResolverV1: VersionFirst, ResolverV2: VersionHighest} {
+		p, err := resolverVersionPolicy(resolver)
+		require.NoError(t, err)
+		assert.Equal(t, policy, p, "resolver %d", resolver)
+	}
+}
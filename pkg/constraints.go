@@ -0,0 +1,156 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// ConstraintsFile is the name of the machine-managed file pinning versions
+// of the packages of the jsonnetfile next to it
+const ConstraintsFile = "jsonnetfile.constraints.json"
+
+// ConstraintConflict is returned if a constraint doesn't fit the version the
+// jsonnetfile asks for
+var ConstraintConflict = errors.New("constraint conflicts with the jsonnetfile")
+
+// Constraints tighten or pin the versions of packages of the jsonnetfile, by
+// name. See WithConstraints.
+type Constraints map[string]string
+
+// constraintsFile is the format of the ConstraintsFile
+type constraintsFile struct {
+	Constraints Constraints `json:"constraints"`
+}
+
+// LoadConstraints reads the constraints from a ConstraintsFile
+func LoadConstraints(path string) (Constraints, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f constraintsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return f.Constraints, nil
+}
+
+// applyConstraints returns a copy of the jsonnetfile with the versions of its
+// packages replaced according to the constraints. Locks of packages pinned to
+// another commit are dropped, so the pin takes effect. All conflicts are
+// returned together.
+func applyConstraints(direct v1.JsonnetFile, c Constraints, locks *deps.Ordered) (v1.JsonnetFile, error) {
+	if len(c) == 0 {
+		return direct, nil
+	}
+
+	constrained := direct
+	constrained.Dependencies = deps.NewOrdered()
+	problems := []string{}
+	used := map[string]struct{}{}
+	for _, k := range direct.Dependencies.Keys() {
+		d, _ := direct.Dependencies.Get(k)
+		if pin, ok := c[d.Name()]; ok {
+			used[d.Name()] = struct{}{}
+			version, err := constrainVersion(d, pin)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", d.Name(), err))
+			}
+			d.Version = version
+			if lock, ok := locks.Get(d.Name()); ok && commitShaPattern.MatchString(version) && lock.Version != version {
+				locks.Delete(d.Name())
+			}
+		}
+		constrained.Dependencies.Set(k, d)
+	}
+	for name := range c {
+		if _, ok := used[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: not a package of the jsonnetfile", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return direct, fmt.Errorf("%w:\n  %s", ConstraintConflict, strings.Join(problems, "\n  "))
+	}
+	return constrained, nil
+}
+
+// constrainVersion returns the version to resolve d at, given the pin. Plain
+// refs may only be pinned to a commit. Version specs may be pinned to a
+// matching tag, their fallback branch, or tightened by another constraint,
+// which has to be satisfied as well. Tags are pinned as a version spec, so
+// the lock follows when the pin changes.
+func constrainVersion(d deps.Dependency, pin string) (string, error) {
+	if pin == d.Version {
+		return pin, nil
+	}
+
+	spec, err := parseVersionSpec(d.Version)
+	if err != nil {
+		return "", err
+	}
+	if spec == nil {
+		if commitShaPattern.MatchString(pin) {
+			return pin, nil
+		}
+		return "", fmt.Errorf("'%s' only allows pinning to a commit, not '%s'", d.Version, pin)
+	}
+
+	preReleases := d.Source.GitSource != nil && d.Source.GitSource.PreReleases
+	pinSpec, err := parseVersionSpec(pin)
+	switch {
+	case err != nil:
+		return "", err
+	case pinSpec != nil:
+		// both have to be satisfied, the fallback branch of the pin wins
+		combined := constraintPart(d.Version) + " " + constraintPart(pin)
+		branch := spec.branch
+		if pinSpec.branch != "" {
+			branch = pinSpec.branch
+		}
+		if branch != "" {
+			combined += " " + branchFallbackSeparator + " " + branch
+		}
+		return combined, nil
+	case pin == spec.branch:
+		return pin, nil
+	case commitShaPattern.MatchString(pin):
+		return "", fmt.Errorf("commit '%s' can't be checked against '%s', pin a tag instead", pin, d.Version)
+	}
+
+	v, ok := parseSemver(pin)
+	if !ok || !spec.constraint.matches(v, preReleases) {
+		return "", fmt.Errorf("'%s' is outside of '%s'", pin, d.Version)
+	}
+	return "=" + pin, nil
+}
+
+// constraintPart strips the fallback branch off a version spec
+func constraintPart(spec string) string {
+	if i := strings.LastIndex(spec, branchFallbackSeparator); i >= 0 {
+		return strings.TrimSpace(spec[:i])
+	}
+	return spec
+}
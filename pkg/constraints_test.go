@@ -0,0 +1,113 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestConstrainVersion(t *testing.T) {
+	sha := "0b2ab31b77f0ede56b660850462ff279eadcd50c"
+	tests := []struct {
+		version, pin, want string
+		conflict           bool
+	}{
+		{version: "master", pin: sha, want: sha},
+		{version: "master", pin: "master", want: "master"},
+		{version: "master", pin: "develop", conflict: true},
+		{version: "^1.0.0", pin: "v1.2.0", want: "=v1.2.0"},
+		{version: "^1.0.0", pin: "v2.0.0", conflict: true},
+		{version: "^1.0.0", pin: "~1.2.0", want: "^1.0.0 ~1.2.0"},
+		{version: "^1.0.0 || develop", pin: "~1.2.0", want: "^1.0.0 ~1.2.0 || develop"},
+		{version: "^1.0.0 || develop", pin: "develop", want: "develop"},
+		{version: "^1.0.0", pin: sha, conflict: true},
+	}
+	for _, tc := range tests {
+		got, err := constrainVersion(deps.Dependency{Source: deps.Source{GitSource: &deps.Git{}}, Version: tc.version}, tc.pin)
+		if tc.conflict {
+			assert.Error(t, err, "%s pinned to %s", tc.version, tc.pin)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got, "%s pinned to %s", tc.version, tc.pin)
+	}
+}
+
+func TestEnsureConstraints(t *testing.T) {
+	r := newTestRepo(t, "constrained")
+	first := r.commit(map[string]string{"main.libsonnet": "{ v: '1.0.0' }"})
+	r.git("tag", "v1.0.0")
+	r.commit(map[string]string{"main.libsonnet": "{ v: '1.1.0' }"})
+	r.git("tag", "v1.1.0")
+	r.commit(map[string]string{"main.libsonnet": "{ v: '2.0.0' }"})
+	r.git("tag", "v2.0.0")
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "^1.0.0"})
+	vendorDir := t.TempDir()
+	installed := func(t *testing.T) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithConstraints(Constraints{r.src.Name(): "v1.0.0"}))
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: '1.0.0' }", installed(t))
+
+	// the lock follows a changed pin
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithConstraints(Constraints{r.src.Name(): "v1.1.0"}))
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: '1.1.0' }", installed(t))
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, "tag:v1.1.0", l.Provenance)
+
+	// the jsonnetfile itself is left alone
+	d, _ := jsf.Dependencies.Get(r.src.Name())
+	assert.Equal(t, "^1.0.0", d.Version)
+
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithConstraints(Constraints{r.src.Name(): "v2.0.0"}))
+	assert.ErrorIs(t, err, ConstraintConflict)
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithConstraints(Constraints{"example.com/unknown": "v1.0.0"}))
+	assert.ErrorIs(t, err, ConstraintConflict)
+
+	// branches are pinned to a commit
+	branch := v1.New()
+	branch.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	locks, _, err = Ensure(context.TODO(), branch, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: '2.0.0' }", installed(t))
+	_, _, err = Ensure(context.TODO(), branch, vendorDir, locks, WithConstraints(Constraints{r.src.Name(): first}))
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: '1.0.0' }", installed(t))
+}
+
+func TestLoadConstraints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ConstraintsFile)
+	require.NoError(t, os.WriteFile(path, []byte(`{"constraints": {"github.com/foo/bar": "v1.2.3"}}`), 0644))
+	c, err := LoadConstraints(path)
+	require.NoError(t, err)
+	assert.Equal(t, Constraints{"github.com/foo/bar": "v1.2.3"}, c)
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// Nondeterministic is returned by Ensure, if WithDeterministic is set, when
+// resolving the same jsonnetfile twice led to different locks
+var Nondeterministic = errors.New("resolution is not deterministic")
+
+// ensureLevels is ensure, but independent of the order downloads finish in.
+// The tree is ensured level by level, with the packages of a level claimed
+// in the order they are required. So of a package required differently by
+// several packages, it is always the first requirer that is ensured, like
+// linkDownloaded would walk the tree. The packages of a level are still
+// downloaded concurrently.
+func (pd *parallelDownloader) ensureLevels(ctx context.Context, direct *deps.Ordered, vendorDir string, oldLocks *deps.Ordered) {
+	type requirer struct {
+		list   *deps.Ordered
+		parent string
+	}
+	type claim struct {
+		d      deps.Dependency
+		parent string
+	}
+
+	level := []requirer{{list: direct}}
+	for len(level) > 0 {
+		claims := []claim{}
+		for _, r := range level {
+			for _, k := range r.list.Keys() {
+				d, _ := r.list.Get(k)
+				ref := packageRef{name: d.Name(), version: d.Version}
+				if _, seen := pd.seen.LoadOrStore(ref, struct{}{}); seen {
+					continue
+				}
+				claims = append(claims, claim{d: d, parent: r.parent})
+			}
+		}
+
+		next := make([]requirer, len(claims))
+		var wg sync.WaitGroup
+		for i, c := range claims {
+			wg.Add(1)
+			go func(i int, c claim) {
+				defer wg.Done()
+				nested, parent := pd.ensurePackage(ctx, c.d, vendorDir, c.parent, oldLocks)
+				next[i] = requirer{list: nested, parent: parent}
+			}(i, c)
+		}
+		wg.Wait()
+
+		level = level[:0]
+		for _, r := range next {
+			if r.list != nil {
+				level = append(level, r)
+			}
+		}
+	}
+}
+
+// checkDeterministic resolves direct a second time, starting from the same
+// locks as the first time, and returns an error listing the packages whose
+// locks differ from the ones of the first resolution
+func checkDeterministic(ctx context.Context, direct v1.JsonnetFile, vendorDir string, before, locks *deps.Ordered, o *options, j *cacheJournal) error {
+	again, _, err := downloadAndLink(ctx, direct, vendorDir, before, o, j)
+	if err != nil {
+		return fmt.Errorf("%w: resolving again failed: %s", Nondeterministic, err)
+	}
+
+	differ := []string{}
+	if !reflect.DeepEqual(locks.Keys(), again.Keys()) {
+		differ = append(differ, fmt.Sprintf("order of packages (%s, then %s)", strings.Join(locks.Keys(), ", "), strings.Join(again.Keys(), ", ")))
+	}
+	for _, k := range locks.Keys() {
+		first, _ := locks.Get(k)
+		second, ok := again.Get(k)
+		switch {
+		case !ok:
+			differ = append(differ, fmt.Sprintf("%s (missing the second time)", k))
+		case !reflect.DeepEqual(first, second):
+			differ = append(differ, fmt.Sprintf("%s (%s@%s, then %s@%s)", k, first.Name(), first.Version, second.Name(), second.Version))
+		}
+	}
+	if len(differ) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w, locks differ for:\n  %s", Nondeterministic, strings.Join(differ, "\n  "))
+}
+
+// copyOrdered returns a shallow copy of list
+func copyOrdered(list *deps.Ordered) *deps.Ordered {
+	c := deps.NewOrdered()
+	for _, k := range list.Keys() {
+		d, _ := list.Get(k)
+		c.Set(k, d)
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureDeterministic(t *testing.T) {
+	d := newTestRepo(t, "d")
+	d.commit(map[string]string{"main.libsonnet": "{}"})
+	c := newTestRepo(t, "c")
+	c.commit(map[string]string{"main.libsonnet": "{}", "jsonnetfile.json": jsonnetfileFor(d)})
+	// a requires c without its dependencies, b with them, so what is
+	// installed depends on which of both is ensured first
+	a := newTestRepo(t, "a")
+	a.commit(map[string]string{"main.libsonnet": "{}", "jsonnetfile.json": fmt.Sprintf(`{"version": 1, "dependencies": [{"source": {"git": {"remote": "%s"}}, "version": "master", "single": true}]}`, c.src.Remote())})
+	b := newTestRepo(t, "b")
+	b.commit(map[string]string{"main.libsonnet": "{}", "jsonnetfile.json": jsonnetfileFor(c)})
+
+	jsf := v1.New()
+	for _, r := range []*testRepo{a, b} {
+		jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	}
+
+	var first *deps.Ordered
+	for i := 0; i < 20; i++ {
+		locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithDeterministic(true))
+		require.NoError(t, err)
+		if first == nil {
+			first = locks
+			continue
+		}
+		assert.Equal(t, first.Keys(), locks.Keys(), "install %d", i)
+	}
+	// c is required by a first
+	assert.Equal(t, []string{a.src.Name(), c.src.Name(), b.src.Name()}, first.Keys())
+}
+
+func TestCheckDeterministic(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	jsf := v1.New()
+	jsf.Dependencies.Set(a.Name(), a)
+
+	// the lock of a second resolution doesn't match the one passed
+	other := a
+	other.Version = "v2"
+	err := checkDeterministic(context.TODO(), jsf, vendorDir, orderedOf(a), orderedOf(other), newOptions(nil), nil)
+	require.ErrorIs(t, err, Nondeterministic)
+	assert.ErrorContains(t, err, a.Name()+"@v2, then "+a.Name()+"@v1")
+}
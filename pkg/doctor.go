@@ -0,0 +1,177 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// Severity classifies how serious a Finding is
+type Severity string
+
+const (
+	// SeverityError findings break imports or integrity
+	SeverityError Severity = "error"
+	// SeverityWarning findings are leftovers that Ensure would clean up
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single problem found by Doctor
+type Finding struct {
+	Severity Severity
+	// Package is the name of the affected package, if any
+	Package string
+	// Path is the affected path, if any
+	Path    string
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", strings.ToUpper(string(f.Severity)), f.Message)
+}
+
+// Doctor audits the vendor directory against the jsonnetfile and the lock.
+// It reports dangling symlinks, directories unknown to the lock, locked
+// packages missing from vendor, checksum mismatches, stale legacy symlinks and
+// local sources pointing to nonexistent paths.
+// Nothing is modified.
+func Doctor(jsf v1.JsonnetFile, vendorDir string, locks *deps.Ordered, opts ...Option) []Finding {
+	o := newOptions(opts)
+	findings := []Finding{}
+	add := func(s Severity, pkg, path, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: s, Package: pkg, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// local sources
+	for _, k := range jsf.Dependencies.Keys() {
+		d, _ := jsf.Dependencies.Get(k)
+		if d.Source.LocalSource == nil {
+			continue
+		}
+		if _, err := os.Stat(d.Source.LocalSource.Directory); err != nil {
+			add(SeverityError, d.Name(), d.Source.LocalSource.Directory, "local source of %s does not exist: %s", d.Name(), d.Source.LocalSource.Directory)
+		}
+	}
+
+	// locked packages
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		dir := filepath.Join(vendorDir, o.vendorPrefix, d.Name())
+		if _, err := os.Stat(dir); err != nil {
+			add(SeverityError, d.Name(), dir, "%s@%s is locked but missing from vendor", d.Name(), d.Version)
+			continue
+		}
+		if d.Source.LocalSource != nil || d.Sum == "" {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		sum, skipped, err := hashPackage(resolved, o.packageHashConfig(d))
+		if err != nil {
+			add(SeverityError, d.Name(), dir, "unable to compute checksum of %s@%s: %s", d.Name(), d.Version, err)
+			continue
+		}
+		if len(skipped) > 0 {
+			add(SeverityWarning, d.Name(), dir, "checksum of %s@%s is partial, skipped unreadable files: %s", d.Name(), d.Version, strings.Join(skipped, ", "))
+		}
+		if sum != d.Sum {
+			add(SeverityError, d.Name(), dir, "checksum mismatch for %s@%s", d.Name(), d.Version)
+		}
+	}
+
+	// contents of vendor
+	wantLinks := map[string]string{}
+	if jsf.LegacyImports || o.legacyPrimary {
+		for _, l := range legacyLinks(locks, o.vendorPrefix) {
+			if _, ok := wantLinks[l.legacyName]; !ok {
+				wantLinks[l.legacyName] = l.pkgName
+			}
+		}
+	}
+	pkgNames := map[string]struct{}{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		pkgNames[filepath.ToSlash(filepath.Join(o.vendorPrefix, d.Name()))] = struct{}{}
+	}
+
+	err := filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == vendorDir {
+			return nil
+		}
+		if path == filepath.Join(vendorDir, ".cache") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(vendorDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		_, isPkg := pkgNames[rel]
+
+		if i.Mode()&os.ModeSymlink != 0 {
+			if _, err := os.Stat(path); err != nil {
+				add(SeverityError, "", path, "dangling symlink %s", path)
+				return nil
+			}
+			if isPkg {
+				return nil
+			}
+			if target, ok := wantLinks[rel]; ok {
+				// primary legacy names link to the cache instead
+				if o.legacyPrimary {
+					return nil
+				}
+				if link, err := os.Readlink(path); err == nil && filepath.ToSlash(link) == target {
+					return nil
+				}
+			}
+			switch {
+			case !strings.Contains(rel, "/"):
+				add(SeverityWarning, "", path, "stale legacy symlink %s", path)
+			case !known(locks, o.vendorPrefix, rel):
+				add(SeverityWarning, "", path, "%s is not part of the lock", path)
+			}
+			return nil
+		}
+
+		if !i.IsDir() {
+			return nil
+		}
+		if isPkg {
+			return filepath.SkipDir
+		}
+		if !known(locks, o.vendorPrefix, rel) {
+			add(SeverityWarning, "", path, "%s is not part of the lock", path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		add(SeverityError, "", vendorDir, "unable to inspect vendor: %s", err)
+	}
+
+	return findings
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// vendorPackage places d into the cache of vendorDir, links it into vendor
+// and returns it with the sum set
+func vendorPackage(t *testing.T, vendorDir string, d deps.Dependency, files map[string]string) deps.Dependency {
+	t.Helper()
+	cp := filepath.Join(cachePath(vendorDir, d), d.Name())
+	for name, content := range files {
+		p := filepath.Join(cp, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	sum, err := hashDir(context.TODO(), cp, hashConfig{})
+	require.NoError(t, err)
+	d.Sum = sum
+
+	dest := filepath.Join(vendorDir, d.Name())
+	require.NoError(t, os.MkdirAll(filepath.Dir(dest), os.ModePerm))
+	require.NoError(t, os.Symlink(cp, dest))
+	return d
+}
+
+func TestDoctor(t *testing.T) {
+	vendorDir := t.TempDir()
+
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	c := vendorPackage(t, vendorDir, testDep("c", "v1"), map[string]string{"c.libsonnet": "{}"})
+	c.Sum = "invalid"
+	b := testDep("b", "v1")
+	locks := orderedOf(a, b, c)
+
+	require.NoError(t, os.Symlink(a.Name(), filepath.Join(vendorDir, "a")))
+	require.NoError(t, os.Symlink("example.com/test/gone", filepath.Join(vendorDir, "gone")))
+	require.NoError(t, os.Symlink(a.Name(), filepath.Join(vendorDir, "stale")))
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, "example.com", "other"), os.ModePerm))
+
+	jsf := v1.New()
+	jsf.Dependencies.Set("local", deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "does/not/exist"}}})
+
+	msgs := []string{}
+	for _, f := range Doctor(jsf, vendorDir, locks) {
+		msgs = append(msgs, f.String())
+	}
+
+	assert.ElementsMatch(t, []string{
+		"ERROR: local source of exist does not exist: does/not/exist",
+		"ERROR: example.com/test/b@v1 is locked but missing from vendor",
+		"ERROR: checksum mismatch for example.com/test/c@v1",
+		"ERROR: dangling symlink " + filepath.Join(vendorDir, "gone"),
+		"WARNING: stale legacy symlink " + filepath.Join(vendorDir, "stale"),
+		"WARNING: " + filepath.Join(vendorDir, "example.com", "other") + " is not part of the lock",
+	}, msgs)
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// DriftPolicy controls what happens if a package downloaded again for its
+// lock does not match the locked sum. Packages pinned to a tag or commit
+// always fail with IntegrityFailure, as their content must never change.
+// The policy only applies to packages following a branch, whose lock may
+// just be stale.
+type DriftPolicy int
+
+const (
+	// DriftFail fails with IntegrityFailure for all packages
+	DriftFail DriftPolicy = iota
+	// DriftAccept takes the new content of packages following a branch and
+	// locks its sum
+	DriftAccept
+	// DriftRelock installs packages following a branch again at the tip of
+	// the branch and locks that
+	DriftRelock
+)
+
+// drifted decides about the download l of d not matching the sum of lock.
+// It returns the download to lock instead, if the policy allows one.
+func (pd *parallelDownloader) drifted(ctx context.Context, d deps.Dependency, requested string, lock deps.Dependency, l *deps.Dependency, cp, pathToParentModule string) (*deps.Dependency, error) {
+	branch := followedBranch(ctx, d, requested, lock)
+	if branch == "" || pd.opts.drift == DriftFail {
+		return nil, fmt.Errorf("%w for %s@%s: expected %s, got %s; its content changed although it is pinned, it may have been tampered with", IntegrityFailure, d.Name(), d.Version, lock.Sum, l.Sum)
+	}
+
+	if pd.opts.drift == DriftAccept {
+		warn(ctx, WarningMovingRef, d.Name(), "%s following %s changed, locking the new content", d.Name(), branch)
+		return l, nil
+	}
+
+	// resolve what was asked for again, instead of the locked commit
+	warn(ctx, WarningMovingRef, d.Name(), "%s following %s changed, locking the tip of %s", d.Name(), branch, branch)
+	d.Version = requested
+	d.Provenance = ""
+	d.Requested = ""
+	return pd.fetch(ctx, d, cp, pathToParentModule)
+}
+
+// followedBranch returns the branch the git package of d follows, if any.
+// That is the fallback branch a version spec was resolved to, or the
+// requested version itself if it is a branch of the remote.
+func followedBranch(ctx context.Context, d deps.Dependency, requested string, lock deps.Dependency) string {
+	if strings.HasPrefix(lock.Provenance, "branch:") {
+		return strings.TrimPrefix(lock.Provenance, "branch:")
+	}
+	if d.Source.GitSource == nil || lock.Provenance != "" || commitShaPattern.MatchString(requested) || isSemverConstraint(requested) {
+		return ""
+	}
+
+	refs, err := listRemoteRefs(ctx, d.Source.GitSource.Remote(), d.Source.GitSource.ProtocolVersion)
+	if err != nil {
+		return ""
+	}
+	if _, _, ok := selectRef(refs, refsHeadsPrefix+requested); ok {
+		return requested
+	}
+	return ""
+}
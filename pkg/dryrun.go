@@ -0,0 +1,55 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// dryRun runs Ensure in a scratch directory instead of vendorDir, see
+// WithDryRun. direct has its registry sources and constraints applied
+// already. How vendor would change is recorded in ctx.
+func dryRun(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered, o *options, opts []Option) (*deps.Ordered, error) {
+	scratch, err := newScratchVendor(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	opts = append(opts[:len(opts):len(opts)], WithDryRun(false), WithConstraints(nil))
+	locks, err := ensure(ctx, direct, scratch, copyOrdered(oldLocks), opts...)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := diffVendors(vendorDir, scratch, o.vendorPrefix, oldLocks, locks)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := ctx.Value(diffsKey{}).(*[]PackageDiff); ok {
+		*d = diffs
+	}
+	return locks, nil
+}
+
+type diffsKey struct{}
+
+// withDiffs returns ctx recording the changes of a dry run to d
+func withDiffs(ctx context.Context, d *[]PackageDiff) context.Context {
+	return context.WithValue(ctx, diffsKey{}, d)
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureDryRun(t *testing.T) {
+	a := newTestRepo(t, "a")
+	a.commit(map[string]string{"main.libsonnet": "{}"})
+	b := newTestRepo(t, "b")
+	b.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(a.src.Name(), deps.Dependency{Source: deps.Source{GitSource: a.src}, Version: "master"})
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	// nothing is downloaded if vendor matches the lock
+	require.NoError(t, os.RemoveAll(a.dir))
+	result, err := EnsureWithResult(context.TODO(), jsf, vendorDir, locks, WithDryRun(true))
+	require.NoError(t, err)
+	assert.Equal(t, locks.Keys(), result.Locks.Keys())
+	assert.Empty(t, result.Diffs)
+	assert.False(t, result.Changed())
+
+	// neither vendor nor the lock change if it doesn't
+	before := copyOrdered(locks)
+	jsf.Dependencies.Set(b.src.Name(), deps.Dependency{Source: deps.Source{GitSource: b.src}, Version: "master"})
+	result, err = EnsureWithResult(context.TODO(), jsf, vendorDir, locks, WithDryRun(true))
+	require.NoError(t, err)
+	assert.Equal(t, []string{a.src.Name(), b.src.Name()}, result.Locks.Keys())
+	assert.Equal(t, []string{b.src.Name()}, result.Added)
+	require.Len(t, result.Diffs, 1)
+	assert.Equal(t, b.src.Name(), result.Diffs[0].Name)
+	assert.Equal(t, []string{"main.libsonnet"}, result.Diffs[0].Added)
+	assert.Equal(t, before, locks)
+	assert.NoDirExists(t, filepath.Join(vendorDir, b.src.Name()))
+	entries, err := os.ReadDir(filepath.Join(vendorDir, ".cache"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// not even the cache is created for nothing to install
+	empty := filepath.Join(t.TempDir(), "vendor")
+	_, _, err = Ensure(context.TODO(), v1.New(), empty, nil, WithDryRun(true))
+	require.NoError(t, err)
+	assert.NoDirExists(t, empty)
+}
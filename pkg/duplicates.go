@@ -0,0 +1,63 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// Duplicate is a group of packages with identical contents, likely the same
+// upstream library vendored under different names
+type Duplicate struct {
+	Sum      string
+	Packages []string
+}
+
+// FindDuplicates groups the locked packages by their checksum and returns all
+// groups with more than one package, ordered by sum. Packages without a sum,
+// like local ones, are ignored.
+func FindDuplicates(locks *deps.Ordered) []Duplicate {
+	bySum := map[string][]string{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Sum == "" {
+			continue
+		}
+		bySum[d.Sum] = append(bySum[d.Sum], d.Name())
+	}
+
+	dups := []Duplicate{}
+	for sum, names := range bySum {
+		if len(names) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		dups = append(dups, Duplicate{Sum: sum, Packages: names})
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		return dups[i].Sum < dups[j].Sum
+	})
+	return dups
+}
+
+// reportDuplicates prints all packages with identical contents
+func reportDuplicates(ctx context.Context, locks *deps.Ordered) {
+	for _, d := range FindDuplicates(locks) {
+		logger(ctx).Duplicate(d.Sum, d.Packages)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	withSum := func(repo, sum string) deps.Dependency {
+		d := testDep(repo, "v1")
+		d.Sum = sum
+		return d
+	}
+	locks := orderedOf(
+		withSum("mirror", "x"),
+		withSum("a", "y"),
+		withSum("upstream", "x"),
+		withSum("b", "z"),
+		withSum("c", "y"),
+		withSum("fork", "x"),
+		withSum("local", ""),
+		withSum("other-local", ""),
+	)
+
+	assert.Equal(t, []Duplicate{
+		{Sum: "x", Packages: []string{"example.com/test/fork", "example.com/test/mirror", "example.com/test/upstream"}},
+		{Sum: "y", Packages: []string{"example.com/test/a", "example.com/test/c"}},
+	}, FindDuplicates(locks))
+	assert.Empty(t, FindDuplicates(orderedOf(withSum("a", "x"))))
+}
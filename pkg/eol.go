@@ -0,0 +1,53 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// normalizeLineEndings converts CRLF to LF, unless data is binary
+func normalizeLineEndings(data []byte) []byte {
+	if isBinaryContent(data) {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
+
+// normalizeDirLineEndings rewrites all text files below dir with LF line
+// endings
+func normalizeDirLineEndings(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Mode()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		normalized := normalizeLineEndings(data)
+		if bytes.Equal(data, normalized) {
+			return nil
+		}
+		return os.WriteFile(path, normalized, info.Mode().Perm())
+	})
+}
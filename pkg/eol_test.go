@@ -0,0 +1,76 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+var binaryCRLF = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, '\r', '\n'}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	assert.Equal(t, "{\n  a: 1,\n}\n", string(normalizeLineEndings([]byte("{\r\n  a: 1,\r\n}\r\n"))))
+	assert.Equal(t, binaryCRLF, normalizeLineEndings(binaryCRLF))
+}
+
+func TestHashDirLineEndings(t *testing.T) {
+	write := func(content string) string {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.libsonnet"), []byte(content), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "logo.png"), binaryCRLF, 0644))
+		return dir
+	}
+	lf, crlf := write("{\n}\n"), write("{\r\n}\r\n")
+
+	sum := func(dir string, hc hashConfig) string {
+		s, err := hashDir(context.TODO(), dir, hc)
+		require.NoError(t, err)
+		return s
+	}
+	assert.NotEqual(t, sum(lf, hashConfig{}), sum(crlf, hashConfig{}))
+	assert.Equal(t, sum(lf, hashConfig{normalizeEOL: true}), sum(crlf, hashConfig{normalizeEOL: true}))
+}
+
+func TestEnsureNormalizeLineEndings(t *testing.T) {
+	r := newTestRepo(t, "eol")
+	r.git("config", "core.autocrlf", "false")
+	r.commit(map[string]string{"main.libsonnet": "{\r\n}\r\n"})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithNormalizeLineEndings(true))
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\n}\n", string(b))
+
+	// CRLF reintroduced by the platform does not break the check
+	lock, _ := locks.Get(r.src.Name())
+	cp := cachePath(vendorDir, deps.Dependency{Source: lock.Source, Version: "master"})
+	require.NoError(t, os.WriteFile(filepath.Join(cp, r.src.Name(), "main.libsonnet"), []byte("{\r\n}\r\n"), 0644))
+	assert.True(t, check(context.TODO(), lock, cp, newOptions([]Option{WithNormalizeLineEndings(true)})))
+	assert.False(t, check(context.TODO(), lock, cp, newOptions(nil)))
+}
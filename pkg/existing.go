@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// UnmanagedDirectory is returned if a package would replace a directory in
+// vendor that jb did not create and ExistingError is set
+var UnmanagedDirectory = errors.New("directory is not managed by jb")
+
+// ExistingPolicy controls what happens if a package is about to be linked to
+// a path in vendor that holds a real directory instead of a symlink
+type ExistingPolicy int
+
+const (
+	// ExistingOverwrite removes the directory and links the package in its
+	// place
+	ExistingOverwrite ExistingPolicy = iota
+	// ExistingError fails if the directory is not managed by jb
+	ExistingError
+	// ExistingSkip keeps a directory not managed by jb and does not link the
+	// package
+	ExistingSkip
+)
+
+// existingDirs decides about real directories found where packages are to
+// be linked. Directories of packages in the lock from before Ensure, which
+// can be copies made by materialize, are managed by jb and always replaced.
+type existingDirs struct {
+	policy  ExistingPolicy
+	managed map[string]struct{}
+}
+
+func newExistingDirs(p ExistingPolicy, locks *deps.Ordered) existingDirs {
+	managed := make(map[string]struct{}, len(locks.Keys()))
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		managed[d.Name()] = struct{}{}
+	}
+	return existingDirs{policy: p, managed: managed}
+}
+
+// replace reports whether whatever is at dest may be replaced by the package
+// name. It is true if there is nothing or a symlink.
+func (e existingDirs) replace(ctx context.Context, name, dest string) (bool, error) {
+	fi, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 || e.policy == ExistingOverwrite {
+		return true, nil
+	}
+	if _, ok := e.managed[name]; ok {
+		return true, nil
+	}
+
+	if e.policy == ExistingSkip {
+		warn(ctx, WarningUnmanaged, name, "keeping %s, which is not managed by jb, instead of installing %s", dest, name)
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %s is in the way of %s, remove it or move it elsewhere", UnmanagedDirectory, dest, name)
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// fallback downloads d at the first of its fallback versions that can be
+// installed, after installing its version failed with err. The fallback used
+// is recorded in the returned lock. err is returned as is if d has no
+// fallbacks, they are disabled by WithStrictVersions or none of them works.
+func (pd *parallelDownloader) fallback(ctx context.Context, d deps.Dependency, cp, pathToParentModule string, err error) (*deps.Dependency, error) {
+	if len(d.Fallbacks) == 0 || pd.opts.strictVersion {
+		return nil, err
+	}
+
+	failed := d.Version
+	for _, version := range d.Fallbacks {
+		warn(ctx, WarningFallback, d.Name(), "failed to install %s@%s, falling back to %s: %s", d.Name(), failed, version, err)
+		pd.opts.trace.printf("fallback %s@%s: %s", d.Name(), failed, version)
+		fd := d
+		fd.Version = version
+		fd.Provenance = ""
+		fd.Requested = ""
+		fd.Fallback = version
+		l, ferr := pd.fetch(ctx, fd, cp, pathToParentModule)
+		if ferr == nil {
+			return l, nil
+		}
+		failed, err = version, ferr
+	}
+	return nil, err
+}
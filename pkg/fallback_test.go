@@ -0,0 +1,66 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureFallback(t *testing.T) {
+	r := newTestRepo(t, "retagged")
+	old := r.commit(map[string]string{"main.libsonnet": "{ v: '1.0.0' }"})
+	r.git("tag", "v1.0.0")
+	r.commit(map[string]string{"main.libsonnet": "{ v: '1.1.0' }"})
+	r.git("tag", "v1.1.0")
+	// the primary tag is deleted upstream
+	r.git("tag", "-d", "v1.1.0")
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{
+		Source:    deps.Source{GitSource: r.src},
+		Version:   "v1.1.0",
+		Fallbacks: []string{"v1.0.1", "v1.0.0"},
+	})
+
+	_, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithStrictVersions(true))
+	assert.Error(t, err)
+
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: '1.0.0' }", string(b))
+
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, old, l.Version)
+	assert.Equal(t, "v1.0.0", l.Fallback)
+	assert.Empty(t, l.Fallbacks)
+
+	// the lock keeps recording the fallback
+	again, _, err := Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	l, _ = again.Get(r.src.Name())
+	assert.Equal(t, "v1.0.0", l.Fallback)
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// commitFile records the commit of a cache entry and when it was authored,
+// as "<sha> <RFC 3339 date>". It is written next to the package, if the
+// clone was at hand while downloading.
+const commitFile = ".commit"
+
+// FreshnessInfo describes how long ago the locked commit of a package was
+// authored
+type FreshnessInfo struct {
+	Name    string
+	Version string
+	Date    time.Time
+	Age     time.Duration
+}
+
+// Freshness reports the author dates of the locked commits of all git
+// packages, oldest first. Dates recorded in the cache below vendorDir are
+// used if available, all others are fetched from the remote without
+// checking out any files.
+func Freshness(ctx context.Context, vendorDir string, locks *deps.Ordered) ([]FreshnessInfo, error) {
+	cached := cachedCommitDates(filepath.Join(vendorDir, ".cache"))
+	now := time.Now()
+
+	infos := []FreshnessInfo{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.GitSource == nil {
+			continue
+		}
+
+		date, ok := cached[d.Version]
+		if !ok {
+			var err error
+			date, err = remoteCommitDate(ctx, d.Source.GitSource, d.Version)
+			if err != nil {
+				return nil, fmt.Errorf("unable to find the date of %s@%s: %w", d.Name(), d.Version, err)
+			}
+		}
+		infos = append(infos, FreshnessInfo{Name: d.Name(), Version: d.Version, Date: date, Age: now.Sub(date)})
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Date.Before(infos[j].Date)
+	})
+	return infos, nil
+}
+
+// writeCommitDate records the commit of the cache entry at cp
+func writeCommitDate(cp, sha string, date time.Time) error {
+	return os.WriteFile(filepath.Join(cp, commitFile), []byte(sha+" "+date.Format(time.RFC3339)+"\n"), 0644)
+}
+
+// cachedCommitDates returns the dates of all commits recorded in the cache,
+// by sha
+func cachedCommitDates(cacheDir string) map[string]time.Time {
+	dates := map[string]time.Time{}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return dates
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(cacheDir, e.Name(), commitFile))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			continue
+		}
+		dates[fields[0]] = date
+	}
+	return dates
+}
+
+// remoteCommitDate fetches just the commit from the remote to read its
+// author date. Servers not supporting partial clones send the tree as well.
+func remoteCommitDate(ctx context.Context, source *deps.Git, sha string) (time.Time, error) {
+	dir, err := os.MkdirTemp("", "jb-commit-")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) (string, error) {
+		b := &bytes.Buffer{}
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Stdout = b
+		if !GitQuiet {
+			cmd.Stderr = os.Stderr
+		}
+		err := cmd.Run()
+		return strings.TrimSpace(b.String()), err
+	}
+
+	if _, err := git("init", "--quiet", "--bare"); err != nil {
+		return time.Time{}, err
+	}
+	protocol := gitProtocolArgs(source.ProtocolVersion)
+	if _, err := git(append(protocol, "fetch", "--quiet", "--depth", "1", "--filter=tree:0", source.Remote(), sha)...); err != nil {
+		if _, err := git(append(protocol, "fetch", "--quiet", "--depth", "1", source.Remote(), sha)...); err != nil {
+			return time.Time{}, err
+		}
+	}
+	out, err := git("log", "-1", "--format=%aI", sha)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, out)
+}
+
+// commitDate returns the author date of the commit checked out in dir
+func commitDate(ctx context.Context, dir string) (time.Time, error) {
+	b := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "git", "log", "-1", "--format=%aI", "HEAD")
+	cmd.Dir = dir
+	cmd.Stdout = b
+	if err := cmd.Run(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(b.String()))
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestFreshness(t *testing.T) {
+	commitAt := func(r *testRepo, date string) string {
+		r.commit(map[string]string{"main.libsonnet": "{}"})
+		r.git("commit", "--amend", "--no-edit", "--date", date)
+		return r.git("rev-parse", "HEAD")
+	}
+	fresh := newTestRepo(t, "fresh")
+	commitAt(fresh, "2022-05-01T00:00:00Z")
+	old := newTestRepo(t, "old")
+	oldSha := commitAt(old, "2019-01-01T12:00:00Z")
+
+	// the fresh one is in the cache
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(fresh.src.Name(), deps.Dependency{Source: deps.Source{GitSource: fresh.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	freshLock, _ := locks.Get(fresh.src.Name())
+
+	// the old one needs to be looked up remotely
+	locks.Set(old.src.Name(), deps.Dependency{Source: deps.Source{GitSource: old.src}, Version: oldSha})
+	locks.Set("local", deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "local"}}})
+
+	infos, err := Freshness(context.TODO(), vendorDir, locks)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, old.src.Name(), infos[0].Name)
+	assert.Equal(t, oldSha, infos[0].Version)
+	assert.True(t, infos[0].Date.Equal(time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)), infos[0].Date)
+	assert.Equal(t, fresh.src.Name(), infos[1].Name)
+	assert.True(t, infos[1].Date.Equal(time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)), infos[1].Date)
+	assert.Greater(t, infos[0].Age, infos[1].Age)
+
+	// the cached date is used without asking the remote
+	require.NoError(t, os.RemoveAll(fresh.dir))
+	cached := cachedCommitDates(filepath.Join(vendorDir, ".cache"))
+	assert.Contains(t, cached, freshLock.Version)
+	_, err = Freshness(context.TODO(), vendorDir, orderedOf(freshLock))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// moveDir renames src to dst. If that is not possible because they are
+// located on different filesystems, src is copied and removed instead.
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) || !isCrossDevice(linkErr.Err) {
+		return err
+	}
+
+	if err := copyDir(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// emptyDir removes everything inside of dir, but keeps dir itself
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDir recursively copies the directory src to dst. Symlinks are copied as
+// symlinks.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// warnStagingFilesystem warns if packages staged in stagingDir can not be
+// moved atomically into the cache of vendorDir
+func warnStagingFilesystem(ctx context.Context, stagingDir, vendorDir string) {
+	if stagingDir == "" {
+		return
+	}
+	if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+		return
+	}
+	// the cache doesn't exist before the first install, but will be created
+	// on the filesystem of its closest existing parent
+	same, err := sameFilesystem(stagingDir, existingAncestor(filepath.Join(vendorDir, ".cache")))
+	if err != nil || same {
+		return
+	}
+	warn(ctx, WarningStaging, "", "staging dir '%s' is on a different filesystem than '%s', packages will be copied instead of moved", stagingDir, vendorDir)
+}
+
+// existingAncestor returns p if it exists, or its closest parent that does
+func existingAncestor(p string) string {
+	for {
+		if _, err := os.Stat(p); err == nil || filepath.Dir(p) == p {
+			return p
+		}
+		p = filepath.Dir(p)
+	}
+}
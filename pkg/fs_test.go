@@ -0,0 +1,33 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExistingAncestor(t *testing.T) {
+	dir := t.TempDir()
+	vendorDir := filepath.Join(dir, "vendor")
+	assert.Equal(t, dir, existingAncestor(filepath.Join(vendorDir, ".cache")))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, ".cache"), os.ModePerm))
+	assert.Equal(t, filepath.Join(vendorDir, ".cache"), existingAncestor(filepath.Join(vendorDir, ".cache")))
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package pkg
+
+import (
+	"os"
+	"syscall"
+)
+
+func isCrossDevice(err error) bool {
+	return err == syscall.EXDEV
+}
+
+// sameFilesystem returns whether both paths are located on the same device
+func sameFilesystem(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+
+	sa, okA := ia.Sys().(*syscall.Stat_t)
+	sb, okB := ib.Sys().(*syscall.Stat_t)
+	if !okA || !okB {
+		return true, nil
+	}
+	return sa.Dev == sb.Dev, nil
+}
+
+// fileID returns the inode of the file
+func fileID(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ERROR_NOT_SAME_DEVICE
+const errNotSameDevice syscall.Errno = 17
+
+func isCrossDevice(err error) bool {
+	return err == errNotSameDevice
+}
+
+// sameFilesystem returns whether both paths are located on the same volume
+func sameFilesystem(a, b string) (bool, error) {
+	aa, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	ab, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(filepath.VolumeName(aa), filepath.VolumeName(ab)), nil
+}
+
+// fileID is not available from the FileInfo on windows
+func fileID(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -22,22 +22,65 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/pkg/errors"
 
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
 )
 
+// rootJsonnetfile is where the jsonnetfile of the repository root is kept
+// next to the package, if the source asks for it
+const rootJsonnetfile = "jsonnetfile.root.json"
+
 type GitPackage struct {
 	Source *deps.Git
+
+	// StagingDir is where downloads are prepared before being moved into
+	// place. Defaults to the directory the package is installed to.
+	StagingDir string
+	// Retry controls how failed archive downloads are retried. Defaults to
+	// DefaultHTTPRetryPolicy.
+	Retry *HTTPRetryPolicy
+	// Limit caps the bandwidth of archive downloads. Fetches done by git
+	// itself are not limited. Defaults to unlimited.
+	Limit *RateLimiter
+	// Protocol forces the git protocol version used to talk to the remote,
+	// unless Source.ProtocolVersion does. Empty lets git decide.
+	Protocol string
+	// Precedence decides between a tag and a branch of the same name.
+	// Defaults to RefTagsFirst.
+	Precedence RefPrecedence
+
+	// checkouts shares the trees of commits among the Subdir packages of a
+	// repository, so they are fetched only once. Optional.
+	checkouts *sharedCheckouts
+	// tags lists the tags of version specs using the API of the host.
+	// Optional.
+	tags *tagAPI
+
+	// date is when the installed commit was authored, if the clone was at
+	// hand
+	date time.Time
+	// matched is the provenance of a version naming both a tag and a
+	// branch, as resolved last
+	matched string
+	// tag is the only tag verified against Source.TagKeyring, see
+	// verifiedTag. Empty uses the version Install is called with, unless it
+	// is a commit.
+	tag string
+	// subdir is the candidate of Source.Subdirs installed last
+	subdir string
+	// stderr collects what git reports, to tell transient failures apart.
+	// Optional.
+	stderr *bytes.Buffer
 }
 
 func NewGitPackage(source *deps.Git) Interface {
@@ -46,40 +89,51 @@ func NewGitPackage(source *deps.Git) Interface {
 	}
 }
 
-var GitQuiet = false
-
-func downloadGitHubArchive(filepath string, url string) error {
-	// Get the data
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	if !GitQuiet {
-		color.Cyan("GET %s %d", url, resp.StatusCode)
+// Resolve resolves the version using the references of the remote.
+// Commit-ish expressions are returned as they are, as they can only be
+// resolved against the fetched history by Install.
+func (p *GitPackage) Resolve(ctx context.Context, version string) (string, string, error) {
+	// the object is as precise as it gets, there is nothing to resolve
+	if p.Source.Object != "" {
+		return p.Source.Object, "", nil
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	if isCommitish(version) {
+		return version, "", nil
 	}
+	sha, tag, matched, err := resolveVersion(ctx, p.Source, version, p.protocol(), p.tags, p.Precedence)
+	p.matched = matched
+	return sha, tag, err
+}
 
-	defer resp.Body.Close()
-
-	// Create the file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
+func (p *GitPackage) protocol() string {
+	if p.Source.ProtocolVersion != "" {
+		return p.Source.ProtocolVersion
 	}
-	defer out.Close()
+	return p.Protocol
+}
+
+var GitQuiet = false
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
+// extractGitHubArchive streams the archive at url straight into dst, without
+// storing the archive itself on disk
+func extractGitHubArchive(ctx context.Context, retry *HTTPRetryPolicy, limit *RateLimiter, dst, url, subDir string) error {
+	resp, err := httpGet(ctx, nil, retry, limit, url)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	return nil
+	return gzipUntar(dst, resp.Body, subDir)
 }
 
 func gzipUntar(dst string, r io.Reader, subDir string) error {
+	return gzipUntarStrip(dst, r, subDir, true)
+}
+
+// gzipUntarStrip extracts the gzipped tarball to dst. If strip is set, the
+// first component of all paths is dropped, like the directory GitHub wraps
+// archives in.
+func gzipUntarStrip(dst string, r io.Reader, subDir string, strip bool) error {
 	gzr, err := gzip.NewReader(r)
 	if err != nil {
 		return err
@@ -101,23 +155,31 @@ func gzipUntar(dst string, r io.Reader, subDir string) error {
 			continue
 		}
 
-		// strip the two first components of the path
-		parts := strings.SplitAfterN(header.Name, "/", 2)
-		if len(parts) < 2 {
-			continue
+		// strip the first component of the path
+		suffix := header.Name
+		if strip {
+			parts := strings.SplitAfterN(header.Name, "/", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			suffix = parts[1]
 		}
-		suffix := parts[1]
 		prefix := dst
 
 		// reconstruct the target parh for the archive entry
 		target := filepath.Join(prefix, suffix)
 
-		// if subdir is provided and target is not under it, skip it
+		// if subdir is provided and target is not under it, skip it.
+		// The root jsonnetfile is always kept, as it may be needed.
 		subDirPath := filepath.Join(prefix, subDir)
-		if subDir != "" && !strings.HasPrefix(target, subDirPath) {
+		if subDir != "" && !strings.HasPrefix(target, subDirPath) && suffix != jsonnetfile.File {
 			continue
 		}
 
+		if _, err := archiveTarget(dst, suffix); err != nil {
+			return err
+		}
+
 		// check the file type
 		switch header.Typeflag {
 
@@ -151,6 +213,9 @@ func gzipUntar(dst string, r io.Reader, subDir string) error {
 			}
 
 		case tar.TypeSymlink:
+			if err := archiveLink(dst, target, header.Linkname); err != nil {
+				return err
+			}
 			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
 				return err
 			}
@@ -162,109 +227,159 @@ func gzipUntar(dst string, r io.Reader, subDir string) error {
 	}
 }
 
-func remoteResolveRef(ctx context.Context, remote string, ref string) (string, error) {
-	b := &bytes.Buffer{}
-	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", "--tags", "--refs", "--quiet", remote, ref)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = b
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		return "", err
-	}
-	commitShaPattern := regexp.MustCompile("^([0-9a-f]{40,})\\b")
-	commitSha := commitShaPattern.FindString(b.String())
-	return commitSha, nil
-}
-
 var (
 	githubRegex      = regexp.MustCompile(`^(https|ssh)://github\.com/.+$`)
 	commitShaPattern = regexp.MustCompile("^([0-9a-f]{40,})$")
+	abbrevShaPattern = regexp.MustCompile("^([0-9a-f]{4,})$")
 )
 
 func (p *GitPackage) Install(ctx context.Context, name, dir, version string) (string, error) {
 	destPath := path.Join(dir, name)
 
-	tmpDir, err := os.MkdirTemp(dir, ".tmp-")
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create tmp dir")
+	if p.Source.Object != "" {
+		return p.installObject(ctx, name, dir)
 	}
-	defer os.RemoveAll(tmpDir)
 
-	// Optimization for GitHub sources: download a tarball archive of the requested
-	// version instead of cloning the entire
-	isGitHubRemote := githubRegex.MatchString(p.Source.Remote())
-	if isGitHubRemote {
-		// Let git ls-remote decide if "version" is a ref or a commit SHA in the unlikely
-		// but possible event that a ref is comprised of 40 or more hex characters
-		commitSha, err := remoteResolveRef(ctx, p.Source.Remote(), version)
-		if commitSha == "" && version == "master" {
-			color.Yellow("WARN: ref 'master' resolved to empty string for %s, retrying with 'main'", p.Source.Remote())
-			version = "main"
-			commitSha, err = remoteResolveRef(ctx, p.Source.Remote(), version)
-		}
+	// subdirs of the same commit are all taken from a single shared tree
+	if p.checkouts != nil && p.Source.Subdir != "" && commitShaPattern.MatchString(version) {
+		tree, commitHash, date, err := p.checkouts.get(p.Source.Remote()+"@"+version, func(treeDir string) (string, time.Time, error) {
+			sha, err := p.fetchTree(ctx, name, treeDir, version, "")
+			return sha, p.date, err
+		})
 		if err != nil {
-			color.White("failed to resolve ref %s@%s: %s", name, version, err)
+			return "", err
+		}
+		// every package of the shared checkout records the commit date of the tree
+		p.date = date
+		if err := keepRootJsonnetfile(p.Source, p.Source.Subdir, tree, dir); err != nil {
+			return "", err
+		}
+		if err := keepRootFiles(p.Source, name, version, tree, dir); err != nil {
+			return "", err
+		}
+		if err := checkContent(p.Source.Subdir, tree, name, version); err != nil {
+			return "", err
 		}
+		if err := os.MkdirAll(path.Dir(destPath), os.ModePerm); err != nil {
+			return "", errors.Wrap(err, "failed to create parent path")
+		}
+		if err := os.RemoveAll(destPath); err != nil {
+			return "", errors.Wrap(err, "failed to clean previous destination path")
+		}
+		if err := copyDir(path.Join(tree, p.Source.Subdir), destPath); err != nil {
+			return "", errors.Wrap(err, "failed to copy package from shared checkout")
+		}
+		return commitHash, nil
+	}
 
-		// If the ref resolution failed and "version" looks like a SHA,
-		// assume it is one and proceed.
-		if commitSha == "" && commitShaPattern.MatchString(version) {
-			commitSha = version
+	stagingDir := dir
+	if p.StagingDir != "" {
+		stagingDir = p.StagingDir
+		if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+			return "", errors.Wrap(err, "failed to create staging dir")
 		}
+	}
 
-		archiveUrl := fmt.Sprintf("%s/archive/%s.tar.gz", strings.TrimSuffix(p.Source.Remote(), ".git"), commitSha)
-		archiveFilepath := fmt.Sprintf("%s.tar.gz", tmpDir)
+	tmpDir, err := os.MkdirTemp(stagingDir, ".tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
 
-		defer os.Remove(archiveFilepath)
-		err = downloadGitHubArchive(archiveFilepath, archiveUrl)
+	commitHash, err := p.fetchTree(ctx, name, tmpDir, version, p.Source.Subdir)
+	if err != nil {
+		return "", err
+	}
+
+	subDir, err := chooseSubdir(p.Source, tmpDir, name, version)
+	if err != nil {
+		return "", err
+	}
+	p.subdir = subDir
+
+	if err := keepRootJsonnetfile(p.Source, subDir, tmpDir, dir); err != nil {
+		return "", err
+	}
+	if err := keepRootFiles(p.Source, name, version, tmpDir, dir); err != nil {
+		return "", err
+	}
+
+	if err := checkContent(subDir, tmpDir, name, version); err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(path.Dir(destPath), os.ModePerm)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create parent path")
+	}
+
+	err = os.RemoveAll(destPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to clean previous destination path")
+	}
+
+	err = moveDir(path.Join(tmpDir, subDir), destPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to move package")
+	}
+
+	return commitHash, nil
+}
+
+// fetchTree places the tree of the repository at version into the empty
+// directory tmpDir, without the .git directory. If subDir is set, only that
+// part of the tree (and the root jsonnetfile, if asked for) may be fetched.
+// It returns the commit the version refers to.
+func (p *GitPackage) fetchTree(ctx context.Context, name, tmpDir, version, subDir string) (string, error) {
+	// Optimization for GitHub sources: download a tarball archive of the requested
+	// version instead of cloning the entire
+	// Archives carry no tags, so they can't be used if tags need to be verified.
+	isGitHubRemote := githubRegex.MatchString(p.Source.Remote())
+	if isGitHubRemote && p.Source.TagKeyring == "" && !isCommitish(version) {
+		// Let git ls-remote decide if "version" is a ref or a commit SHA
+		commitSha, _, err := p.Resolve(ctx, version)
 		if err == nil {
-			var ar *os.File
-			ar, err = os.Open(archiveFilepath)
-			defer ar.Close()
+			archiveUrl := fmt.Sprintf("%s/archive/%s.tar.gz", strings.TrimSuffix(p.Source.Remote(), ".git"), commitSha)
+
+			// Extract the sub-directory (if any) from the archive
+			// If none specified, the entire archive is unpacked, as well as if
+			// files outside of it are needed
+			archiveDir := subDir
+			if len(p.Source.RootFiles) > 0 {
+				archiveDir = ""
+			}
+			err = extractGitHubArchive(ctx, p.Retry, p.Limit, tmpDir, archiveUrl, archiveDir)
 			if err == nil {
-				// Extract the sub-directory (if any) from the archive
-				// If none specified, the entire archive is unpacked
-				err = gzipUntar(tmpDir, ar, p.Source.Subdir)
-
-				// Move the extracted directory to its final destination
-				if err == nil {
-					if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-						return "", fmt.Errorf("failed to create parent path: %s", err)
-					}
-					if err := os.Rename(path.Join(tmpDir, p.Source.Subdir), destPath); err != nil {
-						return "", fmt.Errorf("failed to move package: %s; was the sub dir moved?", err)
-					}
-				}
+				return commitSha, nil
+			}
+			// a clone has the same content, which must not be used either
+			if errors.Is(err, UnsafeArchive) {
+				return "", err
 			}
-		}
 
-		if err == nil {
-			return commitSha, nil
+			// discard whatever was extracted before the failure
+			if err := emptyDir(tmpDir); err != nil {
+				return "", errors.Wrap(err, "failed to clean tmp dir")
+			}
 		}
 
-		// The repository may be private or the archive download may not work
-		// for other reasons. In any case, fall back to the slower git-based installation.
-		color.Yellow("archive install failed: %s", err)
-		color.Yellow("retrying with git...")
+		// The ref may not resolve, the repository may be private or the
+		// archive download may not work for other reasons. In any case, fall
+		// back to the slower git-based installation.
+		warn(ctx, WarningArchiveFallback, name, "archive install of %s@%s failed, retrying with git: %s", name, version, err)
 	}
 
 	gitCmd := func(args ...string) *exec.Cmd {
 		cmd := exec.CommandContext(ctx, "git", args...)
 		cmd.Stdin = os.Stdin
-		if GitQuiet {
-			cmd.Stdout = nil
-			cmd.Stderr = nil
-		} else {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		}
+		setGitOutput(ctx, cmd)
+		teeStderr(cmd, p.stderr)
 		cmd.Dir = tmpDir
 		return cmd
 	}
 
 	cmd := gitCmd("init")
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		return "", err
 	}
@@ -276,11 +391,15 @@ func (p *GitPackage) Install(ctx context.Context, name, dir, version string) (st
 	}
 
 	// Attempt shallow fetch at specific revision
-	cmd = gitCmd("fetch", "--tags", "--depth", "1", "origin", version)
-	err = cmd.Run()
-	if err != nil {
+	protocol := gitProtocolArgs(p.protocol())
+	checkout := version
+	if isCommitish(version) {
+		if checkout, err = p.fetchCommitish(ctx, gitCmd, tmpDir, version); err != nil {
+			return "", err
+		}
+	} else if err = gitCmd(append(protocol, "fetch", "--tags", "--depth", "1", "origin", version)...).Run(); err != nil {
 		// Fall back to normal fetch (all revisions)
-		cmd = gitCmd("fetch", "origin")
+		cmd = gitCmd(append(protocol, "fetch", "origin")...)
 		err = cmd.Run()
 		if err != nil {
 			return "", err
@@ -289,21 +408,27 @@ func (p *GitPackage) Install(ctx context.Context, name, dir, version string) (st
 
 	// Sparse checkout optimization: if a Subdir is specified,
 	// there is no need to do a full checkout
-	if p.Source.Subdir != "" {
+	if subDir != "" {
 		cmd = gitCmd("config", "core.sparsecheckout", "true")
 		err = cmd.Run()
 		if err != nil {
 			return "", err
 		}
 
-		glob := []byte(p.Source.Subdir + "/*\n")
+		glob := []byte(subDir + "/*\n")
+		if p.Source.RootJsonnetfile {
+			glob = append(glob, []byte("/"+jsonnetfile.File+"\n")...)
+		}
+		for _, f := range p.Source.RootFiles {
+			glob = append(glob, []byte("/"+f+"\n")...)
+		}
 		err = ioutil.WriteFile(filepath.Join(tmpDir, ".git", "info", "sparse-checkout"), glob, 0644)
 		if err != nil {
 			return "", err
 		}
 	}
 
-	cmd = gitCmd("-c", "advice.detachedHead=false", "checkout", version)
+	cmd = gitCmd("-c", "advice.detachedHead=false", "checkout", checkout)
 	err = cmd.Run()
 	if err != nil {
 		return "", err
@@ -320,25 +445,105 @@ func (p *GitPackage) Install(ctx context.Context, name, dir, version string) (st
 
 	commitHash := strings.TrimSpace(b.String())
 
+	// recorded for Freshness, which has no clone at hand later
+	if date, err := commitDate(ctx, tmpDir); err == nil {
+		p.date = date
+	}
+
+	if p.Source.TagKeyring != "" {
+		tag := p.tag
+		if tag == "" {
+			tag = verifiedTag(deps.Dependency{Version: version})
+		}
+		if err := verifyTag(ctx, tmpDir, p.Source.TagKeyring, version, tag); err != nil {
+			return "", err
+		}
+	}
+
 	err = os.RemoveAll(path.Join(tmpDir, ".git"))
 	if err != nil {
 		return "", err
 	}
+	if err := checkLinks(tmpDir); err != nil {
+		return "", err
+	}
 
-	err = os.MkdirAll(path.Dir(destPath), os.ModePerm)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create parent path")
+	return commitHash, nil
+}
+
+// chooseSubdir returns the subdir of the checkout in tmpDir to install, which
+// is the first of the candidate Subdirs present, if there are any
+func chooseSubdir(source *deps.Git, tmpDir, name, version string) (string, error) {
+	if len(source.Subdirs) == 0 {
+		return source.Subdir, nil
 	}
+	for _, s := range source.Subdirs {
+		if fi, err := os.Stat(filepath.Join(tmpDir, s)); err == nil && fi.IsDir() {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s has none of '%s' in '%s'", MissingSubdir, name, strings.Join(source.Subdirs, "', '"), version)
+}
 
-	err = os.RemoveAll(destPath)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to clean previous destination path")
+// checkContent makes sure the checkout in tmpDir has something to vendor at
+// subDir. Repositories whose default branch is an orphan or empty branch
+// would otherwise silently end up as an empty package.
+func checkContent(subDir, tmpDir, name, version string) error {
+	entries, err := os.ReadDir(filepath.Join(tmpDir, subDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return nil
 	}
 
-	err = os.Rename(path.Join(tmpDir, p.Source.Subdir), destPath)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to move package")
+	if subDir == "" {
+		subDir = "/"
 	}
+	return fmt.Errorf("%w: %s has nothing at '%s' in '%s'; if the default branch is empty, specify an explicit version", EmptyPackage, name, subDir, version)
+}
 
-	return commitHash, nil
+// keepRootJsonnetfile copies the jsonnetfile at the root of the checkout in
+// tmpDir to dir, so the dependencies of a package at subDir can be read from
+// it.
+func keepRootJsonnetfile(source *deps.Git, subDir, tmpDir, dir string) error {
+	if !source.RootJsonnetfile || subDir == "" {
+		return nil
+	}
+
+	// a missing root jsonnetfile is kept as an empty file, which declares no
+	// dependencies
+	data, err := os.ReadFile(filepath.Join(tmpDir, jsonnetfile.File))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read root jsonnetfile")
+	}
+	return os.WriteFile(filepath.Join(dir, rootJsonnetfile), data, 0644)
+}
+
+// keepRootFiles copies the RootFiles of the checkout in tmpDir below dir, to
+// the root of the repository the package name is part of. This way, they
+// keep their position relative to the package.
+func keepRootFiles(source *deps.Git, name, version, tmpDir, dir string) error {
+	if len(source.RootFiles) == 0 {
+		return nil
+	}
+
+	root := filepath.Join(dir, strings.TrimSuffix(name, source.Subdir))
+	for _, f := range source.RootFiles {
+		src := filepath.Join(tmpDir, filepath.FromSlash(f))
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s has no '%s' in '%s'", MissingRootFile, name, f, version)
+		}
+		dest := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.RemoveAll(dest); err != nil {
+			return errors.Wrap(err, "failed to clean previous root file")
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return errors.Wrap(err, "failed to create parent path")
+		}
+		if err := copyDir(src, dest); err != nil {
+			return errors.Wrap(err, "failed to copy root file")
+		}
+	}
+	return nil
 }
@@ -0,0 +1,231 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// GitHTTPPackage installs git packages over HTTPS without requiring the git
+// binary. References are listed using the smart HTTP protocol and the content
+// is downloaded as an archive of the resolved commit, so only repositories on
+// deps.HTTPBackendHosts are supported.
+type GitHTTPPackage struct {
+	Source *deps.Git
+
+	// Client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Retry controls how failed requests are retried. Defaults to
+	// DefaultHTTPRetryPolicy.
+	Retry *HTTPRetryPolicy
+	// Limit caps the bandwidth of downloads. Defaults to unlimited.
+	Limit *RateLimiter
+	// StagingDir is where downloads are prepared before being moved into
+	// place. Defaults to the directory the package is installed to.
+	StagingDir string
+	// Precedence decides between a tag and a branch of the same name.
+	// Defaults to RefTagsFirst.
+	Precedence RefPrecedence
+
+	// matched is the provenance of a version naming both a tag and a
+	// branch, as resolved last
+	matched string
+	// subdir is the candidate of Source.Subdirs installed last
+	subdir string
+}
+
+func NewGitHTTPPackage(source *deps.Git) Interface {
+	return &GitHTTPPackage{
+		Source: source,
+	}
+}
+
+func (p *GitHTTPPackage) Install(ctx context.Context, name, dir, version string) (string, error) {
+	if p.Source.Object != "" {
+		return "", fmt.Errorf("%s: git objects require the %s backend", name, deps.GitBackendExec)
+	}
+	if p.Source.Scheme != deps.GitSchemeHTTPS {
+		return "", fmt.Errorf("the %s backend requires an https remote, got %s", deps.GitBackendHTTP, p.Source.Remote())
+	}
+	if !p.Source.HTTPBackendSupported() {
+		return "", fmt.Errorf("the %s backend supports %s only, got %s", deps.GitBackendHTTP, strings.Join(deps.HTTPBackendHosts, " and "), p.Source.Remote())
+	}
+
+	sha, _, err := p.Resolve(ctx, version)
+	if err != nil {
+		return "", err
+	}
+
+	stagingDir := dir
+	if p.StagingDir != "" {
+		stagingDir = p.StagingDir
+		if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+			return "", errors.Wrap(err, "failed to create staging dir")
+		}
+	}
+	tmpDir, err := os.MkdirTemp(stagingDir, ".tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := p.extractArchive(ctx, sha, tmpDir); err != nil {
+		return "", err
+	}
+	subDir, err := chooseSubdir(p.Source, tmpDir, name, version)
+	if err != nil {
+		return "", err
+	}
+	p.subdir = subDir
+	if err := keepRootJsonnetfile(p.Source, subDir, tmpDir, dir); err != nil {
+		return "", err
+	}
+	if err := keepRootFiles(p.Source, name, version, tmpDir, dir); err != nil {
+		return "", err
+	}
+	if err := checkContent(subDir, tmpDir, name, version); err != nil {
+		return "", err
+	}
+
+	destPath := path.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "failed to create parent path")
+	}
+	if err := os.RemoveAll(destPath); err != nil {
+		return "", errors.Wrap(err, "failed to clean previous destination path")
+	}
+	if err := moveDir(path.Join(tmpDir, subDir), destPath); err != nil {
+		return "", errors.Wrap(err, "failed to move package")
+	}
+
+	return sha, nil
+}
+
+// Resolve resolves the version using the smart HTTP reference advertisement
+func (p *GitHTTPPackage) Resolve(ctx context.Context, version string) (string, string, error) {
+	if commitShaPattern.MatchString(version) {
+		return version, "", nil
+	}
+	if isCommitish(version) {
+		return "", "", fmt.Errorf("%w: '%s' of %s: commit-ish expressions require the exec backend", UnresolvedCommitish, version, p.Source.Remote())
+	}
+	refs, err := p.listRefs(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	sha, tag, matched, err := selectVersion(refs, version, p.Source.PreReleases, p.Precedence)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to resolve version '%s' of %s: %w", version, p.Source.Remote(), err)
+	}
+	warnAmbiguousRef(ctx, version, matched)
+	p.matched = matched
+	return sha, tag, nil
+}
+
+// get performs a GET request, failing on any status other than 200
+func (p *GitHTTPPackage) get(ctx context.Context, url string) (*http.Response, error) {
+	return httpGet(ctx, p.Client, p.Retry, p.Limit, url)
+}
+
+// listRefs lists the references of the remote using the smart HTTP protocol
+func (p *GitHTTPPackage) listRefs(ctx context.Context) ([]gitRef, error) {
+	resp, err := p.get(ctx, p.Source.Remote()+"/info/refs?service=git-upload-pack")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseRefAdvertisement(resp.Body)
+}
+
+// parseRefAdvertisement parses the pkt-line encoded reference advertisement
+// of git-upload-pack
+func parseRefAdvertisement(r io.Reader) ([]gitRef, error) {
+	refs := []gitRef{}
+	br := bufio.NewReader(r)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(br, size[:]); err == io.EOF {
+			return refs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(string(size[:]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length %q", size)
+		}
+		// flush packet
+		if n == 0 {
+			continue
+		}
+		if n < 4 {
+			return nil, fmt.Errorf("invalid pkt-line length %q", size)
+		}
+
+		line := make([]byte, n-4)
+		if _, err := io.ReadFull(br, line); err != nil {
+			return nil, err
+		}
+		s := strings.TrimSuffix(string(line), "\n")
+		if strings.HasPrefix(s, "#") {
+			continue
+		}
+		// capabilities are sent after the first ref
+		s, _, _ = strings.Cut(s, "\x00")
+		sha, name, ok := strings.Cut(s, " ")
+		if !ok || name == "capabilities^{}" {
+			continue
+		}
+		refs = append(refs, gitRef{sha: sha, name: name})
+	}
+}
+
+// archiveURL returns the URL of a tar.gz archive of the commit, in the
+// format of the host
+func (p *GitHTTPPackage) archiveURL(sha string) string {
+	base := strings.TrimSuffix(p.Source.Remote(), ".git")
+	if p.Source.Host == "gitlab.com" {
+		return fmt.Sprintf("%s/-/archive/%s/%s-%s.tar.gz", base, sha, strings.TrimSuffix(p.Source.Repo, ".git"), sha)
+	}
+	return fmt.Sprintf("%s/archive/%s.tar.gz", base, sha)
+}
+
+func (p *GitHTTPPackage) extractArchive(ctx context.Context, sha, dst string) error {
+	resp, err := p.get(ctx, p.archiveURL(sha))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// files outside of the subdir may be needed as well
+	subDir := p.Source.Subdir
+	if len(p.Source.RootFiles) > 0 {
+		subDir = ""
+	}
+	return gzipUntar(dst, resp.Body, subDir)
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// serve serves the repository over HTTP the way a git hosting service does:
+// the smart HTTP reference advertisement and GitHub style archives
+func (r *testRepo) serve() *httptest.Server {
+	prefix := "/" + r.src.User + "/" + strings.TrimSuffix(r.src.Repo, ".git")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == prefix+".git/info/refs":
+			out, err := exec.Command("git", "upload-pack", "--stateless-rpc", "--advertise-refs", r.dir).Output()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, "%04x# service=git-upload-pack\n0000", len("# service=git-upload-pack\n")+4)
+			w.Write(out)
+		case strings.HasPrefix(req.URL.Path, prefix+"/archive/"):
+			sha := strings.TrimSuffix(filepath.Base(req.URL.Path), ".tar.gz")
+			cmd := exec.Command("git", "archive", "--format=tar.gz", "--prefix="+r.src.Repo+"-"+sha+"/", sha)
+			cmd.Dir = r.dir
+			out, err := cmd.Output()
+			if err != nil {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(out)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	r.t.Cleanup(srv.Close)
+	return srv
+}
+
+// hostedOn moves the repository to host, which the http backend requires to
+// be a known one. It is still cloned from its directory.
+func (r *testRepo) hostedOn(host string) {
+	r.src.Host = host
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	r.t.Setenv("GIT_CONFIG_COUNT", strconv.Itoa(n+1))
+	r.t.Setenv(fmt.Sprintf("GIT_CONFIG_KEY_%d", n), "url.file://"+filepath.ToSlash(r.dir)+".insteadOf")
+	r.t.Setenv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", n), r.src.Remote())
+}
+
+// redirectTransport sends all requests to the test server
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestGitBackendsIdentical(t *testing.T) {
+	r := newTestRepo(t, "backends")
+	r.commit(map[string]string{
+		"lib/main.libsonnet":     "{}",
+		"lib/nested/a.libsonnet": "{ a: 1 }",
+		"README.md":              "readme",
+	})
+	r.git("tag", "-a", "-m", "v1.0.0", "v1.0.0")
+	r.commit(map[string]string{"lib/main.libsonnet": "{ b: 2 }"})
+	r.hostedOn("github.com")
+	r.src.Subdir = "/lib"
+	rootFiles := *r.src
+	rootFiles.RootFiles = []string{"README.md"}
+
+	srv := r.serve()
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	client := &http.Client{Transport: redirectTransport{target: target}}
+
+	for name, src := range map[string]*deps.Git{"subdir": r.src, "rootFiles": &rootFiles} {
+		for _, version := range []string{"v1.0.0", "master"} {
+			t.Run(name+"/"+version, func(t *testing.T) {
+				execDir, httpDir := t.TempDir(), t.TempDir()
+
+				execVersion, err := NewGitPackage(src).Install(context.TODO(), src.Name(), execDir, version)
+				require.NoError(t, err)
+				httpVersion, err := (&GitHTTPPackage{Source: src, Client: client}).Install(context.TODO(), src.Name(), httpDir, version)
+				require.NoError(t, err)
+				assert.Equal(t, execVersion, httpVersion)
+
+				execSum, err := hashDir(context.TODO(), filepath.Join(execDir, src.Name()), hashConfig{})
+				require.NoError(t, err)
+				httpSum, err := hashDir(context.TODO(), filepath.Join(httpDir, src.Name()), hashConfig{})
+				require.NoError(t, err)
+				assert.Equal(t, execSum, httpSum)
+
+				for _, f := range src.RootFiles {
+					execFile, err := os.ReadFile(filepath.Join(execDir, r.src.Name(), "..", f))
+					require.NoError(t, err)
+					httpFile, err := os.ReadFile(filepath.Join(httpDir, r.src.Name(), "..", f))
+					require.NoError(t, err)
+					assert.Equal(t, execFile, httpFile)
+				}
+			})
+		}
+	}
+}
+
+func TestGitHTTPUnsupportedHost(t *testing.T) {
+	src := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "example.com", User: "a", Repo: "b"}
+	_, err := NewGitHTTPPackage(src).Install(context.TODO(), src.Name(), t.TempDir(), "master")
+	assert.ErrorContains(t, err, "supports github.com and gitlab.com only")
+}
+
+func TestGitHTTPArchiveURL(t *testing.T) {
+	github := &GitHTTPPackage{Source: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "a", Repo: "b"}}
+	assert.Equal(t, "https://github.com/a/b/archive/abc.tar.gz", github.archiveURL("abc"))
+	gitlab := &GitHTTPPackage{Source: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "gitlab.com", User: "a/sub", Repo: "b"}}
+	assert.Equal(t, "https://gitlab.com/a/sub/b/-/archive/abc/b-abc.tar.gz", gitlab.archiveURL("abc"))
+}
+
+func TestGitBackendOption(t *testing.T) {
+	src := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com"}
+	assert.Equal(t, deps.GitBackendExec, newOptions(nil).gitBackend(src))
+	assert.Equal(t, deps.GitBackendHTTP, newOptions([]Option{WithGitBackend(deps.GitBackendHTTP)}).gitBackend(src))
+
+	// unsupported sources keep using git
+	unsupported := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "example.com"}
+	assert.Equal(t, deps.GitBackendExec, newOptions([]Option{WithGitBackend(deps.GitBackendHTTP)}).gitBackend(unsupported))
+
+	src.Backend = deps.GitBackendExec
+	assert.Equal(t, deps.GitBackendExec, newOptions([]Option{WithGitBackend(deps.GitBackendHTTP)}).gitBackend(src))
+}
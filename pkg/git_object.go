@@ -0,0 +1,141 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// installObject vendors the tree or blob object of the source, bypassing
+// the resolution of versions entirely. The object is returned as the version
+// to lock.
+func (p *GitPackage) installObject(ctx context.Context, name, dir string) (string, error) {
+	object := p.Source.Object
+
+	stagingDir := dir
+	if p.StagingDir != "" {
+		stagingDir = p.StagingDir
+		if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+			return "", errors.Wrap(err, "failed to create staging dir")
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp(stagingDir, ".tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	gitCmd := func(args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		setGitOutput(ctx, cmd)
+		teeStderr(cmd, p.stderr)
+		cmd.Dir = tmpDir
+		return cmd
+	}
+
+	if err := gitCmd("init", "--quiet").Run(); err != nil {
+		return "", err
+	}
+	if err := gitCmd("remote", "add", "origin", p.Source.Remote()).Run(); err != nil {
+		return "", err
+	}
+
+	// Servers allowing it hand out the object alone, all others need a full
+	// fetch to find it among the reachable ones
+	protocol := gitProtocolArgs(p.protocol())
+	fetch := gitCmd(append(protocol, "fetch", "--quiet", "--depth", "1", "origin", object)...)
+	fetch.Stderr = nil
+	if err := fetch.Run(); err != nil {
+		if err := gitCmd(append(protocol, "fetch", "--quiet", "--tags", "origin")...).Run(); err != nil {
+			return "", err
+		}
+	}
+
+	b := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", "cat-file", "-t", object)
+	cmd.Stdout = b
+	cmd.Dir = tmpDir
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git object %s is not reachable from %s", object, p.Source.Remote())
+	}
+
+	pkgDir := filepath.Join(tmpDir, "pkg")
+	switch typ := strings.TrimSpace(b.String()); typ {
+	case "tree":
+		if err := gitCmd("read-tree", object).Run(); err != nil {
+			return "", err
+		}
+		if err := gitCmd("checkout-index", "--all", "--prefix=pkg/").Run(); err != nil {
+			return "", err
+		}
+	case "blob":
+		if p.Source.Subdir == "" {
+			return "", fmt.Errorf("%s: git blob %s requires a subdir to name the file", name, object)
+		}
+		if err := os.MkdirAll(pkgDir, os.ModePerm); err != nil {
+			return "", err
+		}
+		f, err := os.Create(filepath.Join(pkgDir, path.Base(p.Source.Subdir)))
+		if err != nil {
+			return "", err
+		}
+		cmd := exec.CommandContext(ctx, "git", "cat-file", "blob", object)
+		cmd.Stdout = f
+		cmd.Dir = tmpDir
+		err = cmd.Run()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("git object %s is a %s, expected a tree or blob", object, typ)
+	}
+
+	entries, err := os.ReadDir(pkgDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%w: %s has nothing in git object %s", EmptyPackage, name, object)
+	}
+	if err := checkLinks(pkgDir); err != nil {
+		return "", err
+	}
+
+	destPath := path.Join(dir, name)
+	if err := os.MkdirAll(path.Dir(destPath), os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "failed to create parent path")
+	}
+	if err := os.RemoveAll(destPath); err != nil {
+		return "", errors.Wrap(err, "failed to clean previous destination path")
+	}
+	if err := moveDir(pkgDir, destPath); err != nil {
+		return "", errors.Wrap(err, "failed to move package")
+	}
+
+	return object, nil
+}
@@ -0,0 +1,502 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// testRepo is a git repository on the local disk. Git is configured to use it
+// in place of https://example.com/test/<name>.git for the duration of a test.
+type testRepo struct {
+	t   *testing.T
+	dir string
+	src *deps.Git
+}
+
+func newTestRepo(t *testing.T, name string) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	r := &testRepo{
+		t:   t,
+		dir: dir,
+		src: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "example.com", User: "test", Repo: name},
+	}
+
+	// isolate from the user's git configuration, unless an earlier repository
+	// of the same test already did
+	if os.Getenv("GIT_CONFIG_GLOBAL") != os.DevNull {
+		t.Setenv("GIT_CONFIG_COUNT", "0")
+	}
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	t.Setenv("GIT_CONFIG_COUNT", strconv.Itoa(n+1))
+	t.Setenv(fmt.Sprintf("GIT_CONFIG_KEY_%d", n), "url.file://"+filepath.ToSlash(dir)+".insteadOf")
+	t.Setenv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", n), r.src.Remote())
+
+	r.git("init", "--initial-branch", "master")
+	return r
+}
+
+// git runs git inside the repository and returns its trimmed output
+func (r *testRepo) git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(r.t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the given files and commits them, returning the commit sha
+func (r *testRepo) commit(files map[string]string) string {
+	r.t.Helper()
+	for name, content := range files {
+		p := filepath.Join(r.dir, name)
+		require.NoError(r.t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		require.NoError(r.t, os.WriteFile(p, []byte(content), 0644))
+	}
+	r.git("add", "-A")
+	r.git("commit", "--allow-empty", "-m", "commit")
+	return r.git("rev-parse", "HEAD")
+}
+
+func TestResolveVersion(t *testing.T) {
+	r := newTestRepo(t, "resolve")
+	first := r.commit(map[string]string{"main.libsonnet": "{}"})
+	r.git("tag", "v1.0.0")
+	r.git("tag", "-a", "-m", "annotated", "v1.1.0")
+	second := r.commit(map[string]string{"main.libsonnet": "{ a: 1 }"})
+	r.git("branch", "feature", first)
+
+	tests := []struct {
+		version string
+		sha     string
+		tag     string
+		err     bool
+	}{
+		{version: "master", sha: second},
+		{version: "", sha: second},
+		{version: "v1.0.0", sha: first, tag: "v1.0.0"},
+		{version: "v1.1.0", sha: first, tag: "v1.1.0"},
+		{version: "refs/tags/v1.1.0", sha: first, tag: "v1.1.0"},
+		{version: "feature", sha: first},
+		{version: first, sha: first},
+		{version: "missing", err: true},
+		{version: ">=1.0.0", sha: first, tag: "v1.1.0"},
+		{version: "~1.0.0", sha: first, tag: "v1.0.0"},
+		{version: ">=1.0.0 || feature", sha: first, tag: "v1.1.0"},
+		{version: ">=2.0.0 || feature", sha: first},
+		{version: ">=2.0.0", err: true},
+		{version: ">=2.0.0 || missing", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			sha, tag, err := ResolveVersion(context.TODO(), r.src, tc.version)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.sha, sha)
+			assert.Equal(t, tc.tag, tag)
+		})
+	}
+}
+
+func TestResolveVersionMainFallback(t *testing.T) {
+	r := newTestRepo(t, "fallback")
+	sha := r.commit(nil)
+	r.git("branch", "-m", "master", "main")
+
+	got, _, err := ResolveVersion(context.TODO(), r.src, "master")
+	require.NoError(t, err)
+	assert.Equal(t, sha, got)
+}
+
+func TestGitInstall(t *testing.T) {
+	r := newTestRepo(t, "install")
+	sha := r.commit(map[string]string{"lib/main.libsonnet": "{}", "README.md": "readme"})
+	r.git("tag", "v1.0.0")
+	r.src.Subdir = "/lib"
+
+	dir := t.TempDir()
+	d := deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v1.0.0"}
+	l, err := download(context.TODO(), d, dir, "", newOptions(nil), nil)
+	require.NoError(t, err)
+	assert.Equal(t, sha, l.Version)
+	assert.NotEmpty(t, l.Sum)
+	assert.FileExists(t, filepath.Join(dir, d.Name(), "main.libsonnet"))
+	assert.NoFileExists(t, filepath.Join(dir, d.Name(), "README.md"))
+}
+
+// jsonnetfileFor returns the contents of a jsonnetfile depending on the given
+// repositories at master
+func jsonnetfileFor(repos ...*testRepo) string {
+	ds := []string{}
+	for _, r := range repos {
+		ds = append(ds, fmt.Sprintf(`{"source": {"git": {"remote": "%s", "subdir": "%s"}}, "version": "master"}`, r.src.Remote(), strings.TrimPrefix(r.src.Subdir, "/")))
+	}
+	return fmt.Sprintf(`{"version": 1, "dependencies": [%s]}`, strings.Join(ds, ","))
+}
+
+func TestEnsureRootJsonnetfile(t *testing.T) {
+	other := newTestRepo(t, "other")
+	other.commit(map[string]string{"main.libsonnet": "{}"})
+
+	lib := newTestRepo(t, "lib")
+	lib.commit(map[string]string{
+		"jsonnet/main.libsonnet": "{}",
+		jsonnetfile.File:         jsonnetfileFor(other),
+	})
+	lib.src.Subdir = "/jsonnet"
+
+	for _, root := range []bool{false, true} {
+		t.Run(fmt.Sprint(root), func(t *testing.T) {
+			src := *lib.src
+			src.RootJsonnetfile = root
+			jsf := v1.New()
+			jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: "master"})
+
+			vendorDir := t.TempDir()
+			locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+			require.NoError(t, err)
+
+			_, found := locks.Get(other.src.Name())
+			assert.Equal(t, root, found)
+			assert.FileExists(t, filepath.Join(vendorDir, src.Name(), "main.libsonnet"))
+			assert.NoFileExists(t, filepath.Join(vendorDir, src.Name(), jsonnetfile.File))
+		})
+	}
+}
+
+func TestGitInstallStagingDir(t *testing.T) {
+	r := newTestRepo(t, "staging")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	dir, staging := t.TempDir(), t.TempDir()
+	p := &GitPackage{Source: r.src, StagingDir: staging}
+	_, err := p.Install(context.TODO(), r.src.Name(), dir, "master")
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(dir, r.src.Name(), "main.libsonnet"))
+	entries, err := os.ReadDir(staging)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "staging dir must be cleaned up")
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "nothing but the package must be created in dir")
+}
+
+func TestExtractGitHubArchive(t *testing.T) {
+	r := newTestRepo(t, "archive")
+	sha := r.commit(map[string]string{"lib/main.libsonnet": "{}", "README.md": "readme"})
+	srv := r.serve()
+
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "staging")
+	require.NoError(t, os.Mkdir(dst, os.ModePerm))
+
+	url := fmt.Sprintf("%s/test/archive/archive/%s.tar.gz", srv.URL, sha)
+	require.NoError(t, extractGitHubArchive(context.TODO(), nil, nil, dst, url, "/lib"))
+	assert.FileExists(t, filepath.Join(dst, "lib", "main.libsonnet"))
+	assert.NoFileExists(t, filepath.Join(dst, "README.md"))
+
+	// the archive is streamed, nothing is stored next to the staging dir
+	entries, err := os.ReadDir(parent)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	err = extractGitHubArchive(context.TODO(), nil, nil, dst, srv.URL+"/test/archive/archive/missing.tar.gz", "")
+	assert.Error(t, err)
+}
+
+func TestGitInstallUnsafeSymlink(t *testing.T) {
+	r := newTestRepo(t, "unsafe")
+	require.NoError(t, os.Symlink("../../outside", filepath.Join(r.dir, "evil")))
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	r.hostedOn("github.com")
+
+	archives := r.serve()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+
+	for name, srv := range map[string]*httptest.Server{"archive": archives, "clone": missing} {
+		t.Run(name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			ctx := withLogger(context.TODO(), logger)
+			target, err := url.Parse(srv.URL)
+			require.NoError(t, err)
+			transport := http.DefaultClient.Transport
+			http.DefaultClient.Transport = redirectTransport{target: target}
+			t.Cleanup(func() { http.DefaultClient.Transport = transport })
+
+			// the archive is refused without falling back to a clone, and
+			// a clone is checked the same way
+			_, err = NewGitPackage(r.src).Install(ctx, r.src.Name(), t.TempDir(), "master")
+			assert.ErrorIs(t, err, UnsafeArchive)
+			fellBack := false
+			for _, m := range logger.messages {
+				fellBack = fellBack || strings.HasPrefix(m, "warn archive install")
+			}
+			assert.Equal(t, name == "clone", fellBack, logger.messages)
+		})
+	}
+}
+
+func TestEnsureProvenance(t *testing.T) {
+	r := newTestRepo(t, "provenance")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	r.git("branch", "develop")
+
+	tests := []struct {
+		version    string
+		provenance string
+	}{
+		{version: "master"},
+		{version: ">=1.0.0 || develop", provenance: "branch:develop"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			jsf := v1.New()
+			jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: tc.version})
+
+			locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+			require.NoError(t, err)
+			l, ok := locks.Get(r.src.Name())
+			require.True(t, ok)
+			assert.Equal(t, tc.provenance, l.Provenance)
+		})
+	}
+
+	r.git("tag", "v1.0.0")
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: ">=1.0.0 || develop"})
+	locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, "tag:v1.0.0", l.Provenance)
+}
+
+func TestResolveVersionPreReleases(t *testing.T) {
+	r := newTestRepo(t, "prereleases")
+	stable := r.commit(nil)
+	r.git("tag", "v1.9.0")
+	r.git("tag", "v2.0.0-rc.1")
+	rc := r.commit(nil)
+	r.git("tag", "v2.0.0-rc.2")
+	r.git("branch", "develop")
+
+	tests := []struct {
+		version     string
+		preReleases bool
+		sha         string
+		tag         string
+	}{
+		{version: ">=1.0.0", sha: stable, tag: "v1.9.0"},
+		{version: ">=1.0.0", preReleases: true, sha: rc, tag: "v2.0.0-rc.2"},
+		{version: ">=2.0.0-rc.1 || develop", sha: rc},
+		{version: ">=2.0.0-rc.1 || develop", preReleases: true, sha: rc, tag: "v2.0.0-rc.2"},
+		{version: "^2.0.0-rc.1", preReleases: true, sha: rc, tag: "v2.0.0-rc.2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s/%v", tc.version, tc.preReleases), func(t *testing.T) {
+			src := *r.src
+			src.PreReleases = tc.preReleases
+			sha, tag, err := ResolveVersion(context.TODO(), &src, tc.version)
+			require.NoError(t, err)
+			assert.Equal(t, tc.sha, sha)
+			assert.Equal(t, tc.tag, tag)
+		})
+	}
+}
+
+func TestGitProtocol(t *testing.T) {
+	r := newTestRepo(t, "protocol")
+	sha := r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	for _, protocol := range []string{"0", "1", "2"} {
+		t.Run(protocol, func(t *testing.T) {
+			got, _, err := (&GitPackage{Source: r.src, Protocol: protocol}).Resolve(context.TODO(), "master")
+			require.NoError(t, err)
+			assert.Equal(t, sha, got)
+
+			got, err = (&GitPackage{Source: r.src, Protocol: protocol}).Install(context.TODO(), r.src.Name(), t.TempDir(), "master")
+			require.NoError(t, err)
+			assert.Equal(t, sha, got)
+		})
+	}
+
+	// the source takes precedence over the option
+	src := *r.src
+	src.ProtocolVersion = "3"
+	assert.Equal(t, "3", (&GitPackage{Source: &src, Protocol: "2"}).protocol())
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: "master"})
+	_, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	assert.ErrorContains(t, err, "unknown git protocol version '3'")
+}
+
+func TestGitInstallEmptyRef(t *testing.T) {
+	r := newTestRepo(t, "empty")
+	sha := r.commit(map[string]string{"lib/main.libsonnet": "{}"})
+	r.git("tag", "v1.0.0")
+	r.git("checkout", "--orphan", "empty")
+	r.git("rm", "-rf", ".")
+	r.commit(nil)
+	r.git("branch", "-D", "master")
+	r.git("branch", "-m", "empty", "master")
+
+	for _, subDir := range []string{"", "/lib"} {
+		t.Run(subDir, func(t *testing.T) {
+			src := *r.src
+			src.Subdir = subDir
+
+			_, err := NewGitPackage(&src).Install(context.TODO(), src.Name(), t.TempDir(), "master")
+			assert.ErrorIs(t, err, EmptyPackage)
+
+			got, err := NewGitPackage(&src).Install(context.TODO(), src.Name(), t.TempDir(), "v1.0.0")
+			require.NoError(t, err)
+			assert.Equal(t, sha, got)
+		})
+	}
+}
+
+func TestGitInstallSharedCheckout(t *testing.T) {
+	r := newTestRepo(t, "monorepo")
+	sha := r.commit(map[string]string{"a/main.libsonnet": "{ a: 1 }", "b/main.libsonnet": "{ b: 1 }"})
+
+	checkouts := newSharedCheckouts(t.TempDir())
+	install := func(subDir string) string {
+		src := *r.src
+		src.Subdir = subDir
+		dir := t.TempDir()
+		p := &GitPackage{Source: &src, checkouts: checkouts}
+		got, err := p.Install(context.TODO(), src.Name(), dir, sha)
+		require.NoError(t, err)
+		assert.Equal(t, sha, got)
+		// each package records the commit date, not just the one fetching
+		assert.False(t, p.date.IsZero(), subDir)
+
+		sum, err := hashDir(context.TODO(), filepath.Join(dir, src.Name()), hashConfig{})
+		require.NoError(t, err)
+		return sum
+	}
+
+	sumA := install("/a")
+	// the second subdir must come from the tree fetched for the first one
+	require.NoError(t, os.RemoveAll(r.dir))
+	sumB := install("/b")
+	assert.NotEqual(t, sumA, sumB)
+	assert.Len(t, checkouts.entries, 1)
+
+	dir := checkouts.dir
+	require.NoError(t, checkouts.cleanup())
+	assert.NoDirExists(t, dir)
+}
+
+func TestEnsureSharedCheckout(t *testing.T) {
+	r := newTestRepo(t, "shared")
+	r.commit(map[string]string{"a/main.libsonnet": "{ a: 1 }", "b/main.libsonnet": "{ b: 1 }"})
+
+	jsf := v1.New()
+	for _, subDir := range []string{"/a", "/b"} {
+		src := *r.src
+		src.Subdir = subDir
+		jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: "master"})
+	}
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	for _, k := range locks.Keys() {
+		lock, _ := locks.Get(k)
+		resolved, err := filepath.EvalSymlinks(filepath.Join(vendorDir, lock.Name()))
+		require.NoError(t, err)
+		sum, err := hashDir(context.TODO(), resolved, hashConfig{})
+		require.NoError(t, err)
+		assert.Equal(t, lock.Sum, sum, lock.Name())
+		assert.FileExists(t, filepath.Join(resolved, "main.libsonnet"))
+	}
+
+	entries, err := filepath.Glob(filepath.Join(vendorDir, ".cache", ".checkouts-*"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestGitInstallObject(t *testing.T) {
+	r := newTestRepo(t, "objects")
+	r.commit(map[string]string{"lib/main.libsonnet": "{ v: 1 }", "lib/sub/x.libsonnet": "{}", "other.txt": "x"})
+	tree := r.git("rev-parse", "HEAD:lib")
+	blob := r.git("rev-parse", "HEAD:lib/main.libsonnet")
+	// move on, so the objects are no longer at the tip
+	r.commit(map[string]string{"lib/main.libsonnet": "{ v: 2 }"})
+
+	src := *r.src
+	src.Subdir = "/lib"
+	src.Object = tree
+	dir := t.TempDir()
+	got, err := NewGitPackage(&src).Install(context.TODO(), src.Name(), dir, "master")
+	require.NoError(t, err)
+	assert.Equal(t, tree, got)
+	assert.FileExists(t, filepath.Join(dir, src.Name(), "sub", "x.libsonnet"))
+	content, err := os.ReadFile(filepath.Join(dir, src.Name(), "main.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+
+	src.Subdir = "/lib/main.libsonnet"
+	src.Object = blob
+	dir = t.TempDir()
+	got, err = NewGitPackage(&src).Install(context.TODO(), src.Name(), dir, "master")
+	require.NoError(t, err)
+	assert.Equal(t, blob, got)
+	content, err = os.ReadFile(filepath.Join(dir, src.Name(), "main.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+
+	src.Object = strings.Repeat("0", 40)
+	_, err = NewGitPackage(&src).Install(context.TODO(), src.Name(), t.TempDir(), "master")
+	assert.ErrorContains(t, err, "is not reachable")
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// TagVerificationError is returned if the installed commit is not tagged with
+// a tag signed by a trusted key
+type TagVerificationError struct {
+	Version string
+	Reason  string
+}
+
+func (e *TagVerificationError) Error() string {
+	return fmt.Sprintf("tag signature verification failed for %s: %s", e.Version, e.Reason)
+}
+
+// verifyTag checks that HEAD of the repository at repoDir, installed for
+// version, is tagged with a tag signed by a key of the keyring. If tag is
+// set, only that one is considered.
+func verifyTag(ctx context.Context, repoDir, keyring, version, tag string) error {
+	tags, err := tagsAtHead(ctx, repoDir, tag)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(tags) == 0 && tag != "":
+		return &TagVerificationError{Version: version, Reason: fmt.Sprintf("commit is not tagged with %s", tag)}
+	case len(tags) == 0:
+		return &TagVerificationError{Version: version, Reason: "commit is not tagged"}
+	}
+
+	// use a throw-away gpg home, so only keys from the keyring are trusted
+	gnupgHome, err := os.MkdirTemp("", "jb-gnupg-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(gnupgHome)
+	env := append(os.Environ(), "GNUPGHOME="+gnupgHome)
+
+	keyring, err = filepath.Abs(keyring)
+	if err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "gpg", "--batch", "--import", keyring)
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return &TagVerificationError{Version: version, Reason: fmt.Sprintf("unable to import keyring %s: %s", keyring, strings.TrimSpace(out.String()))}
+	}
+
+	reasons := []string{}
+	for _, tag := range tags {
+		out.Reset()
+		cmd := exec.CommandContext(ctx, "git", "verify-tag", tag)
+		cmd.Dir = repoDir
+		cmd.Env = env
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err == nil {
+			return nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", tag, strings.TrimSpace(out.String())))
+	}
+	return &TagVerificationError{Version: version, Reason: strings.Join(reasons, "; ")}
+}
+
+// tagsAtHead returns the tags pointing to HEAD of the repository. If tag is
+// set, only that one is returned, if it is among them.
+func tagsAtHead(ctx context.Context, repoDir, tag string) ([]string, error) {
+	b := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "git", "tag", "--points-at", "HEAD")
+	cmd.Dir = repoDir
+	cmd.Stdout = b
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	tags := strings.Fields(b.String())
+	if tag == "" {
+		return tags, nil
+	}
+	for _, t := range tags {
+		if t == tag || refsTagsPrefix+t == tag {
+			return []string{t}, nil
+		}
+	}
+	return nil, nil
+}
+
+// verifiedTag returns the tag of d to verify, which is the one its version
+// was resolved to or asked for by. Commits asked for directly may carry any
+// tag, so none is returned for them. A branch is returned as well, which is
+// never a tag at HEAD.
+func verifiedTag(d deps.Dependency) string {
+	requested := d.Version
+	switch {
+	case d.Fallback != "":
+		requested = d.Fallback
+	case d.Requested != "":
+		requested = d.Requested
+	}
+	switch {
+	case strings.HasPrefix(d.Provenance, "tag:"):
+		return strings.TrimPrefix(d.Provenance, "tag:")
+	case strings.HasPrefix(d.Provenance, "branch:"):
+		return strings.TrimPrefix(d.Provenance, "branch:")
+	case commitShaPattern.MatchString(requested), abbrevShaPattern.MatchString(requested), isCommitish(requested):
+		return ""
+	}
+	return requested
+}
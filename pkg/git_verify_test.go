@@ -0,0 +1,144 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// newTestKey creates a gpg key in a new gpg home and returns the home and the
+// path of the exported public key
+func newTestKey(t *testing.T, email string) (home, pubkey string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+
+	home, err := os.MkdirTemp("", "jb-test-gnupg-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		cmd := exec.Command("gpgconf", "--kill", "all")
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+		_ = cmd.Run()
+		os.RemoveAll(home)
+	})
+
+	gpg := func(args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--batch", "--passphrase", ""}, args...)...)
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		return out
+	}
+	gpg("--quick-gen-key", email, "ed25519", "sign", "never")
+
+	pubkey = filepath.Join(t.TempDir(), "pubkey.asc")
+	require.NoError(t, os.WriteFile(pubkey, gpg("--armor", "--export", email), 0644))
+	return home, pubkey
+}
+
+func TestGitInstallVerifyTag(t *testing.T) {
+	trustedHome, trusted := newTestKey(t, "trusted@example.com")
+	untrustedHome, _ := newTestKey(t, "untrusted@example.com")
+
+	r := newTestRepo(t, "signed")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	sign := func(home, key, tag string) {
+		t.Setenv("GNUPGHOME", home)
+		r.git("tag", "-u", key, "-m", tag, tag)
+	}
+	sign(trustedHome, "trusted@example.com", "v1.0.0")
+	r.commit(map[string]string{"main.libsonnet": "{ a: 1 }"})
+	sign(untrustedHome, "untrusted@example.com", "v1.1.0")
+	r.commit(map[string]string{"main.libsonnet": "{ a: 2 }"})
+	r.git("tag", "-a", "-m", "unsigned", "v1.2.0")
+
+	src := *r.src
+	src.TagKeyring = trusted
+	p := NewGitPackage(&src)
+
+	for version, ok := range map[string]bool{
+		"v1.0.0": true,
+		"v1.1.0": false,
+		"v1.2.0": false,
+		"master": false,
+	} {
+		t.Run(version, func(t *testing.T) {
+			_, err := p.Install(context.TODO(), src.Name(), t.TempDir(), version)
+			if ok {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *TagVerificationError
+			assert.ErrorAs(t, err, &verr)
+		})
+	}
+
+	t.Run("sha", func(t *testing.T) {
+		sha := r.git("rev-parse", "v1.0.0^{commit}")
+		_, err := p.Install(context.TODO(), src.Name(), t.TempDir(), sha)
+		assert.NoError(t, err)
+	})
+}
+
+// TestEnsureVerifyTag checks that the tag asked for is verified, although
+// Ensure installs the commit it resolves to
+func TestEnsureVerifyTag(t *testing.T) {
+	trustedHome, trusted := newTestKey(t, "trusted@example.com")
+
+	r := newTestRepo(t, "signed")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	t.Setenv("GNUPGHOME", trustedHome)
+	r.git("tag", "-u", "trusted@example.com", "-m", "v1.0.0", "v1.0.0")
+	r.git("tag", "-a", "-m", "unsigned", "v1.0.1")
+
+	src := *r.src
+	src.TagKeyring = trusted
+	install := func(version string, locks *deps.Ordered) (*deps.Ordered, error) {
+		jsf := v1.New()
+		jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: version})
+		locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), locks)
+		return locks, err
+	}
+
+	locks, err := install("v1.0.0", deps.NewOrdered())
+	require.NoError(t, err)
+	// the locked commit is verified against the tag asked for as well
+	_, err = install("v1.0.0", locks)
+	assert.NoError(t, err)
+
+	// the signed tag of the same commit doesn't vouch for other refs
+	for _, version := range []string{"v1.0.1", "master", "~1.0.1"} {
+		t.Run(version, func(t *testing.T) {
+			_, err := install(version, deps.NewOrdered())
+			var verr *TagVerificationError
+			assert.ErrorAs(t, err, &verr)
+		})
+	}
+
+	// a commit asked for directly may carry any signed tag
+	_, err = install(r.git("rev-parse", "HEAD"), deps.NewOrdered())
+	assert.NoError(t, err)
+}
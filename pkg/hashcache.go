@@ -0,0 +1,89 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// verifiedFile records the sum a cache entry was last verified to have, along
+// with the signature of its files back then, as "<signature> <sum>". It is
+// written next to the package.
+const verifiedFile = ".verified"
+
+// racyWindow is how long after their last modification files are not trusted
+// by their signature, as later changes within the resolution of the
+// modification time would go unnoticed
+const racyWindow = 2 * time.Second
+
+// entrySignature summarizes the paths, sizes, modes, modification times and
+// inodes of the files of the package at dir, along with the settings of hc.
+// It changes whenever a file is touched. It also returns the time of the
+// latest modification.
+func entrySignature(dir string, hc hashConfig) (string, time.Time, error) {
+	files, err := hashedFiles(dir, hc)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %t %d\n", hc.namespace, strings.Join(hc.include, ","), hc.normalizeEOL, hc.unreadable)
+	var latest time.Time
+	for _, path := range files {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		fmt.Fprintf(h, "%q %d %o %d %d\n", filepath.ToSlash(rel), fi.Size(), fi.Mode(), fi.ModTime().UnixNano(), fileID(fi))
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), latest, nil
+}
+
+// verifiedSum returns the sum recorded for the cache entry cp, if it was
+// recorded with the given signature
+func verifiedSum(cp, signature string) string {
+	data, err := os.ReadFile(filepath.Join(cp, verifiedFile))
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] != signature {
+		return ""
+	}
+	return fields[1]
+}
+
+// recordVerified records that the cache entry cp had the sum when its files
+// had the signature. Files modified within the racyWindow are not recorded.
+// Failing to record only costs a full hash next time, so errors are ignored.
+func recordVerified(cp, signature, sum string, latest time.Time) {
+	if time.Since(latest) < racyWindow {
+		return
+	}
+	_ = os.WriteFile(filepath.Join(cp, verifiedFile), []byte(signature+" "+sum+"\n"), 0644)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVerifiedSidecar(t *testing.T) {
+	cp := t.TempDir()
+	d := testDep("a", "v1")
+	dir := filepath.Join(cp, d.Name())
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	main := filepath.Join(dir, "main.libsonnet")
+	require.NoError(t, os.WriteFile(main, []byte("{}"), 0644))
+	sum, err := hashDir(context.TODO(), dir, hashConfig{})
+	require.NoError(t, err)
+	d.Sum = sum
+	o := newOptions(nil)
+
+	// recently modified files are hashed, but not recorded
+	assert.True(t, check(context.TODO(), d, cp, o))
+	assert.NoFileExists(t, filepath.Join(cp, verifiedFile))
+
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(main, old, old))
+	assert.True(t, check(context.TODO(), d, cp, o))
+	assert.FileExists(t, filepath.Join(cp, verifiedFile))
+
+	// an unchanged signature is trusted without hashing
+	signature, _, err := entrySignature(dir, hashConfig{})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(cp, verifiedFile), []byte(signature+" recorded\n"), 0644))
+	recorded := d
+	recorded.Sum = "recorded"
+	assert.True(t, check(context.TODO(), recorded, cp, o))
+
+	// other hash settings have a signature of their own
+	assert.False(t, check(context.TODO(), recorded, cp, newOptions([]Option{WithNormalizeLineEndings(true)})))
+
+	// any change falls back to a full hash
+	require.NoError(t, os.WriteFile(main, []byte("{ a: 1 }"), 0644))
+	assert.False(t, check(context.TODO(), recorded, cp, o))
+	assert.False(t, check(context.TODO(), d, cp, o))
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPStatusError is returned if a HTTP request fails with an unexpected
+// status code
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d for %s", e.StatusCode, e.URL)
+}
+
+// HTTPRetryPolicy controls which failed HTTP requests are retried
+type HTTPRetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one
+	Attempts int
+	// Statuses are the status codes worth retrying. Requests failing with
+	// any other status fail immediately.
+	Statuses []int
+	// Delay before the first retry. It doubles with every further one.
+	Delay time.Duration
+}
+
+// DefaultHTTPRetryPolicy retries server side errors that are usually
+// transient, but never client errors like 401 or 404
+var DefaultHTTPRetryPolicy = HTTPRetryPolicy{
+	Attempts: 3,
+	Statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	Delay:    time.Second,
+}
+
+func (p HTTPRetryPolicy) retryable(status int) bool {
+	for _, s := range p.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// httpGet performs a GET request, retrying it according to the policy. Any
+// status other than 200 results in a HTTPStatusError. Reading the body is
+// subject to limit.
+func httpGet(ctx context.Context, client *http.Client, policy *HTTPRetryPolicy, limit *RateLimiter, url string) (*http.Response, error) {
+	return httpGetWithHeader(ctx, client, policy, limit, url, nil)
+}
+
+// httpGetWithHeader is httpGet sending additional headers, like credentials
+func httpGetWithHeader(ctx context.Context, client *http.Client, policy *HTTPRetryPolicy, limit *RateLimiter, url string, header http.Header) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if policy == nil {
+		policy = &DefaultHTTPRetryPolicy
+	}
+
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !GitQuiet {
+			logger(ctx).Request(url, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusOK {
+			resp.Body = limit.reader(ctx, resp.Body)
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		serr := &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		if attempt >= policy.Attempts || !policy.retryable(resp.StatusCode) {
+			return nil, serr
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// HTTPPackage installs an archive of the package downloaded from a plain
+// URL, a gzipped tarball or a zip file. If all of its content is wrapped in
+// a single directory, that directory is the package. The version is the
+// digest of the archive.
+type HTTPPackage struct {
+	Source *deps.HTTP
+
+	// Client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Retry controls how failed requests are retried. Defaults to
+	// DefaultHTTPRetryPolicy.
+	Retry *HTTPRetryPolicy
+	// Limit caps the bandwidth of downloads. Defaults to unlimited.
+	Limit *RateLimiter
+	// StagingDir is where downloads are prepared before being moved into
+	// place. Defaults to the directory the package is installed to.
+	StagingDir string
+}
+
+func NewHTTPPackage(source *deps.HTTP) Interface {
+	return &HTTPPackage{
+		Source: source,
+	}
+}
+
+// Install downloads the archive and extracts it. Its digest is checked
+// against the one of the source and version, if set, and returned as the
+// version.
+func (p *HTTPPackage) Install(ctx context.Context, name, dir, version string) (string, error) {
+	u, err := url.Parse(p.Source.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %s: %w", p.Source.URL, err)
+	}
+	ext := deps.ArchiveExtension(u.Path)
+	if ext == "" {
+		return "", fmt.Errorf("%s is no archive, expected one of %v", p.Source.URL, deps.ArchiveExtensions)
+	}
+
+	stagingDir := dir
+	if p.StagingDir != "" {
+		stagingDir = p.StagingDir
+		if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+			return "", pkgerrors.Wrap(err, "failed to create staging dir")
+		}
+	}
+	tmpDir, err := os.MkdirTemp(stagingDir, ".tmp-")
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive := filepath.Join(tmpDir, "archive")
+	digest, err := downloadDigest(ctx, p.Client, p.Retry, p.Limit, p.Source.URL, nil, archive)
+	if err != nil {
+		return "", err
+	}
+	for _, want := range []string{p.Source.Digest, version} {
+		if want != "" && want != digest {
+			return "", fmt.Errorf("%w: %s has digest %s, expected %s", IntegrityFailure, p.Source.URL, digest, want)
+		}
+	}
+
+	content := filepath.Join(tmpDir, "content")
+	switch ext {
+	case ".zip":
+		err = extractZip(archive, content)
+	default:
+		err = extractAsset(archive, content)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", p.Source.URL, err)
+	}
+
+	destPath := path.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return "", pkgerrors.Wrap(err, "failed to create parent path")
+	}
+	if err := os.RemoveAll(destPath); err != nil {
+		return "", pkgerrors.Wrap(err, "failed to clean previous destination path")
+	}
+	if err := moveDir(content, destPath); err != nil {
+		return "", pkgerrors.Wrap(err, "failed to move package")
+	}
+
+	return digest, nil
+}
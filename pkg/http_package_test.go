@@ -0,0 +1,171 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// zipOf returns a zip file of the files
+func zipOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// serveArchives serves the archives by path
+func serveArchives(t *testing.T, archives map[string]*[]byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a, ok := archives[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(*a)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestEnsureHTTP(t *testing.T) {
+	tarball := tarGz(t, map[string]string{"foo-1.0/main.libsonnet": "{}", "foo-1.0/lib/x.libsonnet": "{}"})
+	zipped := zipOf(t, map[string]string{"main.libsonnet": "{ zip: true }"})
+	srv := serveArchives(t, map[string]*[]byte{"/pkgs/foo-1.0.tar.gz": &tarball, "/pkgs/bar.zip": &zipped})
+
+	foo := &deps.HTTP{URL: srv.URL + "/pkgs/foo-1.0.tar.gz", Path: "example.com/foo"}
+	bar := &deps.HTTP{URL: srv.URL + "/pkgs/bar.zip", Digest: digestOf(zipped)}
+	jsf := v1.New()
+	jsf.Dependencies.Set(foo.Name(), deps.Dependency{Source: deps.Source{HTTPSource: foo}})
+	jsf.Dependencies.Set(bar.Name(), deps.Dependency{Source: deps.Source{HTTPSource: bar}})
+	require.NoError(t, jsf.Validate())
+
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	l, ok := locks.Get("example.com/foo")
+	require.True(t, ok)
+	assert.Equal(t, digestOf(tarball), l.Version, "the digest of the archive is the version")
+	assert.NotEmpty(t, l.Sum)
+	// the wrapping directory is dropped
+	assert.FileExists(t, filepath.Join(vendorDir, "example.com/foo/lib/x.libsonnet"))
+
+	l, ok = locks.Get(bar.Name())
+	require.True(t, ok)
+	assert.Equal(t, digestOf(zipped), l.Version)
+	dir, err := filepath.EvalSymlinks(filepath.Join(vendorDir, bar.Name()))
+	require.NoError(t, err)
+	sum, err := hashDir(context.TODO(), dir, hashConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, l.Sum, sum)
+	b, err := os.ReadFile(filepath.Join(vendorDir, bar.Name(), "main.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ zip: true }", string(b))
+
+	// once locked, a different archive at the same URL fails
+	tarball = tarGz(t, map[string]string{"main.libsonnet": "{ changed: true }"})
+	require.NoError(t, os.RemoveAll(filepath.Join(vendorDir, ".cache")))
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	assert.ErrorIs(t, err, IntegrityFailure)
+
+	// a new URL is downloaded again
+	foo.URL = srv.URL + "/pkgs/foo-1.1.tar.gz"
+	newer := tarGz(t, map[string]string{"main.libsonnet": "{ newer: true }"})
+	srv.Config.Handler = serveArchives(t, map[string]*[]byte{"/pkgs/foo-1.1.tar.gz": &newer, "/pkgs/bar.zip": &zipped}).Config.Handler
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	l, _ = locks.Get("example.com/foo")
+	assert.Equal(t, digestOf(newer), l.Version)
+}
+
+func TestHTTPUnsafeArchive(t *testing.T) {
+	// a symlink out of the package, written through afterwards
+	var linked bytes.Buffer
+	gw := gzip.NewWriter(&linked)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "out", Linkname: "..", Typeflag: tar.TypeSymlink}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "out/evil", Mode: 0644, Size: 2, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	// symlinks out of the package are rejected, even if unused
+	var absolute bytes.Buffer
+	gw = gzip.NewWriter(&absolute)
+	tw = tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "lib/main.libsonnet", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	var zippedLink bytes.Buffer
+	zw := zip.NewWriter(&zippedLink)
+	fh := &zip.FileHeader{Name: "lib/main.libsonnet"}
+	fh.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(fh)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("../../../main.libsonnet"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tarball := tarGz(t, map[string]string{"main.libsonnet": "{}", "../evil": "{}"})
+	zipped := zipOf(t, map[string]string{"main.libsonnet": "{}", "../evil": "{}"})
+	symlink := linked.Bytes()
+	absoluteLink := absolute.Bytes()
+	zipLink := zippedLink.Bytes()
+	srv := serveArchives(t, map[string]*[]byte{"/a.tar.gz": &tarball, "/a.zip": &zipped, "/link.tgz": &symlink, "/abs.tgz": &absoluteLink, "/link.zip": &zipLink})
+
+	for _, name := range []string{"a.tar.gz", "a.zip", "link.tgz", "abs.tgz", "link.zip"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			vendorDir := filepath.Join(dir, "vendor")
+			require.NoError(t, os.Mkdir(vendorDir, os.ModePerm))
+			p := NewHTTPPackage(&deps.HTTP{URL: srv.URL + "/" + name})
+			_, err := p.Install(context.TODO(), "pkg", vendorDir, "")
+			assert.ErrorIs(t, err, UnsafeArchive)
+			assert.NoFileExists(t, filepath.Join(dir, "evil"))
+			assert.NoFileExists(t, filepath.Join(vendorDir, "evil"))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestHTTPGetRetry(t *testing.T) {
+	policy := &HTTPRetryPolicy{
+		Attempts: 3,
+		Statuses: []int{http.StatusServiceUnavailable},
+		Delay:    time.Millisecond,
+	}
+
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		err      int
+	}{
+		{name: "ok", statuses: []int{200}, requests: 1},
+		{name: "retried", statuses: []int{503, 503, 200}, requests: 3},
+		{name: "exhausted", statuses: []int{503, 503, 503, 200}, requests: 3, err: 503},
+		{name: "not retried", statuses: []int{404, 200}, requests: 1, err: 404},
+		{name: "not listed", statuses: []int{502, 200}, requests: 1, err: 502},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statuses[requests])
+				requests++
+			}))
+			defer srv.Close()
+
+			resp, err := httpGet(context.TODO(), srv.Client(), policy, nil, srv.URL)
+			assert.Equal(t, tc.requests, requests)
+			if tc.err == 0 {
+				require.NoError(t, err)
+				resp.Body.Close()
+				return
+			}
+			var serr *HTTPStatusError
+			require.True(t, errors.As(err, &serr), "expected HTTPStatusError, got %v", err)
+			assert.Equal(t, tc.err, serr.StatusCode)
+		})
+	}
+}
+
+func TestDependencyRetryOverride(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	// globally, nothing is retried
+	o := newOptions([]Option{WithHTTPRetryPolicy(HTTPRetryPolicy{Attempts: 1, Statuses: []int{http.StatusServiceUnavailable}, Delay: time.Millisecond})})
+	src := &deps.Git{}
+	_, err := httpGet(context.TODO(), srv.Client(), o.retryPolicy(src), nil, srv.URL)
+	require.Error(t, err)
+	assert.Equal(t, 1, requests)
+
+	// the flaky dependency retries twice, keeping the rest of the policy
+	requests = 0
+	retries := 2
+	src.Retries = &retries
+	policy := o.retryPolicy(src)
+	assert.Equal(t, time.Millisecond, policy.Delay)
+	resp, err := httpGet(context.TODO(), srv.Client(), policy, nil, srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 3, requests)
+
+	// without a global policy, the default one is adjusted
+	assert.Equal(t, 3, newOptions(nil).retryPolicy(src).Attempts)
+	assert.Nil(t, newOptions(nil).retryPolicy(&deps.Git{}))
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	d := deps.Dependency{Source: deps.Source{GitSource: &deps.Git{}}}
+	assert.Zero(t, newOptions(nil).downloadTimeout(d))
+	assert.Equal(t, time.Minute, newOptions([]Option{WithTimeout(time.Minute)}).downloadTimeout(d))
+
+	d.Source.GitSource.Timeout = time.Second
+	assert.Equal(t, time.Second, newOptions([]Option{WithTimeout(time.Minute)}).downloadTimeout(d))
+
+	// a timed out download fails
+	r := newTestRepo(t, "timeout")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	r.src.Timeout = time.Nanosecond
+	_, err := download(context.TODO(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"}, t.TempDir(), "", newOptions(nil), nil)
+	assert.ErrorIs(t, err, TimedOut)
+
+	// and is reported as such by Ensure
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	_, _, err = Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	assert.ErrorIs(t, err, TimedOut)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+// included returns whether the file at the slash separated path rel, relative
+// to the package, is part of the include list. An empty list includes
+// everything. The jsonnetfile is always included, so nested dependencies are
+// still known.
+func included(include []string, rel string) bool {
+	if len(include) == 0 || rel == jsonnetfile.File {
+		return true
+	}
+	for _, pattern := range include {
+		pattern = strings.TrimPrefix(pattern, "/")
+		// the file itself or any of its parent directories may match
+		for p := rel; p != "."; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeExcluded removes all files of the package at dir that are not part of
+// the include list, along with the directories left empty
+func removeExcluded(dir string, include []string) error {
+	if len(include) == 0 {
+		return nil
+	}
+
+	dirs := []string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if info.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if included(include, filepath.ToSlash(rel)) {
+			return nil
+		}
+		return os.Remove(p)
+	})
+	if err != nil {
+		return err
+	}
+
+	// deepest first, so parents are empty once their children are removed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestIncluded(t *testing.T) {
+	include := []string{"main.libsonnet", "/lib/*.libsonnet", "docs"}
+	tests := map[string]bool{
+		"main.libsonnet":         true,
+		"jsonnetfile.json":       true,
+		"lib/a.libsonnet":        true,
+		"lib/a.json":             false,
+		"lib/nested/a.libsonnet": false,
+		"docs/README.md":         true,
+		"docs/img/logo.png":      true,
+		"other.libsonnet":        false,
+	}
+	for rel, want := range tests {
+		assert.Equal(t, want, included(include, rel), rel)
+		assert.True(t, included(nil, rel), rel)
+	}
+}
+
+func TestEnsureInclude(t *testing.T) {
+	r := newTestRepo(t, "include")
+	r.commit(map[string]string{
+		"lib/main.libsonnet":   "{}",
+		"lib/big.libsonnet":    "{ big: true }",
+		"lib/util/a.libsonnet": "{ a: 1 }",
+		"lib/util/b.libsonnet": "{ b: 1 }",
+		"lib/jsonnetfile.json": `{"version": 1, "dependencies": []}`,
+	})
+	r.src.Subdir = "/lib"
+	r.src.Include = []string{"main.libsonnet", "util/a.libsonnet"}
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	dir := filepath.Join(vendorDir, r.src.Name())
+	files, err := packageFiles(dir + "/")
+	require.NoError(t, err)
+	rel := []string{}
+	for _, f := range files {
+		p, err := filepath.Rel(dir, f)
+		require.NoError(t, err)
+		rel = append(rel, filepath.ToSlash(p))
+	}
+	assert.Equal(t, []string{"jsonnetfile.json", "main.libsonnet", "util/a.libsonnet"}, rel)
+
+	// the lock records the include list, so check applies it as well and
+	// files outside of it don't matter
+	lock, _ := locks.Get(r.src.Name())
+	assert.Equal(t, r.src.Include, lock.Source.GitSource.Include)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stray.libsonnet"), []byte("{}"), 0644))
+	cp := cachePath(vendorDir, deps.Dependency{Source: lock.Source, Version: "master"})
+	assert.True(t, check(context.TODO(), lock, cp, newOptions(nil)))
+}
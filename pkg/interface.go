@@ -21,3 +21,10 @@ import (
 type Interface interface {
 	Install(ctx context.Context, name, dir, version string) (lockVersion string, err error)
 }
+
+// Resolver is implemented by packages that can resolve a version to the
+// version that will be locked without installing anything. The tag is set if
+// the version was resolved using one.
+type Resolver interface {
+	Resolve(ctx context.Context, version string) (lockVersion string, tag string, err error)
+}
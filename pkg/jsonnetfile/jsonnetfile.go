@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -34,6 +36,25 @@ var (
 	ErrUpdateJB = errors.New("jsonnetfile version unknown, update jb")
 )
 
+// LockPath returns the path of the lockfile belonging to the jsonnetfile in
+// dir. An empty location is the LockFile next to the jsonnetfile, relative
+// ones are relative to dir. Locations that are a directory, or end in a path
+// separator, hold a LockFile. Local sources in the lock stay relative to dir,
+// wherever the lock is written.
+func LockPath(dir, location string) string {
+	if location == "" {
+		return filepath.Join(dir, LockFile)
+	}
+	path := location
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if fi, err := os.Stat(path); (err == nil && fi.IsDir()) || strings.HasSuffix(location, "/") || strings.HasSuffix(location, string(filepath.Separator)) {
+		return filepath.Join(path, LockFile)
+	}
+	return path
+}
+
 // Load reads a jsonnetfile.(lock).json from disk
 func Load(filepath string) (v1.JsonnetFile, error) {
 	bytes, err := ioutil.ReadFile(filepath)
@@ -255,3 +255,20 @@ func TestFileExists(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestLockPath(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "build"), os.ModePerm))
+
+	tests := map[string]string{
+		"":                     filepath.Join(dir, jsonnetfile.LockFile),
+		"custom.lock.json":     filepath.Join(dir, "custom.lock.json"),
+		"build":                filepath.Join(dir, "build", jsonnetfile.LockFile),
+		"out/":                 filepath.Join(dir, "out", jsonnetfile.LockFile),
+		"/abs/custom.json":     "/abs/custom.json",
+		"../sibling/lock.json": filepath.Join(filepath.Dir(dir), "sibling", "lock.json"),
+	}
+	for location, want := range tests {
+		assert.Equal(t, want, jsonnetfile.LockPath(dir, location), location)
+	}
+}
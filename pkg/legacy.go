@@ -0,0 +1,65 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// LegacyNameTaken is returned by Ensure, if WithStrictLegacy is set, for
+// legacy names that couldn't be linked to their package
+var LegacyNameTaken = errors.New("legacy names are taken")
+
+// legacyCollisions returns the legacy names linkLegacy, or linkLegacyPrimary
+// if primary, left pointing elsewhere than their package, each along with
+// what is in the way
+func legacyCollisions(vendorDir, prefix string, locks *deps.Ordered, primary bool) []Warning {
+	collisions := []Warning{}
+	for _, l := range legacyLinks(locks, prefix) {
+		collision := func(format string, a ...interface{}) {
+			name := filepath.ToSlash(strings.TrimPrefix(l.pkgName, prefix+string(filepath.Separator)))
+			collisions = append(collisions, Warning{Kind: WarningLegacyName, Package: name, Message: fmt.Sprintf(format, a...)})
+		}
+
+		legacyName := filepath.Join(vendorDir, l.legacyName)
+		if primary {
+			fullName := filepath.Join(vendorDir, l.pkgName)
+			back, _ := filepath.Rel(filepath.Dir(fullName), legacyName)
+			if target, err := os.Readlink(fullName); err == nil && target == back {
+				continue
+			}
+		} else if target, err := os.Readlink(legacyName); err == nil && target == l.pkgName {
+			continue
+		}
+
+		fi, err := os.Lstat(legacyName)
+		switch {
+		case err != nil:
+			collision("'%s' for '%s': %s", l.legacyName, l.pkgName, err)
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(legacyName)
+			collision("'%s' for '%s': used by '%s'", l.legacyName, l.pkgName, target)
+		default:
+			collision("'%s' for '%s': file/directory exists", l.legacyName, l.pkgName)
+		}
+	}
+	return collisions
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestLegacyCollisions(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	taken := vendorPackage(t, vendorDir, testDep("taken", "v1"), map[string]string{"t.libsonnet": "{}"})
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, "taken"), os.ModePerm))
+	locks := orderedOf(a, taken)
+
+	require.NoError(t, linkLegacy(context.TODO(), vendorDir, "", locks, false))
+	collisions := legacyCollisions(vendorDir, "", locks, false)
+	require.Len(t, collisions, 1)
+	assert.Contains(t, collisions[0].Message, "'taken' for '"+taken.Name()+"'")
+	assert.Equal(t, taken.Name(), collisions[0].Package)
+
+	primaryDir := t.TempDir()
+	a = vendorPackage(t, primaryDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	taken = vendorPackage(t, primaryDir, testDep("taken", "v1"), map[string]string{"t.libsonnet": "{}"})
+	require.NoError(t, os.MkdirAll(filepath.Join(primaryDir, "taken"), os.ModePerm))
+	locks = orderedOf(a, taken)
+
+	require.NoError(t, linkLegacyPrimary(context.TODO(), primaryDir, "", locks))
+	collisions = legacyCollisions(primaryDir, "", locks, true)
+	require.Len(t, collisions, 1)
+	assert.Contains(t, collisions[0].Message, "'taken' for '"+taken.Name()+"'")
+}
+
+func TestEnsureStrictLegacy(t *testing.T) {
+	// both packages are named lib by their legacy name
+	jsf := v1.New()
+	for _, name := range []string{"first", "second"} {
+		r := newTestRepo(t, name)
+		r.commit(map[string]string{"lib/main.libsonnet": "{}"})
+		src := *r.src
+		src.Subdir = "/lib"
+		jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: "master"})
+	}
+
+	_, warnings, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, WarningLegacyName, warnings[0].Kind)
+	assert.Equal(t, "example.com/test/second/lib", warnings[0].Package)
+
+	_, _, err = Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithStrictLegacy(true))
+	require.ErrorIs(t, err, LegacyNameTaken)
+	assert.ErrorContains(t, err, "'lib' for 'example.com/test/second/lib'")
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// LicenseNotAllowed is returned if the license of a package is not part of
+// the allowlist of WithLicenseCheck
+var LicenseNotAllowed = errors.New("license not allowed")
+
+// LicenseUnknown is the license of packages without a license file, or with
+// one that is not recognized
+const LicenseUnknown = "unknown"
+
+// UnlicensedPolicy controls what WithLicenseCheck does about packages whose
+// license is LicenseUnknown
+type UnlicensedPolicy int
+
+const (
+	// UnlicensedWarn prints a warning
+	UnlicensedWarn UnlicensedPolicy = iota
+	// UnlicensedFail fails like a license that is not allowed
+	UnlicensedFail
+)
+
+// licenseFiles are the names of license files, matched case-insensitively
+// and with any extension
+var licenseFiles = []string{"license", "licence", "copying"}
+
+// licenseMarkers classify license texts by phrases their head contains, the
+// first entry with all phrases present wins. More specific licenses come
+// first.
+var licenseMarkers = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+}
+
+// DetectLicense returns the SPDX identifier of the license in the license
+// file at the root of dir, or LicenseUnknown
+func DetectLicense(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return LicenseUnknown
+	}
+	for _, e := range entries {
+		base := strings.ToLower(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())))
+		if !slices.Contains(licenseFiles, base) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		if id := classifyLicense(string(b)); id != LicenseUnknown {
+			return id
+		}
+	}
+	return LicenseUnknown
+}
+
+// licenseHead is how much of a license text is classified. Licenses name
+// each other further down, e.g. the GPL recommends the LGPL at the end.
+const licenseHead = 1000
+
+// classifyLicense returns the SPDX identifier of the license text
+func classifyLicense(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	if len(text) > licenseHead {
+		text = text[:licenseHead]
+	}
+	for _, m := range licenseMarkers {
+		all := true
+		for _, p := range m.phrases {
+			all = all && strings.Contains(text, p)
+		}
+		if all {
+			return m.id
+		}
+	}
+	return LicenseUnknown
+}
+
+// licenseCheck is the configuration of WithLicenseCheck
+type licenseCheck struct {
+	// allowed holds the lower case identifiers of the allowed licenses
+	allowed    map[string]struct{}
+	unlicensed UnlicensedPolicy
+}
+
+// checkLicenses fails with LicenseNotAllowed, listing all offenders, if a
+// locked package has a license not allowed by c. Local packages are skipped.
+func checkLicenses(ctx context.Context, vendorDir, prefix string, locks *deps.Ordered, c *licenseCheck) error {
+	offenders := []string{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.LocalSource != nil {
+			continue
+		}
+
+		id := DetectLicense(filepath.Join(vendorDir, prefix, d.Name()))
+		if _, ok := c.allowed[strings.ToLower(id)]; ok {
+			continue
+		}
+		if id == LicenseUnknown && c.unlicensed == UnlicensedWarn {
+			warn(ctx, WarningLicense, d.Name(), "no license detected for %s", d.Name())
+			continue
+		}
+		offenders = append(offenders, fmt.Sprintf("%s (%s)", d.Name(), id))
+	}
+	if len(offenders) == 0 {
+		return nil
+	}
+	sort.Strings(offenders)
+	return fmt.Errorf("%w:\n  %s", LicenseNotAllowed, strings.Join(offenders, "\n  "))
+}
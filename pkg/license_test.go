@@ -0,0 +1,96 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+const (
+	apacheText = `
+                                 Apache License
+                           Version 2.0, January 2004
+                        http://www.apache.org/licenses/`
+	gplText = `                    GNU GENERAL PUBLIC LICENSE
+                       Version 3, 29 June 2007`
+	mitText = `MIT License
+
+Copyright (c) 2018 someone
+
+Permission is hereby granted, free of charge, to any person obtaining a copy`
+)
+
+func TestClassifyLicense(t *testing.T) {
+	tests := map[string]string{
+		apacheText: "Apache-2.0",
+		gplText:    "GPL-3.0",
+		mitText:    "MIT",
+		// licenses mentioning others further down are not mistaken for them
+		gplText + strings.Repeat(" terms", 500) + " use the GNU Lesser General Public License instead": "GPL-3.0",
+		"GNU LESSER GENERAL PUBLIC LICENSE\nVersion 2.1, February 1999":                                "LGPL-2.1",
+		"Redistribution and use in source and binary forms ... 3. Neither the name of":                 "BSD-3-Clause",
+		"All rights reserved.": LicenseUnknown,
+	}
+	for text, id := range tests {
+		assert.Equal(t, id, classifyLicense(text), text)
+	}
+}
+
+func TestDetectLicense(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, LicenseUnknown, DetectLicense(dir))
+	assert.Equal(t, LicenseUnknown, DetectLicense(filepath.Join(dir, "missing")))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte(mitText), 0644))
+	assert.Equal(t, LicenseUnknown, DetectLicense(dir))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "License.md"), []byte(mitText), 0644))
+	assert.Equal(t, "MIT", DetectLicense(dir))
+}
+
+func TestEnsureLicenseCheck(t *testing.T) {
+	apache := newTestRepo(t, "apache")
+	apache.commit(map[string]string{"main.libsonnet": "{}", "LICENSE": apacheText})
+	gpl := newTestRepo(t, "gpl")
+	gpl.commit(map[string]string{"main.libsonnet": "{}", "COPYING": gplText})
+	none := newTestRepo(t, "none")
+	none.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := func(repos ...*testRepo) v1.JsonnetFile {
+		f := v1.New()
+		for _, r := range repos {
+			f.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+		}
+		return f
+	}
+	allowed := []string{"apache-2.0", "MIT"}
+
+	_, _, err := Ensure(context.TODO(), jsf(apache, none), t.TempDir(), deps.NewOrdered(), WithLicenseCheck(allowed, UnlicensedWarn))
+	assert.NoError(t, err)
+
+	_, _, err = Ensure(context.TODO(), jsf(apache, gpl, none), t.TempDir(), deps.NewOrdered(), WithLicenseCheck(allowed, UnlicensedFail))
+	require.ErrorIs(t, err, LicenseNotAllowed)
+	assert.Equal(t, "license not allowed:\n  "+gpl.src.Name()+" (GPL-3.0)\n  "+none.src.Name()+" (unknown)", err.Error())
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// LintLock reports everything keeping the lock from being normalized, like
+// entries out of order or leftover legacy names, so diffs of it stay clean.
+// It changes nothing, see NormalizeLock for that.
+func LintLock(locks *deps.Ordered) []string {
+	findings := []string{}
+
+	keys := locks.Keys()
+	if !sort.SliceIsSorted(keys, func(i, j int) bool {
+		return lockName(locks, keys[i]) < lockName(locks, keys[j])
+	}) {
+		findings = append(findings, "entries are not sorted by name")
+	}
+
+	seen := make(map[string]string, len(keys))
+	for _, k := range keys {
+		d, _ := locks.Get(k)
+		name := d.Name()
+
+		if k != name {
+			findings = append(findings, fmt.Sprintf("%s is keyed as %s", name, k))
+		}
+		if other, ok := seen[name]; ok {
+			findings = append(findings, fmt.Sprintf("%s is locked twice, as %s and %s", name, other, k))
+		}
+		seen[name] = k
+
+		if d.Source.LocalSource == nil && d.Sum == "" {
+			findings = append(findings, fmt.Sprintf("%s has no sum", name))
+		}
+		if d.LegacyNameCompat != "" && d.LegacyNameCompat == d.Source.LegacyName() {
+			findings = append(findings, fmt.Sprintf("%s has a redundant legacy name '%s'", name, d.LegacyNameCompat))
+		}
+	}
+	return findings
+}
+
+// NormalizeLock returns the locks sorted and keyed by name, without redundant
+// legacy names. Of packages locked twice, the last one wins, like it does
+// when reading the lock. Missing sums can't be fixed without downloading the
+// packages, which `jb install` does.
+func NormalizeLock(locks *deps.Ordered) *deps.Ordered {
+	byName := make(map[string]deps.Dependency)
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		byName[d.Name()] = d
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	normalized := deps.NewOrdered()
+	for _, name := range names {
+		normalized.Set(name, byName[name])
+	}
+	CleanLegacyName(normalized)
+	return normalized
+}
+
+// lockName returns the name of the package locked at key k
+func lockName(locks *deps.Ordered, k string) string {
+	d, _ := locks.Get(k)
+	return d.Name()
+}
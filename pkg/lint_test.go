@@ -0,0 +1,57 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestLintLock(t *testing.T) {
+	a, b, c := testDep("a", "v1"), testDep("b", "v1"), testDep("c", "v1")
+	a.Sum, c.Sum = "sum-a", "sum-c"
+	a.LegacyNameCompat = "a"
+	c.LegacyNameCompat = "custom"
+	local := deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "local"}}}
+
+	locks := deps.NewOrdered()
+	locks.Set(c.Name(), c)
+	locks.Set(a.Name(), a)
+	locks.Set(b.Name(), b)
+	locks.Set(local.Name(), local)
+	locks.Set("alias", b)
+
+	assert.Equal(t, []string{
+		"entries are not sorted by name",
+		"example.com/test/a has a redundant legacy name 'a'",
+		"example.com/test/b has no sum",
+		"example.com/test/b is keyed as alias",
+		"example.com/test/b is locked twice, as example.com/test/b and alias",
+		"example.com/test/b has no sum",
+	}, LintLock(locks))
+
+	normalized := NormalizeLock(locks)
+	assert.Equal(t, []string{a.Name(), b.Name(), c.Name(), local.Name()}, normalized.Keys())
+	d, _ := normalized.Get(a.Name())
+	assert.Empty(t, d.LegacyNameCompat)
+	d, _ = normalized.Get(c.Name())
+	assert.Equal(t, "custom", d.LegacyNameCompat)
+
+	// only the missing sum is left
+	assert.Equal(t, []string{"example.com/test/b has no sum"}, LintLock(normalized))
+}
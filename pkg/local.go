@@ -15,11 +15,13 @@
 package pkg
 
 import (
+	"bytes"
 	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
-	"github.com/fatih/color"
 	"github.com/pkg/errors"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
@@ -27,6 +29,9 @@ import (
 
 type LocalPackage struct {
 	Source *deps.Local
+
+	// dirty is set by Install if Source tracks git and has uncommitted changes
+	dirty bool
 }
 
 func NewLocalPackage(source *deps.Local) Interface {
@@ -64,7 +69,42 @@ func (p *LocalPackage) Install(ctx context.Context, name, dir, version string) (
 		return "", errors.Wrap(err, "failed to create symlink for local dependency")
 	}
 
-	color.Magenta("LOCAL %s -> %s", name, oldname)
+	logger(ctx).Local(name, oldname)
+
+	if !p.Source.Git {
+		return "", nil
+	}
+
+	sha, dirty, err := localGitState(ctx, oldname)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		warn(ctx, WarningDirty, name, "%s has uncommitted changes, vendoring them on top of %s", name, sha)
+	}
+	p.dirty = dirty
+	return sha, nil
+}
+
+// localGitState returns the HEAD of the git repository dir is part of and
+// whether anything below dir differs from it
+func localGitState(ctx context.Context, dir string) (sha string, dirty bool, err error) {
+	git := func(args ...string) (string, error) {
+		b := &bytes.Buffer{}
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Stdout = b
+		cmd.Dir = dir
+		err := cmd.Run()
+		return strings.TrimSpace(b.String()), err
+	}
 
-	return "", nil
+	sha, err = git("rev-parse", "HEAD")
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to get git HEAD of local source %s", dir)
+	}
+	status, err := git("status", "--porcelain", "--", ".")
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to get git status of local source %s", dir)
+	}
+	return sha, status != "", nil
 }
@@ -22,7 +22,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
 )
 
@@ -85,3 +87,57 @@ func TestLocalInstallSourceAndTargetDoNotExist(t *testing.T) {
 	assert.Error(t, err)
 	assert.Empty(t, lockVersion)
 }
+
+func TestLocalInstallGit(t *testing.T) {
+	r := newTestRepo(t, "monorepo")
+	sha := r.commit(map[string]string{"lib/main.libsonnet": "{}", "other/main.libsonnet": "{}"})
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	relPath, err := filepath.Rel(cwd, filepath.Join(r.dir, "lib"))
+	require.NoError(t, err)
+
+	jsf := v1.New()
+	jsf.Dependencies.Set("lib", deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: relPath, Git: true}}})
+	vendorDir := t.TempDir()
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	lock, _ := locks.Get("lib")
+	assert.Equal(t, sha, lock.Version)
+	assert.False(t, lock.Dirty)
+
+	// changes outside of the package don't count
+	require.NoError(t, os.WriteFile(filepath.Join(r.dir, "other", "main.libsonnet"), []byte("{ a: 1 }"), 0644))
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	lock, _ = locks.Get("lib")
+	assert.False(t, lock.Dirty)
+
+	require.NoError(t, os.WriteFile(filepath.Join(r.dir, "lib", "main.libsonnet"), []byte("{ a: 1 }"), 0644))
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	lock, _ = locks.Get("lib")
+	assert.Equal(t, sha, lock.Version)
+	assert.True(t, lock.Dirty)
+
+	// the lock follows new commits
+	sha = r.commit(nil)
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	lock, _ = locks.Get("lib")
+	assert.Equal(t, sha, lock.Version)
+	assert.False(t, lock.Dirty)
+}
+
+func TestLocalInstallGitOutsideRepository(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	relPath, err := filepath.Rel(cwd, t.TempDir())
+	require.NoError(t, err)
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+
+	p := NewLocalPackage(&deps.Local{Directory: relPath, Git: true})
+	_, err = p.Install(context.TODO(), "foo", t.TempDir(), "")
+	assert.ErrorContains(t, err, "failed to get git HEAD")
+}
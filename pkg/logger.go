@@ -0,0 +1,112 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Logger receives the status messages of Ensure, so programs using this
+// package can route them to their own logging. Its methods may be called
+// concurrently.
+type Logger interface {
+	// Clean reports a directory removed from vendor
+	Clean(path string)
+	// ChecksumFail reports an installed package not matching its checksum
+	ChecksumFail(name, version string)
+	// Prune reports a package removed from the lock, for the reason given,
+	// if any
+	Prune(name, reason string)
+	// Strip reports a file removed from a package, like a binary
+	Strip(name, file string)
+	// Local reports a local package linked to its directory
+	Local(name, dir string)
+	// Duplicate reports packages with identical contents
+	Duplicate(sum string, packages []string)
+	// Request reports an HTTP request and the status it got
+	Request(url string, status int)
+	// Warn reports something Ensure worked around
+	Warn(format string, a ...interface{})
+	// Error reports something that failed, but didn't stop Ensure
+	Error(format string, a ...interface{})
+}
+
+// colorLogger is the default Logger, printing to the terminal in color. The
+// messages of a package are buffered according to ctx.
+type colorLogger struct {
+	ctx context.Context
+}
+
+func (l colorLogger) Clean(path string) {
+	printColor(l.ctx, color.FgMagenta, "CLEAN %s", path)
+}
+
+func (l colorLogger) ChecksumFail(name, version string) {
+	printColor(l.ctx, color.FgYellow, "CHECKSUM FAIL %s@%s", name, version)
+}
+
+func (l colorLogger) Prune(name, reason string) {
+	if reason != "" {
+		printColor(l.ctx, color.FgMagenta, "PRUNE %s (%s)", name, reason)
+		return
+	}
+	printColor(l.ctx, color.FgMagenta, "PRUNE %s", name)
+}
+
+func (l colorLogger) Strip(name, file string) {
+	printColor(l.ctx, color.FgYellow, "STRIP %s: %s", name, file)
+}
+
+func (l colorLogger) Local(name, dir string) {
+	printColor(l.ctx, color.FgMagenta, "LOCAL %s -> %s", name, dir)
+}
+
+func (l colorLogger) Duplicate(sum string, packages []string) {
+	printColor(l.ctx, color.FgYellow, "DUPLICATE %s: %s", sum, strings.Join(packages, ", "))
+}
+
+func (l colorLogger) Request(url string, status int) {
+	printColor(l.ctx, color.FgCyan, "GET %s %d", url, status)
+}
+
+func (l colorLogger) Warn(format string, a ...interface{}) {
+	printColor(l.ctx, color.FgYellow, "WARN: %s", fmt.Sprintf(format, a...))
+}
+
+func (l colorLogger) Error(format string, a ...interface{}) {
+	printColor(l.ctx, color.FgRed, "ERROR: %s", fmt.Sprintf(format, a...))
+}
+
+type loggerKey struct{}
+
+// withLogger returns ctx logging to l. A nil l keeps the default.
+func withLogger(ctx context.Context, l Logger) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logger returns the Logger of ctx, defaulting to the terminal
+func logger(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return colorLogger{ctx: ctx}
+}
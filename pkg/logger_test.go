@@ -0,0 +1,107 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func (l *recordingLogger) Clean(path string) {
+	l.record("clean " + path)
+}
+
+func (l *recordingLogger) ChecksumFail(name, version string) {
+	l.record("checksum " + name + "@" + version)
+}
+
+func (l *recordingLogger) Prune(name, reason string) {
+	l.record("prune " + name + " " + reason)
+}
+
+func (l *recordingLogger) Strip(name, file string) {
+	l.record("strip " + name + ": " + file)
+}
+
+func (l *recordingLogger) Local(name, dir string) {
+	l.record("local " + name + " -> " + dir)
+}
+
+func (l *recordingLogger) Duplicate(sum string, packages []string) {
+	l.record("duplicate " + sum + ": " + strings.Join(packages, ", "))
+}
+
+func (l *recordingLogger) Request(url string, status int) {
+	l.record(fmt.Sprintf("get %s %d", url, status))
+}
+
+func (l *recordingLogger) Warn(format string, a ...interface{}) {
+	l.record("warn " + fmt.Sprintf(format, a...))
+}
+
+func (l *recordingLogger) Error(format string, a ...interface{}) {
+	l.record("error " + fmt.Sprintf(format, a...))
+}
+
+func TestEnsureLogger(t *testing.T) {
+	r := newTestRepo(t, "logged")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+
+	stale := filepath.Join(vendorDir, "stale")
+	require.NoError(t, os.Mkdir(stale, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"), []byte("{ changed: true }"), 0644))
+
+	logger := &recordingLogger{}
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithLogger(logger))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"checksum " + r.src.Name() + "@" + l.Version,
+		"clean " + stale,
+	}, logger.messages)
+
+	logger = &recordingLogger{}
+	_, _, err = Ensure(context.TODO(), v1.New(), vendorDir, locks, WithLogger(logger), WithPruneLock(true))
+	require.NoError(t, err)
+	assert.Contains(t, logger.messages, "prune "+r.src.Name()+" ")
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// ManifestFile is the name of the vendor manifest, relative to the vendor
+// directory. Being a file at the top of vendor, it is neither part of a
+// package checksum nor removed during cleanup.
+const ManifestFile = "jsonnetfile.manifest.json"
+
+// Manifest lists all vendored files and where they came from
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is a single vendored file
+type ManifestEntry struct {
+	// Path relative to the vendor directory, using forward slashes
+	Path    string `json:"path"`
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+}
+
+// BuildManifest lists the files of all locked packages present in vendor.
+// Entries are sorted by path, so the result is deterministic.
+// Only WithVendorPrefix is relevant of the options.
+func BuildManifest(vendorDir string, locks *deps.Ordered, opts ...Option) (*Manifest, error) {
+	o := newOptions(opts)
+	m := &Manifest{Files: []ManifestEntry{}}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+
+		dir, err := filepath.EvalSymlinks(filepath.Join(vendorDir, o.vendorPrefix, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files, err := packageFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			rel, err := filepath.Rel(dir, f)
+			if err != nil {
+				return nil, err
+			}
+			m.Files = append(m.Files, ManifestEntry{
+				Path:    filepath.ToSlash(filepath.Join(o.vendorPrefix, d.Name(), rel)),
+				Package: d.Name(),
+				Version: d.Version,
+				Source:  sourceURL(d.Source),
+			})
+		}
+	}
+
+	sort.SliceStable(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	return m, nil
+}
+
+// writeManifest writes the manifest of the locked packages into vendor
+func writeManifest(vendorDir, prefix string, locks *deps.Ordered) error {
+	m, err := BuildManifest(vendorDir, locks, WithVendorPrefix(prefix))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return os.WriteFile(filepath.Join(vendorDir, ManifestFile), b, 0644)
+}
+
+// sourceURL returns where a package is retrieved from
+func sourceURL(s deps.Source) string {
+	switch {
+	case s.GitSource != nil:
+		return s.GitSource.Remote()
+	case s.LocalSource != nil:
+		return s.LocalSource.Directory
+	case s.ReleaseSource != nil:
+		return "https://" + s.ReleaseSource.Name() + "/releases"
+	case s.HTTPSource != nil:
+		return s.HTTPSource.URL
+	default:
+		return ""
+	}
+}
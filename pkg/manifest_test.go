@@ -0,0 +1,37 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildManifest(t *testing.T) {
+	vendorDir := t.TempDir()
+	b := vendorPackage(t, vendorDir, testDep("b", "v2"), map[string]string{"b.libsonnet": "{}"})
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"z.libsonnet": "{}", "lib/a.libsonnet": "{}"})
+
+	m, err := BuildManifest(vendorDir, orderedOf(b, a))
+	require.NoError(t, err)
+
+	assert.Equal(t, []ManifestEntry{
+		{Path: "example.com/test/a/lib/a.libsonnet", Package: "example.com/test/a", Version: "v1", Source: "https://example.com/test/a.git"},
+		{Path: "example.com/test/a/z.libsonnet", Package: "example.com/test/a", Version: "v1", Source: "https://example.com/test/a.git"},
+		{Path: "example.com/test/b/b.libsonnet", Package: "example.com/test/b", Version: "v2", Source: "https://example.com/test/b.git"},
+	}, m.Files)
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MaterializeMode controls whether vendor links to the cache or holds real
+// copies of the packages
+type MaterializeMode int
+
+const (
+	// MaterializeOff links packages into vendor
+	MaterializeOff MaterializeMode = iota
+	// MaterializeCopy replaces all symlinks in vendor by copies of their
+	// targets
+	MaterializeCopy
+	// MaterializeDetach copies like MaterializeCopy and removes the cache
+	// afterwards, leaving a self-contained vendor directory behind. As
+	// nothing is cached, packages are downloaded again on every run.
+	MaterializeDetach
+)
+
+// materialize replaces all symlinks in vendor by copies of their targets
+func materialize(vendorDir string, m MaterializeMode) error {
+	if m == MaterializeOff {
+		return nil
+	}
+
+	cacheDir := filepath.Join(vendorDir, ".cache")
+	links := []string{}
+	err := filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == cacheDir {
+			return filepath.SkipDir
+		}
+		if i.Mode()&os.ModeSymlink != 0 {
+			links = append(links, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return fmt.Errorf("failed to materialize %s: %w", link, err)
+		}
+		tmp := link + ".materialize"
+		if err := copyResolved(target, tmp, map[string]struct{}{}); err != nil {
+			os.RemoveAll(tmp)
+			return fmt.Errorf("failed to materialize %s: %w", link, err)
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, link); err != nil {
+			return err
+		}
+	}
+
+	if m == MaterializeDetach {
+		return os.RemoveAll(cacheDir)
+	}
+	return nil
+}
+
+// copyResolved copies src to dst like copyDir, but follows symlinks instead
+// of copying them. parents holds the directories currently being copied, to
+// detect cycles.
+func copyResolved(src, dst string, parents map[string]struct{}) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(src, dst, info.Mode().Perm())
+	}
+
+	real, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	if _, ok := parents[real]; ok {
+		return fmt.Errorf("symlink cycle at %s", src)
+	}
+	parents[real] = struct{}{}
+	defer delete(parents, real)
+
+	if err := os.MkdirAll(dst, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := copyResolved(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()), parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// PackageMetaFile is the name of the file describing a package, inside its
+// vendored directory. It is neither part of the checksum of the package nor
+// of the files listed for it.
+const PackageMetaFile = ".jb-meta.json"
+
+// PackageMeta describes where a vendored package comes from, so tooling can
+// tell without reading the lock
+type PackageMeta struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Sum     string      `json:"sum,omitempty"`
+	Source  deps.Source `json:"source"`
+}
+
+// writePackageMetas writes a PackageMetaFile into the vendored directory of
+// every locked package. Local packages are skipped, as their directory is
+// the source itself.
+func writePackageMetas(vendorDir, prefix string, locks *deps.Ordered) error {
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.LocalSource != nil {
+			continue
+		}
+
+		b, err := json.MarshalIndent(PackageMeta{Name: d.Name(), Version: d.Version, Sum: d.Sum, Source: d.Source}, "", "  ")
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+
+		path := filepath.Join(vendorDir, prefix, d.Name(), PackageMetaFile)
+		// leave the file alone if it is up to date already
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, b) {
+			continue
+		}
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
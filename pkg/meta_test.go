@@ -0,0 +1,68 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsurePackageMeta(t *testing.T) {
+	r := newTestRepo(t, "described")
+	sha := r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	d := deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"}
+	jsf.Dependencies.Set(r.src.Name(), d)
+	vendorDir := t.TempDir()
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithPackageMeta(true))
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+
+	data, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), PackageMetaFile))
+	require.NoError(t, err)
+	var meta PackageMeta
+	require.NoError(t, json.Unmarshal(data, &meta))
+	assert.Equal(t, r.src.Name(), meta.Name)
+	assert.Equal(t, sha, meta.Version)
+	assert.Equal(t, l.Sum, meta.Sum)
+	require.NotNil(t, meta.Source.GitSource)
+	assert.Equal(t, r.src.Remote(), meta.Source.GitSource.Remote())
+
+	// the file is not part of the package
+	assert.True(t, check(context.TODO(), l, cachePath(vendorDir, d), newOptions(nil)))
+	plan, err := PlanCleanup(vendorDir, locks)
+	require.NoError(t, err)
+	for _, p := range plan {
+		assert.False(t, p.Remove, p.Path)
+	}
+
+	again, _, err := Ensure(context.TODO(), jsf, vendorDir, locks, WithPackageMeta(true))
+	require.NoError(t, err)
+	assert.Equal(t, locks, again)
+	after, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), PackageMetaFile))
+	require.NoError(t, err)
+	assert.Equal(t, data, after)
+}
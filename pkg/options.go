@@ -0,0 +1,681 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// Option changes the behavior of Ensure
+type Option func(*options)
+
+// options holds the configuration of a single Ensure run.
+// The zero value is the default behavior.
+type options struct {
+	binaryPolicy  BinaryPolicy
+	unreadable    UnreadablePolicy
+	errorPolicy   ErrorPolicy
+	existing      ExistingPolicy
+	drift         DriftPolicy
+	licenses      *licenseCheck
+	versionPolicy *VersionPolicy
+	constraints   Constraints
+	registry      Index
+	output        OutputMode
+	logger        Logger
+	trace         *tracer
+	versionLess   func(a, b string) bool
+	strictLock    bool
+	strictVersion bool
+	pruneLock     bool
+	prefetch      bool
+	fast          bool
+	deterministic bool
+	manifest      bool
+	packageMeta   bool
+	tree          bool
+	snapshot      bool
+	transactional bool
+	readOnly      bool
+	dryRun        bool
+	profiles      map[string]struct{}
+	exclusive     bool
+	expectedTree  *Tree
+
+	reportDuplicates bool
+
+	checkSymlinks bool
+
+	legacyPrimary bool
+	strictLegacy  bool
+	materialize   MaterializeMode
+
+	symlinkFallback bool
+
+	stagingDir   string
+	vendorPrefix string
+	backend      string
+	gitProtocol  string
+	httpRetry    *HTTPRetryPolicy
+	retry        *DownloadRetryPolicy
+	bandwidth    *RateLimiter
+	timeout      time.Duration
+	concurrency  int
+	kindLimits   map[SourceKind]int
+	apiTokens    map[string]string
+	exclude      map[string]struct{}
+	normalizeEOL bool
+
+	refPrecedence RefPrecedence
+	hashNamespace string
+	cacheContext  func(deps.Dependency) string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithBinaryPolicy sets how binary files found in downloaded packages are
+// handled. Defaults to BinaryAllow.
+func WithBinaryPolicy(p BinaryPolicy) Option {
+	return func(o *options) {
+		o.binaryPolicy = p
+	}
+}
+
+// WithErrorPolicy sets how vendor is recovered if Ensure fails. Defaults to
+// ErrorLeave.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return func(o *options) {
+		o.errorPolicy = p
+	}
+}
+
+// WithExistingPolicy sets what happens if vendor holds a real directory where
+// a package is to be linked. Defaults to ExistingOverwrite.
+func WithExistingPolicy(p ExistingPolicy) Option {
+	return func(o *options) {
+		o.existing = p
+	}
+}
+
+// WithDriftPolicy sets what happens if a package following a branch no
+// longer matches the locked sum when downloaded again. Defaults to
+// DriftFail.
+func WithDriftPolicy(p DriftPolicy) Option {
+	return func(o *options) {
+		o.drift = p
+	}
+}
+
+// WithLicenseCheck fails Ensure with LicenseNotAllowed if the license of a
+// downloaded package is not one of allowed, given as SPDX identifiers like
+// "Apache-2.0". Packages without a recognized license are handled according
+// to unlicensed. Local packages are not checked.
+func WithLicenseCheck(allowed []string, unlicensed UnlicensedPolicy) Option {
+	return func(o *options) {
+		c := &licenseCheck{allowed: map[string]struct{}{}, unlicensed: unlicensed}
+		for _, id := range allowed {
+			c.allowed[strings.ToLower(id)] = struct{}{}
+		}
+		o.licenses = c
+	}
+}
+
+// WithVersionPolicy sets which version wins if a package is requested at
+// multiple versions. Defaults to the policy of the resolver version declared
+// by the jsonnetfile.
+func WithVersionPolicy(p VersionPolicy) Option {
+	return func(o *options) {
+		o.versionPolicy = &p
+	}
+}
+
+// versions returns the VersionPolicy to use, falling back to VersionFirst
+func (o *options) versions() VersionPolicy {
+	if o.versionPolicy == nil {
+		return VersionFirst
+	}
+	return *o.versionPolicy
+}
+
+// WithConstraints tightens or pins the versions of packages of the
+// jsonnetfile, taking precedence over the looser versions it asks for.
+// Constraints that don't fit the jsonnetfile fail Ensure with
+// ConstraintConflict. See LoadConstraints.
+func WithConstraints(c Constraints) Option {
+	return func(o *options) {
+		o.constraints = c
+	}
+}
+
+// WithRegistry resolves registry sources, in the jsonnetfile and in nested
+// ones, to the concrete sources of the index before anything is resolved.
+// The lock records the concrete sources. Without it, registry sources fail.
+func WithRegistry(index Index) Option {
+	return func(o *options) {
+		o.registry = index
+	}
+}
+
+// WithOutputMode sets how the messages of packages downloaded concurrently
+// are printed. Defaults to OutputStream.
+func WithOutputMode(m OutputMode) Option {
+	return func(o *options) {
+		o.output = m
+	}
+}
+
+// WithLogger sends the status messages of Ensure to l instead of printing
+// them. They are passed on as they happen, regardless of the OutputMode.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithStrictVersions ignores the fallback versions of all packages, so a
+// version that can't be installed anymore is an error.
+func WithStrictVersions(strict bool) Option {
+	return func(o *options) {
+		o.strictVersion = strict
+	}
+}
+
+// WithResolutionTrace writes every step of the resolution to w, for
+// debugging unexpected versions: ref resolutions, cache hits and misses,
+// nested jsonnetfiles and the winners of version conflicts.
+func WithResolutionTrace(w io.Writer) Option {
+	return func(o *options) {
+		o.trace = newTracer(w)
+	}
+}
+
+// WithVersionLess sets how versions are ordered when VersionHighest decides
+// between colliding versions, for schemes that are not semver. less must
+// report whether a is lower than b. Defaults to DefaultVersionLess.
+func WithVersionLess(less func(a, b string) bool) Option {
+	return func(o *options) {
+		o.versionLess = less
+	}
+}
+
+// WithCacheContext isolates cache entries by the context a package is
+// fetched in, for sources whose content depends on e.g. the credentials or
+// the mirror used. Packages of different contexts are cached separately.
+// Only a hash of the context ends up in the cache, so it may contain
+// secrets. An empty context shares the entry with plain runs.
+func WithCacheContext(context func(d deps.Dependency) string) Option {
+	return func(o *options) {
+		o.cacheContext = context
+	}
+}
+
+// cachePath returns the cache entry of d, within its cache context if any
+func (o *options) cachePath(vendorDir string, d deps.Dependency) string {
+	cp := cachePath(vendorDir, d)
+	if o.cacheContext == nil {
+		return cp
+	}
+	ctx := o.cacheContext(d)
+	if ctx == "" {
+		return cp
+	}
+	sum := sha256.Sum256([]byte(ctx))
+	return cp + "-" + hex.EncodeToString(sum[:8])
+}
+
+// WithExclude prunes the packages of the given names from the dependency
+// tree wherever a nested jsonnetfile requires them, for packages provided
+// some other way. They are neither downloaded nor locked, so anything left of
+// them in vendor is cleaned up. Direct dependencies are not affected.
+func WithExclude(names ...string) Option {
+	return func(o *options) {
+		if o.exclude == nil {
+			o.exclude = map[string]struct{}{}
+		}
+		for _, n := range names {
+			o.exclude[n] = struct{}{}
+		}
+	}
+}
+
+// WithProfiles activates the given profiles, installing the dependencies
+// restricted to them as well. Dependencies without profiles are always
+// installed. Those of inactive profiles are left in vendor and the lock as
+// they are, unless WithExclusiveProfiles is set.
+func WithProfiles(names ...string) Option {
+	return func(o *options) {
+		if o.profiles == nil {
+			o.profiles = map[string]struct{}{}
+		}
+		for _, n := range names {
+			o.profiles[n] = struct{}{}
+		}
+	}
+}
+
+// WithExclusiveProfiles removes the packages only required by inactive
+// profiles from vendor and the lock, so vendor holds exactly the active
+// profiles.
+func WithExclusiveProfiles(exclusive bool) Option {
+	return func(o *options) {
+		o.exclusive = exclusive
+	}
+}
+
+// WithStrictLock makes Ensure fail if a transitive dependency is not
+// already part of the lock. This prevents new dependencies from silently
+// appearing in the tree without an explicit `jb update`.
+func WithStrictLock(strict bool) Option {
+	return func(o *options) {
+		o.strictLock = strict
+	}
+}
+
+// WithFast trusts the packages present in the cache to match their lock, by
+// only checking that they exist. Only packages entirely missing are
+// downloaded. This is much faster for large trees, but corrupted or modified
+// packages go unnoticed, so it is only meant for trusted local loops.
+func WithFast(fast bool) Option {
+	return func(o *options) {
+		o.fast = fast
+	}
+}
+
+// WithDeterministic makes Ensure independent of the order concurrent
+// downloads finish in, and checks that by resolving the jsonnetfile a second
+// time, failing with Nondeterministic if the locks differ. Meant for CI and
+// tests, as it is slower.
+func WithDeterministic(deterministic bool) Option {
+	return func(o *options) {
+		o.deterministic = deterministic
+	}
+}
+
+// WithStrictLegacy makes Ensure fail if legacy names are taken, listing all
+// of them, instead of warning and leaving these packages to be imported by
+// their absolute name.
+func WithStrictLegacy(strict bool) Option {
+	return func(o *options) {
+		o.strictLegacy = strict
+	}
+}
+
+// WithPruneLock removes packages that are no longer reachable from the direct
+// dependencies from the lock. Otherwise they are only reported.
+func WithPruneLock(prune bool) Option {
+	return func(o *options) {
+		o.pruneLock = prune
+	}
+}
+
+// WithHashNamespace scopes all checksums to the given namespace, so sums
+// recorded by one project are not valid for another one sharing the cache.
+// It must be the same when writing and verifying a lock.
+func WithHashNamespace(ns string) Option {
+	return func(o *options) {
+		o.hashNamespace = ns
+	}
+}
+
+func (o *options) hashConfig() hashConfig {
+	return hashConfig{namespace: o.hashNamespace, normalizeEOL: o.normalizeEOL, unreadable: o.unreadable}
+}
+
+// packageHashConfig is the hashConfig for the package of d
+func (o *options) packageHashConfig(d deps.Dependency) hashConfig {
+	hc := o.hashConfig()
+	if g := d.Source.GitSource; g != nil {
+		hc.include = g.Include
+		// relative to the package, which is the Subdir
+		up := strings.Repeat("../", strings.Count(strings.Trim(g.Subdir, "/"), "/")+1)
+		for _, f := range g.RootFiles {
+			hc.rootFiles = append(hc.rootFiles, up+f)
+		}
+	}
+	return hc
+}
+
+// WithUnreadablePolicy sets what computing the checksum of a package does
+// about files that can't be read. Defaults to UnreadableFail.
+func WithUnreadablePolicy(p UnreadablePolicy) Option {
+	return func(o *options) {
+		o.unreadable = p
+	}
+}
+
+// WithPrefetch starts downloading the locked nested packages that are known
+// from the jsonnetfiles in the cache right away, instead of discovering them
+// one level at a time.
+func WithPrefetch(prefetch bool) Option {
+	return func(o *options) {
+		o.prefetch = prefetch
+	}
+}
+
+// WithManifest writes a ManifestFile into vendor, listing all vendored files
+// together with the package, version and source they come from.
+func WithManifest(manifest bool) Option {
+	return func(o *options) {
+		o.manifest = manifest
+	}
+}
+
+// WithPackageMeta writes a PackageMetaFile into the directory of every
+// vendored package, recording its name, version, sum and source.
+func WithPackageMeta(meta bool) Option {
+	return func(o *options) {
+		o.packageMeta = meta
+	}
+}
+
+// WithTree writes the resolved Tree into vendor as TreeFile, recording which
+// package requires which.
+func WithTree(tree bool) Option {
+	return func(o *options) {
+		o.tree = tree
+	}
+}
+
+// WithSnapshot writes a SnapshotFile into vendor, recording every vendored
+// file of git packages, so CheckTamper can quickly detect modifications made
+// after the installation.
+func WithSnapshot(snapshot bool) Option {
+	return func(o *options) {
+		o.snapshot = snapshot
+	}
+}
+
+// WithTransactional gives Ensure all-or-nothing semantics: if vendor
+// already matches the lock completely, nothing is done at all. Otherwise,
+// everything but the cache is removed from vendor and installed again,
+// instead of fixing up only the packages that changed.
+func WithTransactional(transactional bool) Option {
+	return func(o *options) {
+		o.transactional = transactional
+	}
+}
+
+// WithReadOnly makes vendor a precondition instead of an output: if vendor
+// does not match the lock completely, Ensure fails with ReadOnlyVendor and
+// the first change it would have made, without touching the disk.
+func WithReadOnly(readOnly bool) Option {
+	return func(o *options) {
+		o.readOnly = readOnly
+	}
+}
+
+// WithDryRun makes Ensure report what it would do without touching vendor,
+// the cache or locks. If vendor matches the lock, nothing is downloaded.
+// Otherwise, Ensure runs in a scratch directory seeded with a copy of the
+// cache. The locks returned are the ones that would result, EnsureWithResult
+// tells how vendor would change as well.
+func WithDryRun(dryRun bool) Option {
+	return func(o *options) {
+		o.dryRun = dryRun
+	}
+}
+
+// WithExpectedTree fails Ensure with TreeMismatch if the resolved tree
+// differs in shape from t, e.g. one read by ReadTree after an earlier run.
+func WithExpectedTree(t *Tree) Option {
+	return func(o *options) {
+		o.expectedTree = t
+	}
+}
+
+// WithDuplicateReport reports packages with identical contents but different
+// names after resolution, which are likely the same library mirrored under
+// different URLs. This is purely advisory.
+func WithDuplicateReport(report bool) Option {
+	return func(o *options) {
+		o.reportDuplicates = report
+	}
+}
+
+// WithStagingDir sets where packages are downloaded to before being moved
+// into the cache. It should be on the same filesystem as the vendor
+// directory, otherwise packages are copied instead of moved atomically.
+func WithStagingDir(dir string) Option {
+	return func(o *options) {
+		o.stagingDir = dir
+	}
+}
+
+// WithVendorPrefix places all packages below the given directory inside of
+// vendor, e.g. vendor/gen/github.com/foo/bar. Package names and legacy
+// symlinks are not affected, so the physical layout can diverge from the
+// import paths during a migration.
+func WithVendorPrefix(prefix string) Option {
+	return func(o *options) {
+		o.vendorPrefix = prefix
+	}
+}
+
+// WithMaterialize copies the packages into vendor instead of linking them to
+// the cache. Defaults to MaterializeOff.
+func WithMaterialize(m MaterializeMode) Option {
+	return func(o *options) {
+		o.materialize = m
+	}
+}
+
+// WithSymlinkFallback copies packages into vendor if they can't be linked to
+// the cache, like on network mounts with spotty symlink support, instead of
+// failing. Legacy names that can't be linked are skipped. Each of these is
+// reported as a WarningSymlinkFallback.
+func WithSymlinkFallback(fallback bool) Option {
+	return func(o *options) {
+		o.symlinkFallback = fallback
+	}
+}
+
+// WithLegacyPrimary vendors packages under their legacy name, with the full
+// name being a symlink to it. This inverts the default, where the legacy name
+// is the symlink.
+func WithLegacyPrimary(primary bool) Option {
+	return func(o *options) {
+		o.legacyPrimary = primary
+	}
+}
+
+// WithGitBackend sets the backend used for git sources that don't choose one
+// themselves. Defaults to deps.GitBackendExec. Sources deps.GitBackendHTTP
+// doesn't support keep using deps.GitBackendExec.
+func WithGitBackend(backend string) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// gitBackend returns the backend to use for the source
+func (o *options) gitBackend(source *deps.Git) string {
+	switch {
+	case source.Backend != "":
+		return source.Backend
+	case o.backend == deps.GitBackendHTTP && !source.HTTPBackendSupported():
+		return deps.GitBackendExec
+	case o.backend != "":
+		return o.backend
+	default:
+		return deps.GitBackendExec
+	}
+}
+
+// WithGitProtocol forces the git protocol version ("0", "1" or "2") used by
+// the exec backend, for servers misbehaving with the one git picks. The
+// ProtocolVersion of a source takes precedence. Empty lets git decide, which
+// is the default.
+func WithGitProtocol(version string) Option {
+	return func(o *options) {
+		o.gitProtocol = version
+	}
+}
+
+// WithRefPrecedence decides whether a version naming both a tag and a branch
+// refers to the tag, which is the default like in git, or the branch. Which
+// one was used is recorded in the provenance of the lock.
+func WithRefPrecedence(p RefPrecedence) Option {
+	return func(o *options) {
+		o.refPrecedence = p
+	}
+}
+
+// WithNormalizeLineEndings converts CRLF line endings of text files to LF
+// when vendoring, and hashes them that way, so sums don't depend on the
+// platform or core.autocrlf. Binary files are left untouched. Changes the
+// sums of affected packages, so it is off by default.
+func WithNormalizeLineEndings(normalize bool) Option {
+	return func(o *options) {
+		o.normalizeEOL = normalize
+	}
+}
+
+// WithSymlinkCheck fails Ensure if any symlink in vendor points outside of
+// it after the installation. Only links of local packages are exempt.
+func WithSymlinkCheck(check bool) Option {
+	return func(o *options) {
+		o.checkSymlinks = check
+	}
+}
+
+// WithHTTPRetryPolicy sets how failed HTTP requests of downloads are retried.
+// Defaults to DefaultHTTPRetryPolicy.
+func WithHTTPRetryPolicy(p HTTPRetryPolicy) Option {
+	return func(o *options) {
+		o.httpRetry = &p
+	}
+}
+
+// retryPolicy returns the HTTP retry policy of the source, which may
+// override the number of retries of the global one
+func (o *options) retryPolicy(source *deps.Git) *HTTPRetryPolicy {
+	if source.Retries == nil {
+		return o.httpRetry
+	}
+	p := DefaultHTTPRetryPolicy
+	if o.httpRetry != nil {
+		p = *o.httpRetry
+	}
+	p.Attempts = *source.Retries + 1
+	return &p
+}
+
+// WithDownloadRetryPolicy sets how downloads failing for likely transient
+// reasons are retried. The Retries of a source take precedence. Defaults to
+// DefaultDownloadRetryPolicy.
+func WithDownloadRetryPolicy(p DownloadRetryPolicy) Option {
+	return func(o *options) {
+		o.retry = &p
+	}
+}
+
+// downloadRetryPolicy returns the policy of retrying failed downloads of d,
+// the Retries of its source overriding the number of retries of the global
+// one
+func (o *options) downloadRetryPolicy(d deps.Dependency) DownloadRetryPolicy {
+	p := DefaultDownloadRetryPolicy
+	if o.retry != nil {
+		p = *o.retry
+	}
+	if d.Source.GitSource != nil && d.Source.GitSource.Retries != nil {
+		p.Attempts = *d.Source.GitSource.Retries + 1
+	}
+	return p
+}
+
+// WithTimeout limits how long downloading a single package may take, each
+// package having the full timeout to itself. Downloads running out of time
+// fail with TimedOut. The Timeout of a source takes precedence. Defaults to
+// no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// downloadTimeout returns the timeout of downloading the package of d, zero
+// meaning none
+func (o *options) downloadTimeout(d deps.Dependency) time.Duration {
+	if d.Source.GitSource != nil && d.Source.GitSource.Timeout != 0 {
+		return d.Source.GitSource.Timeout
+	}
+	return o.timeout
+}
+
+// WithConcurrency limits how many packages are downloaded at once. Kinds of
+// sources limited by WithSourceConcurrency don't count towards it. Defaults
+// to four per CPU, less than zero meaning unlimited. It also limits how many
+// stale directories are removed from vendor at once, which defaults to the
+// number of CPUs.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithSourceConcurrency limits how many packages of the given kind are
+// downloaded at once, e.g. fewer git clones than archive downloads, as they
+// are heavier on CPU and disk. Kinds without a limit of their own share the
+// one of WithConcurrency.
+func WithSourceConcurrency(kind SourceKind, n int) Option {
+	return func(o *options) {
+		if o.kindLimits == nil {
+			o.kindLimits = map[SourceKind]int{}
+		}
+		o.kindLimits[kind] = n
+	}
+}
+
+// WithAPIToken lists the tags of sources hosted at host, APIHostGitHub or
+// APIHostGitLab, using its REST API authenticated by token, instead of
+// git ls-remote. This is faster for repositories with many refs. Only tags
+// are listed this way, everything else and failed requests fall back to git.
+func WithAPIToken(host, token string) Option {
+	return func(o *options) {
+		if o.apiTokens == nil {
+			o.apiTokens = map[string]string{}
+		}
+		o.apiTokens[host] = token
+	}
+}
+
+// tagAPI returns the API to list tags with, nil if no token is configured
+func (o *options) tagAPI() *tagAPI {
+	if len(o.apiTokens) == 0 {
+		return nil
+	}
+	return &tagAPI{tokens: o.apiTokens, retry: o.httpRetry}
+}
+
+// WithBandwidthLimit caps the bandwidth of HTTP downloads to bytesPerSecond,
+// shared by all concurrent downloads. Fetches done by the git executable are
+// not limited. Defaults to unlimited.
+func WithBandwidthLimit(bytesPerSecond int64) Option {
+	return func(o *options) {
+		o.bandwidth = NewRateLimiter(bytesPerSecond)
+	}
+}
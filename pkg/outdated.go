@@ -0,0 +1,141 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// NotApplicable is reported as the latest version of packages pinned to a
+// commit, as there is nothing newer to compare against
+const NotApplicable = "n/a"
+
+// OutdatedInfo compares the locked version of a package to the newest one
+// available
+type OutdatedInfo struct {
+	Name string
+	// Current is the tag or branch the package is locked at, or the locked
+	// commit if it is neither
+	Current string
+	// Latest is the highest semver tag for tags, the commit at the tip for
+	// branches and NotApplicable for commits
+	Latest   string
+	Outdated bool
+}
+
+// Outdated lists the remote references of all locked git packages and
+// reports whether newer versions are available. Neither the lock nor vendor
+// are modified. Only WithAPIToken and WithHTTPRetryPolicy are relevant of the
+// options.
+func Outdated(ctx context.Context, locks *deps.Ordered, opts ...Option) ([]OutdatedInfo, error) {
+	tags := newOptions(opts).tagAPI()
+
+	infos := []OutdatedInfo{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.GitSource == nil {
+			continue
+		}
+
+		// packages following a branch need the heads, which the API doesn't list
+		if !strings.HasPrefix(d.Provenance, "branch:") {
+			if refs, ok := tags.listTags(ctx, d.Source.GitSource); ok {
+				infos = append(infos, outdated(d, refs))
+				continue
+			}
+		}
+
+		refs, err := listRemoteRefs(ctx, d.Source.GitSource.Remote(), d.Source.GitSource.ProtocolVersion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check %s for newer versions: %w", d.Name(), err)
+		}
+		infos = append(infos, outdated(d, refs))
+	}
+	return infos, nil
+}
+
+// outdated compares the lock of d to the refs of its remote
+func outdated(d deps.Dependency, refs []gitRef) OutdatedInfo {
+	info := OutdatedInfo{Name: d.Name(), Current: d.Version, Latest: NotApplicable}
+
+	current := ""
+	switch {
+	case strings.HasPrefix(d.Provenance, "tag:"):
+		current = strings.TrimPrefix(d.Provenance, "tag:")
+	case strings.HasPrefix(d.Provenance, "branch:"):
+		branch := strings.TrimPrefix(d.Provenance, "branch:")
+		info.Current = branch
+		if sha, _, ok := selectRef(refs, refsHeadsPrefix+branch); ok {
+			info.Latest = sha
+			info.Outdated = sha != d.Version
+		}
+		return info
+	default:
+		current = lockedTag(refs, d.Version)
+	}
+
+	if current == "" {
+		return info
+	}
+	info.Current = current
+	cv, ok := parseSemver(current)
+	if !ok {
+		return info
+	}
+
+	// pre-releases are only offered to those already using them
+	latest, ok := highestTag(refs, &semverConstraint{}, d.Source.GitSource.PreReleases || cv.pre != "")
+	if !ok {
+		return info
+	}
+	lv, _ := parseSemver(latest)
+	info.Latest = latest
+	info.Outdated = lv.compare(cv) > 0
+	return info
+}
+
+// lockedTag returns the highest semver tag pointing to the commit sha, if any
+func lockedTag(refs []gitRef, sha string) string {
+	commits := map[string]string{}
+	for _, r := range refs {
+		if !strings.HasPrefix(r.name, refsTagsPrefix) {
+			continue
+		}
+		tag := strings.TrimPrefix(r.name, refsTagsPrefix)
+		// annotated tags point to the tag object, the peeled ref to the commit
+		if strings.HasSuffix(tag, peeledSuffix) {
+			commits[strings.TrimSuffix(tag, peeledSuffix)] = r.sha
+		} else if _, ok := commits[tag]; !ok {
+			commits[tag] = r.sha
+		}
+	}
+
+	best := ""
+	var bestVer semver
+	for tag, commit := range commits {
+		v, ok := parseSemver(tag)
+		if commit != sha || !ok {
+			continue
+		}
+		if best == "" || v.newer(bestVer) {
+			best, bestVer = tag, v
+		}
+	}
+	return best
+}
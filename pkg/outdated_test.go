@@ -0,0 +1,71 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestOutdated(t *testing.T) {
+	r := newTestRepo(t, "outdated")
+	v100 := r.commit(map[string]string{"main.libsonnet": "1"})
+	r.git("tag", "-a", "-m", "v1.0.0", "v1.0.0")
+	untagged := r.commit(map[string]string{"main.libsonnet": "1.0.1"})
+	v110 := r.commit(map[string]string{"main.libsonnet": "1.1"})
+	r.git("tag", "v1.1.0")
+	r.commit(map[string]string{"main.libsonnet": "2-rc"})
+	r.git("tag", "v2.0.0-rc.1")
+	r.git("checkout", "-b", "develop")
+	tip := r.commit(map[string]string{"main.libsonnet": "dev"})
+
+	lock := func(name, version, provenance string) deps.Dependency {
+		src := *r.src
+		src.Subdir = "/" + name
+		return deps.Dependency{Source: deps.Source{GitSource: &src}, Version: version, Provenance: provenance}
+	}
+	locks := orderedOf(
+		lock("tag", v100, ""),
+		lock("constraint", v110, "tag:v1.1.0"),
+		lock("branch", v110, "branch:develop"),
+		lock("tip", tip, "branch:develop"),
+		lock("commit", untagged, ""),
+		deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "local"}}},
+	)
+
+	infos, err := Outdated(context.TODO(), locks)
+	require.NoError(t, err)
+	name := r.src.Name()
+	assert.Equal(t, []OutdatedInfo{
+		{Name: name + "/tag", Current: "v1.0.0", Latest: "v1.1.0", Outdated: true},
+		{Name: name + "/constraint", Current: "v1.1.0", Latest: "v1.1.0"},
+		{Name: name + "/branch", Current: "develop", Latest: tip, Outdated: true},
+		{Name: name + "/tip", Current: "develop", Latest: tip},
+		{Name: name + "/commit", Current: untagged, Latest: NotApplicable},
+	}, infos)
+
+	// pre-releases are offered once opted in
+	src := *r.src
+	src.PreReleases = true
+	infos, err = Outdated(context.TODO(), orderedOf(deps.Dependency{Source: deps.Source{GitSource: &src}, Version: v110}))
+	require.NoError(t, err)
+	assert.Equal(t, "v2.0.0-rc.1", infos[0].Latest)
+	assert.True(t, infos[0].Outdated)
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// OutputMode sets how the messages of packages downloaded concurrently are
+// printed
+type OutputMode int
+
+const (
+	// OutputStream prints messages right away, so progress is visible, but
+	// the ones of concurrent downloads interleave
+	OutputStream OutputMode = iota
+	// OutputOrdered buffers the messages of each package and prints them
+	// sorted by package name once all packages are downloaded, so logs are
+	// the same for every run
+	OutputOrdered
+)
+
+// packageOutput collects the messages of packages downloaded concurrently,
+// according to the OutputMode
+type packageOutput struct {
+	mode OutputMode
+
+	mu      sync.Mutex
+	buffers map[string]*bytes.Buffer
+}
+
+func newPackageOutput(mode OutputMode) *packageOutput {
+	return &packageOutput{mode: mode, buffers: make(map[string]*bytes.Buffer)}
+}
+
+// context returns ctx with the messages of the package name going to its
+// buffer, if output is ordered
+func (po *packageOutput) context(ctx context.Context, name string) context.Context {
+	if po == nil || po.mode != OutputOrdered {
+		return ctx
+	}
+	return context.WithValue(ctx, outputKey{}, packageWriter{po: po, name: name})
+}
+
+// flush prints the buffered messages sorted by package name
+func (po *packageOutput) flush() {
+	if po == nil {
+		return
+	}
+	po.mu.Lock()
+	defer po.mu.Unlock()
+
+	names := make([]string, 0, len(po.buffers))
+	for name := range po.buffers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = po.buffers[name].WriteTo(color.Output)
+	}
+	po.buffers = make(map[string]*bytes.Buffer)
+}
+
+// packageWriter appends to the buffer of a package. Packages of the same name
+// but different versions share it.
+type packageWriter struct {
+	po   *packageOutput
+	name string
+}
+
+func (w packageWriter) Write(p []byte) (int, error) {
+	w.po.mu.Lock()
+	defer w.po.mu.Unlock()
+	b, ok := w.po.buffers[w.name]
+	if !ok {
+		b = &bytes.Buffer{}
+		w.po.buffers[w.name] = b
+	}
+	return b.Write(p)
+}
+
+// outputKey is the context key of the packageWriter messages are buffered in
+type outputKey struct{}
+
+// bufferedOutput returns the writer messages are buffered in, if any
+func bufferedOutput(ctx context.Context) (io.Writer, bool) {
+	w, ok := ctx.Value(outputKey{}).(packageWriter)
+	return w, ok
+}
+
+// printColor prints a line in the color attr, like color.Yellow and friends,
+// unless it is buffered according to ctx
+func printColor(ctx context.Context, attr color.Attribute, format string, a ...interface{}) {
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	w, ok := bufferedOutput(ctx)
+	if !ok {
+		w = color.Output
+	}
+	_, _ = color.New(attr).Fprintf(w, format, a...)
+}
+
+// setGitOutput points the output of the git command to the buffer of ctx, if
+// any, or to the terminal unless GitQuiet is set
+func setGitOutput(ctx context.Context, cmd *exec.Cmd) {
+	switch w, ok := bufferedOutput(ctx); {
+	case GitQuiet:
+		cmd.Stdout = nil
+		cmd.Stderr = nil
+	case ok:
+		cmd.Stdout = w
+		cmd.Stderr = w
+	default:
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageOutputOrdered(t *testing.T) {
+	out := &bytes.Buffer{}
+	defer func(w io.Writer, noColor bool) {
+		color.Output, color.NoColor = w, noColor
+	}(color.Output, color.NoColor)
+	color.Output, color.NoColor = out, true
+
+	po := newPackageOutput(OutputOrdered)
+	var wg sync.WaitGroup
+	for _, name := range []string{"example.com/c", "example.com/a", "example.com/b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ctx := po.context(context.Background(), name)
+			printColor(ctx, color.FgYellow, "first %s", name)
+			printColor(ctx, color.FgYellow, "second %s", name)
+		}(name)
+	}
+	wg.Wait()
+	assert.Empty(t, out.String())
+
+	po.flush()
+	assert.Equal(t, "first example.com/a\nsecond example.com/a\n"+
+		"first example.com/b\nsecond example.com/b\n"+
+		"first example.com/c\nsecond example.com/c\n", out.String())
+}
+
+func TestPackageOutputStream(t *testing.T) {
+	po := newPackageOutput(OutputStream)
+	ctx := po.context(context.Background(), "example.com/a")
+	_, buffered := bufferedOutput(ctx)
+	assert.False(t, buffered)
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// PackVendor writes the locked packages of vendor to out as an uncompressed
+// tar archive. Symlinks are followed, so the archive holds real copies. It is
+// reproducible: entries are sorted, timestamps and owners zeroed and
+// permissions fixed to 0755 for directories and executables and 0644 for
+// everything else, so the same lock always yields the same bytes.
+// Vendor must match the lock exactly, otherwise nothing is written.
+func PackVendor(vendorDir string, locks *deps.Ordered, out io.Writer) error {
+	if err := VerifyParallel(vendorDir, locks, 0, true); err != nil {
+		return err
+	}
+	unknown, err := unknownDirs(vendorDir, "", locks)
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("vendor does not match the lock: %s is not locked", unknown[0])
+	}
+
+	// archive path -> file on disk, "" for directories
+	entries := map[string]string{}
+	addParents := func(name string) {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			entries[dir] = ""
+		}
+	}
+	roots := []string{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		roots = append(roots, d.Name())
+	}
+	for _, l := range legacyLinks(locks, "") {
+		if _, err := os.Lstat(filepath.Join(vendorDir, l.legacyName)); err == nil {
+			roots = append(roots, l.legacyName)
+		}
+	}
+	for _, root := range roots {
+		name := filepath.ToSlash(root)
+		addParents(name)
+		if err := packEntries(filepath.Join(vendorDir, root), name, entries, map[string]struct{}{}); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(out)
+	for _, name := range names {
+		if err := packEntry(tw, name, entries[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// packEntries adds src and everything below it to entries as name,
+// following symlinks. parents holds the directories currently being walked,
+// to detect cycles.
+func packEntries(src, name string, entries map[string]string, parents map[string]struct{}) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		entries[name] = src
+		return nil
+	}
+
+	real, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	if _, ok := parents[real]; ok {
+		return fmt.Errorf("symlink cycle at %s", src)
+	}
+	parents[real] = struct{}{}
+	defer delete(parents, real)
+
+	entries[name] = ""
+	children, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if err := packEntries(filepath.Join(src, c.Name()), name+"/"+c.Name(), entries, parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packEntry writes a single entry with normalized metadata. Directories
+// have no src.
+func packEntry(tw *tar.Writer, name, src string) error {
+	hdr := &tar.Header{Name: name, ModTime: time.Unix(0, 0), Format: tar.FormatPAX}
+	if src == "" {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = 0755
+		return tw.WriteHeader(hdr)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr.Typeflag = tar.TypeReg
+	hdr.Size = info.Size()
+	hdr.Mode = 0644
+	if info.Mode().Perm()&0111 != 0 {
+		hdr.Mode = 0755
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestPackVendor(t *testing.T) {
+	r := newTestRepo(t, "packed")
+	r.commit(map[string]string{"main.libsonnet": "{}", "lib/util.libsonnet": "{ util: true }"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	install := func(t *testing.T) (string, *deps.Ordered) {
+		vendorDir := t.TempDir()
+		locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+		require.NoError(t, err)
+		return vendorDir, locks
+	}
+	pack := func(t *testing.T, vendorDir string, locks *deps.Ordered) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, PackVendor(vendorDir, locks, &buf))
+		return buf.Bytes()
+	}
+
+	vendorDir, locks := install(t)
+	first := pack(t, vendorDir, locks)
+
+	// another install at another time yields the same bytes
+	otherDir, otherLocks := install(t)
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(otherDir, r.src.Name(), "main.libsonnet"), later, later))
+	assert.Equal(t, first, pack(t, otherDir, otherLocks))
+
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(first))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Zero(t, hdr.ModTime.Unix(), hdr.Name)
+		names = append(names, hdr.Name)
+	}
+	assert.IsIncreasing(t, names)
+	assert.Contains(t, names, r.src.Name()+"/lib/util.libsonnet")
+	assert.Contains(t, names, r.src.LegacyName()+"/main.libsonnet")
+
+	// vendor must match the lock
+	stray := filepath.Join(vendorDir, "stray")
+	require.NoError(t, os.MkdirAll(stray, os.ModePerm))
+	assert.Error(t, PackVendor(vendorDir, locks, io.Discard))
+	require.NoError(t, os.Remove(stray))
+
+	require.NoError(t, os.WriteFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"), []byte("tampered"), 0644))
+	assert.ErrorIs(t, PackVendor(vendorDir, locks, io.Discard), IntegrityFailure)
+}
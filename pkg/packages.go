@@ -22,10 +22,10 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
-	"github.com/fatih/color"
 	"github.com/pkg/errors"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
@@ -35,6 +35,16 @@ import (
 
 var (
 	VersionMismatch = errors.New("multiple colliding versions specified")
+	LockIncomplete  = errors.New("lock is missing transitive dependencies")
+	CaseCollision   = errors.New("package names only differ by case")
+	EmptyPackage    = errors.New("resolved ref has no content")
+	UntrustedSum    = errors.New("sum does not match the trusted sum")
+	LockNotHonored  = errors.New("locked commit was not installed")
+	MissingSubdir   = errors.New("none of the candidate subdirs exist")
+	MissingRootFile = errors.New("root file does not exist")
+	TimedOut        = errors.New("download timed out")
+	UnsafeArchive   = errors.New("archive entry escapes the package")
+	BinaryContent   = errors.New("binary content not allowed")
 )
 
 // Ensure receives all direct packages, the directory to vendor into and all known locks.
@@ -47,63 +57,238 @@ var (
 // In case a (nested) package is already present in the lock,
 // the one from the lock takes precedence. This allows the user to set the
 // desired version in case by `jb install`ing it.
+// Packages locked to a commit are installed at exactly that commit, even when
+// following a branch that moved on since. Only dropping them from the lock,
+// like `jb update` does, resolves the tip of the branch again.
 //
 // Finally, all unknown files and directories are removed from vendor/
 // The full list of locked depedencies is returned
-func Ensure(direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered) (*deps.Ordered, error) {
+//
+// If Ensure fails, vendor is recovered according to the ErrorPolicy. Failures
+// while linking report the packages installed so far in a
+// PartialInstallError. All packages that failed to download are reported at
+// once.
+// Canceling ctx aborts running downloads and starts no new ones, the error
+// returned then wraps the one of ctx.
+// WithTransactional replaces the incremental installation by a clean one.
+// WithReadOnly fails instead of making any change to vendor.
+// The jsonnetfile and all nested ones are validated before they are used.
+// Registry sources are resolved using the index of WithRegistry.
+//
+// Neither the jsonnetfile nor the lock have to exist on disk: direct may be
+// built in memory, like with v1.FromDependencies, and oldLocks may be nil if
+// nothing is locked yet. The lock is returned, writing it is up to the
+// caller. Only nested jsonnetfiles are read, from the downloaded packages.
+// Relative local sources are relative to the working directory.
+//
+// Along with the lock, the warnings printed are returned, sorted by package,
+// for callers that want to handle them themselves.
+//
+// EnsureWithResult tells which packages changed as well.
+func Ensure(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered, opts ...Option) (*deps.Ordered, []Warning, error) {
+	r, err := EnsureWithResult(ctx, direct, vendorDir, oldLocks, opts...)
+	return r.Locks, r.Warnings, err
+}
+
+func ensure(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered, opts ...Option) (*deps.Ordered, error) {
+	if direct.Dependencies == nil {
+		direct.Dependencies = deps.NewOrdered()
+	}
+	if oldLocks == nil {
+		oldLocks = deps.NewOrdered()
+	}
+	if err := direct.Validate(); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	ctx = withLogger(ctx, o.logger)
+	if o.dryRun {
+		// the constraints applied below may drop locks
+		oldLocks = copyOrdered(oldLocks)
+	} else {
+		warnStagingFilesystem(ctx, o.stagingDir, vendorDir)
+	}
+
+	resolved, err := resolveRegistry(direct.Dependencies, o.registry)
+	if err != nil {
+		return nil, err
+	}
+	direct.Dependencies = resolved
+
+	direct, err = applyConstraints(direct, o.constraints, oldLocks)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the way the jsonnetfile asks for, unless told otherwise
+	policy, err := resolverVersionPolicy(direct.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	if o.versionPolicy == nil {
+		o.versionPolicy = &policy
+	}
+
+	if o.transactional || o.readOnly || o.dryRun {
+		reason, err := vendorMismatch(direct, vendorDir, oldLocks, o)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			return oldLocks, nil
+		}
+		if o.readOnly {
+			return nil, fmt.Errorf("%w: %s", ReadOnlyVendor, reason)
+		}
+		if o.dryRun {
+			return dryRun(ctx, direct, vendorDir, oldLocks, o, opts)
+		}
+		if len(oldLocks.Keys()) > 0 {
+			logger(ctx).Warn("reinstalling vendor: %s", reason)
+		}
+	}
+
+	tx, err := beginVendorTx(vendorDir, o.errorPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if o.transactional {
+		if err := clearVendor(vendorDir); err != nil {
+			if err := tx.abort(); err != nil {
+				logger(ctx).Error("failed to recover vendor: %s", err)
+			}
+			return nil, err
+		}
+	}
+	locks, err := ensureVendor(ctx, direct, vendorDir, oldLocks, o, tx.journal)
+	if err != nil {
+		if err := tx.abort(); err != nil {
+			logger(ctx).Error("failed to recover vendor: %s", err)
+		}
+		// only ErrorLeave keeps the packages installed so far
+		var partial *PartialInstallError
+		if errors.As(err, &partial) && o.errorPolicy != ErrorLeave {
+			err = partial.Err
+		}
+		// whatever failed because of a cancellation is reported as such
+		if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+			err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		return nil, err
+	}
+	return locks, tx.commit()
+}
+
+func ensureVendor(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered, o *options, j *cacheJournal) (*deps.Ordered, error) {
 	// ensure all required files are in vendor
 	// This is the actual installation
-	locks, err := downloadAndLink(direct, vendorDir, oldLocks)
+	var before *deps.Ordered
+	if o.deterministic {
+		before = copyOrdered(oldLocks)
+	}
+	locks, tree, err := downloadAndLink(ctx, direct, vendorDir, oldLocks, o, j)
 	if err != nil {
 		return nil, err
 	}
+	if o.deterministic {
+		if err := checkDeterministic(ctx, direct, vendorDir, before, locks, o, j); err != nil {
+			return nil, err
+		}
+	}
+	if o.expectedTree != nil {
+		if err := checkTree(o.expectedTree, tree); err != nil {
+			return nil, err
+		}
+	}
+	if o.licenses != nil {
+		if err := checkLicenses(ctx, vendorDir, o.vendorPrefix, locks, o.licenses); err != nil {
+			return nil, err
+		}
+	}
 
 	// remove unchanged legacyNames
 	CleanLegacyName(locks)
 
-	// find unknown dirs in vendor/
-	names := []string{}
-	err = filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
-		if path == vendorDir {
-			return nil
+	// find unknown dirs in vendor/ and remove them
+	unknown, err := unknownDirs(vendorDir, o.vendorPrefix, locks)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeDirs(ctx, unknown, o.concurrency); err != nil {
+		return nil, err
+	}
+
+	// remove all stale symlinks. Legacy links that are still wanted are
+	// kept and fixed in place by linkLegacy, so imports never break midway.
+	var keep map[string]struct{}
+	if direct.LegacyImports && !o.legacyPrimary {
+		keep = wantedLegacyLinks(vendorDir, o.vendorPrefix, locks)
+	}
+	if _, err := cleanLegacySymlinks(vendorDir, o.vendorPrefix, locks, keep); err != nil {
+		return nil, err
+	}
+	switch {
+	case o.legacyPrimary:
+		if err := linkLegacyPrimary(ctx, vendorDir, o.vendorPrefix, locks); err != nil {
+			return nil, err
 		}
-		if strings.HasPrefix(path, filepath.Join(vendorDir, ".cache")) {
-			return nil
+	case direct.LegacyImports:
+		if err := linkLegacy(ctx, vendorDir, o.vendorPrefix, locks, o.symlinkFallback); err != nil {
+			return nil, err
 		}
-		if !i.IsDir() {
-			return nil
+	}
+	if o.legacyPrimary || direct.LegacyImports {
+		collisions := legacyCollisions(vendorDir, o.vendorPrefix, locks, o.legacyPrimary)
+		if o.strictLegacy && len(collisions) > 0 {
+			messages := make([]string, 0, len(collisions))
+			for _, c := range collisions {
+				messages = append(messages, c.Message)
+			}
+			return nil, fmt.Errorf("%w: %s", LegacyNameTaken, strings.Join(messages, ", "))
+		}
+		// already printed while linking
+		for _, c := range collisions {
+			recordWarning(ctx, c.Kind, c.Package, c.Message)
 		}
+	}
 
-		names = append(names, path)
-		return nil
-	})
-	if err != nil {
+	if err := materialize(vendorDir, o.materialize); err != nil {
 		return nil, err
 	}
 
-	// remove them
-	for _, dir := range names {
-		name, err := filepath.Rel(vendorDir, dir)
-		if err != nil {
+	if o.checkSymlinks {
+		if err := checkSymlinks(vendorDir, o.vendorPrefix, locks); err != nil {
 			return nil, err
 		}
-		if !known(locks, name) {
-			if err := os.RemoveAll(dir); err != nil {
-				return nil, err
-			}
-			color.Magenta("CLEAN %s", dir)
+	}
+
+	if o.reportDuplicates {
+		reportDuplicates(ctx, locks)
+	}
+
+	if o.manifest {
+		if err := writeManifest(vendorDir, o.vendorPrefix, locks); err != nil {
+			return nil, err
 		}
 	}
 
-	// remove all symlinks, optionally adding known ones back later if wished
-	if err := cleanLegacySymlinks(vendorDir, locks); err != nil {
-		return nil, err
+	if o.packageMeta {
+		if err := writePackageMetas(vendorDir, o.vendorPrefix, locks); err != nil {
+			return nil, err
+		}
 	}
-	if !direct.LegacyImports {
-		return locks, nil
+
+	if o.tree {
+		if err := writeTree(vendorDir, tree); err != nil {
+			return nil, err
+		}
 	}
-	if err := linkLegacy(vendorDir, locks); err != nil {
-		return nil, err
+
+	if o.snapshot {
+		if err := writeSnapshot(vendorDir, o.vendorPrefix, locks); err != nil {
+			return nil, err
+		}
 	}
 
 	// return the final lockfile contents
@@ -122,66 +307,217 @@ func CleanLegacyName(list *deps.Ordered) {
 	}
 }
 
-func cleanLegacySymlinks(vendorDir string, locks *deps.Ordered) error {
+// PruneLegacyLinks removes all symlinks in vendor that are not a locked
+// package, like the legacy symlinks left behind after disabling LegacyImports.
+// It returns the removed symlinks and may safely be run repeatedly.
+// Only WithVendorPrefix is relevant of the options.
+func PruneLegacyLinks(vendorDir string, locks *deps.Ordered, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	return cleanLegacySymlinks(vendorDir, o.vendorPrefix, locks, nil)
+}
+
+// cleanLegacySymlinks removes all symlinks in vendor that are neither a
+// locked package nor in keep
+func cleanLegacySymlinks(vendorDir, prefix string, locks *deps.Ordered, keep map[string]struct{}) ([]string, error) {
 	// local packages need to be ignored
 	known := map[string]struct{}{}
 	for _, k := range locks.Keys() {
 		d, _ := locks.Get(k)
 		// Name contains the absolute path to the package, we only want to remove the relative ones
-		known[filepath.Join(vendorDir, d.Name())] = struct{}{}
+		known[filepath.Join(vendorDir, prefix, d.Name())] = struct{}{}
+		for _, f := range rootFiles(d) {
+			known[filepath.Join(vendorDir, prefix, f)] = struct{}{}
+		}
 	}
 
 	// remove all unknown symlinks first
-	return filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+	removed := []string{}
+	err := filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
 		if strings.HasPrefix(path, filepath.Join(vendorDir, ".cache")) {
 			return nil
 		}
 		if _, found := known[path]; found {
 			return nil
 		}
+		if _, found := keep[path]; found {
+			return nil
+		}
 
 		if i.Mode()&os.ModeSymlink != 0 {
 			if err := os.Remove(path); err != nil {
 				return err
 			}
+			removed = append(removed, path)
 		}
 		return nil
 	})
+	return removed, err
 }
 
-func linkLegacy(vendorDir string, locks *deps.Ordered) error {
-	// create only the ones we want
+// legacyLink is a symlink from the legacy name of a package to its full name
+type legacyLink struct {
+	name       string
+	legacyName string
+	// pkgName is the path of the package relative to vendor, including the
+	// vendor prefix
+	pkgName string
+}
+
+// legacyLinks returns the legacy symlinks wanted for the locked packages
+// vendored below prefix
+func legacyLinks(locks *deps.Ordered, prefix string) []legacyLink {
+	links := []legacyLink{}
 	for _, k := range locks.Keys() {
 		d, _ := locks.Get(k)
 		// localSource still uses the relative style
 		if d.Source.LocalSource != nil {
 			continue
 		}
+		links = append(links, legacyLink{name: d.Name(), legacyName: d.LegacyName(), pkgName: filepath.Join(prefix, d.Name())})
+	}
+	return links
+}
 
-		legacyName := filepath.Join(vendorDir, d.LegacyName())
-		pkgName := d.Name()
+// wantedLegacyLinks returns the paths of the symlinks linkLegacy creates
+func wantedLegacyLinks(vendorDir, prefix string, locks *deps.Ordered) map[string]struct{} {
+	wanted := map[string]struct{}{}
+	for _, l := range legacyLinks(locks, prefix) {
+		wanted[filepath.Join(vendorDir, l.legacyName)] = struct{}{}
+	}
+	return wanted
+}
+
+// linkLegacy creates the legacy symlinks. Existing ones are left alone if
+// correct and atomically replaced otherwise, so there is no moment without a
+// valid link. With symlinkFallback, links that can't be created are skipped.
+func linkLegacy(ctx context.Context, vendorDir, prefix string, locks *deps.Ordered, symlinkFallback bool) error {
+	// packages and the first package linked win a legacy name
+	linked := map[string]struct{}{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		linked[filepath.Join(vendorDir, prefix, d.Name())] = struct{}{}
+	}
+	for _, l := range legacyLinks(locks, prefix) {
+		legacyName := filepath.Join(vendorDir, l.legacyName)
+		pkgName := l.pkgName
+
+		fi, err := os.Lstat(legacyName)
+		_, owned := linked[legacyName]
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		case owned || fi.Mode()&os.ModeSymlink == 0:
+			if _, err := checkLegacyNameTaken(ctx, legacyName, pkgName); err != nil {
+				logger(ctx).Error("%s", err)
+			}
+			continue
+		default:
+			if target, err := os.Readlink(legacyName); err == nil && target == pkgName {
+				linked[legacyName] = struct{}{}
+				continue
+			}
+		}
+
+		if err := replaceSymlink(pkgName, legacyName); err != nil {
+			if !symlinkFallback {
+				return err
+			}
+			// a copy would be taken for a real directory in the way next time
+			warn(ctx, WarningSymlinkFallback, l.name, "failed to link legacy name %s, skipping it: %s", legacyName, err)
+			continue
+		}
+		linked[legacyName] = struct{}{}
+	}
+	return nil
+}
+
+// symlink creates the symlinks of packages, replaced by tests simulating
+// filesystems without symlink support
+var symlink = os.Symlink
+
+// replaceSymlink points the symlink at path to target, replacing whatever
+// symlink is there atomically
+func replaceSymlink(target, path string) error {
+	tmp := path + ".tmp-link"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
 
-		taken, err := checkLegacyNameTaken(legacyName, pkgName)
+// linkLegacyPrimary inverts the relation of linkLegacy: the legacy name
+// becomes the primary location of a package, and the full name a symlink to
+// it. Packages whose legacy name is taken keep their full name as primary.
+func linkLegacyPrimary(ctx context.Context, vendorDir, prefix string, locks *deps.Ordered) error {
+	for _, l := range legacyLinks(locks, prefix) {
+		legacyName := filepath.Join(vendorDir, l.legacyName)
+		fullName := filepath.Join(vendorDir, l.pkgName)
+
+		taken, err := checkLegacyNameTaken(ctx, legacyName, l.pkgName)
 		if err != nil {
-			fmt.Println(err)
+			logger(ctx).Error("%s", err)
 			continue
 		}
 		if taken {
 			continue
 		}
 
-		// create the symlink
-		if err := os.Symlink(
-			filepath.Join(pkgName),
-			filepath.Join(legacyName),
-		); err != nil {
+		// materialized packages are moved as a whole
+		fi, err := os.Lstat(fullName)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			if err := os.Rename(fullName, legacyName); err != nil {
+				return err
+			}
+			back, err := filepath.Rel(filepath.Dir(fullName), legacyName)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(back, fullName); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// move the link to the package from the full to the legacy name
+		target, err := os.Readlink(fullName)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			if target, err = filepath.Rel(filepath.Dir(legacyName), filepath.Join(filepath.Dir(fullName), target)); err != nil {
+				return err
+			}
+		}
+		if err := os.Symlink(target, legacyName); err != nil {
+			return err
+		}
+
+		back, err := filepath.Rel(filepath.Dir(fullName), legacyName)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(fullName); err != nil {
+			return err
+		}
+		if err := os.Symlink(back, fullName); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func checkLegacyNameTaken(legacyName string, pkgName string) (bool, error) {
+func checkLegacyNameTaken(ctx context.Context, legacyName string, pkgName string) (bool, error) {
 	fi, err := os.Lstat(legacyName)
 	if err != nil {
 		// does not exist: not taken
@@ -198,34 +534,91 @@ func checkLegacyNameTaken(legacyName string, pkgName string) (bool, error) {
 		if err != nil {
 			return false, err
 		}
-		color.Yellow("WARN: cannot link '%s' to '%s', because package '%s' already uses that name. The absolute import still works\n", pkgName, legacyName, s)
+		logger(ctx).Warn("cannot link '%s' to '%s', because package '%s' already uses that name. The absolute import still works", pkgName, legacyName, s)
 		return true, nil
 	}
 
 	// sth else
-	color.Yellow("WARN: cannot link '%s' to '%s', because the file/directory already exists. The absolute import still works.\n", pkgName, legacyName)
+	logger(ctx).Warn("cannot link '%s' to '%s', because the file/directory already exists. The absolute import still works.", pkgName, legacyName)
 	return true, nil
 }
 
-func known(deps *deps.Ordered, p string) bool {
+// unknownDirs returns the directories in vendor that don't belong to any of
+// the locked packages, excluding the cache
+func unknownDirs(vendorDir, prefix string, locks *deps.Ordered) ([]string, error) {
+	names := []string{}
+	err := filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+		if path == vendorDir {
+			return nil
+		}
+		if strings.HasPrefix(path, filepath.Join(vendorDir, ".cache")) {
+			return nil
+		}
+		if !i.IsDir() {
+			return nil
+		}
+
+		names = append(names, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := []string{}
+	for _, dir := range names {
+		name, err := filepath.Rel(vendorDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !known(locks, prefix, name) {
+			unknown = append(unknown, dir)
+		}
+	}
+	return unknown, nil
+}
+
+// known returns whether p is the path of a package vendored below prefix, or
+// one of its parent directories
+func known(deps *deps.Ordered, prefix, p string) bool {
+	_, ok := knownBy(deps, prefix, p)
+	return ok
+}
+
+// knownBy returns the first package that makes p known
+func knownBy(deps *deps.Ordered, prefix, p string) (string, bool) {
 	p = filepath.ToSlash(p)
 	for _, kd := range deps.Keys() {
 		d, _ := deps.Get(kd)
-		k := filepath.ToSlash(d.Name())
-		if strings.HasPrefix(p, k) || strings.HasPrefix(k, p) {
-			return true
+		for _, name := range append([]string{d.Name()}, rootFiles(d)...) {
+			k := filepath.ToSlash(filepath.Join(prefix, name))
+			if strings.HasPrefix(p, k) || strings.HasPrefix(k, p) {
+				return d.Name(), true
+			}
 		}
 	}
-	return false
+	return "", false
 }
 
 // download retrieves a package from a remote upstream. The checksum of the
 // files is generated afterwards.
-func download(d deps.Dependency, vendorDir, pathToParentModule string) (*deps.Dependency, error) {
+func download(ctx context.Context, d deps.Dependency, vendorDir, pathToParentModule string, o *options, checkouts *sharedCheckouts) (*deps.Dependency, error) {
 	var p Interface
 	switch {
+	case d.Source.GitSource != nil && o.gitBackend(d.Source.GitSource) == deps.GitBackendHTTP:
+		p = &GitHTTPPackage{Source: d.Source.GitSource, StagingDir: o.stagingDir, Retry: o.retryPolicy(d.Source.GitSource), Limit: o.bandwidth, Precedence: o.refPrecedence}
+	case d.Source.GitSource != nil && o.gitBackend(d.Source.GitSource) == deps.GitBackendExec:
+		gp := &GitPackage{Source: d.Source.GitSource, StagingDir: o.stagingDir, Retry: o.retryPolicy(d.Source.GitSource), Limit: o.bandwidth, Protocol: o.gitProtocol, Precedence: o.refPrecedence, checkouts: checkouts, tags: o.tagAPI()}
+		if !validGitProtocol(gp.protocol()) {
+			return nil, fmt.Errorf("unknown git protocol version '%s'", gp.protocol())
+		}
+		p = gp
 	case d.Source.GitSource != nil:
-		p = NewGitPackage(d.Source.GitSource)
+		return nil, fmt.Errorf("unknown git backend '%s'", o.gitBackend(d.Source.GitSource))
+	case d.Source.ReleaseSource != nil:
+		p = &ReleasePackage{Source: d.Source.ReleaseSource, Token: o.apiTokens[APIHostGitHub], StagingDir: o.stagingDir, Retry: o.httpRetry, Limit: o.bandwidth}
+	case d.Source.HTTPSource != nil:
+		p = &HTTPPackage{Source: d.Source.HTTPSource, StagingDir: o.stagingDir, Retry: o.httpRetry, Limit: o.bandwidth}
 	case d.Source.LocalSource != nil:
 		wd, err := os.Getwd()
 		if err != nil {
@@ -241,24 +634,109 @@ func download(d deps.Dependency, vendorDir, pathToParentModule string) (*deps.De
 			modulePath = d.Source.LocalSource.Directory
 		}
 
-		p = NewLocalPackage(&deps.Local{Directory: modulePath})
+		p = NewLocalPackage(&deps.Local{Directory: modulePath, Git: d.Source.LocalSource.Git})
 	}
 
 	if p == nil {
-		return nil, errors.New("either git or local source is required")
+		return nil, errors.New("a git, local, release or http source is required")
 	}
 
-	version, err := p.Install(context.TODO(), d.Name(), vendorDir, d.Version)
+	if timeout := o.downloadTimeout(d); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", TimedOut, timeout))
+		defer cancel()
+	}
+
+	version := d.Version
+	if r, ok := p.(Resolver); ok {
+		// resolve the version upfront, so the fetch is done at a fixed commit.
+		// If this fails, let git try its best with the original version,
+		// unless it is a version spec git can't make sense of anyways.
+		resolved, tag, err := r.Resolve(ctx, d.Version)
+		if err != nil {
+			o.trace.printf("resolve %s@%s: %s", d.Name(), d.Version, err)
+		} else {
+			o.trace.printf("resolve %s@%s: %s (tag %q)", d.Name(), d.Version, resolved, tag)
+		}
+		switch {
+		case err == nil:
+			version = resolved
+			if pv := provenance(d.Version, tag); pv != "" {
+				d.Provenance = pv
+			}
+			// names of both a tag and a branch record which one was used
+			switch gp := p.(type) {
+			case *GitPackage:
+				if gp.matched != "" {
+					d.Provenance = gp.matched
+				}
+			case *GitHTTPPackage:
+				if gp.matched != "" {
+					d.Provenance = gp.matched
+				}
+			}
+		case isSemverConstraint(d.Version):
+			return nil, timedOut(ctx, err)
+		}
+	}
+
+	if gp, ok := p.(*GitPackage); ok {
+		gp.tag = verifiedTag(d)
+	}
+
+	version, err := install(ctx, p, d.Name(), vendorDir, version, o.downloadRetryPolicy(d))
 	if err != nil {
-		return nil, err
+		return nil, timedOut(ctx, err)
+	}
+	if d.Source.GitSource != nil && len(d.Source.GitSource.Subdirs) > 0 {
+		switch gp := p.(type) {
+		case *GitPackage:
+			d.InstalledSubdir = path.Join("/", gp.subdir)
+		case *GitHTTPPackage:
+			d.InstalledSubdir = path.Join("/", gp.subdir)
+		}
+	}
+	if lp, ok := p.(*LocalPackage); ok {
+		d.Dirty = lp.dirty
+	}
+	if rp, ok := p.(*ReleasePackage); ok {
+		// the source is shared with the jsonnetfile, only the lock gets the digest
+		release := *d.Source.ReleaseSource
+		release.Digest = rp.digest
+		d.Source.ReleaseSource = &release
+	}
+	if d.Source.HTTPSource != nil {
+		// the lock gets its own copy, to tell whether the URL changed since
+		archive := *d.Source.HTTPSource
+		d.Source.HTTPSource = &archive
+	}
+	if gp, ok := p.(*GitPackage); ok && !gp.date.IsZero() {
+		if err := writeCommitDate(vendorDir, version, gp.date); err != nil {
+			return nil, err
+		}
 	}
 
 	var sum string
 	if d.Source.LocalSource == nil {
-		sum, err = hashDir(filepath.Join(vendorDir, d.Name()))
+		if d.Source.GitSource != nil {
+			if err := removeExcluded(filepath.Join(vendorDir, d.Name()), d.Source.GitSource.Include); err != nil {
+				return nil, err
+			}
+		}
+		if err := applyBinaryPolicy(ctx, o.binaryPolicy, d.Name(), filepath.Join(vendorDir, d.Name())); err != nil {
+			return nil, err
+		}
+		if o.normalizeEOL {
+			if err := normalizeDirLineEndings(filepath.Join(vendorDir, d.Name())); err != nil {
+				return nil, err
+			}
+		}
+		var skipped []string
+		sum, skipped, err = hashPackage(filepath.Join(vendorDir, d.Name()), o.packageHashConfig(d))
 		if err != nil {
 			return nil, err
 		}
+		warnPartialSum(ctx, d.Name(), skipped)
 	}
 
 	d.Version = version
@@ -269,10 +747,15 @@ func download(d deps.Dependency, vendorDir, pathToParentModule string) (*deps.De
 // check returns whether the files present at the vendor/ folder match the
 // sha256 sum of the package. local-directory dependencies are not checked as
 // their purpose is to change during development where integrity checking would
-// be a hindrance.
-func check(d deps.Dependency, vendorDir string) bool {
-	// assume a local dependency is intact as long as it exists
+// be a hindrance. Packages whose files are unchanged since they were last
+// verified, according to the verifiedFile, are not hashed again.
+func check(ctx context.Context, d deps.Dependency, vendorDir string, o *options) bool {
+	// assume a local dependency is intact as long as it exists. Ones tracked
+	// by git are always linked again, so the lock follows their git state.
 	if d.Source.LocalSource != nil {
+		if d.Source.LocalSource.Git {
+			return false
+		}
 		x, err := jsonnetfile.Exists(filepath.Join(vendorDir, d.Name()))
 		if err != nil {
 			return false
@@ -285,50 +768,184 @@ func check(d deps.Dependency, vendorDir string) bool {
 		return false
 	}
 
+	// trust whatever is present, see WithFast
 	dir := filepath.Join(vendorDir, d.Name())
-	sum, err := hashDir(dir)
+	if o.fast {
+		_, err := os.Stat(dir)
+		return err == nil
+	}
+
+	// entries untouched since they were last verified need no full hash
+	hc := o.packageHashConfig(d)
+	signature, latest, sigErr := entrySignature(dir, hc)
+	if sigErr == nil && verifiedSum(vendorDir, signature) == d.Sum {
+		return true
+	}
+
+	sum, skipped, err := hashPackage(dir, hc)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			color.Red("ERROR %s@%s %s", d.Name(), d.Version, err)
+			logger(ctx).Error("%s@%s %s", d.Name(), d.Version, err)
 		}
 		return false
 	}
+	warnPartialSum(ctx, d.Name(), skipped)
 	if d.Sum == sum {
+		if sigErr == nil {
+			recordVerified(vendorDir, signature, sum, latest)
+		}
 		return true
 	}
-	color.Yellow("CHECKSUM FAIL %s@%s", d.Name(), d.Version)
+	logger(ctx).ChecksumFail(d.Name(), d.Version)
+	recordWarning(ctx, WarningChecksum, d.Name(), fmt.Sprintf("%s@%s does not match its checksum", d.Name(), d.Version))
 	return false
 }
 
+// timedOut reports err as TimedOut, if the download it failed has run out of
+// time, rather than whatever the killed git or request made of it
+func timedOut(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, TimedOut) {
+		return fmt.Errorf("%w: %s", cause, err)
+	}
+	return err
+}
+
+// hashConfig holds all settings that influence the checksum of a package
+type hashConfig struct {
+	namespace string
+	// include restricts the sum to the included files, see included
+	include []string
+	// normalizeEOL hashes text files with LF line endings
+	normalizeEOL bool
+	// unreadable is what happens to files that can't be read
+	unreadable UnreadablePolicy
+	// rootFiles are the RootFiles of the package, relative to it. They are
+	// hashed after the files of the package, regardless of include.
+	rootFiles []string
+}
+
 // hashDir computes the checksum of a directory by concatenating all files and
 // hashing this data using sha256. This can be memory heavy with lots of data,
-// but jsonnet files should be fairly small
-func hashDir(dir string) (string, error) {
+// but jsonnet files should be fairly small. Files skipped according to the
+// UnreadablePolicy are warned about.
+func hashDir(ctx context.Context, dir string, hc hashConfig) (string, error) {
+	sum, skipped, err := hashPackage(dir, hc)
+	if err != nil {
+		return "", err
+	}
+	warnPartialSum(ctx, dir, skipped)
+	return sum, nil
+}
+
+// hashPackage is hashDir returning the files skipped according to the
+// UnreadablePolicy instead of warning about them, relative to dir
+func hashPackage(dir string, hc hashConfig) (string, []string, error) {
 	hasher := sha256.New()
+	skipped := []string{}
 
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	// scope the sum to a namespace, so it is only valid for that project
+	if hc.namespace != "" {
+		hasher.Write([]byte(hc.namespace + "\x00"))
+	}
+
+	files, err := hashedFiles(dir, hc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, path := range files {
+		rel, err := filepath.Rel(dir, path)
 		if err != nil {
+			return "", nil, err
+		}
+		rel = filepath.ToSlash(rel)
+
+		err = func() error {
+			// read files completely first, unless failing anyways, so
+			// unreadable ones leave nothing behind in the sum
+			if hc.normalizeEOL || hc.unreadable != UnreadableFail {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				if hc.normalizeEOL {
+					data = normalizeLineEndings(data)
+				}
+				hasher.Write(data)
+				return nil
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(hasher, f)
 			return err
+		}()
+		switch {
+		case err == nil:
+		case hc.unreadable == UnreadableSkip:
+			skipped = append(skipped, rel)
+		case hc.unreadable == UnreadableChanged:
+			// no sum of readable content ever matches this
+			hasher.Write([]byte("\x00unreadable " + rel + "\x00"))
+		default:
+			return "", nil, err
 		}
+	}
 
-		// if having the same dependencies with subdir and without subdir
-		// there might be symlinks injected
-		if info.IsDir() || info.Mode()&fs.ModeSymlink != 0 {
-			return nil
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil)), skipped, nil
+}
+
+// hashedFiles returns the paths of the files that make up the checksum of the
+// package at dir according to hc: the included files of the package, followed
+// by its root files
+func hashedFiles(dir string, hc hashConfig) ([]string, error) {
+	all, err := packageFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(all))
+	for _, path := range all {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		if included(hc.include, filepath.ToSlash(rel)) {
+			files = append(files, path)
+		}
+	}
+	for _, f := range hc.rootFiles {
+		root, err := packageFiles(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return nil, err
 		}
+		files = append(files, root...)
+	}
+	return files, nil
+}
 
-		f, err := os.Open(path)
+// packageFiles returns the paths of all files of the package at dir that are
+// part of its checksum, in lexical order. The PackageMetaFile is not.
+func packageFiles(dir string) ([]string, error) {
+	meta := filepath.Join(dir, PackageMetaFile)
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		defer f.Close()
 
-		if _, err := io.Copy(hasher, f); err != nil {
-			return err
+		// if having the same dependencies with subdir and without subdir
+		// there might be symlinks injected
+		if info.IsDir() || info.Mode()&fs.ModeSymlink != 0 || path == meta {
+			return nil
 		}
 
+		files = append(files, path)
 		return nil
 	})
-
-	return base64.StdEncoding.EncodeToString(hasher.Sum(nil)), err
+	return files, err
 }
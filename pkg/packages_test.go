@@ -15,8 +15,17 @@
 package pkg
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
 )
 
@@ -59,7 +68,7 @@ func TestKnown(t *testing.T) {
 	}
 
 	for _, p := range paths {
-		if known(testDeps, p) != w[p] {
+		if known(testDeps, "", p) != w[p] {
 			t.Fatalf("expected %s to be %v", p, w[p])
 		}
 	}
@@ -94,3 +103,411 @@ func TestCleanLegacyName(t *testing.T) {
 		}
 	}
 }
+
+func TestHashDirNamespace(t *testing.T) {
+	vendorDir := t.TempDir()
+	d := testDep("a", "v1")
+	dir := filepath.Join(vendorDir, d.Name())
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.libsonnet"), []byte("{}"), 0644))
+
+	plain, err := hashDir(context.TODO(), dir, hashConfig{})
+	require.NoError(t, err)
+	a, err := hashDir(context.TODO(), dir, hashConfig{namespace: "project-a"})
+	require.NoError(t, err)
+	b, err := hashDir(context.TODO(), dir, hashConfig{namespace: "project-b"})
+	require.NoError(t, err)
+
+	assert.NotEqual(t, plain, a)
+	assert.NotEqual(t, a, b)
+
+	d.Sum = a
+	assert.True(t, check(context.TODO(), d, vendorDir, newOptions([]Option{WithHashNamespace("project-a")})))
+	assert.False(t, check(context.TODO(), d, vendorDir, newOptions(nil)))
+}
+
+func TestEnsureCanceled(t *testing.T) {
+	r := newTestRepo(t, "canceled")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := Ensure(ctx, jsf, vendorDir, deps.NewOrdered())
+	require.ErrorIs(t, err, context.Canceled)
+	assert.NoDirExists(t, filepath.Join(vendorDir, r.src.Name()))
+}
+
+func TestCheckFast(t *testing.T) {
+	vendorDir := t.TempDir()
+	d := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	cp := cachePath(vendorDir, d)
+	require.NoError(t, os.WriteFile(filepath.Join(cp, d.Name(), "a.libsonnet"), []byte("{ modified: true }"), 0644))
+
+	// modified content is only noticed when verifying
+	assert.False(t, check(context.TODO(), d, cp, newOptions(nil)))
+	assert.True(t, check(context.TODO(), d, cp, newOptions([]Option{WithFast(true)})))
+
+	// missing packages are downloaded either way
+	require.NoError(t, os.RemoveAll(cp))
+	assert.False(t, check(context.TODO(), d, cp, newOptions([]Option{WithFast(true)})))
+}
+
+func TestLinkLegacyPrimary(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	taken := vendorPackage(t, vendorDir, testDep("taken", "v1"), map[string]string{"t.libsonnet": "{}"})
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, "taken"), os.ModePerm))
+
+	require.NoError(t, linkLegacyPrimary(context.TODO(), vendorDir, "", orderedOf(a, taken)))
+
+	// the legacy name links into the cache, the full name to the legacy one
+	target, err := os.Readlink(filepath.Join(vendorDir, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cachePath(vendorDir, a), a.Name()), target)
+	target, err = os.Readlink(filepath.Join(vendorDir, a.Name()))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "..", "a"), target)
+	assert.FileExists(t, filepath.Join(vendorDir, a.Name(), "a.libsonnet"))
+
+	// taken legacy names keep the full name as primary
+	target, err = os.Readlink(filepath.Join(vendorDir, taken.Name()))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cachePath(vendorDir, taken), taken.Name()), target)
+}
+
+func TestEnsureVendorPrefix(t *testing.T) {
+	r := newTestRepo(t, "prefixed")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithVendorPrefix("gen"))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(vendorDir, "gen", r.src.Name(), "main.libsonnet"))
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.LegacyName(), "main.libsonnet"))
+	assert.NoDirExists(t, filepath.Join(vendorDir, r.src.Name()))
+	assert.Empty(t, Doctor(jsf, vendorDir, locks, WithVendorPrefix("gen")))
+
+	// dropping the prefix moves the packages back
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.LegacyName(), "main.libsonnet"))
+	assert.NoDirExists(t, filepath.Join(vendorDir, "gen"))
+}
+
+func TestEnsureRelocatable(t *testing.T) {
+	r := newTestRepo(t, "relocatable")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+
+	tests := map[string][]Option{
+		"plain":          nil,
+		"prefix":         {WithVendorPrefix("gen/libs")},
+		"legacy primary": {WithLegacyPrimary(true)},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			bundle := filepath.Join(t.TempDir(), "a", "project")
+			vendorDir := filepath.Join(bundle, "vendor")
+			_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), opts...)
+			require.NoError(t, err)
+
+			// move vendor and cache to a different absolute prefix
+			moved := filepath.Join(t.TempDir(), "elsewhere")
+			require.NoError(t, os.Rename(bundle, moved))
+			vendorDir = filepath.Join(moved, "vendor")
+
+			links := 0
+			err = filepath.Walk(vendorDir, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.Mode()&os.ModeSymlink == 0 {
+					return err
+				}
+				links++
+				target, err := os.Readlink(path)
+				require.NoError(t, err)
+				assert.False(t, filepath.IsAbs(target), "%s links to %s", path, target)
+				_, err = os.Stat(path)
+				assert.NoError(t, err, "%s is dangling", path)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.NotZero(t, links)
+			assert.FileExists(t, filepath.Join(vendorDir, r.src.LegacyName(), "main.libsonnet"))
+		})
+	}
+}
+
+func TestEnsureErrorPolicy(t *testing.T) {
+	r := newTestRepo(t, "good")
+	r.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+	broken := newTestRepo(t, "broken")
+
+	tests := []struct {
+		policy  ErrorPolicy
+		content string
+	}{
+		{policy: ErrorLeave, content: "{ v: 2 }"},
+		{policy: ErrorRollback, content: "{ v: 1 }"},
+		{policy: ErrorClean},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprint(tc.policy), func(t *testing.T) {
+			r.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+			vendorDir := t.TempDir()
+			jsf := v1.New()
+			jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+			_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+			require.NoError(t, err)
+
+			// without a lock, the good package is downloaded again before the
+			// broken one fails
+			r.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+			jsf.Dependencies.Set(broken.src.Name(), deps.Dependency{Source: deps.Source{GitSource: broken.src}, Version: "master"})
+			_, _, err = Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithErrorPolicy(tc.policy))
+			require.Error(t, err)
+
+			main := filepath.Join(vendorDir, r.src.Name(), "main.libsonnet")
+			if tc.content == "" {
+				assert.NoFileExists(t, main)
+				_, err := os.Lstat(filepath.Join(vendorDir, r.src.Name()))
+				assert.True(t, os.IsNotExist(err), "dangling link must be removed")
+			} else {
+				b, err := os.ReadFile(main)
+				require.NoError(t, err)
+				assert.Equal(t, tc.content, string(b))
+			}
+
+			entries, err := os.ReadDir(filepath.Join(vendorDir, ".cache"))
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.NotContains(t, e.Name(), ".rollback-")
+			}
+		})
+	}
+}
+
+func TestEnsurePartialInstall(t *testing.T) {
+	r := newTestRepo(t, "good")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	broken := newTestRepo(t, "broken")
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	jsf.Dependencies.Set(broken.src.Name(), deps.Dependency{Source: deps.Source{GitSource: broken.src}, Version: "master"})
+
+	vendorDir := t.TempDir()
+	_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	var partial *PartialInstallError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []string{r.src.Name()}, partial.Installed.Keys())
+	l, _ := partial.Installed.Get(r.src.Name())
+	d, _ := jsf.Dependencies.Get(r.src.Name())
+	assert.True(t, check(context.TODO(), l, cachePath(vendorDir, d), newOptions(nil)))
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+
+	// the progress is undone by the other policies
+	_, _, err = Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithErrorPolicy(ErrorRollback))
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &partial))
+}
+
+func TestEnsureAllErrors(t *testing.T) {
+	first := newTestRepo(t, "first")
+	r := newTestRepo(t, "good")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	last := newTestRepo(t, "last")
+
+	jsf := v1.New()
+	for _, repo := range []*testRepo{first, r, last} {
+		jsf.Dependencies.Set(repo.src.Name(), deps.Dependency{Source: deps.Source{GitSource: repo.src}, Version: "master"})
+	}
+
+	// all failures are reported, and packages after one are installed still
+	_, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	var partial *PartialInstallError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []string{r.src.Name()}, partial.Installed.Keys())
+	assert.ErrorContains(t, err, first.src.Name()+"@master")
+	assert.ErrorContains(t, err, last.src.Name()+"@master")
+}
+
+func TestEnsureMaterialize(t *testing.T) {
+	r := newTestRepo(t, "materialized")
+	require.NoError(t, os.Symlink("main.libsonnet", filepath.Join(r.dir, "alias.libsonnet")))
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+
+	for _, m := range []MaterializeMode{MaterializeCopy, MaterializeDetach} {
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			vendorDir := t.TempDir()
+			locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithMaterialize(m))
+			require.NoError(t, err)
+
+			for _, name := range []string{r.src.Name(), r.src.LegacyName()} {
+				assert.FileExists(t, filepath.Join(vendorDir, name, "main.libsonnet"))
+				assert.FileExists(t, filepath.Join(vendorDir, name, "alias.libsonnet"))
+			}
+			err = filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+				if i.Name() == ".cache" {
+					return filepath.SkipDir
+				}
+				assert.Zero(t, i.Mode()&os.ModeSymlink, "%s must not be a symlink", path)
+				return err
+			})
+			require.NoError(t, err)
+
+			if m == MaterializeDetach {
+				assert.NoDirExists(t, filepath.Join(vendorDir, ".cache"))
+			}
+
+			// a materialized vendor can be ensured again
+			_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithMaterialize(m))
+			require.NoError(t, err)
+			assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+		})
+	}
+}
+
+func TestPruneLegacyLinks(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	locks := orderedOf(a)
+	require.NoError(t, linkLegacy(context.TODO(), vendorDir, "", locks, false))
+	require.NoError(t, os.Symlink("nowhere", filepath.Join(vendorDir, "stale")))
+
+	removed, err := PruneLegacyLinks(vendorDir, locks)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{filepath.Join(vendorDir, "a"), filepath.Join(vendorDir, "stale")}, removed)
+	assert.FileExists(t, filepath.Join(vendorDir, a.Name(), "a.libsonnet"))
+
+	// nothing left to do
+	removed, err = PruneLegacyLinks(vendorDir, locks)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+}
+
+func TestEnsureTrustedSum(t *testing.T) {
+	r := newTestRepo(t, "trusted")
+	r.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+
+	jsf := v1.New()
+	d := deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"}
+	jsf.Dependencies.Set(r.src.Name(), d)
+	locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	require.NoError(t, err)
+	lock, _ := locks.Get(r.src.Name())
+	trusted := lock.Sum
+
+	d.TrustedSum = trusted
+	jsf.Dependencies.Set(r.src.Name(), d)
+	locks, _, err = Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	require.NoError(t, err)
+	lock, _ = locks.Get(r.src.Name())
+	assert.Equal(t, trusted, lock.Sum)
+	assert.Empty(t, lock.TrustedSum)
+
+	// the trusted sum wins over a lock agreeing with the new content
+	r.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+	d.TrustedSum = ""
+	jsf.Dependencies.Set(r.src.Name(), d)
+	vendorDir := t.TempDir()
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	lock, _ = locks.Get(r.src.Name())
+
+	d.TrustedSum = trusted
+	jsf.Dependencies.Set(r.src.Name(), d)
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	assert.ErrorIs(t, err, UntrustedSum)
+	assert.ErrorContains(t, err, trusted)
+	assert.ErrorContains(t, err, lock.Sum)
+}
+
+func TestEnsureValidate(t *testing.T) {
+	vendorDir := filepath.Join(t.TempDir(), "vendor")
+	jsf := v1.New()
+	jsf.Dependencies.Set("broken", deps.Dependency{})
+	_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	var verr *v1.ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.NoDirExists(t, vendorDir, "nothing must happen before validation")
+
+	r := newTestRepo(t, "nested-invalid")
+	r.commit(map[string]string{"jsonnetfile.json": `{"version": 1, "dependencies": [{"source": {"local": {"directory": ""}}}]}`})
+	jsf = v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.ErrorAs(t, err, &verr)
+	assert.ErrorContains(t, err, "jsonnetfile of "+r.src.Name())
+	assert.ErrorContains(t, err, "local source without directory")
+}
+
+func TestLinkLegacyInPlace(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	b := vendorPackage(t, vendorDir, testDep("b", "v1"), map[string]string{"b.libsonnet": "{}"})
+	locks := orderedOf(a, b)
+	require.NoError(t, linkLegacy(context.TODO(), vendorDir, "", locks, false))
+
+	valid := filepath.Join(vendorDir, "a")
+	before, err := os.Lstat(valid)
+	require.NoError(t, err)
+
+	wrong := filepath.Join(vendorDir, "b")
+	require.NoError(t, os.Remove(wrong))
+	require.NoError(t, os.Symlink(a.Name(), wrong))
+	stale := filepath.Join(vendorDir, "stale")
+	require.NoError(t, os.Symlink(a.Name(), stale))
+
+	keep := wantedLegacyLinks(vendorDir, "", locks)
+	_, err = cleanLegacySymlinks(vendorDir, "", locks, keep)
+	require.NoError(t, err)
+	require.NoError(t, linkLegacy(context.TODO(), vendorDir, "", locks, false))
+
+	// the valid link was never removed and recreated
+	after, err := os.Lstat(valid)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(before, after))
+
+	target, err := os.Readlink(wrong)
+	require.NoError(t, err)
+	assert.Equal(t, b.Name(), target)
+	assert.NoFileExists(t, stale)
+}
+
+func TestEnsureInMemory(t *testing.T) {
+	r := newTestRepo(t, "inline")
+	sha := r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	dir := t.TempDir()
+	vendorDir := filepath.Join(dir, "vendor")
+	locks, _, err := Ensure(context.TODO(), v1.FromDependencies(deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"}), vendorDir, nil)
+	require.NoError(t, err)
+	l, ok := locks.Get(r.src.Name())
+	require.True(t, ok)
+	assert.Equal(t, sha, l.Version)
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+
+	// neither a jsonnetfile nor a lock is written
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "vendor", entries[0].Name())
+
+	// a zero jsonnetfile has nothing to install
+	locks, _, err = Ensure(context.TODO(), v1.JsonnetFile{}, vendorDir, nil)
+	require.NoError(t, err)
+	assert.Empty(t, locks.Keys())
+}
@@ -1,10 +1,15 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
@@ -12,9 +17,114 @@ import (
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
 )
 
-func downloadAndLink(direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered) (*deps.Ordered, error) {
-	dl := new(parallelDownloader).Ensure(direct.Dependencies, vendorDir, "", oldLocks)
-	return oldLocks, linkDownloaded(direct.Dependencies, vendorDir, dl, oldLocks, make(map[string]struct{}))
+// downloadAndLink downloads all packages and links them into vendor. Along
+// with the locks, it returns the resolved tree.
+func downloadAndLink(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered, o *options, j *cacheJournal) (*deps.Ordered, *Tree, error) {
+	active, inactive := splitProfiles(direct.Dependencies, o.profiles)
+	existing := newExistingDirs(o.existing, oldLocks)
+	dropStaleLocks(ctx, active, oldLocks)
+	dropEditedLocks(ctx, active, oldLocks)
+	dl := (&parallelDownloader{opts: o, journal: j}).Ensure(ctx, active, vendorDir, "", oldLocks)
+	if o.strictLock {
+		if err := checkLockComplete(active, dl, oldLocks); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := checkCaseCollisions(dl); err != nil {
+		return nil, nil, err
+	}
+	winners, err := selectVersions(active, dl, o.versions(), o.versionLess, o.trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]struct{})
+	materialized := materializedPackages(active, dl)
+	linked := deps.NewOrdered()
+	var failed []error
+	err = linkDownloaded(ctx, active, vendorDir, o.vendorPrefix, dl, winners, materialized, o.symlinkFallback, existing, oldLocks, linked, seen, &failed)
+	if err == nil {
+		err = errors.Join(failed...)
+	}
+	if err != nil {
+		if len(linked.Keys()) > 0 {
+			return nil, nil, &PartialInstallError{Installed: linked, Err: err}
+		}
+		return nil, nil, err
+	}
+
+	// packages of inactive profiles are kept as they are, unless exclusive
+	for name := range lockedClosure(inactive, oldLocks, filepath.Join(vendorDir, o.vendorPrefix), o.registry) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		if o.exclusive {
+			oldLocks.Delete(name)
+			logger(ctx).Prune(name, "inactive profile")
+			continue
+		}
+		seen[name] = struct{}{}
+	}
+	reconcileLock(ctx, oldLocks, seen, o.pruneLock)
+	return oldLocks, buildTree(active, dl, winners), nil
+}
+
+// splitProfiles separates the dependencies to install for the active
+// profiles from the ones of inactive profiles
+func splitProfiles(list *deps.Ordered, profiles map[string]struct{}) (active, inactive *deps.Ordered) {
+	active, inactive = deps.NewOrdered(), deps.NewOrdered()
+	for _, k := range list.Keys() {
+		d, _ := list.Get(k)
+		if len(d.Profiles) == 0 {
+			active.Set(k, d)
+			continue
+		}
+		enabled := false
+		for _, p := range d.Profiles {
+			if _, ok := profiles[p]; ok {
+				enabled = true
+				break
+			}
+		}
+		if enabled {
+			active.Set(k, d)
+		} else {
+			inactive.Set(k, d)
+		}
+	}
+	return active, inactive
+}
+
+// lockedClosure returns the names of the locked packages of list, along with
+// the ones they require according to the jsonnetfiles present in pkgDir
+func lockedClosure(list, locks *deps.Ordered, pkgDir string, index Index) map[string]struct{} {
+	closure := make(map[string]struct{})
+	var walk func(list *deps.Ordered)
+	walk = func(list *deps.Ordered) {
+		for _, k := range list.Keys() {
+			d, _ := list.Get(k)
+			if _, ok := closure[d.Name()]; ok {
+				continue
+			}
+			if _, ok := locks.Get(d.Name()); !ok {
+				continue
+			}
+			closure[d.Name()] = struct{}{}
+			if d.Single {
+				continue
+			}
+			f, err := jsonnetfile.Load(filepath.Join(pkgDir, d.Name(), jsonnetfile.File))
+			if err != nil {
+				continue
+			}
+			nested, err := resolveRegistry(f.Dependencies, index)
+			if err != nil {
+				continue
+			}
+			walk(nested)
+		}
+	}
+	walk(list)
+	return closure
 }
 
 type packageRef struct {
@@ -25,6 +135,8 @@ type packageRef struct {
 type downloadedPackage struct {
 	lock deps.Dependency
 	jsf  *v1.JsonnetFile
+	// dir is the cache entry holding the package
+	dir string
 
 	downloadErr error
 }
@@ -33,6 +145,16 @@ type downloadedPackage struct {
 // The zero parallelDownloader is empty and ready for use. Must not be copied after first use.
 // Should not be used after calling Ensure.
 type parallelDownloader struct {
+	opts *options
+	// journal records the cache entries written, if any
+	journal *cacheJournal
+	// checkouts are the trees shared among packages of the same commit
+	checkouts *sharedCheckouts
+	// slots limit the concurrent downloads
+	slots *downloadSlots
+	// out buffers the messages of the packages, if output is ordered
+	out *packageOutput
+
 	// seen stores the packages that we are already working on
 	seen sync.Map
 	// stores how many goroutines are still working
@@ -52,9 +174,38 @@ type parallelDownloader struct {
 // The download of the package might fail. This function does not return an error but stores them in the returned map.
 // The downloadedPackage should be checked for downloadErr before use.
 // The parallelDownloader must be discarded after calling Ensure.
-func (pd *parallelDownloader) Ensure(direct *deps.Ordered, vendorDir, pathToParentModule string, oldLocks *deps.Ordered) map[packageRef]downloadedPackage {
-	pd.ensure(direct, vendorDir, "", oldLocks)
+func (pd *parallelDownloader) Ensure(ctx context.Context, direct *deps.Ordered, vendorDir, pathToParentModule string, oldLocks *deps.Ordered) map[packageRef]downloadedPackage {
+	if pd.opts == nil {
+		pd.opts = newOptions(nil)
+	}
+	if pd.checkouts == nil {
+		parent := pd.opts.stagingDir
+		if parent == "" {
+			parent = filepath.Join(vendorDir, ".cache")
+		}
+		pd.checkouts = newSharedCheckouts(parent)
+	}
+	if pd.slots == nil {
+		pd.slots = newDownloadSlots(pd.opts.downloadConcurrency(), pd.opts.kindLimits)
+	}
+	if pd.out == nil {
+		pd.out = newPackageOutput(pd.opts.output)
+	}
+	switch {
+	case pd.opts.deterministic:
+		// prefetching would claim packages regardless of their requirers
+		pd.ensureLevels(ctx, direct, vendorDir, oldLocks)
+	default:
+		if pd.opts.prefetch {
+			pd.prefetch(ctx, direct, vendorDir, oldLocks)
+		}
+		pd.ensure(ctx, direct, vendorDir, "", oldLocks)
+	}
 	pd.working.Wait()
+	pd.out.flush()
+	if err := pd.checkouts.cleanup(); err != nil {
+		warn(ctx, WarningCleanup, "", "failed to remove shared checkouts: %s", err)
+	}
 	return pd.locks
 }
 
@@ -62,7 +213,7 @@ func (pd *parallelDownloader) Ensure(direct *deps.Ordered, vendorDir, pathToPare
 // It spawns goroutines for all dependencies and does not wait for the goroutines to finish.
 // Callers should call pd.working.Wait() to wait for all goroutines to finish.
 // Stores all downloaded packages in pd.locks and all errors in pd.errs.
-func (pd *parallelDownloader) ensure(direct *deps.Ordered, vendorDir, pathToParentModule string, oldLocks *deps.Ordered) {
+func (pd *parallelDownloader) ensure(ctx context.Context, direct *deps.Ordered, vendorDir, pathToParentModule string, oldLocks *deps.Ordered) {
 	for _, k := range direct.Keys() {
 		pd.working.Add(1)
 		go func(k string) {
@@ -75,70 +226,229 @@ func (pd *parallelDownloader) ensure(direct *deps.Ordered, vendorDir, pathToPare
 			if seen {
 				return
 			}
+			if nested, parent := pd.ensurePackage(ctx, d, vendorDir, pathToParentModule, oldLocks); nested != nil {
+				pd.ensure(ctx, nested, vendorDir, parent, oldLocks)
+			}
+		}(k)
+	}
+}
 
-			cp := cachePath(vendorDir, d)
-			needsDownload := true
-			expectedSum := ""
+// ensurePackage downloads d, unless it is already intact, and stores its lock
+// in pd.locks or its error in pd.errs. It returns the dependencies of d to
+// ensure next, along with the path of d they are relative to, if any.
+func (pd *parallelDownloader) ensurePackage(ctx context.Context, d deps.Dependency, vendorDir, pathToParentModule string, oldLocks *deps.Ordered) (*deps.Ordered, string) {
+	ref := packageRef{name: d.Name(), version: d.Version}
+	ctx = pd.out.context(ctx, d.Name())
 
-			lock, present := oldLocks.Get(d.Name())
-			if present {
-				// if in lock file and the integrity is intact, no need to download
-				if check(lock, cp) {
-					needsDownload = false
-				}
-				// we should use the resolved version from the lock file
-				// e.g. master -> 0b2ab31b77f0ede56b660850462ff279eadcd50c
-				d.Version = lock.Version
-				expectedSum = lock.Sum
+	cp := pd.opts.cachePath(vendorDir, d)
+	needsDownload := true
+	expectedSum := ""
+	requested := d.Version
+
+	lock, present := oldLocks.Get(d.Name())
+	if present {
+		// the sum asserted in the jsonnetfile supersedes the locked one
+		if d.TrustedSum != "" {
+			lock.Sum = d.TrustedSum
+		}
+		// if in lock file and the integrity is intact, no need to download
+		intact := check(ctx, lock, cp, pd.opts) && hasNestedJsonnetfile(cp, d)
+		binaries := intact && pd.opts.cachedBinaries(d, cp)
+		if intact && !binaries {
+			needsDownload = false
+			touchCacheEntry(cp)
+		}
+		pd.opts.trace.printf("lock %s@%s: %s", d.Name(), d.Version, lock.Version)
+		// we should use the resolved version from the lock file
+		// e.g. master -> 0b2ab31b77f0ede56b660850462ff279eadcd50c
+		d.Version = lock.Version
+		d.Provenance = lock.Provenance
+		d.Requested = lock.Requested
+		d.Fallback = lock.Fallback
+		expectedSum = lock.Sum
+		// the locked sum includes the binary files about to be stripped
+		if binaries && pd.opts.binaryPolicy == BinaryStrip {
+			expectedSum = ""
+		}
+		// a release asset must not change once locked
+		if r, l := d.Source.ReleaseSource, lock.Source.ReleaseSource; r != nil && l != nil && r.Digest == "" && r.Repo == l.Repo && r.Asset == l.Asset {
+			release := *d.Source.ReleaseSource
+			release.Digest = lock.Source.ReleaseSource.Digest
+			d.Source.ReleaseSource = &release
+		}
+	}
+
+	if needsDownload {
+		pd.opts.trace.printf("cache miss %s@%s", d.Name(), d.Version)
+	} else {
+		pd.opts.trace.printf("cache hit %s@%s", d.Name(), d.Version)
+	}
+
+	if needsDownload {
+		// don't start downloads once canceled
+		if err := ctx.Err(); err != nil {
+			pd.addErr(ref, err)
+			return nil, ""
+		}
+		if err := pd.journal.replace(cp); err != nil {
+			pd.addErr(ref, err)
+			return nil, ""
+		}
+		l, err := pd.fetch(ctx, d, cp, pathToParentModule)
+		fellBack := false
+		if err != nil {
+			if l, err = pd.fallback(ctx, d, cp, pathToParentModule, err); err != nil {
+				pd.addErr(ref, err)
+				return nil, ""
 			}
+			fellBack = true
+		}
+		switch {
+		// a locked commit is never traded for the tip of its branch,
+		// unless it can't be installed anymore
+		case present && !fellBack && d.Source.GitSource != nil && commitShaPattern.MatchString(lock.Version) && l.Version != lock.Version:
+			pd.addErr(ref, fmt.Errorf("%w for %s: locked %s, got %s", LockNotHonored, d.Name(), lock.Version, l.Version))
+			return nil, ""
+		case d.TrustedSum != "" && d.TrustedSum != l.Sum:
+			pd.addErr(ref, fmt.Errorf("%w for %s@%s: trusted %s, got %s", UntrustedSum, d.Name(), d.Version, d.TrustedSum, l.Sum))
+			return nil, ""
+		case expectedSum != "" && expectedSum != l.Sum:
+			if l, err = pd.drifted(ctx, d, requested, lock, l, cp, pathToParentModule); err != nil {
+				pd.addErr(ref, err)
+				return nil, ""
+			}
+		}
+		lock = *l
+		lock.TrustedSum = ""
+		lock.Fallbacks = nil
+		lock.Materialize = false
+		// kept to notice when the jsonnetfile asks for another version
+		lock.Requested = ""
+		if requested != lock.Version {
+			lock.Requested = requested
+		}
+		recordDownload(ctx, packageRef{name: lock.Name(), version: lock.Version}, cp)
+	}
 
-			if needsDownload {
-				if err := os.RemoveAll(cp); err != nil {
-					pd.addErr(ref, err)
-					return
-				}
-				if err := os.MkdirAll(cp, os.ModePerm); err != nil {
-					pd.addErr(ref, err)
-					return
-				}
-				l, err := download(d, cp, pathToParentModule)
-				if err != nil {
-					pd.addErr(ref, err)
-					return
-				}
-				if expectedSum != "" && expectedSum != l.Sum {
-					pd.addErr(ref, fmt.Errorf("integrity check failed for %s@%s", d.Name(), d.Version))
-					return
-				}
-				lock = *l
+	if d.Single {
+		// skip dependencies that explicitely don't want nested ones installed
+		pd.addLock(ref, downloadedPackage{lock: lock, dir: cp})
+		return nil, ""
+	}
+
+	// load jsonnetfile from the package and recursively download dependencies
+	f, err := jsonnetfile.Load(nestedJsonnetfile(cp, d))
+	if err != nil {
+		if os.IsNotExist(err) {
+			pd.addLock(ref, downloadedPackage{lock: lock, dir: cp})
+			return nil, ""
+		}
+		pd.addErr(ref, err)
+		return nil, ""
+	}
+	if err := f.Validate(); err != nil {
+		pd.addErr(ref, fmt.Errorf("jsonnetfile of %s: %w", d.Name(), err))
+		return nil, ""
+	}
+	if f.Dependencies, err = resolveRegistry(f.Dependencies, pd.opts.registry); err != nil {
+		pd.addErr(ref, fmt.Errorf("jsonnetfile of %s: %w", d.Name(), err))
+		return nil, ""
+	}
+	excludeDependencies(ctx, d.Name(), f.Dependencies, pd.opts.exclude)
+	for _, k := range f.Dependencies.Keys() {
+		nested, _ := f.Dependencies.Get(k)
+		pd.opts.trace.printf("require %s@%s: %s@%s", d.Name(), lock.Version, nested.Name(), nested.Version)
+	}
+	pd.addLock(ref, downloadedPackage{lock: lock, jsf: &f, dir: cp})
+
+	absolutePath, err := filepath.EvalSymlinks(filepath.Join(cp, d.Name()))
+	if err != nil {
+		pd.addErr(ref, err)
+		return nil, ""
+	}
+
+	return f.Dependencies, absolutePath
+}
+
+// fetch downloads d into the cache entry cp, replacing whatever is there
+func (pd *parallelDownloader) fetch(ctx context.Context, d deps.Dependency, cp, pathToParentModule string) (*deps.Dependency, error) {
+	if err := os.RemoveAll(cp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cp, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// the slot is held for the download only, never while ensuring the
+	// nested packages, so trees deeper than the limit can't deadlock
+	release, err := pd.slots.acquire(ctx, pd.opts.sourceKind(d))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return download(ctx, d, cp, pathToParentModule, pd.opts, pd.checkouts)
+}
+
+// prefetch enumerates the nested packages expected below the direct ones by
+// reading the jsonnetfiles already present in the cache, and starts ensuring
+// them right away instead of waiting for their parents to be ensured.
+// Only locked git packages are considered, everything else is discovered by
+// ensure as usual.
+func (pd *parallelDownloader) prefetch(ctx context.Context, direct *deps.Ordered, vendorDir string, oldLocks *deps.Ordered) {
+	expected := deps.NewOrdered()
+	visited := make(map[packageRef]struct{})
+
+	var walk func(list *deps.Ordered, nested bool)
+	walk = func(list *deps.Ordered, nested bool) {
+		for _, k := range list.Keys() {
+			d, _ := list.Get(k)
+			ref := packageRef{name: d.Name(), version: d.Version}
+			if _, ok := visited[ref]; ok {
+				continue
 			}
+			visited[ref] = struct{}{}
 
+			if d.Source.GitSource == nil {
+				continue
+			}
+			if _, locked := oldLocks.Get(d.Name()); !locked {
+				continue
+			}
+			if nested {
+				if _, excluded := pd.opts.exclude[d.Name()]; excluded {
+					continue
+				}
+				expected.Set(ref.name+"@"+ref.version, d)
+			}
 			if d.Single {
-				// skip dependencies that explicitely don't want nested ones installed
-				pd.addLock(ref, downloadedPackage{lock: lock})
-				return
+				continue
 			}
 
-			// load jsonnetfile from the package and recursively download dependencies
-			f, err := jsonnetfile.Load(filepath.Join(cp, d.Name(), jsonnetfile.File))
+			f, err := jsonnetfile.Load(nestedJsonnetfile(pd.opts.cachePath(vendorDir, d), d))
 			if err != nil {
-				if os.IsNotExist(err) {
-					pd.addLock(ref, downloadedPackage{lock: lock})
-					return
-				}
-				pd.addErr(ref, err)
-				return
+				continue
 			}
-			pd.addLock(ref, downloadedPackage{lock: lock, jsf: &f})
-
-			absolutePath, err := filepath.EvalSymlinks(filepath.Join(cp, d.Name()))
+			nested, err := resolveRegistry(f.Dependencies, pd.opts.registry)
 			if err != nil {
-				pd.addErr(ref, err)
-				return
+				continue
 			}
+			walk(nested, true)
+		}
+	}
+	walk(direct, false)
 
-			pd.ensure(f.Dependencies, vendorDir, absolutePath, oldLocks)
-		}(k)
+	pd.ensure(ctx, expected, vendorDir, "", oldLocks)
+}
+
+// excludeDependencies removes the excluded packages from the dependencies
+// of parent, so they are neither downloaded nor linked
+func excludeDependencies(ctx context.Context, parent string, list *deps.Ordered, exclude map[string]struct{}) {
+	for _, k := range list.Keys() {
+		d, _ := list.Get(k)
+		if _, ok := exclude[d.Name()]; !ok {
+			continue
+		}
+		list.Delete(k)
+		warn(ctx, WarningExcluded, d.Name(), "%s requires %s, which is excluded", parent, d.Name())
 	}
 }
 
@@ -160,14 +470,50 @@ func (pd *parallelDownloader) addErr(p packageRef, err error) {
 	pd.locks[p] = downloadedPackage{downloadErr: err}
 }
 
+// nestedJsonnetfile returns the path of the jsonnetfile declaring the
+// dependencies of the package in the cache path cp
+func nestedJsonnetfile(cp string, d deps.Dependency) string {
+	if g := d.Source.GitSource; g != nil && g.RootJsonnetfile {
+		root := filepath.Join(cp, rootJsonnetfile)
+		if g.Subdir != "" {
+			return root
+		}
+		// there is none if the root itself is the candidate installed
+		if ok, _ := jsonnetfile.Exists(root); ok && len(g.Subdirs) > 0 {
+			return root
+		}
+	}
+	return filepath.Join(cp, d.Name(), jsonnetfile.File)
+}
+
+// hasNestedJsonnetfile returns false if the cache path is missing the
+// root jsonnetfile the package asks for, e.g. because it was downloaded before
+// the package asked for it
+func hasNestedJsonnetfile(cp string, d deps.Dependency) bool {
+	if d.Source.GitSource == nil || !d.Source.GitSource.RootJsonnetfile || d.Source.GitSource.Subdir == "" {
+		return true
+	}
+	ok, _ := jsonnetfile.Exists(filepath.Join(cp, rootJsonnetfile))
+	return ok
+}
+
 func cachePath(vendorDir string, d deps.Dependency) string {
-	return filepath.Join(vendorDir, ".cache", url.PathEscape(d.Name()+"-"+d.Version))
+	// the ':' of digests is not allowed in file names on Windows
+	return filepath.Join(vendorDir, ".cache", strings.ReplaceAll(url.PathEscape(d.Name()+"-"+d.Version), ":", "%3A"))
 }
 
-// linkDownloaded recursively links all downloaded packages into the vendor directory.
+// linkDownloaded recursively links all downloaded packages into the vendor directory,
+// below the given prefix.
 // It also deterministically adds the downloaded packages to the locks.
-// The first seen packages version is used as the lock version.
-func linkDownloaded(direct *deps.Ordered, vendorDir string, downloaded map[packageRef]downloadedPackage, oldLocks *deps.Ordered, seen map[string]struct{}) error {
+// The version of winners is used as the lock version, if present. Otherwise
+// the first seen packages version is used.
+// Packages in materialized are copied instead of linked, as are the ones that
+// fail to link if symlinkFallback is set. Real directories in the way of a
+// package are handled according to existing.
+// The locks of the packages linked so far are collected in linked.
+// Packages that failed to download are skipped, their errors collected in
+// failed, so all of them are reported at once.
+func linkDownloaded(ctx context.Context, direct *deps.Ordered, vendorDir, prefix string, downloaded map[packageRef]downloadedPackage, winners map[string]string, materialized map[string]struct{}, symlinkFallback bool, existing existingDirs, oldLocks, linked *deps.Ordered, seen map[string]struct{}, failed *[]error) error {
 	for _, k := range direct.Keys() {
 		d, _ := direct.Get(k)
 		// skip if we already linked and locked this package
@@ -175,6 +521,9 @@ func linkDownloaded(direct *deps.Ordered, vendorDir string, downloaded map[packa
 			continue
 		}
 		seen[d.Name()] = struct{}{}
+		if v, ok := winners[d.Name()]; ok {
+			d.Version = v
+		}
 
 		// check cache if we downloaded this package
 		// it should always be present
@@ -183,20 +532,45 @@ func linkDownloaded(direct *deps.Ordered, vendorDir string, downloaded map[packa
 			return fmt.Errorf("could not find downloaded package %s@%s", d.Name(), d.Version)
 		}
 		if dl.downloadErr != nil {
-			return fmt.Errorf("downloaded package %s@%s has error but is required: %w", d.Name(), d.Version, dl.downloadErr)
+			*failed = append(*failed, fmt.Errorf("downloaded package %s@%s has error but is required: %w", d.Name(), d.Version, dl.downloadErr))
+			continue
 		}
 		oldLocks.Set(d.Name(), dl.lock)
 
 		// link the package into the vendor directory
-		dest := filepath.Join(vendorDir, d.Name())
-		if err := os.RemoveAll(dest); err != nil {
+		// symlinks are swapped atomically, so the package never disappears
+		dest := filepath.Join(vendorDir, prefix, d.Name())
+		_, materialize := materialized[d.Name()]
+		replace, err := existing.replace(ctx, d.Name(), dest)
+		if err != nil {
 			return err
 		}
+		if fi, err := os.Lstat(dest); replace && (materialize || (err == nil && fi.Mode()&os.ModeSymlink == 0)) {
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+		}
 		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
 			return err
 		}
-		if err := os.Symlink(filepath.Join(cachePath(vendorDir, d), d.Name()), dest); err != nil {
-			return err
+		src := filepath.Join(dl.dir, d.Name())
+		switch {
+		case !replace:
+			// the directory stays, its dependencies are installed nonetheless
+		case materialize:
+			if err := copyResolved(src, dest, map[string]struct{}{}); err != nil {
+				return fmt.Errorf("failed to materialize %s: %w", d.Name(), err)
+			}
+		default:
+			if err := linkOrCopy(ctx, d.Name(), src, dest, symlinkFallback); err != nil {
+				return err
+			}
+		}
+		if replace {
+			if err := linkRootFiles(ctx, d, vendorDir, prefix, dl.dir, materialize, symlinkFallback); err != nil {
+				return err
+			}
+			linked.Set(d.Name(), dl.lock)
 		}
 
 		if dl.jsf == nil {
@@ -204,8 +578,177 @@ func linkDownloaded(direct *deps.Ordered, vendorDir string, downloaded map[packa
 		}
 
 		// if the package has a jsonnetfile, recursively link and lock its dependencies
-		linkDownloaded(dl.jsf.Dependencies, vendorDir, downloaded, oldLocks, seen)
+		if err := linkDownloaded(ctx, dl.jsf.Dependencies, vendorDir, prefix, downloaded, winners, materialized, symlinkFallback, existing, oldLocks, linked, seen, failed); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// rootFiles returns the paths the RootFiles of d are vendored at, relative to
+// the vendor prefix
+func rootFiles(d deps.Dependency) []string {
+	g := d.Source.GitSource
+	if g == nil || g.Subdir == "" {
+		return nil
+	}
+	paths := make([]string, 0, len(g.RootFiles))
+	for _, f := range g.RootFiles {
+		paths = append(paths, path.Join(strings.TrimSuffix(d.Name(), g.Subdir), f))
+	}
+	return paths
+}
+
+// linkRootFiles links the RootFiles of d from the cache entry dir into vendor,
+// next to the package. They are copied if the package is materialized.
+func linkRootFiles(ctx context.Context, d deps.Dependency, vendorDir, prefix, dir string, materialize, symlinkFallback bool) error {
+	for _, f := range rootFiles(d) {
+		src := filepath.Join(dir, filepath.FromSlash(f))
+		dest := filepath.Join(vendorDir, prefix, filepath.FromSlash(f))
+		if fi, err := os.Lstat(dest); err == nil && (materialize || fi.Mode()&os.ModeSymlink == 0) {
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return err
+		}
+		if materialize {
+			if err := copyResolved(src, dest, map[string]struct{}{}); err != nil {
+				return fmt.Errorf("failed to materialize %s: %w", f, err)
+			}
+			continue
+		}
+		if err := linkOrCopy(ctx, d.Name(), src, dest, symlinkFallback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkOrCopy links dest to src. If that fails and fallback is set, src is
+// copied to dest instead, which hashes just the same.
+func linkOrCopy(ctx context.Context, pkgName, src, dest string, fallback bool) error {
+	// relative to the link, so vendor and cache can be moved together
+	target, err := filepath.Rel(filepath.Dir(dest), src)
+	if err != nil {
+		return err
+	}
+	err = replaceSymlink(target, dest)
+	if err == nil || !fallback {
+		return err
+	}
+	warn(ctx, WarningSymlinkFallback, pkgName, "failed to link %s, copying it instead: %s", dest, err)
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := copyResolved(src, dest, map[string]struct{}{}); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", dest, err)
+	}
+	return nil
+}
+
+// materializedPackages returns the names of all packages that any
+// jsonnetfile of the tree asks to materialize
+func materializedPackages(direct *deps.Ordered, downloaded map[packageRef]downloadedPackage) map[string]struct{} {
+	materialized := make(map[string]struct{})
+	add := func(list *deps.Ordered) {
+		for _, k := range list.Keys() {
+			if d, _ := list.Get(k); d.Materialize {
+				materialized[d.Name()] = struct{}{}
+			}
+		}
+	}
+	add(direct)
+	for _, dl := range downloaded {
+		if dl.jsf != nil {
+			add(dl.jsf.Dependencies)
+		}
+	}
+	return materialized
+}
+
+// checkLockComplete returns an error listing all transitive packages that are
+// not part of the lock, together with the package that requires them.
+// Direct dependencies are not checked, as they are explicitly requested.
+func checkLockComplete(direct *deps.Ordered, downloaded map[packageRef]downloadedPackage, locks *deps.Ordered) error {
+	missing := []string{}
+	seen := make(map[string]struct{})
+
+	var walk func(parent string, list *deps.Ordered)
+	walk = func(parent string, list *deps.Ordered) {
+		for _, k := range list.Keys() {
+			d, _ := list.Get(k)
+			if _, ok := seen[d.Name()]; ok {
+				continue
+			}
+			seen[d.Name()] = struct{}{}
+
+			if _, locked := locks.Get(d.Name()); parent != "" && !locked {
+				missing = append(missing, fmt.Sprintf("%s (required by %s)", d.Name(), parent))
+			}
+
+			dl, ok := downloaded[packageRef{name: d.Name(), version: d.Version}]
+			if !ok || dl.jsf == nil {
+				continue
+			}
+			walk(d.Name(), dl.jsf.Dependencies)
+		}
+	}
+	walk("", direct)
+
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w, run `jb update` to accept new transitive dependencies:\n  %s", LockIncomplete, strings.Join(missing, "\n  "))
+}
+
+// reconcileLock reports all locked packages that are no longer reachable from
+// the direct dependencies, e.g. because one was removed from the jsonnetfile
+// by hand. If prune is set, they are removed from the lock as well.
+func reconcileLock(ctx context.Context, locks *deps.Ordered, reachable map[string]struct{}, prune bool) {
+	for _, k := range locks.Keys() {
+		if _, ok := reachable[k]; ok {
+			continue
+		}
+		if !prune {
+			warn(ctx, WarningUnrequired, k, "%s is locked but no longer required", k)
+			continue
+		}
+		locks.Delete(k)
+		logger(ctx).Prune(k, "")
+	}
+}
+
+// checkCaseCollisions returns an error if the names of two packages only
+// differ by case. They would end up at the same path on case-insensitive
+// filesystems, silently overwriting each other.
+func checkCaseCollisions(downloaded map[packageRef]downloadedPackage) error {
+	byFold := map[string]map[string]struct{}{}
+	for ref := range downloaded {
+		folded := strings.ToLower(ref.name)
+		if byFold[folded] == nil {
+			byFold[folded] = map[string]struct{}{}
+		}
+		byFold[folded][ref.name] = struct{}{}
+	}
+
+	collisions := []string{}
+	for _, names := range byFold {
+		if len(names) < 2 {
+			continue
+		}
+		list := make([]string, 0, len(names))
+		for n := range names {
+			list = append(list, n)
+		}
+		sort.Strings(list)
+		collisions = append(collisions, strings.Join(list, ", "))
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+	sort.Strings(collisions)
+	return fmt.Errorf("%w, they would overwrite each other on case-insensitive filesystems:\n  %s", CaseCollision, strings.Join(collisions, "\n  "))
+}
@@ -0,0 +1,704 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func testDep(repo, version string) deps.Dependency {
+	return deps.Dependency{
+		Source: deps.Source{GitSource: &deps.Git{
+			Scheme: deps.GitSchemeHTTPS,
+			Host:   "example.com",
+			User:   "test",
+			Repo:   repo,
+		}},
+		Version: version,
+	}
+}
+
+func orderedOf(ds ...deps.Dependency) *deps.Ordered {
+	o := deps.NewOrdered()
+	for _, d := range ds {
+		o.Set(d.Name(), d)
+	}
+	return o
+}
+
+func TestCheckLockComplete(t *testing.T) {
+	a, b, c := testDep("a", "v1"), testDep("b", "v1"), testDep("c", "v1")
+	downloaded := map[packageRef]downloadedPackage{
+		{name: a.Name(), version: "v1"}: {lock: a, jsf: &v1.JsonnetFile{Dependencies: orderedOf(b)}},
+		{name: b.Name(), version: "v1"}: {lock: b, jsf: &v1.JsonnetFile{Dependencies: orderedOf(c)}},
+		{name: c.Name(), version: "v1"}: {lock: c},
+	}
+
+	// direct dependencies need not be locked
+	assert.NoError(t, checkLockComplete(orderedOf(a), downloaded, orderedOf(b, c)))
+
+	err := checkLockComplete(orderedOf(a), downloaded, orderedOf(a, b))
+	require.ErrorIs(t, err, LockIncomplete)
+	assert.Contains(t, err.Error(), "example.com/test/c (required by example.com/test/b)")
+	assert.NotContains(t, err.Error(), "example.com/test/b (")
+}
+
+func TestPrefetch(t *testing.T) {
+	vendorDir := t.TempDir()
+
+	b := vendorPackage(t, vendorDir, testDep("b", "v1"), map[string]string{"b.libsonnet": "{}"})
+	jsf, err := json.Marshal(v1.JsonnetFile{Dependencies: orderedOf(testDep("b", "v1"))})
+	require.NoError(t, err)
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{jsonnetfile.File: string(jsf)})
+
+	pd := &parallelDownloader{opts: newOptions(nil)}
+	pd.prefetch(context.TODO(), orderedOf(testDep("a", "v1")), vendorDir, orderedOf(a, b))
+	pd.working.Wait()
+
+	// only the nested package is started by prefetch, the direct one is left
+	// to ensure
+	require.Len(t, pd.locks, 1)
+	dl, ok := pd.locks[packageRef{name: b.Name(), version: "v1"}]
+	require.True(t, ok)
+	assert.NoError(t, dl.downloadErr)
+	assert.Equal(t, b, dl.lock)
+}
+
+func TestReconcileLock(t *testing.T) {
+	a, b, c := testDep("a", "v1"), testDep("b", "v1"), testDep("c", "v1")
+	reachable := map[string]struct{}{a.Name(): {}, b.Name(): {}}
+
+	locks := orderedOf(a, b, c)
+	reconcileLock(context.TODO(), locks, reachable, false)
+	assert.Equal(t, []string{a.Name(), b.Name(), c.Name()}, locks.Keys())
+
+	reconcileLock(context.TODO(), locks, reachable, true)
+	assert.Equal(t, []string{a.Name(), b.Name()}, locks.Keys())
+}
+
+func TestCheckCaseCollisions(t *testing.T) {
+	upper, lower, other := testDep("Repo", "v1"), testDep("repo", "v1"), testDep("other", "v1")
+	downloaded := map[packageRef]downloadedPackage{
+		{name: lower.Name(), version: "v1"}: {lock: lower},
+		{name: lower.Name(), version: "v2"}: {lock: lower},
+		{name: other.Name(), version: "v1"}: {lock: other},
+	}
+	assert.NoError(t, checkCaseCollisions(downloaded))
+
+	downloaded[packageRef{name: upper.Name(), version: "v1"}] = downloadedPackage{lock: upper}
+	err := checkCaseCollisions(downloaded)
+	require.ErrorIs(t, err, CaseCollision)
+	assert.Contains(t, err.Error(), "example.com/test/Repo, example.com/test/repo")
+}
+
+func TestEnsureExclude(t *testing.T) {
+	wanted := newTestRepo(t, "wanted")
+	wanted.commit(map[string]string{"main.libsonnet": "{}"})
+	unwanted := newTestRepo(t, "unwanted")
+	unwanted.commit(map[string]string{"main.libsonnet": "{}"})
+	parent := newTestRepo(t, "parent")
+	parent.commit(map[string]string{"jsonnetfile.json": `{"version": 1, "dependencies": [
+		{"source": {"git": {"remote": "https://example.com/test/wanted.git"}}, "version": "master"},
+		{"source": {"git": {"remote": "https://example.com/test/unwanted.git"}}, "version": "master"}
+	]}`})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(parent.src.Name(), deps.Dependency{Source: deps.Source{GitSource: parent.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	_, ok := locks.Get(unwanted.src.Name())
+	require.True(t, ok)
+
+	for i := 0; i < 2; i++ {
+		locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithExclude(unwanted.src.Name()), WithPrefetch(i == 1), WithPruneLock(true))
+		require.NoError(t, err)
+		_, ok = locks.Get(unwanted.src.Name())
+		assert.False(t, ok)
+		_, ok = locks.Get(wanted.src.Name())
+		assert.True(t, ok)
+		assert.NoDirExists(t, filepath.Join(vendorDir, unwanted.src.Name()))
+	}
+
+	// direct dependencies are kept
+	jsf.Dependencies.Set(unwanted.src.Name(), deps.Dependency{Source: deps.Source{GitSource: unwanted.src}, Version: "master"})
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithExclude(unwanted.src.Name()))
+	require.NoError(t, err)
+	_, ok = locks.Get(unwanted.src.Name())
+	assert.True(t, ok)
+}
+
+func TestEnsureMaterializeDependency(t *testing.T) {
+	copied := newTestRepo(t, "copied")
+	copied.commit(map[string]string{"main.libsonnet": "{}", "lib/x.libsonnet": "{}"})
+	linked := newTestRepo(t, "linked")
+	linked.commit(map[string]string{"main.libsonnet": "{}"})
+
+	isLink := func(t *testing.T, path string) bool {
+		fi, err := os.Lstat(path)
+		require.NoError(t, err)
+		return fi.Mode()&os.ModeSymlink != 0
+	}
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(copied.src.Name(), deps.Dependency{Source: deps.Source{GitSource: copied.src}, Version: "master", Materialize: true})
+	jsf.Dependencies.Set(linked.src.Name(), deps.Dependency{Source: deps.Source{GitSource: linked.src}, Version: "master"})
+
+	// a second run must not clean up the real directory
+	locks := deps.NewOrdered()
+	for i := 0; i < 2; i++ {
+		var err error
+		locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+		require.NoError(t, err)
+
+		assert.False(t, isLink(t, filepath.Join(vendorDir, copied.src.Name())))
+		assert.FileExists(t, filepath.Join(vendorDir, copied.src.Name(), "lib", "x.libsonnet"))
+		assert.True(t, isLink(t, filepath.Join(vendorDir, linked.src.Name())))
+	}
+	l, _ := locks.Get(copied.src.Name())
+	assert.False(t, l.Materialize)
+
+	// and switching back links it again
+	d, _ := jsf.Dependencies.Get(copied.src.Name())
+	d.Materialize = false
+	jsf.Dependencies.Set(copied.src.Name(), d)
+	_, _, err := Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	assert.True(t, isLink(t, filepath.Join(vendorDir, copied.src.Name())))
+}
+
+func TestEnsureTransactional(t *testing.T) {
+	a := newTestRepo(t, "txa")
+	a.commit(map[string]string{"main.libsonnet": "{}"})
+	b := newTestRepo(t, "txb")
+	b.commit(map[string]string{"main.libsonnet": "{}"})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(a.src.Name(), deps.Dependency{Source: deps.Source{GitSource: a.src}, Version: "master"})
+	jsf.Dependencies.Set(b.src.Name(), deps.Dependency{Source: deps.Source{GitSource: b.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithTransactional(true))
+	require.NoError(t, err)
+
+	// if vendor matches, not even the links are recreated
+	link := filepath.Join(vendorDir, a.src.Name())
+	before, err := os.Lstat(link)
+	require.NoError(t, err)
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithTransactional(true))
+	require.NoError(t, err)
+	after, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.Equal(t, before.ModTime(), after.ModTime())
+
+	// anything off reinstalls everything
+	stray := filepath.Join(vendorDir, "stray")
+	require.NoError(t, os.MkdirAll(stray, os.ModePerm))
+	bFile := filepath.Join(vendorDir, b.src.Name(), "main.libsonnet")
+	require.NoError(t, os.WriteFile(bFile, []byte("{ tampered: true }"), 0644))
+
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithTransactional(true))
+	require.NoError(t, err)
+	assert.NoDirExists(t, stray)
+	after, err = os.Lstat(link)
+	require.NoError(t, err)
+	assert.NotEqual(t, before.ModTime(), after.ModTime())
+	content, err := os.ReadFile(bFile)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+}
+
+func TestEnsureProfiles(t *testing.T) {
+	always := newTestRepo(t, "always")
+	always.commit(map[string]string{"main.libsonnet": "{}"})
+	nested := newTestRepo(t, "devnested")
+	nested.commit(map[string]string{"main.libsonnet": "{}"})
+	dev := newTestRepo(t, "dev")
+	dev.commit(map[string]string{"jsonnetfile.json": `{"version": 1, "dependencies": [
+		{"source": {"git": {"remote": "https://example.com/test/devnested.git"}}, "version": "master"}
+	]}`})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(always.src.Name(), deps.Dependency{Source: deps.Source{GitSource: always.src}, Version: "master"})
+	jsf.Dependencies.Set(dev.src.Name(), deps.Dependency{Source: deps.Source{GitSource: dev.src}, Version: "master", Profiles: []string{"dev"}})
+
+	installed := func(locks *deps.Ordered, name string) bool {
+		_, locked := locks.Get(name)
+		_, err := os.Stat(filepath.Join(vendorDir, name))
+		assert.Equal(t, locked, err == nil, name)
+		return locked
+	}
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	assert.True(t, installed(locks, always.src.Name()))
+	assert.False(t, installed(locks, dev.src.Name()))
+
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithProfiles("dev"))
+	require.NoError(t, err)
+	assert.True(t, installed(locks, dev.src.Name()))
+	assert.True(t, installed(locks, nested.src.Name()))
+
+	// inactive profiles are kept, even when pruning the lock
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithPruneLock(true))
+	require.NoError(t, err)
+	assert.True(t, installed(locks, dev.src.Name()))
+	assert.True(t, installed(locks, nested.src.Name()))
+
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithExclusiveProfiles(true))
+	require.NoError(t, err)
+	assert.True(t, installed(locks, always.src.Name()))
+	assert.False(t, installed(locks, dev.src.Name()))
+	assert.False(t, installed(locks, nested.src.Name()))
+}
+
+func TestEnsureFloatingMajor(t *testing.T) {
+	r := newTestRepo(t, "floating")
+	tag := func(v string) string {
+		sha := r.commit(map[string]string{"version.txt": v})
+		r.git("tag", v)
+		return sha
+	}
+	tag("v2.0.0")
+	v21 := tag("v2.1.0")
+	v3 := tag("v3.0.0")
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "^2"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, v21, l.Version)
+	assert.Equal(t, "tag:v2.1.0", l.Provenance)
+
+	// installs stick to the lock
+	v22 := tag("v2.2.0")
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v21, l.Version)
+
+	// updates move within the major, never to 3.0.0
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v22, l.Version)
+	assert.Equal(t, "tag:v2.2.0", l.Provenance)
+
+	// a lock not satisfying a changed constraint is resolved again
+	d, _ := jsf.Dependencies.Get(r.src.Name())
+	d.Version = "^3"
+	jsf.Dependencies.Set(r.src.Name(), d)
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v3, l.Version)
+}
+
+func TestEnsureExistingPolicy(t *testing.T) {
+	r := newTestRepo(t, "existing")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+
+	// setup places a directory of the user where the package belongs
+	setup := func(t *testing.T) (vendorDir, userFile string) {
+		vendorDir = t.TempDir()
+		userFile = filepath.Join(vendorDir, r.src.Name(), "mine.libsonnet")
+		require.NoError(t, os.MkdirAll(filepath.Dir(userFile), os.ModePerm))
+		require.NoError(t, os.WriteFile(userFile, []byte("{}"), 0644))
+		return vendorDir, userFile
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		vendorDir, userFile := setup(t)
+		_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+		require.NoError(t, err)
+		assert.NoFileExists(t, userFile)
+		assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		vendorDir, userFile := setup(t)
+		_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithExistingPolicy(ExistingError))
+		assert.ErrorIs(t, err, UnmanagedDirectory)
+		assert.FileExists(t, userFile)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		vendorDir, userFile := setup(t)
+		locks, warnings, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithExistingPolicy(ExistingSkip))
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+		assert.Equal(t, WarningUnmanaged, warnings[0].Kind)
+		assert.Equal(t, r.src.Name(), warnings[0].Package)
+		assert.FileExists(t, userFile)
+		assert.NoFileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+		_, ok := locks.Get(r.src.Name())
+		assert.True(t, ok)
+	})
+
+	t.Run("materialized copies are managed", func(t *testing.T) {
+		vendorDir := t.TempDir()
+		locks := deps.NewOrdered()
+		for i := 0; i < 2; i++ {
+			var err error
+			locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithMaterialize(MaterializeCopy), WithExistingPolicy(ExistingError))
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestEnsureBuildMetadata(t *testing.T) {
+	r := newTestRepo(t, "build-metadata")
+	tag := func(v string) string {
+		sha := r.commit(map[string]string{"version.txt": v})
+		r.git("tag", v)
+		return sha
+	}
+	tag("v1.2.3+build.9")
+	build10 := tag("v1.2.3+build.10")
+	tag("v1.2.2+build.99")
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "^1.2"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, build10, l.Version)
+	assert.Equal(t, "tag:v1.2.3+build.10", l.Provenance)
+
+	// the lock still satisfies the constraint
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, build10, l.Version)
+}
+
+func TestEnsureReadOnly(t *testing.T) {
+	r := newTestRepo(t, "readonly")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+	extra := newTestRepo(t, "readonly-extra")
+	extra.commit(map[string]string{"main.libsonnet": "{}"})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	got, _, err := Ensure(context.TODO(), jsf, vendorDir, locks, WithReadOnly(true))
+	require.NoError(t, err)
+	assert.Equal(t, locks, got)
+
+	// a stray directory would be removed
+	stray := filepath.Join(vendorDir, "stray")
+	require.NoError(t, os.MkdirAll(stray, os.ModePerm))
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithReadOnly(true))
+	assert.ErrorIs(t, err, ReadOnlyVendor)
+	assert.Contains(t, err.Error(), "stray would be removed")
+	assert.DirExists(t, stray)
+	require.NoError(t, os.Remove(stray))
+
+	// a new dependency would be downloaded
+	jsf.Dependencies.Set(extra.src.Name(), deps.Dependency{Source: deps.Source{GitSource: extra.src}, Version: "master"})
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithReadOnly(true))
+	assert.ErrorIs(t, err, ReadOnlyVendor)
+	assert.Contains(t, err.Error(), extra.src.Name()+" would be installed")
+	assert.NoDirExists(t, filepath.Join(vendorDir, extra.src.Name()))
+}
+
+func TestEnsureCacheContext(t *testing.T) {
+	r := newTestRepo(t, "context")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	withContext := func(ctx string) Option {
+		return WithCacheContext(func(deps.Dependency) string { return ctx })
+	}
+
+	for _, ctx := range []string{"", "mirror", "token=s3cr3t", ""} {
+		_, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), withContext(ctx))
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(vendorDir, ".cache"))
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), "s3cr3t")
+	}
+
+	// the same context always maps to the same entry
+	d, _ := jsf.Dependencies.Get(r.src.Name())
+	a := newOptions([]Option{withContext("mirror")}).cachePath(vendorDir, d)
+	b := newOptions([]Option{withContext("mirror")}).cachePath(vendorDir, d)
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, cachePath(vendorDir, d), a)
+}
+
+func TestEnsureDriftPolicy(t *testing.T) {
+	r := newTestRepo(t, "drift")
+	first := r.commit(map[string]string{"main.libsonnet": "{}"})
+	r.git("tag", "v1")
+
+	following := v1.New()
+	following.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	pinned := v1.New()
+	pinned.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v1"})
+	locks, _, err := Ensure(context.TODO(), following, t.TempDir(), deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	sum := l.Sum
+
+	// a lock with a sum the download doesn't match, as if it was stale
+	stale := l
+	stale.Sum = "stale"
+	staleLock := func() *deps.Ordered { return orderedOf(stale) }
+	second := r.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+
+	t.Run("fail", func(t *testing.T) {
+		_, _, err := Ensure(context.TODO(), following, t.TempDir(), staleLock())
+		assert.ErrorIs(t, err, IntegrityFailure)
+	})
+
+	t.Run("pinned always fails", func(t *testing.T) {
+		// v1 is the locked commit as well
+		stale := stale
+		stale.Requested = "v1"
+		for _, p := range []DriftPolicy{DriftAccept, DriftRelock} {
+			_, _, err := Ensure(context.TODO(), pinned, t.TempDir(), orderedOf(stale), WithDriftPolicy(p))
+			assert.ErrorIs(t, err, IntegrityFailure)
+		}
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		locks, _, err := Ensure(context.TODO(), following, t.TempDir(), staleLock(), WithDriftPolicy(DriftAccept))
+		require.NoError(t, err)
+		l, _ := locks.Get(r.src.Name())
+		assert.Equal(t, first, l.Version)
+		assert.Equal(t, sum, l.Sum)
+	})
+
+	t.Run("relock", func(t *testing.T) {
+		vendorDir := t.TempDir()
+		locks, _, err := Ensure(context.TODO(), following, vendorDir, staleLock(), WithDriftPolicy(DriftRelock))
+		require.NoError(t, err)
+		l, _ := locks.Get(r.src.Name())
+		assert.Equal(t, second, l.Version)
+		assert.NotEqual(t, sum, l.Sum)
+		content, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+		require.NoError(t, err)
+		assert.Equal(t, "{ v: 2 }", string(content))
+	})
+
+	t.Run("fallback branch of a version spec", func(t *testing.T) {
+		spec := v1.New()
+		spec.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "^2 || master"})
+		fromSpec := stale
+		fromSpec.Provenance = "branch:master"
+		locks, _, err := Ensure(context.TODO(), spec, t.TempDir(), orderedOf(fromSpec), WithDriftPolicy(DriftRelock))
+		require.NoError(t, err)
+		l, _ := locks.Get(r.src.Name())
+		assert.Equal(t, second, l.Version)
+		assert.Equal(t, "branch:master", l.Provenance)
+	})
+}
+
+func TestEnsureBranchReproducible(t *testing.T) {
+	r := newTestRepo(t, "branch")
+	first := r.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	require.Equal(t, first, l.Version)
+	lock := func() *deps.Ordered { return orderedOf(l) }
+
+	// the branch moves on
+	second := r.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+
+	assertInstalled := func(t *testing.T, vendorDir string, locks *deps.Ordered, commit, content string) {
+		t.Helper()
+		l, _ := locks.Get(r.src.Name())
+		assert.Equal(t, commit, l.Version)
+		got, err := os.ReadFile(filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got))
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		locks, _, err := Ensure(context.TODO(), jsf, vendorDir, lock())
+		require.NoError(t, err)
+		assertInstalled(t, vendorDir, locks, first, "{ v: 1 }")
+	})
+
+	t.Run("fresh cache", func(t *testing.T) {
+		vendorDir := t.TempDir()
+		locks, _, err := Ensure(context.TODO(), jsf, vendorDir, lock())
+		require.NoError(t, err)
+		assertInstalled(t, vendorDir, locks, first, "{ v: 1 }")
+	})
+
+	t.Run("corrupted cache", func(t *testing.T) {
+		vendorDir := t.TempDir()
+		_, _, err := Ensure(context.TODO(), jsf, vendorDir, lock())
+		require.NoError(t, err)
+		d, _ := jsf.Dependencies.Get(r.src.Name())
+		require.NoError(t, os.RemoveAll(filepath.Join(cachePath(vendorDir, d), r.src.Name())))
+		locks, _, err := Ensure(context.TODO(), jsf, vendorDir, lock())
+		require.NoError(t, err)
+		assertInstalled(t, vendorDir, locks, first, "{ v: 1 }")
+	})
+
+	t.Run("lock without sum", func(t *testing.T) {
+		unsummed := l
+		unsummed.Sum = ""
+		vendorDir := t.TempDir()
+		locks, _, err := Ensure(context.TODO(), jsf, vendorDir, orderedOf(unsummed))
+		require.NoError(t, err)
+		assertInstalled(t, vendorDir, locks, first, "{ v: 1 }")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		vendorDir := t.TempDir()
+		locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+		require.NoError(t, err)
+		assertInstalled(t, vendorDir, locks, second, "{ v: 2 }")
+	})
+}
+
+func TestEnsureRefPrecedence(t *testing.T) {
+	r := newTestRepo(t, "ambiguous")
+	tagged := r.commit(map[string]string{"main.libsonnet": "{ from: 'tag' }"})
+	r.git("tag", "v1.0")
+	r.git("checkout", "-q", "-b", "v1.0")
+	branched := r.commit(map[string]string{"main.libsonnet": "{ from: 'branch' }"})
+	r.git("checkout", "-q", "master")
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v1.0"})
+
+	tests := []struct {
+		precedence RefPrecedence
+		commit     string
+		provenance string
+	}{
+		{precedence: RefTagsFirst, commit: tagged, provenance: "tag:v1.0"},
+		{precedence: RefBranchesFirst, commit: branched, provenance: "branch:v1.0"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.provenance, func(t *testing.T) {
+			locks, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithRefPrecedence(tc.precedence))
+			require.NoError(t, err)
+			l, _ := locks.Get(r.src.Name())
+			assert.Equal(t, tc.commit, l.Version)
+			assert.Equal(t, tc.provenance, l.Provenance)
+		})
+	}
+
+	// unambiguous refs record no provenance
+	plain := v1.New()
+	plain.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), plain, t.TempDir(), deps.NewOrdered(), WithRefPrecedence(RefBranchesFirst))
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, tagged, l.Version)
+	assert.Empty(t, l.Provenance)
+}
+
+func TestEnsureEditedVersion(t *testing.T) {
+	nested := newTestRepo(t, "nested")
+	pinned := nested.commit(map[string]string{"main.libsonnet": "{}"})
+	r := newTestRepo(t, "edited")
+	v1Commit := r.commit(map[string]string{"main.libsonnet": "{ v: 1 }", "jsonnetfile.json": jsonnetfileFor(nested)})
+	r.git("tag", "v1")
+	v2Commit := r.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+	r.git("tag", "v2")
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v1"})
+	vendorDir := t.TempDir()
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+	l, _ := locks.Get(r.src.Name())
+	assert.Equal(t, v1Commit, l.Version)
+	assert.Equal(t, "v1", l.Requested)
+	nested.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+
+	// the edit of the jsonnetfile is honored, the transitive lock kept
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v2"})
+	locks, warnings, err := Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	assert.Equal(t, []Warning{{Kind: WarningRelock, Package: r.src.Name(), Message: r.src.Name() + " is locked for 'v1', but 'v2' is required now, resolving again"}}, warnings)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v2Commit, l.Version)
+	assert.Equal(t, "v2", l.Requested)
+	n, _ := locks.Get(nested.src.Name())
+	assert.Equal(t, pinned, n.Version)
+
+	// a lock without the requested version, like one written before it was
+	// recorded, is an edit unless the version is the locked commit
+	l.Requested = ""
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v1"})
+	locks, warnings, err = Ensure(context.TODO(), jsf, vendorDir, orderedOf(l, n))
+	require.NoError(t, err)
+	assert.Len(t, warnings, 1)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v1Commit, l.Version)
+
+	// a pinned commit records no requested version, editing it to a tag is
+	// noticed nonetheless
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: v1Commit})
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	l, _ = locks.Get(r.src.Name())
+	require.Empty(t, l.Requested)
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "v2"})
+	locks, warnings, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	require.NoError(t, err)
+	assert.Equal(t, []Warning{{Kind: WarningRelock, Package: r.src.Name(), Message: r.src.Name() + " is locked for '" + v1Commit + "', but 'v2' is required now, resolving again"}}, warnings)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v2Commit, l.Version)
+
+	// an abbreviation of the locked commit keeps it
+	l.Requested = ""
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: v2Commit[:7]})
+	locks, warnings, err = Ensure(context.TODO(), jsf, vendorDir, orderedOf(l, n))
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	l, _ = locks.Get(r.src.Name())
+	assert.Equal(t, v2Commit, l.Version)
+}
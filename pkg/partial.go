@@ -0,0 +1,40 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// PartialInstallError is returned by Ensure if it failed while linking the
+// packages into vendor, after some of them were linked already. Those are
+// installed and verified, so passing their locks to the next Ensure resumes
+// from there. It is only returned with ErrorLeave, as the other policies undo
+// the progress.
+type PartialInstallError struct {
+	// Installed holds the locks of the packages installed before the failure
+	Installed *deps.Ordered
+	Err       error
+}
+
+func (e *PartialInstallError) Error() string {
+	return fmt.Sprintf("%s (%d packages installed before the failure)", e.Err, len(e.Installed.Keys()))
+}
+
+func (e *PartialInstallError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// UnknownImport is returned if an import is not provided by any package
+var UnknownImport = errors.New("import not provided by any package")
+
+// Prune returns the subset of locks that provides the given imports, to
+// vendor only what is actually used, e.g. for a slim deployment.
+//
+// imports must be the transitive import closure of the entrypoints, as paths
+// relative to vendor, like "github.com/grafana/jsonnet-libs/grafana/main.libsonnet".
+// Imports of legacy names are attributed to the package they link to. An
+// import belongs to the package with the longest matching name, so nested
+// packages of the same repository are told apart.
+func Prune(imports []string, locks *deps.Ordered) (*deps.Ordered, error) {
+	used := make(map[string]struct{})
+	for _, imp := range imports {
+		imp = path.Clean(strings.TrimPrefix(imp, "/"))
+
+		owner, best := "", -1
+		for _, k := range locks.Keys() {
+			d, _ := locks.Get(k)
+			for _, name := range []string{d.Name(), d.LegacyName()} {
+				if name == "" || len(name) <= best {
+					continue
+				}
+				if imp == name || strings.HasPrefix(imp, name+"/") {
+					owner, best = k, len(name)
+				}
+			}
+		}
+		if best < 0 {
+			return nil, fmt.Errorf("%w: %s", UnknownImport, imp)
+		}
+		used[owner] = struct{}{}
+	}
+
+	pruned := deps.NewOrdered()
+	for _, k := range locks.Keys() {
+		if _, ok := used[k]; !ok {
+			continue
+		}
+		d, _ := locks.Get(k)
+		pruned.Set(k, d)
+	}
+	return pruned, nil
+}
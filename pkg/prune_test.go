@@ -0,0 +1,73 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	a, b, c := testDep("a", "v1"), testDep("b", "v1"), testDep("c", "v1")
+	nested := testDep("c", "v1")
+	nested.Source.GitSource.Subdir = "/lib"
+	locks := orderedOf(a, b, c, nested)
+
+	tests := []struct {
+		name    string
+		imports []string
+		want    []string
+		err     error
+	}{
+		{
+			name:    "full",
+			imports: []string{"example.com/test/a/main.libsonnet", "/example.com/test/b/x/y.libsonnet"},
+			want:    []string{a.Name(), b.Name()},
+		},
+		{
+			name:    "legacy",
+			imports: []string{"b/main.libsonnet"},
+			want:    []string{b.Name()},
+		},
+		{
+			name:    "nested",
+			imports: []string{"example.com/test/c/lib/main.libsonnet"},
+			want:    []string{nested.Name()},
+		},
+		{
+			name:    "prefix of other name",
+			imports: []string{"example.com/test/ab/main.libsonnet"},
+			err:     UnknownImport,
+		},
+		{
+			name: "none",
+			want: []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pruned, err := Prune(tc.imports, locks)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, pruned.Keys())
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// UnknownPackage is returned if a registry source names a package the index
+// doesn't know
+var UnknownPackage = errors.New("package not found in the registry index")
+
+// IndexEntry is the concrete source a short name of the registry resolves to
+type IndexEntry struct {
+	Source deps.Source `json:"source"`
+	// Version is used if the jsonnetfile doesn't ask for one
+	Version string `json:"version,omitempty"`
+}
+
+// Index maps the short names used by registry sources to their packages. See
+// WithRegistry.
+type Index map[string]IndexEntry
+
+// indexFile is the format of a registry index
+type indexFile struct {
+	Packages Index `json:"packages"`
+}
+
+// LoadIndex reads a registry index from a file or a http(s) URL. Only
+// WithHTTPRetryPolicy and WithBandwidthLimit are relevant of the options.
+func LoadIndex(ctx context.Context, location string, opts ...Option) (Index, error) {
+	var data []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		o := newOptions(opts)
+		resp, err := httpGet(ctx, nil, o.httpRetry, o.bandwidth, location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(location); err != nil {
+			return nil, err
+		}
+	}
+
+	var f indexFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse registry index %s: %w", location, err)
+	}
+	return f.Packages, nil
+}
+
+// resolveRegistry returns the dependencies with all registry sources
+// replaced by the concrete ones of the index. The version of the index is
+// used if the dependency doesn't ask for one. list is returned as is if it
+// has no registry sources.
+func resolveRegistry(list *deps.Ordered, index Index) (*deps.Ordered, error) {
+	found := false
+	for _, k := range list.Keys() {
+		d, _ := list.Get(k)
+		if d.Source.RegistrySource != nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return list, nil
+	}
+
+	resolved := deps.NewOrdered()
+	for _, k := range list.Keys() {
+		d, _ := list.Get(k)
+		r := d.Source.RegistrySource
+		if r == nil {
+			resolved.Set(k, d)
+			continue
+		}
+
+		if index == nil {
+			return nil, fmt.Errorf("%s is a registry source, but no registry is configured", r.Name)
+		}
+		entry, ok := index[r.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", UnknownPackage, r.Name)
+		}
+		if entry.Source.RegistrySource != nil || entry.Source.Name() == "" {
+			return nil, fmt.Errorf("registry index entry of %s has no concrete source", r.Name)
+		}
+
+		d.Source = entry.Source
+		if d.Version == "" {
+			d.Version = entry.Version
+		}
+		resolved.Set(d.Name(), d)
+	}
+	return resolved, nil
+}
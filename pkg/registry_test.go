@@ -0,0 +1,71 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureRegistry(t *testing.T) {
+	r := newTestRepo(t, "indexed")
+	sha := r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	data, err := json.Marshal(indexFile{Packages: Index{
+		"acme/indexed": {Source: deps.Source{GitSource: r.src}, Version: "master"},
+	}})
+	require.NoError(t, err)
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	require.NoError(t, os.WriteFile(indexPath, data, 0644))
+	index, err := LoadIndex(context.TODO(), indexPath)
+	require.NoError(t, err)
+
+	jsf := v1.New()
+	jsf.Dependencies.Set("acme/indexed", deps.Dependency{Source: deps.Source{RegistrySource: &deps.Registry{Name: "acme/indexed"}}})
+	vendorDir := t.TempDir()
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithRegistry(index))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(vendorDir, r.src.Name(), "main.libsonnet"))
+
+	// the lock records the concrete source
+	l, ok := locks.Get(r.src.Name())
+	require.True(t, ok)
+	require.NotNil(t, l.Source.GitSource)
+	assert.Equal(t, r.src.Remote(), l.Source.GitSource.Remote())
+	assert.Equal(t, sha, l.Version)
+
+	// installing again uses the lock
+	again, _, err := Ensure(context.TODO(), jsf, vendorDir, locks, WithRegistry(index))
+	require.NoError(t, err)
+	assert.Equal(t, locks.Keys(), again.Keys())
+
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks)
+	assert.Error(t, err)
+
+	unknown := v1.New()
+	unknown.Dependencies.Set("acme/unknown", deps.Dependency{Source: deps.Source{RegistrySource: &deps.Registry{Name: "acme/unknown"}}})
+	_, _, err = Ensure(context.TODO(), unknown, vendorDir, locks, WithRegistry(index))
+	assert.ErrorIs(t, err, UnknownPackage)
+}
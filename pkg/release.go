@@ -0,0 +1,246 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// ReleasePackage installs an asset of a GitHub release, a gzipped tarball
+// of the package. If all of its content is wrapped in a single directory,
+// that directory is the package.
+type ReleasePackage struct {
+	Source *deps.Release
+
+	// Token authenticates the requests to the GitHub API, required for
+	// private repositories. Optional.
+	Token string
+	// Client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Retry controls how failed requests are retried. Defaults to
+	// DefaultHTTPRetryPolicy.
+	Retry *HTTPRetryPolicy
+	// Limit caps the bandwidth of downloads. Defaults to unlimited.
+	Limit *RateLimiter
+	// StagingDir is where downloads are prepared before being moved into
+	// place. Defaults to the directory the package is installed to.
+	StagingDir string
+
+	// digest of the installed asset
+	digest string
+}
+
+func NewReleasePackage(source *deps.Release) Interface {
+	return &ReleasePackage{
+		Source: source,
+	}
+}
+
+// releaseAsset is the part of an asset the GitHub API returns that is of
+// interest
+type releaseAsset struct {
+	Name string `json:"name"`
+	// URL of the asset in the API, which serves private assets as well
+	URL    string `json:"url"`
+	Digest string `json:"digest"`
+}
+
+// Install downloads the asset of the release tagged version and extracts
+// it. The version stays the tag, the digest of the asset is checked against
+// the one of the source and the API, if any.
+func (p *ReleasePackage) Install(ctx context.Context, name, dir, version string) (string, error) {
+	asset, err := p.asset(ctx, version)
+	if err != nil {
+		return "", err
+	}
+
+	stagingDir := dir
+	if p.StagingDir != "" {
+		stagingDir = p.StagingDir
+		if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+			return "", pkgerrors.Wrap(err, "failed to create staging dir")
+		}
+	}
+	tmpDir, err := os.MkdirTemp(stagingDir, ".tmp-")
+	if err != nil {
+		return "", pkgerrors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive := filepath.Join(tmpDir, "asset")
+	digest, err := p.downloadAsset(ctx, asset.URL, archive)
+	if err != nil {
+		return "", err
+	}
+	for _, want := range []string{p.Source.Digest, asset.Digest} {
+		if want != "" && want != digest {
+			return "", fmt.Errorf("%w: asset %s of release %s of %s has digest %s, expected %s", IntegrityFailure, p.Source.Asset, version, p.Source.Repo, digest, want)
+		}
+	}
+
+	content := filepath.Join(tmpDir, "content")
+	if err := extractAsset(archive, content); err != nil {
+		return "", fmt.Errorf("failed to extract asset %s: %w", p.Source.Asset, err)
+	}
+
+	destPath := path.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return "", pkgerrors.Wrap(err, "failed to create parent path")
+	}
+	if err := os.RemoveAll(destPath); err != nil {
+		return "", pkgerrors.Wrap(err, "failed to clean previous destination path")
+	}
+	if err := moveDir(content, destPath); err != nil {
+		return "", pkgerrors.Wrap(err, "failed to move package")
+	}
+
+	p.digest = digest
+	return version, nil
+}
+
+// asset looks up the asset in the release tagged version
+func (p *ReleasePackage) asset(ctx context.Context, version string) (*releaseAsset, error) {
+	base := defaultTagAPIURLs[APIHostGitHub]
+	u := fmt.Sprintf("%s/repos/%s/releases/tags/%s", base, p.Source.Repo, url.PathEscape(version))
+	resp, err := httpGetWithHeader(ctx, p.Client, p.Retry, nil, u, p.header("application/vnd.github+json"))
+	var serr *HTTPStatusError
+	if errors.As(err, &serr) && serr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("release %s of %s not found", version, p.Source.Repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var release struct {
+		Assets []releaseAsset `json:"assets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to decode release %s of %s: %w", version, p.Source.Repo, err)
+	}
+	names := []string{}
+	for _, a := range release.Assets {
+		if a.Name == p.Source.Asset {
+			return &a, nil
+		}
+		names = append(names, a.Name)
+	}
+	return nil, fmt.Errorf("release %s of %s has no asset %s, only: %s", version, p.Source.Repo, p.Source.Asset, strings.Join(names, ", "))
+}
+
+// downloadAsset writes the asset to dst and returns its digest
+func (p *ReleasePackage) downloadAsset(ctx context.Context, u, dst string) (string, error) {
+	return downloadDigest(ctx, p.Client, p.Retry, p.Limit, u, p.header("application/octet-stream"), dst)
+}
+
+// downloadDigest writes the file at u to dst and returns its digest
+func downloadDigest(ctx context.Context, client *http.Client, policy *HTTPRetryPolicy, limit *RateLimiter, u string, header http.Header, dst string) (string, error) {
+	resp, err := httpGetWithHeader(ctx, client, policy, limit, u, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), f.Close()
+}
+
+func (p *ReleasePackage) header(accept string) http.Header {
+	h := http.Header{"Accept": {accept}}
+	if p.Token != "" {
+		h.Set("Authorization", "Bearer "+p.Token)
+	}
+	return h
+}
+
+// extractAsset extracts the gzipped tarball to dst, dropping the directory
+// all of the content is wrapped in, if any
+func extractAsset(archive, dst string) error {
+	wrapped, err := singleTopDir(archive)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	return gzipUntarStrip(dst, f, "", wrapped)
+}
+
+// singleTopDir returns whether all entries of the gzipped tarball are below
+// a single directory
+func singleTopDir(archive string) (bool, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	defer gzr.Close()
+
+	top := ""
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return top != "", nil
+		}
+		if err != nil {
+			return false, err
+		}
+		first, _, nested := strings.Cut(header.Name, "/")
+		if !nested && header.Typeflag != tar.TypeDir {
+			return false, nil
+		}
+		if top != "" && first != top {
+			return false, nil
+		}
+		top = first
+	}
+}
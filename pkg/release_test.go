@@ -0,0 +1,137 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// tarGz returns a gzipped tarball of the files
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+// serveRelease serves a GitHub API with the release v1.0.0 of test/bundle,
+// which has the asset bundle.tar.gz. The asset is whatever *asset holds.
+func serveRelease(t *testing.T, asset *[]byte) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Bearer s3cr3t", req.Header.Get("Authorization"))
+		switch req.URL.Path {
+		case "/repos/test/bundle/releases/tags/v1.0.0":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"assets": []map[string]string{
+					{"name": "checksums.txt", "url": srv.URL + "/assets/1"},
+					{"name": "bundle.tar.gz", "url": srv.URL + "/assets/2"},
+				},
+			})
+		case "/assets/2":
+			assert.Equal(t, "application/octet-stream", req.Header.Get("Accept"))
+			w.Write(*asset)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	defaults := defaultTagAPIURLs
+	defaultTagAPIURLs = map[string]string{APIHostGitHub: srv.URL}
+	t.Cleanup(func() { defaultTagAPIURLs = defaults })
+	return srv
+}
+
+func TestEnsureRelease(t *testing.T) {
+	asset := tarGz(t, map[string]string{"bundle-1.0.0/main.libsonnet": "{}", "bundle-1.0.0/lib/x.libsonnet": "{}"})
+	serveRelease(t, &asset)
+	sum := sha256.Sum256(asset)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	release := &deps.Release{Repo: "test/bundle", Asset: "bundle.tar.gz"}
+	jsf := v1.New()
+	jsf.Dependencies.Set(release.Name(), deps.Dependency{Source: deps.Source{ReleaseSource: release}, Version: "v1.0.0"})
+
+	vendorDir := t.TempDir()
+	token := WithAPIToken(APIHostGitHub, "s3cr3t")
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), token)
+	require.NoError(t, err)
+
+	l, ok := locks.Get("github.com/test/bundle")
+	require.True(t, ok)
+	assert.Equal(t, "v1.0.0", l.Version)
+	assert.Equal(t, digest, l.Source.ReleaseSource.Digest)
+	assert.NotEmpty(t, l.Sum)
+	assert.Empty(t, release.Digest, "the jsonnetfile must not be modified")
+	// the wrapping directory is dropped
+	assert.FileExists(t, filepath.Join(vendorDir, "github.com/test/bundle/lib/x.libsonnet"))
+
+	// once locked, a different asset under the same name fails
+	asset = tarGz(t, map[string]string{"main.libsonnet": "{ changed: true }"})
+	require.NoError(t, os.RemoveAll(filepath.Join(vendorDir, ".cache")))
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, token)
+	assert.ErrorIs(t, err, IntegrityFailure)
+
+	// but is taken without a lock, with nothing to unwrap
+	locks, _, err = Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), token)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(vendorDir, "github.com/test/bundle/main.libsonnet"))
+}
+
+func TestReleaseMissing(t *testing.T) {
+	asset := tarGz(t, map[string]string{"main.libsonnet": "{}"})
+	serveRelease(t, &asset)
+
+	tests := map[string]struct {
+		source  deps.Release
+		version string
+		err     string
+	}{
+		"release": {source: deps.Release{Repo: "test/bundle", Asset: "bundle.tar.gz"}, version: "v2.0.0", err: "release v2.0.0 of test/bundle not found"},
+		"asset":   {source: deps.Release{Repo: "test/bundle", Asset: "other.tar.gz"}, version: "v1.0.0", err: "release v1.0.0 of test/bundle has no asset other.tar.gz, only: checksums.txt, bundle.tar.gz"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &ReleasePackage{Source: &tc.source, Token: "s3cr3t"}
+			_, err := p.Install(context.TODO(), tc.source.Name(), t.TempDir(), tc.version)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+const (
+	refsTagsPrefix  = "refs/tags/"
+	refsHeadsPrefix = "refs/heads/"
+	peeledSuffix    = "^{}"
+
+	// branchFallbackSeparator separates a semver constraint from the branch
+	// to use if no tag matches it, e.g. ">=1.2.0 || develop"
+	branchFallbackSeparator = "||"
+)
+
+// RefPrecedence decides which ref a version names, if there is both a tag and
+// a branch of that name
+type RefPrecedence int
+
+const (
+	// RefTagsFirst picks the tag, like git itself does
+	RefTagsFirst RefPrecedence = iota
+	// RefBranchesFirst picks the branch
+	RefBranchesFirst
+)
+
+// gitRef is a single reference as advertised by a remote
+type gitRef struct {
+	name string
+	sha  string
+}
+
+// ResolveVersion resolves the given version of a git source to a commit SHA
+// by listing the remote references. No content is downloaded.
+// If the version was resolved using a tag, the name of the tag is returned as
+// well.
+//
+// Besides plain refs, the version may be a semver constraint like ">=1.2.0",
+// which resolves to the highest matching tag. A constraint may name a branch
+// to fall back to if no tag matches, e.g. ">=1.2.0 || develop".
+func ResolveVersion(ctx context.Context, source *deps.Git, versionOrConstraint string) (sha string, tag string, err error) {
+	sha, tag, _, err = resolveVersion(ctx, source, versionOrConstraint, source.ProtocolVersion, nil, RefTagsFirst)
+	return sha, tag, err
+}
+
+// resolveVersion is ResolveVersion talking to the remote using the given git
+// protocol version. Version specs are resolved using the tags listed by the
+// API first, if it supports the source. Names of both a tag and a branch are
+// resolved according to precedence, see selectVersion for matched.
+func resolveVersion(ctx context.Context, source *deps.Git, versionOrConstraint, protocol string, tags *tagAPI, precedence RefPrecedence) (sha, tag, matched string, err error) {
+	// a full commit sha needs no resolution
+	if commitShaPattern.MatchString(versionOrConstraint) {
+		return versionOrConstraint, "", "", nil
+	}
+
+	// only tags are listed, so the fallback branch of a spec needs git
+	if isSemverConstraint(versionOrConstraint) {
+		if refs, ok := tags.listTags(ctx, source); ok {
+			if sha, tag, _, err := selectVersion(refs, versionOrConstraint, source.PreReleases, precedence); err == nil && tag != "" {
+				return sha, tag, "", nil
+			}
+		}
+	}
+
+	refs, err := listRemoteRefs(ctx, source.Remote(), protocol)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	sha, tag, matched, err = selectVersion(refs, versionOrConstraint, source.PreReleases, precedence)
+	if err != nil && versionOrConstraint == "master" {
+		logger(ctx).Warn("ref 'master' resolved to empty string for %s, retrying with 'main'", source.Remote())
+		sha, tag, matched, err = selectVersion(refs, "main", source.PreReleases, precedence)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("unable to resolve version '%s' of %s: %w", versionOrConstraint, source.Remote(), err)
+	}
+	warnAmbiguousRef(ctx, versionOrConstraint, matched)
+	return sha, tag, matched, nil
+}
+
+// listRemoteRefs lists all references of the remote using git ls-remote
+func listRemoteRefs(ctx context.Context, remote, protocol string) ([]gitRef, error) {
+	b := &bytes.Buffer{}
+	args := append(gitProtocolArgs(protocol), "ls-remote", "--quiet", remote)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = b
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return parseRemoteRefs(b.String()), nil
+}
+
+// gitProtocolArgs returns the arguments forcing git to use the protocol
+// version, if any
+func gitProtocolArgs(protocol string) []string {
+	if protocol == "" {
+		return nil
+	}
+	return []string{"-c", "protocol.version=" + protocol}
+}
+
+// validGitProtocol returns whether git knows the protocol version. Empty lets
+// git decide.
+func validGitProtocol(protocol string) bool {
+	switch protocol {
+	case "", "0", "1", "2":
+		return true
+	}
+	return false
+}
+
+// parseRemoteRefs parses the output of git ls-remote
+func parseRemoteRefs(out string) []gitRef {
+	refs := []gitRef{}
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		refs = append(refs, gitRef{sha: fields[0], name: fields[1]})
+	}
+	return refs
+}
+
+// selectRef picks the commit the version refers to. Refs are looked up in the
+// same order git itself uses: the exact name, tags and finally branches.
+// Annotated tags are peeled to the commit they point to.
+func selectRef(refs []gitRef, version string) (sha string, tag string, ok bool) {
+	if version == "" {
+		version = "HEAD"
+	}
+
+	byName := make(map[string]string, len(refs))
+	for _, r := range refs {
+		byName[r.name] = r.sha
+	}
+	lookup := func(name string) (string, bool) {
+		if sha, ok := byName[name+peeledSuffix]; ok {
+			return sha, true
+		}
+		sha, ok := byName[name]
+		return sha, ok
+	}
+
+	if sha, ok := lookup(version); ok {
+		if strings.HasPrefix(version, refsTagsPrefix) {
+			tag = strings.TrimPrefix(version, refsTagsPrefix)
+		}
+		return sha, tag, true
+	}
+	if sha, ok := lookup(refsTagsPrefix + version); ok {
+		return sha, version, true
+	}
+	if sha, ok := lookup(refsHeadsPrefix + version); ok {
+		return sha, "", true
+	}
+	return "", "", false
+}
+
+// selectNamedRef is selectRef for names that may be both a tag and a branch.
+// Those are picked according to precedence, and matched is the provenance to
+// lock for the pick, like "branch:v1.0". See warnAmbiguousRef.
+func selectNamedRef(refs []gitRef, version string, precedence RefPrecedence) (sha, tag, matched string, ok bool) {
+	tagSha, _, isTag := selectRef(refs, refsTagsPrefix+version)
+	branchSha, _, isBranch := selectRef(refs, refsHeadsPrefix+version)
+	if version == "" || strings.HasPrefix(version, "refs/") || !isTag || !isBranch {
+		sha, tag, ok = selectRef(refs, version)
+		return sha, tag, "", ok
+	}
+
+	if precedence == RefBranchesFirst {
+		return branchSha, "", "branch:" + version, true
+	}
+	return tagSha, version, "tag:" + version, true
+}
+
+// warnAmbiguousRef warns about the pick selectNamedRef made, if the version
+// named both a tag and a branch
+func warnAmbiguousRef(ctx context.Context, version, matched string) {
+	switch {
+	case strings.HasPrefix(matched, "branch:"):
+		logger(ctx).Warn("'%s' is both a tag and a branch, using the branch", version)
+	case strings.HasPrefix(matched, "tag:"):
+		logger(ctx).Warn("'%s' is both a tag and a branch, using the tag", version)
+	}
+}
+
+// versionSpec is a semver constraint with an optional fallback branch
+type versionSpec struct {
+	constraint *semverConstraint
+	branch     string
+}
+
+// parseVersionSpec parses a version of the form "<constraint> || <branch>".
+// It returns nil if the version is a plain ref instead.
+func parseVersionSpec(version string) (*versionSpec, error) {
+	if !isSemverConstraint(version) {
+		return nil, nil
+	}
+
+	spec := &versionSpec{}
+	constraint := version
+	if i := strings.LastIndex(version, branchFallbackSeparator); i >= 0 {
+		constraint = version[:i]
+		spec.branch = strings.TrimSpace(version[i+len(branchFallbackSeparator):])
+		if spec.branch == "" {
+			return nil, fmt.Errorf("missing fallback branch in '%s'", version)
+		}
+	}
+
+	c, err := parseSemverConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	spec.constraint = c
+	return spec, nil
+}
+
+// selectVersion picks the commit the version refers to, which is either a
+// plain ref or a version spec. Version specs only resolve to pre-release tags
+// if preReleases is set. Plain refs naming both a tag and a branch are picked
+// according to precedence, and matched is the provenance recording the pick.
+func selectVersion(refs []gitRef, version string, preReleases bool, precedence RefPrecedence) (sha, tag, matched string, err error) {
+	spec, err := parseVersionSpec(version)
+	if err != nil {
+		return "", "", "", err
+	}
+	if spec == nil {
+		sha, tag, matched, ok := selectNamedRef(refs, version, precedence)
+		if !ok {
+			return "", "", "", fmt.Errorf("no such ref")
+		}
+		return sha, tag, matched, nil
+	}
+
+	if tag, ok := highestTag(refs, spec.constraint, preReleases); ok {
+		sha, _, _ := selectRef(refs, refsTagsPrefix+tag)
+		return sha, tag, "", nil
+	}
+	if spec.branch == "" {
+		return "", "", "", fmt.Errorf("no tag matches")
+	}
+	sha, _, ok := selectRef(refs, refsHeadsPrefix+spec.branch)
+	if !ok {
+		return "", "", "", fmt.Errorf("no tag matches and branch '%s' does not exist", spec.branch)
+	}
+	return sha, "", "", nil
+}
+
+// highestTag returns the highest semver tag matching the constraint
+func highestTag(refs []gitRef, c *semverConstraint, preReleases bool) (string, bool) {
+	var (
+		best    string
+		bestVer semver
+	)
+	for _, r := range refs {
+		if !strings.HasPrefix(r.name, refsTagsPrefix) {
+			continue
+		}
+		tag := strings.TrimSuffix(strings.TrimPrefix(r.name, refsTagsPrefix), peeledSuffix)
+		v, ok := parseSemver(tag)
+		if !ok || !c.matches(v, preReleases) {
+			continue
+		}
+		if best == "" || v.newer(bestVer) {
+			best, bestVer = tag, v
+		}
+	}
+	return best, best != ""
+}
+
+// lockSatisfies returns whether the lock of d still satisfies its version
+// spec, so a changed constraint like "^2" to "^3" resolves again instead of
+// sticking to the lock. Plain refs and locks without provenance always do.
+func lockSatisfies(d, lock deps.Dependency) bool {
+	spec, err := parseVersionSpec(d.Version)
+	if err != nil || spec == nil {
+		return true
+	}
+
+	switch {
+	case strings.HasPrefix(lock.Provenance, "tag:"):
+		v, ok := parseSemver(strings.TrimPrefix(lock.Provenance, "tag:"))
+		if !ok {
+			return false
+		}
+		preReleases := d.Source.GitSource != nil && d.Source.GitSource.PreReleases
+		return spec.constraint.matches(v, preReleases)
+	case strings.HasPrefix(lock.Provenance, "branch:"):
+		return spec.branch == strings.TrimPrefix(lock.Provenance, "branch:")
+	}
+	return true
+}
+
+// dropStaleLocks removes the locks of the direct dependencies that no longer
+// satisfy their version spec
+func dropStaleLocks(ctx context.Context, direct, locks *deps.Ordered) {
+	for _, k := range direct.Keys() {
+		d, _ := direct.Get(k)
+		lock, ok := locks.Get(d.Name())
+		if !ok || lockSatisfies(d, lock) {
+			continue
+		}
+		warn(ctx, WarningRelock, d.Name(), "locked %s (%s) does not satisfy '%s', resolving again", d.Name(), lock.Provenance, d.Version)
+		locks.Delete(d.Name())
+	}
+}
+
+// dropEditedLocks removes the locks of the direct dependencies whose version
+// was changed in the jsonnetfile since they were locked, so the new one is
+// resolved. Transitive dependencies stay pinned by the lock. Locks not
+// recording the requested version, which equalled the locked one or predates
+// Requested, are kept for the locked version and abbreviations of it only.
+func dropEditedLocks(ctx context.Context, direct, locks *deps.Ordered) {
+	for _, k := range direct.Keys() {
+		d, _ := direct.Get(k)
+		lock, ok := locks.Get(d.Name())
+		if !ok || d.Source.LocalSource != nil {
+			continue
+		}
+		// an HTTP archive is locked at its digest, as long as its URL is
+		if h := d.Source.HTTPSource; h != nil {
+			if l := lock.Source.HTTPSource; l != nil && l.URL == h.URL && (h.Digest == "" || h.Digest == lock.Version) && (d.Version == "" || d.Version == lock.Version) {
+				continue
+			}
+			warn(ctx, WarningRelock, d.Name(), "%s is locked for another archive than %s, downloading again", d.Name(), h.URL)
+			locks.Delete(d.Name())
+			continue
+		}
+		requested := lock.Requested
+		if requested == "" {
+			if d.Version == "" || abbreviates(d.Version, lock.Version) {
+				continue
+			}
+			requested = lock.Version
+		}
+		if requested == d.Version {
+			continue
+		}
+		warn(ctx, WarningRelock, d.Name(), "%s is locked for '%s', but '%s' is required now, resolving again", d.Name(), requested, d.Version)
+		locks.Delete(d.Name())
+	}
+}
+
+// abbreviates returns whether version is sha or a prefix of it git accepts
+// as an abbreviated commit
+func abbreviates(version, sha string) bool {
+	return version == sha || (abbrevShaPattern.MatchString(version) && commitShaPattern.MatchString(sha) && strings.HasPrefix(sha, version))
+}
+
+// provenance describes how the version spec was resolved for the lock. Plain
+// refs need no explanation.
+func provenance(version, tag string) string {
+	spec, err := parseVersionSpec(version)
+	if err != nil || spec == nil {
+		return ""
+	}
+	if tag != "" {
+		return "tag:" + tag
+	}
+	return "branch:" + spec.branch
+}
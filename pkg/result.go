@@ -0,0 +1,125 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// EnsureResult describes what EnsureWithResult changed. Package names are
+// sorted.
+type EnsureResult struct {
+	// Locks are the locks of all installed packages, nil on failure
+	Locks *deps.Ordered
+	// Warnings are the warnings printed, sorted by package
+	Warnings []Warning
+
+	// Added are the packages downloaded, because they weren't installed at
+	// their locked version yet
+	Added []string
+	// Reused are the packages that were installed already
+	Reused []string
+	// Removed are the packages locked before, but not anymore
+	Removed []string
+
+	// BytesWritten is the size of all packages downloaded
+	BytesWritten int64
+
+	// Diffs is how the files in vendor would change, only set by dry runs.
+	// See DiffVendor.
+	Diffs []PackageDiff
+}
+
+// Changed reports whether any package was added or removed
+func (r *EnsureResult) Changed() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0
+}
+
+// EnsureWithResult is Ensure, but tells which packages it downloaded, reused
+// and removed.
+func EnsureWithResult(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered, opts ...Option) (*EnsureResult, error) {
+	// ensure drops the locks it doesn't honor
+	var before []string
+	if oldLocks != nil {
+		before = oldLocks.Keys()
+	}
+
+	w := &warnings{}
+	dl := &downloads{sizes: make(map[packageRef]int64)}
+	diffs := []PackageDiff{}
+	ctx = withDiffs(withDownloads(withWarnings(ctx, w), dl), &diffs)
+	locks, err := ensure(ctx, direct, vendorDir, oldLocks, opts...)
+	r := &EnsureResult{Locks: locks, Warnings: w.sorted()}
+	if newOptions(opts).dryRun {
+		r.Diffs = diffs
+	}
+	if err != nil {
+		return r, err
+	}
+
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		size, ok := dl.sizes[packageRef{name: d.Name(), version: d.Version}]
+		if !ok {
+			r.Reused = append(r.Reused, k)
+			continue
+		}
+		r.Added = append(r.Added, k)
+		r.BytesWritten += size
+	}
+	for _, k := range before {
+		if _, ok := locks.Get(k); !ok {
+			r.Removed = append(r.Removed, k)
+		}
+	}
+	sort.Strings(r.Added)
+	sort.Strings(r.Reused)
+	sort.Strings(r.Removed)
+	return r, nil
+}
+
+// downloads collects the sizes of the packages downloaded concurrently. They
+// are taken right away, as the cache may be gone once Ensure is done.
+type downloads struct {
+	mu    sync.Mutex
+	sizes map[packageRef]int64
+}
+
+type downloadsKey struct{}
+
+// withDownloads returns ctx collecting the downloaded packages in dl
+func withDownloads(ctx context.Context, dl *downloads) context.Context {
+	return context.WithValue(ctx, downloadsKey{}, dl)
+}
+
+// recordDownload adds the package downloaded to dir to the ones collected by
+// ctx, if any
+func recordDownload(ctx context.Context, ref packageRef, dir string) {
+	dl, ok := ctx.Value(downloadsKey{}).(*downloads)
+	if !ok {
+		return
+	}
+	// the size is informational only, a package that can't be walked fails
+	// elsewhere
+	size, _ := dirSize(dir)
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.sizes[ref] = size
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureWithResult(t *testing.T) {
+	r := newTestRepo(t, "counted")
+	r.commit(map[string]string{"main.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+
+	// fresh install
+	result, err := EnsureWithResult(context.TODO(), jsf, vendorDir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{r.src.Name()}, result.Added)
+	assert.Empty(t, result.Reused)
+	assert.Empty(t, result.Removed)
+	assert.Positive(t, result.BytesWritten)
+	assert.True(t, result.Changed())
+
+	// nothing to do
+	result, err = EnsureWithResult(context.TODO(), jsf, vendorDir, result.Locks)
+	require.NoError(t, err)
+	assert.Empty(t, result.Added)
+	assert.Equal(t, []string{r.src.Name()}, result.Reused)
+	assert.Zero(t, result.BytesWritten)
+	assert.False(t, result.Changed())
+
+	// no longer required
+	result, err = EnsureWithResult(context.TODO(), v1.New(), vendorDir, result.Locks, WithPruneLock(true))
+	require.NoError(t, err)
+	assert.Empty(t, result.Reused)
+	assert.Equal(t, []string{r.src.Name()}, result.Removed)
+	assert.True(t, result.Changed())
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DownloadRetryPolicy controls how downloads failing for likely transient
+// reasons, like an unreachable host or a server error, are retried as a
+// whole. Failures like missing repositories or denied access are never
+// retried.
+type DownloadRetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one
+	Attempts int
+	// Delay before the first retry. It doubles with every further one, and
+	// up to as much again is added at random, so concurrent downloads of the
+	// same host don't retry all at once.
+	Delay time.Duration
+}
+
+// DefaultDownloadRetryPolicy makes three attempts
+var DefaultDownloadRetryPolicy = DownloadRetryPolicy{
+	Attempts: 3,
+	Delay:    time.Second,
+}
+
+// permanentGitErrors are what git reports about failures that won't go
+// away by retrying. They take precedence over transientGitErrors.
+var permanentGitErrors = []string{
+	"Authentication failed",
+	"could not read Username",
+	"Permission denied",
+	"Repository not found",
+	"repository not found",
+	"does not appear to be a git repository",
+	"couldn't find remote ref",
+}
+
+// transientGitErrors are what git reports about failures that usually go
+// away by retrying
+var transientGitErrors = []string{
+	"Could not resolve host",
+	"Temporary failure in name resolution",
+	"Connection timed out",
+	"Connection refused",
+	"Connection reset",
+	"Operation timed out",
+	"The requested URL returned error: 5",
+	"RPC failed; HTTP 5",
+	"unexpected disconnect",
+	"early EOF",
+}
+
+// transientError tells whether the download failing with err is worth
+// retrying, judging by err itself and what git reported on stderr. Failed
+// HTTP requests were retried by their HTTPRetryPolicy already, so they are
+// not retried once more as a whole.
+func transientError(ctx context.Context, err error, stderr string) bool {
+	var serr *HTTPStatusError
+	if ctx.Err() != nil || errors.As(err, &serr) {
+		return false
+	}
+	for _, s := range permanentGitErrors {
+		if strings.Contains(stderr, s) {
+			return false
+		}
+	}
+	for _, s := range transientGitErrors {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// install installs p, retrying likely transient failures according to policy.
+// Whatever a failed attempt left in dir is removed before the next one.
+func install(ctx context.Context, p Interface, name, dir, version string, policy DownloadRetryPolicy) (string, error) {
+	var stderr *bytes.Buffer
+	if gp, ok := p.(*GitPackage); ok {
+		stderr = new(bytes.Buffer)
+		gp.stderr = stderr
+	}
+
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		if stderr != nil {
+			stderr.Reset()
+		}
+		lockVersion, err := p.Install(ctx, name, dir, version)
+		reported := ""
+		if stderr != nil {
+			reported = stderr.String()
+		}
+		if err == nil || attempt >= policy.Attempts || !transientError(ctx, err, reported) {
+			return lockVersion, err
+		}
+
+		wait := delay
+		if delay > 0 {
+			wait += time.Duration(rand.Int63n(int64(delay)))
+		}
+		logger(ctx).Warn("installing %s failed, retrying in %s: %s", name, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(wait):
+		}
+		if err := emptyDir(dir); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		delay *= 2
+	}
+}
+
+// teeStderr additionally collects the stderr of cmd in buf, if any
+func teeStderr(cmd *exec.Cmd, buf *bytes.Buffer) {
+	switch {
+	case buf == nil:
+	case cmd.Stderr == nil:
+		cmd.Stderr = buf
+	default:
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, buf)
+	}
+}
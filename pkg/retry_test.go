@@ -0,0 +1,111 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// flakyPackage fails its first attempts with err, leaving a file behind
+type flakyPackage struct {
+	fails    int
+	err      error
+	attempts int
+}
+
+func (p *flakyPackage) Install(ctx context.Context, name, dir, version string) (string, error) {
+	p.attempts++
+	if p.attempts <= p.fails {
+		if err := os.WriteFile(filepath.Join(dir, "partial"), nil, 0644); err != nil {
+			return "", err
+		}
+		return "", p.err
+	}
+	return version, nil
+}
+
+func TestInstallRetry(t *testing.T) {
+	policy := DownloadRetryPolicy{Attempts: 3, Delay: time.Millisecond}
+	transient := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	dir := t.TempDir()
+	p := &flakyPackage{fails: 2, err: transient}
+	version, err := install(context.TODO(), p, "a", dir, "v1", policy)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", version)
+	assert.Equal(t, 3, p.attempts)
+	assert.NoFileExists(t, filepath.Join(dir, "partial"))
+
+	// the attempts are limited
+	p = &flakyPackage{fails: 3, err: transient}
+	_, err = install(context.TODO(), p, "a", t.TempDir(), "v1", policy)
+	assert.ErrorIs(t, err, transient)
+	assert.Equal(t, 3, p.attempts)
+
+	// permanent failures aren't retried
+	permanent := errors.New("exit status 128")
+	p = &flakyPackage{fails: 1, err: permanent}
+	_, err = install(context.TODO(), p, "a", t.TempDir(), "v1", policy)
+	assert.ErrorIs(t, err, permanent)
+	assert.Equal(t, 1, p.attempts)
+}
+
+func TestDownloadRetryPolicyRetries(t *testing.T) {
+	transient := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	retries := 0
+	d := deps.Dependency{Source: deps.Source{GitSource: &deps.Git{Retries: &retries}}}
+	o := newOptions([]Option{WithDownloadRetryPolicy(DownloadRetryPolicy{Attempts: 3, Delay: time.Millisecond})})
+
+	// no retries means a single attempt
+	p := &flakyPackage{fails: 1, err: transient}
+	_, err := install(context.TODO(), p, "a", t.TempDir(), "v1", o.downloadRetryPolicy(d))
+	assert.ErrorIs(t, err, transient)
+	assert.Equal(t, 1, p.attempts)
+
+	retries = 1
+	assert.Equal(t, 2, o.downloadRetryPolicy(d).Attempts)
+	assert.Equal(t, time.Millisecond, o.downloadRetryPolicy(d).Delay)
+	assert.Equal(t, 3, o.downloadRetryPolicy(deps.Dependency{Source: deps.Source{GitSource: &deps.Git{}}}).Attempts)
+}
+
+func TestTransientError(t *testing.T) {
+	exit := errors.New("exit status 128")
+	assert.True(t, transientError(context.TODO(), exit, "fatal: unable to access 'https://example.com/a.git/': Could not resolve host: example.com"))
+	assert.True(t, transientError(context.TODO(), exit, "error: RPC failed; HTTP 502 curl 22 The requested URL returned error: 502"))
+	assert.False(t, transientError(context.TODO(), exit, "remote: Repository not found.\nfatal: repository 'https://example.com/a.git/' not found"))
+	assert.False(t, transientError(context.TODO(), exit, "fatal: Authentication failed for 'https://example.com/a.git/'"))
+	assert.False(t, transientError(context.TODO(), exit, ""))
+
+	assert.True(t, transientError(context.TODO(), &net.DNSError{Err: "server misbehaving", Name: "example.com"}, ""))
+	// retried by the HTTP retry policy already
+	assert.False(t, transientError(context.TODO(), fmt.Errorf("download: %w", &HTTPStatusError{StatusCode: 503}), ""))
+
+	// nothing is retried once canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, transientError(ctx, exit, "Could not resolve host: example.com"))
+}
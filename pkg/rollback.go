@@ -0,0 +1,215 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorPolicy controls what happens to vendor if Ensure fails midway
+type ErrorPolicy int
+
+const (
+	// ErrorLeave leaves vendor as it is at the time of the failure
+	ErrorLeave ErrorPolicy = iota
+	// ErrorRollback restores vendor to the state before Ensure was called
+	ErrorRollback
+	// ErrorClean removes all packages downloaded by the failed run, together
+	// with the links to them
+	ErrorClean
+)
+
+// vendorTx records the changes Ensure makes to vendor, so they can be undone
+// according to the ErrorPolicy
+type vendorTx struct {
+	policy    ErrorPolicy
+	vendorDir string
+	// dir holds the snapshot of vendor and the previous cache entries
+	dir     string
+	journal *cacheJournal
+}
+
+// beginVendorTx prepares undoing the changes to vendor. For ErrorRollback,
+// everything but the cache is snapshotted right away. Cache entries are only
+// backed up once they are about to be replaced.
+func beginVendorTx(vendorDir string, p ErrorPolicy) (*vendorTx, error) {
+	tx := &vendorTx{policy: p, vendorDir: vendorDir}
+	if p == ErrorLeave {
+		return tx, nil
+	}
+
+	// the cache is skipped by the cleanup of vendor
+	cacheDir := filepath.Join(vendorDir, ".cache")
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(cacheDir, ".rollback-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create rollback dir")
+	}
+	tx.dir = dir
+	tx.journal = &cacheJournal{backupDir: filepath.Join(dir, "cache"), replaced: map[string]string{}}
+
+	if p == ErrorRollback {
+		if err := tx.snapshot(); err != nil {
+			os.RemoveAll(dir)
+			return nil, errors.Wrap(err, "failed to snapshot vendor")
+		}
+	}
+	return tx, nil
+}
+
+// snapshot copies everything in vendor except the cache
+func (tx *vendorTx) snapshot() error {
+	snap := filepath.Join(tx.dir, "vendor")
+	if err := os.MkdirAll(snap, os.ModePerm); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(tx.vendorDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".cache" {
+			continue
+		}
+		if err := copyDir(filepath.Join(tx.vendorDir, e.Name()), filepath.Join(snap, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit discards the recorded state after Ensure succeeded
+func (tx *vendorTx) commit() error {
+	if tx.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(tx.dir)
+}
+
+// abort undoes the changes according to the policy after Ensure failed
+func (tx *vendorTx) abort() error {
+	if tx.policy == ErrorLeave {
+		return nil
+	}
+	defer os.RemoveAll(tx.dir)
+
+	for _, cp := range tx.journal.created {
+		if err := os.RemoveAll(cp); err != nil {
+			return err
+		}
+	}
+	for cp, backup := range tx.journal.replaced {
+		if err := os.RemoveAll(cp); err != nil {
+			return err
+		}
+		if tx.policy == ErrorRollback {
+			if err := os.Rename(backup, cp); err != nil {
+				return err
+			}
+		}
+	}
+
+	if tx.policy == ErrorClean {
+		return removeDanglingSymlinks(tx.vendorDir)
+	}
+
+	// restore everything but the cache from the snapshot
+	entries, err := os.ReadDir(tx.vendorDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".cache" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(tx.vendorDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	snap := filepath.Join(tx.dir, "vendor")
+	entries, err = os.ReadDir(snap)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(snap, e.Name()), filepath.Join(tx.vendorDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDanglingSymlinks removes all symlinks in vendor pointing nowhere
+func removeDanglingSymlinks(vendorDir string) error {
+	return filepath.Walk(vendorDir, func(path string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(path, filepath.Join(vendorDir, ".cache")) {
+			return filepath.SkipDir
+		}
+		if i.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// cacheJournal records which cache entries were created or replaced. A nil
+// journal records nothing.
+type cacheJournal struct {
+	backupDir string
+
+	mu       sync.Mutex
+	created  []string
+	replaced map[string]string
+}
+
+// replace must be called before the cache entry at cp is (re)written. An
+// existing entry is moved to the backup dir.
+func (j *cacheJournal) replace(cp string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := os.Lstat(cp); os.IsNotExist(err) {
+		j.created = append(j.created, cp)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(j.backupDir, os.ModePerm); err != nil {
+		return err
+	}
+	backup := filepath.Join(j.backupDir, filepath.Base(cp))
+	if err := os.Rename(cp, backup); err != nil {
+		return err
+	}
+	j.replaced[cp] = backup
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version. Build metadata is kept, but has no
+// influence on precedence.
+type semver struct {
+	major, minor, patch int
+	pre                 string
+	build               string
+}
+
+// parseSemver parses versions like v1.2.3, 1.2.3-rc.1+build.5 or 1.2.
+// Missing minor and patch versions default to 0.
+func parseSemver(s string) (semver, bool) {
+	s = strings.TrimPrefix(s, "v")
+	var v semver
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s, v.build = s[:i], s[i+1:]
+		if !validBuild(v.build) {
+			return semver{}, false
+		}
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+		if v.pre == "" {
+			return semver{}, false
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	if v.build != "" {
+		s += "+" + v.build
+	}
+	return s
+}
+
+// validBuild returns whether build consists of dot separated, non-empty
+// identifiers of alphanumerics and hyphens
+func validBuild(build string) bool {
+	for _, id := range strings.Split(build, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or higher than o
+func (v semver) compare(o semver) int {
+	for _, c := range [][2]int{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// a pre-release is lower than the release itself
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+	return comparePre(v.pre, o.pre)
+}
+
+// newer returns whether v takes precedence over o. Versions that only
+// differ in build metadata have the same precedence, so they are ordered by
+// their build metadata instead, for the choice between them to be
+// deterministic. No build metadata is lower than any.
+func (v semver) newer(o semver) bool {
+	if c := v.compare(o); c != 0 {
+		return c > 0
+	}
+	switch {
+	case v.build == o.build, v.build == "":
+		return false
+	case o.build == "":
+		return true
+	}
+	return comparePre(v.build, o.build) > 0
+}
+
+// comparePre compares pre-release identifiers as specified by semver:
+// numeric identifiers numerically, everything else lexically
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// semverConstraint is a set of comparisons a version must all satisfy, e.g.
+// ">=1.2.0 <2.0.0". Supported operators are =, >, >=, <, <=, ^ and ~.
+// Like in npm, ^ keeps the leftmost non-zero version given: "^1.2.3" allows
+// any 1.x from 1.2.3, "^0.2.3" any 0.2.x and "^0.0.3" only 0.0.3.
+// Partial versions float: "^2" and "^0" allow any minor version of the
+// major, "^0.0" any patch version of 0.0 and "~1" any minor version of 1.
+type semverConstraint struct {
+	checks []func(semver) bool
+}
+
+// isSemverConstraint returns whether the version is meant as a constraint
+// instead of a plain git ref
+func isSemverConstraint(s string) bool {
+	return s != "" && strings.ContainsRune("=<>^~", rune(s[0]))
+}
+
+func parseSemverConstraint(s string) (*semverConstraint, error) {
+	c := &semverConstraint{}
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+
+	for _, f := range fields {
+		op := strings.TrimRight(f, "0123456789.-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		v, ok := parseSemver(f[len(op):])
+		if !ok {
+			return nil, fmt.Errorf("invalid version '%s' in constraint '%s'", f[len(op):], s)
+		}
+		// only the major version given, e.g. "^2", or no patch version, e.g.
+		// "^0.0"
+		core := strings.SplitN(strings.SplitN(f[len(op):], "+", 2)[0], "-", 2)[0]
+		majorOnly := !strings.Contains(core, ".")
+		withPatch := strings.Count(core, ".") >= 2
+
+		var check func(semver) bool
+		switch op {
+		case "", "=":
+			check = func(o semver) bool { return o.compare(v) == 0 }
+		case ">":
+			check = func(o semver) bool { return o.compare(v) > 0 }
+		case ">=":
+			check = func(o semver) bool { return o.compare(v) >= 0 }
+		case "<":
+			check = func(o semver) bool { return o.compare(v) < 0 }
+		case "<=":
+			check = func(o semver) bool { return o.compare(v) <= 0 }
+		case "^":
+			// same major version, or minor version for 0.x releases, or
+			// patch version for 0.0.x releases
+			check = func(o semver) bool {
+				switch {
+				case o.compare(v) < 0 || o.major != v.major:
+					return false
+				case v.major != 0 || majorOnly:
+					return true
+				case o.minor != v.minor:
+					return false
+				}
+				return v.minor != 0 || !withPatch || o.patch == v.patch
+			}
+		case "~":
+			// same minor version
+			check = func(o semver) bool {
+				return o.compare(v) >= 0 && o.major == v.major && (majorOnly || o.minor == v.minor)
+			}
+		default:
+			return nil, fmt.Errorf("unknown operator '%s' in constraint '%s'", op, s)
+		}
+		c.checks = append(c.checks, check)
+	}
+	return c, nil
+}
+
+// matches returns whether v satisfies the constraint. Pre-releases only
+// match if explicitly allowed, so unstable versions are not picked up by
+// accident.
+func (c *semverConstraint) matches(v semver, preReleases bool) bool {
+	if v.pre != "" && !preReleases {
+		return false
+	}
+	for _, check := range c.checks {
+		if !check(v) {
+			return false
+		}
+	}
+	return true
+}
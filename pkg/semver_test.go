@@ -0,0 +1,109 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"0.9.0", "v1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2", "v1.10.0", "2"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, ok := parseSemver(ordered[i])
+		require.True(t, ok, ordered[i])
+		b, ok := parseSemver(ordered[i+1])
+		require.True(t, ok, ordered[i+1])
+		assert.Equal(t, -1, a.compare(b), "%s < %s", a, b)
+		assert.Equal(t, 1, b.compare(a), "%s > %s", b, a)
+		assert.Equal(t, 0, a.compare(a))
+	}
+
+	for _, invalid := range []string{"", "master", "1.2.3.4", "v1.x", "1.0.0-", "1.0.0+", "1.0.0+a..b", "1.0.0+a_b"} {
+		_, ok := parseSemver(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestSemverBuildMetadata(t *testing.T) {
+	v, ok := parseSemver("v1.2.3-rc.1+build.45")
+	require.True(t, ok)
+	assert.Equal(t, "1.2.3-rc.1+build.45", v.String())
+
+	// build metadata is ignored in precedence ...
+	ordered := []string{"1.2.3-rc.1+build.99", "1.2.3+build.1", "1.2.4+0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		assert.Equal(t, -1, a.compare(b), "%s < %s", a, b)
+	}
+	a, _ := parseSemver("1.2.3+build.9")
+	b, _ := parseSemver("1.2.3+build.10")
+	assert.Equal(t, 0, a.compare(b))
+
+	// ... but breaks ties between otherwise equal versions
+	assert.True(t, b.newer(a))
+	assert.False(t, a.newer(b))
+	plain, _ := parseSemver("1.2.3")
+	assert.True(t, a.newer(plain))
+	assert.False(t, plain.newer(a))
+	assert.False(t, a.newer(a))
+
+	c, err := parseSemverConstraint("^1.2.3+build.5")
+	require.NoError(t, err)
+	assert.True(t, c.matches(a, false))
+}
+
+func TestSemverConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{constraint: ">=1.2.0", match: []string{"1.2.0", "v1.3.0", "2.0.0"}, noMatch: []string{"1.1.9", "1.3.0-rc.1"}},
+		{constraint: ">1.2.0 <2", match: []string{"1.2.1", "1.9.9"}, noMatch: []string{"1.2.0", "2.0.0"}},
+		{constraint: ">=1.0.0, <=1.1.0", match: []string{"1.0.0", "1.1.0"}, noMatch: []string{"1.1.1"}},
+		{constraint: "^2", match: []string{"2.0.0", "2.9.1"}, noMatch: []string{"1.9.0", "3.0.0"}},
+		{constraint: "^0.2.1", match: []string{"0.2.1", "0.2.9"}, noMatch: []string{"0.3.0", "0.2.0"}},
+		{constraint: "^0", match: []string{"0.0.1", "0.9.0"}, noMatch: []string{"1.0.0"}},
+		{constraint: "^0.0.3", match: []string{"0.0.3"}, noMatch: []string{"0.0.4", "0.0.9", "0.1.0", "0.0.2"}},
+		{constraint: "^0.0", match: []string{"0.0.0", "0.0.9"}, noMatch: []string{"0.1.0"}},
+		{constraint: "~1.2.0", match: []string{"1.2.0", "1.2.5"}, noMatch: []string{"1.3.0"}},
+		{constraint: "~1", match: []string{"1.0.0", "1.9.0"}, noMatch: []string{"2.0.0", "0.9.0"}},
+		{constraint: "=v1.0.0", match: []string{"1.0.0"}, noMatch: []string{"1.0.1"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.constraint, func(t *testing.T) {
+			c, err := parseSemverConstraint(tc.constraint)
+			require.NoError(t, err)
+			for _, s := range tc.match {
+				v, _ := parseSemver(s)
+				assert.True(t, c.matches(v, false), s)
+			}
+			for _, s := range tc.noMatch {
+				v, _ := parseSemver(s)
+				assert.False(t, c.matches(v, false), s)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"", ">=", ">=master", "!1.0.0"} {
+		_, err := parseSemverConstraint(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// SnapshotFile is the name of the vendor snapshot, relative to the vendor
+// directory. Like the ManifestFile, it is neither part of a package checksum
+// nor removed during cleanup.
+const SnapshotFile = "jsonnetfile.snapshot.json"
+
+// InvalidSnapshot is returned if the snapshot itself was modified
+var InvalidSnapshot = errors.New("vendor snapshot does not match its sum")
+
+// snapshot records every vendored file, so modifications can be detected by
+// comparing the file metadata first and only hashing the files whose
+// metadata changed
+type snapshot struct {
+	// Packages are the directories of the packages, relative to vendor
+	Packages []string        `json:"packages"`
+	Files    []snapshotEntry `json:"files"`
+	// Sum covers Packages and Files, so the snapshot can't be edited along
+	// with the vendored files unnoticed
+	Sum string `json:"sum"`
+}
+
+type snapshotEntry struct {
+	// Path relative to the vendor directory, using forward slashes
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Sum     string `json:"sum"`
+}
+
+func (s *snapshot) sum() (string, error) {
+	data, err := json.Marshal(struct {
+		Packages []string
+		Files    []snapshotEntry
+	}{s.Packages, s.Files})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(h[:]), nil
+}
+
+// writeSnapshot records all files of the locked git packages in vendor.
+// Local packages are meant to be edited, so they are left out.
+func writeSnapshot(vendorDir, prefix string, locks *deps.Ordered) error {
+	s := &snapshot{Packages: []string{}, Files: []snapshotEntry{}}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.LocalSource != nil {
+			continue
+		}
+		pkgDir := filepath.ToSlash(filepath.Join(prefix, d.Name()))
+		s.Packages = append(s.Packages, pkgDir)
+
+		files, err := snapshotFiles(vendorDir, pkgDir)
+		if err != nil {
+			return err
+		}
+		for path, abs := range files {
+			info, err := os.Stat(abs)
+			if err != nil {
+				return err
+			}
+			sum, err := fileSum(abs)
+			if err != nil {
+				return err
+			}
+			s.Files = append(s.Files, snapshotEntry{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Sum: sum})
+		}
+	}
+	sort.Strings(s.Packages)
+	sort.Slice(s.Files, func(i, j int) bool {
+		return s.Files[i].Path < s.Files[j].Path
+	})
+
+	sum, err := s.sum()
+	if err != nil {
+		return err
+	}
+	s.Sum = sum
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(vendorDir, SnapshotFile), append(b, '\n'), 0644)
+}
+
+// CheckTamper compares vendor to the snapshot written by Ensure using
+// WithSnapshot and returns the paths of all files modified, removed or added
+// since, relative to vendor. Only files whose size or modification time
+// changed are hashed, which makes this much faster than checking the sum of
+// every package.
+func CheckTamper(vendorDir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(vendorDir, SnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	sum, err := s.sum()
+	if err != nil {
+		return nil, err
+	}
+	if sum != s.Sum {
+		return nil, InvalidSnapshot
+	}
+
+	current := map[string]string{}
+	for _, p := range s.Packages {
+		files, err := snapshotFiles(vendorDir, p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for path, abs := range files {
+			current[path] = abs
+		}
+	}
+
+	tampered := []string{}
+	for _, e := range s.Files {
+		abs, ok := current[e.Path]
+		if !ok {
+			tampered = append(tampered, e.Path)
+			continue
+		}
+		delete(current, e.Path)
+
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() != e.Size {
+			tampered = append(tampered, e.Path)
+			continue
+		}
+		if info.ModTime().UnixNano() == e.ModTime {
+			continue
+		}
+		sum, err := fileSum(abs)
+		if err != nil {
+			return nil, err
+		}
+		if sum != e.Sum {
+			tampered = append(tampered, e.Path)
+		}
+	}
+
+	// whatever is left was added
+	for path := range current {
+		tampered = append(tampered, path)
+	}
+	sort.Strings(tampered)
+	return tampered, nil
+}
+
+// snapshotFiles returns the files of the package at pkgDir, keyed by their
+// slash separated path relative to vendor
+func snapshotFiles(vendorDir, pkgDir string) (map[string]string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(vendorDir, filepath.FromSlash(pkgDir)))
+	if err != nil {
+		return nil, err
+	}
+	files, err := packageFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(files))
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			return nil, err
+		}
+		m[pkgDir+"/"+filepath.ToSlash(rel)] = f
+	}
+	return m, nil
+}
+
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTamper(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}", "lib/x.libsonnet": "{ x: 1 }"})
+	b := vendorPackage(t, vendorDir, testDep("b", "v1"), map[string]string{"b.libsonnet": "{}"})
+	require.NoError(t, writeSnapshot(vendorDir, "", orderedOf(a, b)))
+
+	tampered, err := CheckTamper(vendorDir)
+	require.NoError(t, err)
+	assert.Empty(t, tampered)
+
+	dir := filepath.Join(vendorDir, a.Name())
+	// same size, different content
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib/x.libsonnet"), []byte("{ x: 2 }"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "lib/x.libsonnet"), time.Now(), time.Now().Add(time.Hour)))
+	require.NoError(t, os.Remove(filepath.Join(dir, "a.libsonnet")))
+	require.NoError(t, os.WriteFile(filepath.Join(vendorDir, b.Name(), "extra.libsonnet"), []byte("{}"), 0644))
+
+	tampered, err = CheckTamper(vendorDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"example.com/test/a/a.libsonnet",
+		"example.com/test/a/lib/x.libsonnet",
+		"example.com/test/b/extra.libsonnet",
+	}, tampered)
+}
+
+func TestCheckTamperTouched(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	require.NoError(t, writeSnapshot(vendorDir, "", orderedOf(a)))
+
+	// a new mtime alone is no modification
+	p := filepath.Join(vendorDir, a.Name(), "a.libsonnet")
+	require.NoError(t, os.Chtimes(p, time.Now(), time.Now().Add(time.Hour)))
+
+	tampered, err := CheckTamper(vendorDir)
+	require.NoError(t, err)
+	assert.Empty(t, tampered)
+}
+
+func TestCheckTamperInvalidSnapshot(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	require.NoError(t, writeSnapshot(vendorDir, "", orderedOf(a)))
+
+	p := filepath.Join(vendorDir, SnapshotFile)
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(p, []byte(strings.Replace(string(data), `"size": 2`, `"size": 3`, 1)), 0644))
+
+	_, err = CheckTamper(vendorDir)
+	assert.ErrorIs(t, err, InvalidSnapshot)
+}
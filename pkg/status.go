@@ -0,0 +1,86 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// ModifiedFile is a vendored file modified after a point in time
+type ModifiedFile struct {
+	// Path is relative to vendor and slash separated
+	Path    string
+	Package string
+}
+
+// ModifiedSince lists the files of all locked packages in vendor modified
+// after since, usually the modification time of the lockfile. Nothing is
+// hashed, so it is fast enough for a pre-commit hook.
+//
+// This is a heuristic only: modification times can be preserved by tools,
+// reset by checkouts or be off due to clock skew. Confirm suspicious packages
+// using VerifyParallel, which compares them to their sums.
+// Local packages and packages missing from vendor are skipped. Only
+// WithVendorPrefix is relevant of the options.
+func ModifiedSince(vendorDir string, locks *deps.Ordered, since time.Time, opts ...Option) ([]ModifiedFile, error) {
+	o := newOptions(opts)
+	modified := []ModifiedFile{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.LocalSource != nil {
+			continue
+		}
+
+		dir, err := filepath.EvalSymlinks(filepath.Join(vendorDir, o.vendorPrefix, d.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files, err := packageFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			if err != nil {
+				return nil, err
+			}
+			if !fi.ModTime().After(since) {
+				continue
+			}
+			rel, err := filepath.Rel(dir, f)
+			if err != nil {
+				return nil, err
+			}
+			modified = append(modified, ModifiedFile{
+				Path:    filepath.ToSlash(filepath.Join(o.vendorPrefix, d.Name(), rel)),
+				Package: d.Name(),
+			})
+		}
+	}
+
+	sort.Slice(modified, func(i, j int) bool {
+		return modified[i].Path < modified[j].Path
+	})
+	return modified, nil
+}
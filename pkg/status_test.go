@@ -0,0 +1,59 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModifiedSince(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}", "lib/b.libsonnet": "{}"})
+	c := vendorPackage(t, vendorDir, testDep("c", "v1"), map[string]string{"c.libsonnet": "{}"})
+	locks := orderedOf(a, c, testDep("missing", "v1"))
+
+	// the lock is written after the packages were vendored
+	lockTime := time.Now()
+	for _, f := range []string{"a.libsonnet", "lib/b.libsonnet"} {
+		require.NoError(t, os.Chtimes(filepath.Join(vendorDir, a.Name(), f), lockTime.Add(-time.Minute), lockTime.Add(-time.Minute)))
+	}
+	require.NoError(t, os.Chtimes(filepath.Join(vendorDir, c.Name(), "c.libsonnet"), lockTime.Add(-time.Minute), lockTime.Add(-time.Minute)))
+
+	modified, err := ModifiedSince(vendorDir, locks, lockTime)
+	require.NoError(t, err)
+	assert.Empty(t, modified)
+
+	// a touch is suspicious, but only an edit fails verification
+	later := lockTime.Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(vendorDir, c.Name(), "c.libsonnet"), later, later))
+	require.NoError(t, os.WriteFile(filepath.Join(vendorDir, a.Name(), "lib", "b.libsonnet"), []byte("{ edited: true }"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join(vendorDir, a.Name(), "lib", "b.libsonnet"), later, later))
+
+	modified, err = ModifiedSince(vendorDir, locks, lockTime)
+	require.NoError(t, err)
+	assert.Equal(t, []ModifiedFile{
+		{Path: a.Name() + "/lib/b.libsonnet", Package: a.Name()},
+		{Path: c.Name() + "/c.libsonnet", Package: c.Name()},
+	}, modified)
+
+	assert.ErrorIs(t, VerifyParallel(vendorDir, orderedOf(a), 0, false), IntegrityFailure)
+	assert.NoError(t, VerifyParallel(vendorDir, orderedOf(c), 0, false))
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureCandidateSubdirs(t *testing.T) {
+	r := newTestRepo(t, "moved")
+	r.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+	r.git("tag", "v1")
+	r.git("rm", "-q", "main.libsonnet")
+	r.commit(map[string]string{"lib/main.libsonnet": "{ v: 2 }"})
+	r.git("tag", "v2")
+
+	src := *r.src
+	src.Subdirs = []string{"/lib", ""}
+
+	for _, tc := range []struct {
+		version, subdir, content string
+	}{
+		{version: "v1", subdir: "/", content: "{ v: 1 }"},
+		{version: "v2", subdir: "/lib", content: "{ v: 2 }"},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			jsf := v1.New()
+			jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: tc.version})
+			vendorDir := t.TempDir()
+
+			locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+			require.NoError(t, err)
+			b, err := os.ReadFile(filepath.Join(vendorDir, src.Name(), "main.libsonnet"))
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, string(b))
+			l, _ := locks.Get(src.Name())
+			assert.Equal(t, tc.subdir, l.InstalledSubdir)
+		})
+	}
+
+	missing := *r.src
+	missing.Subdirs = []string{"/jsonnet", "/src"}
+	jsf := v1.New()
+	jsf.Dependencies.Set(missing.Name(), deps.Dependency{Source: deps.Source{GitSource: &missing}, Version: "v2"})
+	_, _, err := Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered())
+	assert.ErrorIs(t, err, MissingSubdir)
+}
+
+func TestEnsureRootFiles(t *testing.T) {
+	r := newTestRepo(t, "monorepo")
+	r.commit(map[string]string{
+		"config.libsonnet":         "{ shared: true }",
+		"shared/util.libsonnet":    "{}",
+		"lib/main.libsonnet":       "(import '../config.libsonnet') + (import '../shared/util.libsonnet')",
+		"unrelated/main.libsonnet": "{}",
+	})
+
+	src := *r.src
+	src.Subdir = "/lib"
+	src.RootFiles = []string{"config.libsonnet", "shared"}
+	jsf := v1.New()
+	jsf.Dependencies.Set(src.Name(), deps.Dependency{Source: deps.Source{GitSource: &src}, Version: "master"})
+	vendorDir := t.TempDir()
+
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	// the relative imports of the package resolve within vendor
+	pkgDir := filepath.Join(vendorDir, src.Name())
+	b, err := os.ReadFile(filepath.Join(pkgDir, "..", "config.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ shared: true }", string(b))
+	assert.FileExists(t, filepath.Join(pkgDir, "..", "shared", "util.libsonnet"))
+	assert.NoFileExists(t, filepath.Join(pkgDir, "..", "unrelated", "main.libsonnet"))
+
+	// the root files are part of the sum
+	l, _ := locks.Get(src.Name())
+	cached, err := filepath.EvalSymlinks(pkgDir)
+	require.NoError(t, err)
+	withoutRoot := *r.src
+	withoutRoot.Subdir = "/lib"
+	sum, err := hashDir(context.TODO(), cached, newOptions(nil).packageHashConfig(deps.Dependency{Source: deps.Source{GitSource: &withoutRoot}}))
+	require.NoError(t, err)
+	assert.NotEqual(t, sum, l.Sum)
+
+	// they survive the cleanup of vendor and are checked like the package
+	require.NoError(t, os.WriteFile(filepath.Join(cached, "..", "config.libsonnet"), []byte("{}"), 0644))
+	logger := &recordingLogger{}
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithLogger(logger))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"checksum " + src.Name() + "@" + l.Version}, logger.messages)
+	b, err = os.ReadFile(filepath.Join(pkgDir, "..", "config.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ shared: true }", string(b))
+}
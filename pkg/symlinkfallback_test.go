@@ -0,0 +1,75 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureSymlinkFallback(t *testing.T) {
+	r := newTestRepo(t, "copied")
+	r.commit(map[string]string{"main.libsonnet": "{}", "lib/x.libsonnet": "{}"})
+
+	jsf := v1.New()
+	jsf.LegacyImports = false
+	jsf.Dependencies.Set(r.src.Name(), deps.Dependency{Source: deps.Source{GitSource: r.src}, Version: "master"})
+	vendorDir := t.TempDir()
+
+	// a filesystem without symlink support
+	unsupported := errors.New("operation not supported")
+	symlink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: unsupported}
+	}
+	t.Cleanup(func() { symlink = os.Symlink })
+
+	_, _, err := Ensure(context.TODO(), jsf, vendorDir, nil)
+	require.ErrorIs(t, err, unsupported)
+
+	locks, warnings, err := Ensure(context.TODO(), jsf, vendorDir, nil, WithSymlinkFallback(true))
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, WarningSymlinkFallback, warnings[0].Kind)
+	assert.Equal(t, r.src.Name(), warnings[0].Package)
+
+	// a real copy, which checks out just like the cache entry
+	dir := filepath.Join(vendorDir, r.src.Name())
+	fi, err := os.Lstat(dir)
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	l, ok := locks.Get(r.src.Name())
+	require.True(t, ok)
+	sum, err := hashDir(context.TODO(), dir, hashConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, l.Sum, sum)
+	assert.True(t, check(context.TODO(), l, vendorDir, newOptions(nil)))
+
+	// the copy is replaced by a link once symlinks work again
+	symlink = os.Symlink
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithSymlinkFallback(true))
+	require.NoError(t, err)
+	fi, err = os.Lstat(dir)
+	require.NoError(t, err)
+	assert.NotZero(t, fi.Mode()&os.ModeSymlink)
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// SymlinkEscapeError lists symlinks pointing outside of the vendor directory
+type SymlinkEscapeError struct {
+	// Links maps each offending symlink to its target
+	Links map[string]string
+}
+
+func (e *SymlinkEscapeError) Error() string {
+	links := []string{}
+	for link, target := range e.Links {
+		links = append(links, fmt.Sprintf("%s -> %s", link, target))
+	}
+	sort.Strings(links)
+	return fmt.Sprintf("symlinks pointing outside of vendor: %s", strings.Join(links, ", "))
+}
+
+// checkSymlinks verifies that all symlinks below vendorDir point to a location
+// inside of it. Only the links of local packages may point elsewhere.
+func checkSymlinks(vendorDir, prefix string, locks *deps.Ordered) error {
+	root, err := filepath.EvalSymlinks(vendorDir)
+	if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	locals := map[string]struct{}{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Source.LocalSource != nil {
+			locals[filepath.Join(vendorDir, prefix, d.Name())] = struct{}{}
+		}
+	}
+
+	escaping := map[string]string{}
+	err = filepath.Walk(vendorDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		if _, ok := locals[path]; ok {
+			return nil
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		// follow the chain of links if possible, dangling links are checked
+		// lexically
+		resolved, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			resolved = filepath.Clean(target)
+		}
+		resolved, err = filepath.Abs(resolved)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			escaping[path] = resolved
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(escaping) > 0 {
+		return &SymlinkEscapeError{Links: escaping}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestCheckSymlinks(t *testing.T) {
+	vendorDir := t.TempDir()
+	a := vendorPackage(t, vendorDir, testDep("a", "v1"), map[string]string{"a.libsonnet": "{}"})
+	require.NoError(t, os.Symlink(a.Name(), filepath.Join(vendorDir, "a")))
+
+	local := deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "../local"}}}
+	require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(vendorDir, local.Name())))
+
+	locks := orderedOf(a, local)
+	require.NoError(t, checkSymlinks(vendorDir, "", locks))
+
+	// a dependency shipping a link to outside of vendor
+	pkgDir := filepath.Join(cachePath(vendorDir, a), a.Name())
+	escaping := filepath.Join(pkgDir, "passwd")
+	require.NoError(t, os.Symlink("../../../../../../../etc/passwd", escaping))
+
+	err := checkSymlinks(vendorDir, "", locks)
+	var serr *SymlinkEscapeError
+	require.ErrorAs(t, err, &serr)
+	assert.Len(t, serr.Links, 1)
+	assert.Contains(t, serr.Links, escaping)
+}
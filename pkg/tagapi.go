@@ -0,0 +1,143 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+const (
+	// APIHostGitHub is the host of sources whose tags can be listed using
+	// the GitHub REST API
+	APIHostGitHub = "github.com"
+	// APIHostGitLab is the host of sources whose tags can be listed using
+	// the GitLab REST API
+	APIHostGitLab = "gitlab.com"
+)
+
+// tagAPIPageSize is the number of tags requested per page, the maximum of
+// both APIs
+const tagAPIPageSize = 100
+
+// tagAPI lists the tags of sources hosted by GitHub or GitLab using their
+// REST APIs, which is faster than git ls-remote for repositories with many
+// refs. Hosts without a token are not supported.
+type tagAPI struct {
+	tokens map[string]string
+	client *http.Client
+	retry  *HTTPRetryPolicy
+	// baseURLs of the APIs by host
+	baseURLs map[string]string
+}
+
+var defaultTagAPIURLs = map[string]string{
+	APIHostGitHub: "https://api.github.com",
+	APIHostGitLab: "https://gitlab.com/api/v4",
+}
+
+// listTags returns the tags of the source as refs pointing to their commits.
+// If the API can't be used for the source, or fails, ok is false and the
+// caller must fall back to git.
+func (a *tagAPI) listTags(ctx context.Context, source *deps.Git) (refs []gitRef, ok bool) {
+	if a == nil {
+		return nil, false
+	}
+	token, found := a.tokens[source.Host]
+	if !found || token == "" {
+		return nil, false
+	}
+	base := a.baseURLs[source.Host]
+	if base == "" {
+		base = defaultTagAPIURLs[source.Host]
+	}
+	repo := source.User + "/" + strings.TrimSuffix(source.Repo, ".git")
+
+	var err error
+	switch source.Host {
+	case APIHostGitHub:
+		refs, err = a.listPages(ctx, func(page int) string {
+			return fmt.Sprintf("%s/repos/%s/tags?per_page=%d&page=%d", base, repo, tagAPIPageSize, page)
+		}, http.Header{"Authorization": {"Bearer " + token}}, func(dec *json.Decoder) ([]gitRef, error) {
+			var tags []struct {
+				Name   string `json:"name"`
+				Commit struct {
+					SHA string `json:"sha"`
+				} `json:"commit"`
+			}
+			if err := dec.Decode(&tags); err != nil {
+				return nil, err
+			}
+			refs := make([]gitRef, 0, len(tags))
+			for _, t := range tags {
+				refs = append(refs, gitRef{name: refsTagsPrefix + t.Name, sha: t.Commit.SHA})
+			}
+			return refs, nil
+		})
+	case APIHostGitLab:
+		refs, err = a.listPages(ctx, func(page int) string {
+			return fmt.Sprintf("%s/projects/%s/repository/tags?per_page=%d&page=%d", base, url.PathEscape(repo), tagAPIPageSize, page)
+		}, http.Header{"PRIVATE-TOKEN": {token}}, func(dec *json.Decoder) ([]gitRef, error) {
+			var tags []struct {
+				Name   string `json:"name"`
+				Commit struct {
+					ID string `json:"id"`
+				} `json:"commit"`
+			}
+			if err := dec.Decode(&tags); err != nil {
+				return nil, err
+			}
+			refs := make([]gitRef, 0, len(tags))
+			for _, t := range tags {
+				refs = append(refs, gitRef{name: refsTagsPrefix + t.Name, sha: t.Commit.ID})
+			}
+			return refs, nil
+		})
+	default:
+		return nil, false
+	}
+
+	if err != nil {
+		logger(ctx).Warn("failed to list the tags of %s using the API, falling back to git: %s", source.Remote(), err)
+		return nil, false
+	}
+	return refs, true
+}
+
+// listPages requests the pages until one is not full
+func (a *tagAPI) listPages(ctx context.Context, pageURL func(page int) string, header http.Header, decode func(*json.Decoder) ([]gitRef, error)) ([]gitRef, error) {
+	refs := []gitRef{}
+	for page := 1; ; page++ {
+		resp, err := httpGetWithHeader(ctx, a.client, a.retry, nil, pageURL(page), header)
+		if err != nil {
+			return nil, err
+		}
+		got, err := decode(json.NewDecoder(resp.Body))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, got...)
+		if len(got) < tagAPIPageSize {
+			return refs, nil
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// serveTags mocks the tag listings of the GitHub and GitLab APIs, serving n
+// tags v1.0.<i> of user/repo
+func serveTags(t *testing.T, n int) *httptest.Server {
+	page := func(req *http.Request) []int {
+		p, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(req.URL.Query().Get("per_page"))
+		ids := []int{}
+		for i := (p - 1) * size; i < n && i < p*size; i++ {
+			ids = append(ids, i)
+		}
+		return ids
+	}
+	sha := func(i int) string { return fmt.Sprintf("%040d", i) }
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tags := []map[string]interface{}{}
+		switch {
+		case req.URL.Path == "/github/repos/user/repo/tags" && req.Header.Get("Authorization") == "Bearer gh-token":
+			for _, i := range page(req) {
+				tags = append(tags, map[string]interface{}{"name": fmt.Sprintf("v1.0.%d", i), "commit": map[string]string{"sha": sha(i)}})
+			}
+		case req.URL.EscapedPath() == "/gitlab/projects/user%2Frepo/repository/tags" && req.Header.Get("PRIVATE-TOKEN") == "gl-token":
+			for _, i := range page(req) {
+				tags = append(tags, map[string]interface{}{"name": fmt.Sprintf("v1.0.%d", i), "commit": map[string]string{"id": sha(i)}})
+			}
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(tags))
+	}))
+}
+
+func testTagAPI(srv *httptest.Server) *tagAPI {
+	return &tagAPI{
+		tokens:   map[string]string{APIHostGitHub: "gh-token", APIHostGitLab: "gl-token"},
+		baseURLs: map[string]string{APIHostGitHub: srv.URL + "/github", APIHostGitLab: srv.URL + "/gitlab"},
+		retry:    &HTTPRetryPolicy{Attempts: 1},
+	}
+}
+
+func TestTagAPIListTags(t *testing.T) {
+	srv := serveTags(t, 150)
+	defer srv.Close()
+	api := testTagAPI(srv)
+
+	for _, host := range []string{APIHostGitHub, APIHostGitLab} {
+		t.Run(host, func(t *testing.T) {
+			refs, ok := api.listTags(context.TODO(), &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: host, User: "user", Repo: "repo"})
+			require.True(t, ok)
+			// spans two pages
+			require.Len(t, refs, 150)
+			assert.Equal(t, gitRef{name: "refs/tags/v1.0.149", sha: fmt.Sprintf("%040d", 149)}, refs[149])
+		})
+	}
+}
+
+func TestTagAPIFallback(t *testing.T) {
+	srv := serveTags(t, 1)
+	defer srv.Close()
+	api := testTagAPI(srv)
+
+	// unsupported host
+	_, ok := api.listTags(context.TODO(), &deps.Git{Host: "example.com", User: "user", Repo: "repo"})
+	assert.False(t, ok)
+
+	// no token
+	_, ok = (&tagAPI{}).listTags(context.TODO(), &deps.Git{Host: APIHostGitHub, User: "user", Repo: "repo"})
+	assert.False(t, ok)
+
+	// failing request
+	api.tokens[APIHostGitHub] = "wrong"
+	_, ok = api.listTags(context.TODO(), &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: APIHostGitHub, User: "user", Repo: "repo"})
+	assert.False(t, ok)
+
+	// no API at all
+	var none *tagAPI
+	_, ok = none.listTags(context.TODO(), &deps.Git{Host: APIHostGitHub, User: "user", Repo: "repo"})
+	assert.False(t, ok)
+}
+
+func TestResolveVersionTagAPI(t *testing.T) {
+	srv := serveTags(t, 3)
+	defer srv.Close()
+
+	// the remote doesn't exist, so this only works without git
+	source := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: APIHostGitHub, User: "user", Repo: "repo"}
+	sha, tag, _, err := resolveVersion(context.TODO(), source, "^1.0.0", "", testTagAPI(srv), RefTagsFirst)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.2", tag)
+	assert.Equal(t, fmt.Sprintf("%040d", 2), sha)
+}
+
+func TestOutdatedTagAPI(t *testing.T) {
+	srv := serveTags(t, 3)
+	defer srv.Close()
+
+	defaults := defaultTagAPIURLs
+	defaultTagAPIURLs = map[string]string{APIHostGitHub: srv.URL + "/github"}
+	defer func() { defaultTagAPIURLs = defaults }()
+
+	d := deps.Dependency{
+		Source:     deps.Source{GitSource: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: APIHostGitHub, User: "user", Repo: "repo"}},
+		Version:    fmt.Sprintf("%040d", 0),
+		Provenance: "tag:v1.0.0",
+	}
+	infos, err := Outdated(context.TODO(), orderedOf(d), WithAPIToken(APIHostGitHub, "gh-token"))
+	require.NoError(t, err)
+	assert.Equal(t, []OutdatedInfo{{Name: d.Name(), Current: "v1.0.0", Latest: "v1.0.2", Outdated: true}}, infos)
+}
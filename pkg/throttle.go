@@ -0,0 +1,90 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter caps the bandwidth of all readers sharing it. A nil
+// RateLimiter does not limit anything.
+type RateLimiter struct {
+	bytesPerSecond int64
+
+	mu sync.Mutex
+	// next is when the bandwidth handed out so far is used up
+	next time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond in total.
+// Zero or less means unlimited.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until n more bytes fit into the bandwidth
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// reader wraps rc, so reading from it is subject to the limit
+func (l *RateLimiter) reader(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return rc
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, limit: l}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx   context.Context
+	limit *RateLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// keep single reads from hogging the bandwidth for longer than a second
+	if int64(len(p)) > r.limit.bytesPerSecond {
+		p = p[:r.limit.bytesPerSecond]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.limit.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	download := func(limit *RateLimiter) {
+		resp, err := httpGet(context.TODO(), srv.Client(), nil, limit, srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(b))
+	}
+
+	start := time.Now()
+	download(NewRateLimiter(0))
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+
+	// two concurrent downloads of 1000 bytes share 5000 bytes/sec
+	limit := NewRateLimiter(5000)
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			download(limit)
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 380*time.Millisecond)
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := NewRateLimiter(1).reader(ctx, io.NopCloser(strings.NewReader("xx")))
+	_, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureResolutionTrace(t *testing.T) {
+	child := newTestRepo(t, "traced-child")
+	child.commit(map[string]string{"main.libsonnet": "{}"})
+	parent := newTestRepo(t, "traced-parent")
+	sha := parent.commit(map[string]string{jsonnetfile.File: jsonnetfileFor(child)})
+
+	jsf := v1.New()
+	jsf.Dependencies.Set(parent.src.Name(), deps.Dependency{Source: deps.Source{GitSource: parent.src}, Version: "master"})
+	vendorDir := t.TempDir()
+
+	trace := &bytes.Buffer{}
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithResolutionTrace(trace))
+	require.NoError(t, err)
+	assert.Contains(t, trace.String(), "TRACE cache miss "+parent.src.Name()+"@master\n")
+	assert.Contains(t, trace.String(), "TRACE resolve "+parent.src.Name()+"@master: "+sha)
+	assert.Contains(t, trace.String(), "TRACE require "+parent.src.Name()+"@"+sha+": "+child.src.Name()+"@master\n")
+
+	trace.Reset()
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithResolutionTrace(trace))
+	require.NoError(t, err)
+	assert.Contains(t, trace.String(), "TRACE lock "+parent.src.Name()+"@master: "+sha+"\n")
+	assert.Contains(t, trace.String(), "TRACE cache hit "+parent.src.Name()+"@"+sha+"\n")
+	assert.NotContains(t, trace.String(), "TRACE resolve")
+}
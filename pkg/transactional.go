@@ -0,0 +1,113 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// ReadOnlyVendor is returned by Ensure with WithReadOnly, if vendor would
+// have to change
+var ReadOnlyVendor = errors.New("vendor is read-only")
+
+// vendorMismatch returns why vendor is not exactly what Ensure would produce
+// from the lock, or "" if it is: all direct and nested dependencies are
+// locked, all locked packages match their sums and there is nothing else in
+// vendor. Nothing is written to disk.
+func vendorMismatch(direct v1.JsonnetFile, vendorDir string, locks *deps.Ordered, o *options) (string, error) {
+	active, _ := splitProfiles(direct.Dependencies, o.profiles)
+	if name := firstUnlocked(active, locks); name != "" {
+		return fmt.Sprintf("%s would be installed, it is not locked", name), nil
+	}
+	for _, k := range active.Keys() {
+		d, _ := active.Get(k)
+		if lock, _ := locks.Get(d.Name()); !lockSatisfies(d, lock) {
+			return fmt.Sprintf("%s would be resolved again, its lock does not satisfy '%s'", d.Name(), d.Version), nil
+		}
+	}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Single {
+			continue
+		}
+		f, err := jsonnetfile.Load(filepath.Join(vendorDir, o.vendorPrefix, d.Name(), jsonnetfile.File))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Sprintf("%s would be installed again, its jsonnetfile is unreadable: %s", d.Name(), err), nil
+		}
+		if f.Dependencies, err = resolveRegistry(f.Dependencies, o.registry); err != nil {
+			return "", err
+		}
+		excludeDependencies(context.TODO(), d.Name(), f.Dependencies, o.exclude)
+		if name := firstUnlocked(f.Dependencies, locks); name != "" {
+			return fmt.Sprintf("%s would be installed, it is required by %s but not locked", name, d.Name()), nil
+		}
+	}
+
+	if err := verifyParallel(vendorDir, locks, 0, true, o); err != nil {
+		return fmt.Sprintf("vendor would be reinstalled, it does not match the lock: %s", err), nil
+	}
+
+	unknown, err := unknownDirs(vendorDir, o.vendorPrefix, locks)
+	if err != nil {
+		return "", err
+	}
+	if len(unknown) > 0 {
+		return fmt.Sprintf("%s would be removed, it is not locked", unknown[0]), nil
+	}
+	return "", nil
+}
+
+// firstUnlocked returns the name of the first package of list that is not
+// part of locks, or "" if all are
+func firstUnlocked(list, locks *deps.Ordered) string {
+	for _, k := range list.Keys() {
+		d, _ := list.Get(k)
+		if _, ok := locks.Get(d.Name()); !ok {
+			return d.Name()
+		}
+	}
+	return ""
+}
+
+// clearVendor removes everything from vendor but the cache
+func clearVendor(vendorDir string) error {
+	entries, err := os.ReadDir(vendorDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".cache" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(vendorDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// TreeFile is the name of the resolved tree, relative to the vendor
+// directory. Like the ManifestFile, it is neither part of a package checksum
+// nor removed during cleanup.
+const TreeFile = "jsonnetfile.tree.json"
+
+// TreeVersion is the version of the Tree format
+const TreeVersion uint = 1
+
+// TreeMismatch is returned if the resolved tree differs from the expected one
+var TreeMismatch = errors.New("resolved tree does not match the expected one")
+
+// Tree is the resolved dependency graph. Unlike the flat lock, it records
+// which package requires which, so the exact tree can be reconstructed.
+type Tree struct {
+	Version uint `json:"version"`
+	// Roots are the names of the direct dependencies, in the order of the
+	// jsonnetfile
+	Roots []string `json:"roots"`
+	// Packages are all resolved packages, sorted by name
+	Packages []TreeNode `json:"packages"`
+}
+
+// TreeNode is a single resolved package
+type TreeNode struct {
+	Name string `json:"name"`
+	// Package is the lock of the package, including its source, version,
+	// sum and provenance
+	Package deps.Dependency `json:"package"`
+	// Children are the names of the direct dependencies of the package
+	Children []string `json:"children,omitempty"`
+}
+
+// buildTree walks the downloaded packages the same way linkDownloaded does,
+// recording the winning version of each package along with its children
+func buildTree(direct *deps.Ordered, downloaded map[packageRef]downloadedPackage, winners map[string]string) *Tree {
+	t := &Tree{Version: TreeVersion, Roots: []string{}, Packages: []TreeNode{}}
+	for _, k := range direct.Keys() {
+		d, _ := direct.Get(k)
+		t.Roots = append(t.Roots, d.Name())
+	}
+
+	seen := map[string]struct{}{}
+	var walk func(list *deps.Ordered)
+	walk = func(list *deps.Ordered) {
+		for _, k := range list.Keys() {
+			d, _ := list.Get(k)
+			if _, ok := seen[d.Name()]; ok {
+				continue
+			}
+			seen[d.Name()] = struct{}{}
+			if v, ok := winners[d.Name()]; ok {
+				d.Version = v
+			}
+
+			dl, ok := downloaded[packageRef{name: d.Name(), version: d.Version}]
+			if !ok {
+				continue
+			}
+			node := TreeNode{Name: d.Name(), Package: dl.lock}
+			if dl.jsf != nil {
+				for _, ck := range dl.jsf.Dependencies.Keys() {
+					c, _ := dl.jsf.Dependencies.Get(ck)
+					node.Children = append(node.Children, c.Name())
+				}
+			}
+			t.Packages = append(t.Packages, node)
+
+			if dl.jsf != nil {
+				walk(dl.jsf.Dependencies)
+			}
+		}
+	}
+	walk(direct)
+
+	sort.SliceStable(t.Packages, func(i, j int) bool {
+		return t.Packages[i].Name < t.Packages[j].Name
+	})
+	return t
+}
+
+// ReadTree reads a Tree written by Ensure
+func ReadTree(path string) (*Tree, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Tree
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	if t.Version != TreeVersion {
+		return nil, fmt.Errorf("unsupported tree version %d", t.Version)
+	}
+	return &t, nil
+}
+
+func writeTree(vendorDir string, t *Tree) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(vendorDir, TreeFile), append(data, '\n'), 0644)
+}
+
+// CompareTrees returns the differences in shape between two trees: roots,
+// packages, their versions and sums and their children
+func CompareTrees(expected, actual *Tree) []string {
+	diffs := []string{}
+	if !reflect.DeepEqual(expected.Roots, actual.Roots) {
+		diffs = append(diffs, fmt.Sprintf("roots: expected [%s], got [%s]", strings.Join(expected.Roots, ", "), strings.Join(actual.Roots, ", ")))
+	}
+
+	nodes := func(t *Tree) map[string]TreeNode {
+		m := make(map[string]TreeNode, len(t.Packages))
+		for _, n := range t.Packages {
+			m[n.Name] = n
+		}
+		return m
+	}
+	want, got := nodes(expected), nodes(actual)
+
+	for _, e := range expected.Packages {
+		a, ok := got[e.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: missing", e.Name))
+			continue
+		}
+		if e.Package.Version != a.Package.Version {
+			diffs = append(diffs, fmt.Sprintf("%s: expected version %s, got %s", e.Name, e.Package.Version, a.Package.Version))
+		}
+		if e.Package.Sum != a.Package.Sum {
+			diffs = append(diffs, fmt.Sprintf("%s: expected sum %s, got %s", e.Name, e.Package.Sum, a.Package.Sum))
+		}
+		if strings.Join(e.Children, ",") != strings.Join(a.Children, ",") {
+			diffs = append(diffs, fmt.Sprintf("%s: expected children [%s], got [%s]", e.Name, strings.Join(e.Children, ", "), strings.Join(a.Children, ", ")))
+		}
+	}
+	for _, a := range actual.Packages {
+		if _, ok := want[a.Name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected", a.Name))
+		}
+	}
+	return diffs
+}
+
+// checkTree fails with TreeMismatch if actual differs from expected
+func checkTree(expected, actual *Tree) error {
+	diffs := CompareTrees(expected, actual)
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n  %s", TreeMismatch, strings.Join(diffs, "\n  "))
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnsureTree(t *testing.T) {
+	child := newTestRepo(t, "child")
+	child.commit(map[string]string{"main.libsonnet": "{ v: 1 }"})
+	parent := newTestRepo(t, "parent")
+	parent.commit(map[string]string{"jsonnetfile.json": `{"version": 1, "dependencies": [
+		{"source": {"git": {"remote": "https://example.com/test/child.git"}}, "version": "master"}
+	]}`})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(parent.src.Name(), deps.Dependency{Source: deps.Source{GitSource: parent.src}, Version: "master"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered(), WithTree(true))
+	require.NoError(t, err)
+
+	tree, err := ReadTree(filepath.Join(vendorDir, TreeFile))
+	require.NoError(t, err)
+	assert.Equal(t, []string{parent.src.Name()}, tree.Roots)
+	require.Len(t, tree.Packages, 2)
+	assert.Equal(t, child.src.Name(), tree.Packages[0].Name)
+	assert.Empty(t, tree.Packages[0].Children)
+	assert.Equal(t, parent.src.Name(), tree.Packages[1].Name)
+	assert.Equal(t, []string{child.src.Name()}, tree.Packages[1].Children)
+	for _, n := range tree.Packages {
+		lock, _ := locks.Get(n.Name)
+		assert.Equal(t, lock.Version, n.Package.Version)
+		assert.Equal(t, lock.Sum, n.Package.Sum)
+	}
+
+	// the same resolution matches the tree it wrote
+	_, _, err = Ensure(context.TODO(), jsf, vendorDir, locks, WithExpectedTree(tree))
+	require.NoError(t, err)
+
+	child.commit(map[string]string{"main.libsonnet": "{ v: 2 }"})
+	_, _, err = Ensure(context.TODO(), jsf, t.TempDir(), deps.NewOrdered(), WithExpectedTree(tree))
+	assert.ErrorIs(t, err, TreeMismatch)
+	assert.ErrorContains(t, err, child.src.Name()+": expected version")
+	assert.NotContains(t, err.Error(), "children")
+}
+
+func TestCompareTrees(t *testing.T) {
+	a := &Tree{Version: TreeVersion, Roots: []string{"a"}, Packages: []TreeNode{
+		{Name: "a", Package: testDep("a", "v1"), Children: []string{"b"}},
+		{Name: "b", Package: testDep("b", "v1")},
+	}}
+	b := &Tree{Version: TreeVersion, Roots: []string{"a", "c"}, Packages: []TreeNode{
+		{Name: "a", Package: testDep("a", "v1")},
+		{Name: "c", Package: testDep("c", "v1")},
+	}}
+	assert.Empty(t, CompareTrees(a, a))
+	assert.Equal(t, []string{
+		"roots: expected [a], got [a, c]",
+		"a: expected children [b], got []",
+		"b: missing",
+		"c: unexpected",
+	}, CompareTrees(a, b))
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"strings"
+)
+
+// UnreadablePolicy sets what computing the checksum of a package does about
+// files that can't be read, like ones without read permission
+type UnreadablePolicy int
+
+const (
+	// UnreadableFail fails computing the checksum. This is the default.
+	UnreadableFail UnreadablePolicy = iota
+	// UnreadableSkip leaves the files out of the checksum with a warning
+	// listing them, so the checksum is partial
+	UnreadableSkip
+	// UnreadableChanged treats the package as changed, so it doesn't match
+	// its lock and is installed again
+	UnreadableChanged
+)
+
+// warnPartialSum warns about the files skipped while computing the checksum
+// of the package
+func warnPartialSum(ctx context.Context, pkgName string, skipped []string) {
+	if len(skipped) == 0 {
+		return
+	}
+	warn(ctx, WarningPartialSum, pkgName, "the checksum of %s is partial, skipped unreadable files: %s", pkgName, strings.Join(skipped, ", "))
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashUnreadable(t *testing.T) {
+	readable := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(readable, "main.libsonnet"), []byte("{}"), 0644))
+	want, err := hashDir(context.TODO(), readable, hashConfig{})
+	require.NoError(t, err)
+
+	// sockets can't be opened like files, not even by root
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.libsonnet"), []byte("{}"), 0644))
+	l, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if err != nil {
+		t.Skipf("unix sockets not supported: %s", err)
+	}
+	defer l.Close()
+
+	_, _, err = hashPackage(dir, hashConfig{unreadable: UnreadableFail})
+	assert.Error(t, err)
+
+	sum, skipped, err := hashPackage(dir, hashConfig{unreadable: UnreadableSkip})
+	require.NoError(t, err)
+	assert.Equal(t, want, sum)
+	assert.Equal(t, []string{"sock"}, skipped)
+
+	sum, skipped, err = hashPackage(dir, hashConfig{unreadable: UnreadableChanged})
+	require.NoError(t, err)
+	assert.NotEqual(t, want, sum)
+	assert.Empty(t, skipped)
+}
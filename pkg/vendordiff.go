@@ -0,0 +1,174 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// PackageDiff lists how the files of a package in vendor would change. Paths
+// are relative to the package.
+type PackageDiff struct {
+	Name string `json:"name"`
+	// OldVersion and NewVersion are the locked versions before and after,
+	// empty if the package is added or removed
+	OldVersion string `json:"oldVersion,omitempty"`
+	NewVersion string `json:"newVersion,omitempty"`
+
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+// DiffVendor returns how the packages in vendor would change if Ensure ran
+// with the same arguments. Ensure runs in a scratch directory seeded with a
+// copy of the cache, so vendor, the cache and locks are left untouched.
+// Packages without changed files are omitted, the rest is sorted by name.
+func DiffVendor(direct v1.JsonnetFile, vendorDir string, locks *deps.Ordered, opts ...Option) ([]PackageDiff, error) {
+	scratch, err := newScratchVendor(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	newLocks, _, err := Ensure(context.Background(), direct, scratch, copyOrdered(locks), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return diffVendors(vendorDir, scratch, newOptions(opts).vendorPrefix, locks, newLocks)
+}
+
+// newScratchVendor creates a temporary vendor directory seeded with a copy
+// of the cache of vendorDir, so Ensure can run in it without downloading
+// what is cached already. Removing it is up to the caller.
+func newScratchVendor(vendorDir string) (string, error) {
+	scratch, err := os.MkdirTemp("", "jb-diff-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create scratch dir: %w", err)
+	}
+
+	cacheDir := filepath.Join(vendorDir, ".cache")
+	if _, err := os.Stat(cacheDir); err == nil {
+		if err := copyDir(cacheDir, filepath.Join(scratch, ".cache")); err != nil {
+			os.RemoveAll(scratch)
+			return "", fmt.Errorf("failed to copy the cache: %w", err)
+		}
+	}
+	return scratch, nil
+}
+
+// diffVendors compares the packages of locks in vendorDir to the ones of
+// newLocks in scratch
+func diffVendors(vendorDir, scratch, prefix string, locks, newLocks *deps.Ordered) ([]PackageDiff, error) {
+	versions := map[string][2]string{}
+	for i, l := range []*deps.Ordered{locks, newLocks} {
+		for _, k := range l.Keys() {
+			d, _ := l.Get(k)
+			v := versions[d.Name()]
+			v[i] = d.Version
+			versions[d.Name()] = v
+		}
+	}
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	diffs := []PackageDiff{}
+	for _, name := range names {
+		before, err := packageFileSums(filepath.Join(vendorDir, prefix, name))
+		if err != nil {
+			return nil, err
+		}
+		after, err := packageFileSums(filepath.Join(scratch, prefix, name))
+		if err != nil {
+			return nil, err
+		}
+
+		diff := PackageDiff{Name: name, OldVersion: versions[name][0], NewVersion: versions[name][1]}
+		for path, sum := range after {
+			old, ok := before[path]
+			switch {
+			case !ok:
+				diff.Added = append(diff.Added, path)
+			case old != sum:
+				diff.Modified = append(diff.Modified, path)
+			}
+		}
+		for path := range before {
+			if _, ok := after[path]; !ok {
+				diff.Removed = append(diff.Removed, path)
+			}
+		}
+		if len(diff.Added)+len(diff.Removed)+len(diff.Modified) == 0 {
+			continue
+		}
+		sort.Strings(diff.Added)
+		sort.Strings(diff.Removed)
+		sort.Strings(diff.Modified)
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// packageFileSums returns the sums of all files of the package at dir by
+// their slash separated path relative to it. Symlinks are followed. A
+// missing package has no files.
+func packageFileSums(dir string) (map[string]string, error) {
+	sums := map[string]string{}
+	root, err := filepath.EvalSymlinks(dir)
+	if os.IsNotExist(err) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == PackageMetaFile {
+			return nil
+		}
+		sum, err := fileSum(path)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	return sums, err
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jsonnet-bundler/jsonnet-bundler/spec/v1"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestDiffVendor(t *testing.T) {
+	changed := newTestRepo(t, "changed")
+	v1sha := changed.commit(map[string]string{"main.libsonnet": "{}", "old.libsonnet": "{}", "same.libsonnet": "{}"})
+	changed.git("tag", "v1.0.0")
+	changed.git("rm", "-q", "old.libsonnet")
+	v2sha := changed.commit(map[string]string{"main.libsonnet": "{ v: 2 }", "new.libsonnet": "{}"})
+	changed.git("tag", "v2.0.0")
+	added := newTestRepo(t, "added")
+	added.commit(map[string]string{"main.libsonnet": "{}"})
+
+	vendorDir := t.TempDir()
+	jsf := v1.New()
+	jsf.Dependencies.Set(changed.src.Name(), deps.Dependency{Source: deps.Source{GitSource: changed.src}, Version: "^1.0.0"})
+	locks, _, err := Ensure(context.TODO(), jsf, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	diffs, err := DiffVendor(jsf, vendorDir, locks)
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	jsf.Dependencies.Set(changed.src.Name(), deps.Dependency{Source: deps.Source{GitSource: changed.src}, Version: "^2.0.0"})
+	jsf.Dependencies.Set(added.src.Name(), deps.Dependency{Source: deps.Source{GitSource: added.src}, Version: "master"})
+	diffs, err = DiffVendor(jsf, vendorDir, locks)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+	assert.Equal(t, PackageDiff{Name: added.src.Name(), NewVersion: added.git("rev-parse", "HEAD"), Added: []string{"main.libsonnet"}}, diffs[0])
+	assert.Equal(t, PackageDiff{
+		Name:       changed.src.Name(),
+		OldVersion: v1sha,
+		NewVersion: v2sha,
+		Added:      []string{"new.libsonnet"},
+		Removed:    []string{"old.libsonnet"},
+		Modified:   []string{"main.libsonnet"},
+	}, diffs[1])
+
+	// neither vendor nor the lock changed
+	l, _ := locks.Get(changed.src.Name())
+	assert.Equal(t, v1sha, l.Version)
+	_, ok := locks.Get(added.src.Name())
+	assert.False(t, ok)
+	assert.FileExists(t, filepath.Join(vendorDir, changed.src.Name(), "old.libsonnet"))
+	assert.NoDirExists(t, filepath.Join(vendorDir, added.src.Name()))
+	entries, err := os.ReadDir(filepath.Join(vendorDir, ".cache"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
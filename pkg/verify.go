@@ -0,0 +1,123 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// IntegrityFailure is returned if a vendored package does not match the sum
+// of its lock
+var IntegrityFailure = errors.New("integrity check failed")
+
+// VerifyParallel checks all locked packages in vendor against their sums,
+// hashing up to maxConcurrency packages at once. Zero or less uses one
+// worker per CPU.
+// With failFast, the first failure is returned as soon as it occurs and no
+// further packages are checked. Otherwise, all failures are returned joined
+// together, in the order of the lock.
+// Packages without a sum, like local ones, only need to exist.
+func VerifyParallel(vendorDir string, locks *deps.Ordered, maxConcurrency int, failFast bool, opts ...Option) error {
+	return verifyParallel(vendorDir, locks, maxConcurrency, failFast, newOptions(opts))
+}
+
+func verifyParallel(vendorDir string, locks *deps.Ordered, maxConcurrency int, failFast bool, o *options) error {
+	if maxConcurrency <= 0 {
+		maxConcurrency = runtime.NumCPU()
+	}
+	ctx := withLogger(context.Background(), o.logger)
+
+	keys := locks.Keys()
+	errs := make([]error, len(keys))
+	work := make(chan int)
+	done := make(chan struct{})
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+
+	for w := 0; w < maxConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				select {
+				case <-done:
+					// already failed, drain what is left
+					continue
+				default:
+				}
+				d, _ := locks.Get(keys[i])
+				errs[i] = verifyPackage(ctx, vendorDir, d, o)
+				if errs[i] != nil && failFast {
+					once.Do(func() { close(done) })
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range keys {
+		select {
+		case work <- i:
+		case <-done:
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if failFast {
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// verifyPackage checks a single locked package in vendor
+func verifyPackage(ctx context.Context, vendorDir string, d deps.Dependency, o *options) error {
+	dir := filepath.Join(vendorDir, o.vendorPrefix, d.Name())
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w for %s@%s: missing from vendor", IntegrityFailure, d.Name(), d.Version)
+		}
+		return err
+	}
+	if d.Source.LocalSource != nil || d.Sum == "" {
+		return nil
+	}
+
+	sum, err := hashDir(ctx, resolved, o.packageHashConfig(d))
+	if err != nil {
+		return err
+	}
+	if sum != d.Sum {
+		return fmt.Errorf("%w for %s@%s: expected %s, got %s", IntegrityFailure, d.Name(), d.Version, d.Sum, sum)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestVerifyParallel(t *testing.T) {
+	vendorDir := t.TempDir()
+	locks := deps.NewOrdered()
+	for i := 0; i < 20; i++ {
+		d := vendorPackage(t, vendorDir, testDep(fmt.Sprintf("pkg%d", i), "v1"), map[string]string{"main.libsonnet": fmt.Sprint(i)})
+		locks.Set(d.Name(), d)
+	}
+
+	for _, concurrency := range []int{0, 1, 4} {
+		require.NoError(t, VerifyParallel(vendorDir, locks, concurrency, true))
+		require.NoError(t, VerifyParallel(vendorDir, locks, concurrency, false))
+	}
+
+	broken, _ := locks.Get("example.com/test/pkg3")
+	broken.Sum = "invalid"
+	locks.Set(broken.Name(), broken)
+	missing := testDep("missing", "v1")
+	locks.Set(missing.Name(), missing)
+
+	err := VerifyParallel(vendorDir, locks, 4, false)
+	assert.ErrorIs(t, err, IntegrityFailure)
+	assert.Equal(t, "integrity check failed for example.com/test/pkg3@v1: expected invalid, got "+mustSum(t, vendorDir, broken)+"\n"+
+		"integrity check failed for example.com/test/missing@v1: missing from vendor", err.Error())
+
+	err = VerifyParallel(vendorDir, locks, 1, true)
+	assert.ErrorIs(t, err, IntegrityFailure)
+	assert.ErrorContains(t, err, "pkg3")
+	assert.NotContains(t, err.Error(), "missing")
+}
+
+func mustSum(t *testing.T, vendorDir string, d deps.Dependency) string {
+	t.Helper()
+	sum, err := hashDir(context.TODO(), cachePath(vendorDir, d)+"/"+d.Name(), hashConfig{})
+	require.NoError(t, err)
+	return sum
+}
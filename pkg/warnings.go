@@ -0,0 +1,120 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// WarningKind classifies the warnings of Ensure
+type WarningKind string
+
+const (
+	// WarningLegacyName is a legacy name that couldn't be linked, because it
+	// is taken
+	WarningLegacyName WarningKind = "legacyName"
+	// WarningMovingRef is a package following a branch whose content
+	// changed since it was locked
+	WarningMovingRef WarningKind = "movingRef"
+	// WarningChecksum is a package not matching its checksum, so it is
+	// downloaded again
+	WarningChecksum WarningKind = "checksum"
+	// WarningPartialSum is a checksum computed without unreadable files
+	WarningPartialSum WarningKind = "partialSum"
+	// WarningFallback is a package installed at a fallback version
+	WarningFallback WarningKind = "fallback"
+	// WarningDirty is a local package vendored with uncommitted changes
+	WarningDirty WarningKind = "dirty"
+	// WarningExcluded is a package required by another one, but excluded
+	WarningExcluded WarningKind = "excluded"
+	// WarningRelock is a locked package resolved again, because the
+	// jsonnetfile asks for another version
+	WarningRelock WarningKind = "relock"
+	// WarningUnrequired is a locked package that is no longer required
+	WarningUnrequired WarningKind = "unrequired"
+	// WarningUnmanaged is a directory not managed by jb kept in vendor
+	// instead of installing a package, see ExistingSkip
+	WarningUnmanaged WarningKind = "unmanaged"
+	// WarningLicense is a package without a recognized license, see
+	// UnlicensedWarn
+	WarningLicense WarningKind = "license"
+	// WarningStaging is a staging dir on another filesystem than vendor,
+	// so packages are copied instead of moved
+	WarningStaging WarningKind = "staging"
+	// WarningArchiveFallback is a package the archive of which couldn't be
+	// installed, so it is cloned instead
+	WarningArchiveFallback WarningKind = "archiveFallback"
+	// WarningCleanup is something left behind that couldn't be removed
+	WarningCleanup WarningKind = "cleanup"
+	// WarningSymlinkFallback is a package copied into vendor, because it
+	// couldn't be linked to the cache
+	WarningSymlinkFallback WarningKind = "symlinkFallback"
+)
+
+// Warning is something Ensure worked around, but the user may want to know
+// about. Ensure prints them as well.
+type Warning struct {
+	Kind WarningKind
+	// Package is the name of the affected package
+	Package string
+	Message string
+}
+
+// warnings collects the warnings of packages ensured concurrently
+type warnings struct {
+	mu   sync.Mutex
+	list []Warning
+}
+
+type warningsKey struct{}
+
+// withWarnings returns ctx collecting the warnings in w
+func withWarnings(ctx context.Context, w *warnings) context.Context {
+	return context.WithValue(ctx, warningsKey{}, w)
+}
+
+// recordWarning adds the warning to the ones collected by ctx, if any,
+// without printing it
+func recordWarning(ctx context.Context, kind WarningKind, pkgName, message string) {
+	w, ok := ctx.Value(warningsKey{}).(*warnings)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.list = append(w.list, Warning{Kind: kind, Package: pkgName, Message: message})
+}
+
+// warn prints the warning and adds it to the ones collected by ctx
+func warn(ctx context.Context, kind WarningKind, pkgName, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	logger(ctx).Warn("%s", message)
+	recordWarning(ctx, kind, pkgName, message)
+}
+
+// sorted returns the warnings ordered by package, so they don't depend on the
+// order of concurrent downloads
+func (w *warnings) sorted() []Warning {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := append([]Warning{}, w.list...)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Package < list[j].Package
+	})
+	return list
+}
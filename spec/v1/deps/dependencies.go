@@ -26,6 +26,47 @@ type Dependency struct {
 	Sum     string `json:"sum,omitempty"`
 	Single  bool   `json:"single,omitempty"`
 
+	// Provenance records how a version constraint was resolved, e.g.
+	// "tag:v1.2.0" or "branch:develop". Only used in the lock.
+	Provenance string `json:"provenance,omitempty"`
+
+	// Requested records the version the jsonnetfile asked for, if other
+	// than Version, e.g. the branch a commit was resolved from. Only used in
+	// the lock.
+	Requested string `json:"requested,omitempty"`
+
+	// InstalledSubdir records which of the candidate subdirs of the git
+	// source was installed as part of the provenance, like "/lib" or "/"
+	// for the repository root. Only used in the lock.
+	InstalledSubdir string `json:"installedSubdir,omitempty"`
+
+	// TrustedSum is a sum asserted by the author of the jsonnetfile. The
+	// package must match it, regardless of the sum in the lock. Never
+	// written to the lock.
+	TrustedSum string `json:"trustedSum,omitempty"`
+
+	// Dirty records that a local source tracked by git had uncommitted
+	// changes when it was vendored. Only used in the lock.
+	Dirty bool `json:"dirty,omitempty"`
+
+	// Materialize vendors a real copy of the package instead of a symlink
+	// into the cache, for tools that don't follow symlinks. Never written to
+	// the lock.
+	Materialize bool `json:"materialize,omitempty"`
+
+	// Profiles restricts the dependency to the given profiles, like "dev".
+	// It is only installed if one of them is active. Empty means always.
+	Profiles []string `json:"profiles,omitempty"`
+
+	// Fallbacks are the versions tried in order if Version can't be
+	// installed anymore, like a tag deleted upstream. Never written to the
+	// lock.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+
+	// Fallback records the fallback version the package was locked at,
+	// because Version could not be installed. Only used in the lock.
+	Fallback string `json:"fallback,omitempty"`
+
 	// older schema used to have `name`. We still need that data for
 	// `LegacyName`
 	LegacyNameCompat string `json:"name,omitempty"`
@@ -36,6 +77,10 @@ func Parse(dir, uri string) *Dependency {
 		return nil
 	}
 
+	if d := parseHTTP(uri); d != nil {
+		return d
+	}
+
 	if d := parseGit(uri); d != nil {
 		return d
 	}
@@ -61,8 +106,12 @@ func NewOrdered() *Ordered {
 }
 
 type Source struct {
-	GitSource   *Git   `json:"git,omitempty"`
-	LocalSource *Local `json:"local,omitempty"`
+	GitSource     *Git     `json:"git,omitempty"`
+	LocalSource   *Local   `json:"local,omitempty"`
+	ReleaseSource *Release `json:"release,omitempty"`
+	HTTPSource    *HTTP    `json:"http,omitempty"`
+	// RegistrySource is resolved to one of the others using a registry index
+	RegistrySource *Registry `json:"registry,omitempty"`
 }
 
 func (s Source) Name() string {
@@ -71,6 +120,12 @@ func (s Source) Name() string {
 		return s.GitSource.Name()
 	case s.LocalSource != nil:
 		return s.LegacyName()
+	case s.ReleaseSource != nil:
+		return s.ReleaseSource.Name()
+	case s.HTTPSource != nil:
+		return s.HTTPSource.Name()
+	case s.RegistrySource != nil:
+		return s.RegistrySource.Name
 	default:
 		return ""
 	}
@@ -86,6 +141,12 @@ func (s Source) LegacyName() string {
 			panic("unable to create absolute path from local source directory: " + err.Error())
 		}
 		return filepath.Base(p)
+	case s.ReleaseSource != nil:
+		return s.ReleaseSource.LegacyName()
+	case s.HTTPSource != nil:
+		return s.HTTPSource.LegacyName()
+	case s.RegistrySource != nil:
+		return s.RegistrySource.LegacyName()
 	default:
 		return ""
 	}
@@ -93,6 +154,10 @@ func (s Source) LegacyName() string {
 
 type Local struct {
 	Directory string `json:"directory"`
+
+	// Git records the git HEAD of the repository containing Directory in the
+	// lock, along with whether Directory has uncommitted changes
+	Git bool `json:"git,omitempty"`
 }
 
 func parseLocal(dir, p string) *Dependency {
@@ -53,6 +53,18 @@ func TestParseDependency(t *testing.T) {
 			path: "examplec/foo/bar",
 			want: nil,
 		},
+		{
+			name: "http",
+			path: "https://example.com/pkgs/foo-1.0.tar.gz",
+			want: &Dependency{
+				Source: Source{
+					HTTPSource: &HTTP{
+						URL: "https://example.com/pkgs/foo-1.0.tar.gz",
+					},
+				},
+				Version: "",
+			},
+		},
 		{
 			name: "local",
 			path: testFolder,
@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const (
@@ -26,6 +27,32 @@ const (
 	GitSchemeHTTPS = "https://"
 )
 
+const (
+	// GitBackendExec downloads using the git binary
+	GitBackendExec = "exec"
+	// GitBackendHTTP downloads over HTTPS without requiring the git binary.
+	// Only repositories on the HTTPBackendHosts are supported, as the
+	// archives are downloaded the way the host provides them.
+	GitBackendHTTP = "http"
+)
+
+// HTTPBackendHosts are the hosts GitBackendHTTP can download from
+var HTTPBackendHosts = []string{"github.com", "gitlab.com"}
+
+// HTTPBackendSupported returns whether GitBackendHTTP can download the
+// repository, which must be on one of the HTTPBackendHosts and use https
+func (gs *Git) HTTPBackendSupported() bool {
+	if gs.Scheme != GitSchemeHTTPS {
+		return false
+	}
+	for _, h := range HTTPBackendHosts {
+		if gs.Host == h {
+			return true
+		}
+	}
+	return false
+}
+
 // Git holds all required information for cloning a package from git
 type Git struct {
 	// Scheme (Protocol) used (https, git+ssh)
@@ -39,12 +66,73 @@ type Git struct {
 	Repo string
 	// Subdir (example.com/<user>/<repo>/<subdir>)
 	Subdir string
+
+	// Subdirs are candidates for the package, in order, for repositories
+	// that moved it across versions. The first one present in the installed
+	// version is used. Empty candidates refer to the repository root. Can't
+	// be used with Subdir.
+	Subdirs []string
+
+	// RootJsonnetfile reads the dependencies of a Subdir package from the
+	// jsonnetfile at the repository root instead of the one in Subdir
+	RootJsonnetfile bool
+
+	// TagKeyring is the path to a keyring of trusted public GPG keys. If set,
+	// the installed commit must be tagged with a tag signed by one of them.
+	TagKeyring string
+
+	// Backend used to download the package, one of the GitBackend constants.
+	// Empty uses the default.
+	Backend string
+
+	// ProtocolVersion forces the git protocol version ("0", "1" or "2") used
+	// to talk to the remote. Empty lets git decide.
+	ProtocolVersion string
+
+	// PreReleases allows semver constraints to resolve to pre-release tags
+	// like v2.0.0-rc1. Only stable tags are considered otherwise.
+	PreReleases bool
+
+	// Timeout of downloading the package. Zero uses the global setting.
+	Timeout time.Duration
+
+	// Retries is how often failed downloads are retried, both as a whole and
+	// each of their HTTP requests. Nil uses the global settings.
+	Retries *int
+
+	// Object pins the package to a git tree or blob object ID instead of a
+	// version. A tree is vendored as the package, a blob as a single file
+	// named after the last element of Subdir. Only supported by the exec
+	// backend.
+	Object string
+
+	// Include lists the files to vendor from Subdir, as slash separated
+	// globs. A pattern matching a directory includes all of its contents.
+	// Empty vendors everything.
+	Include []string
+
+	// RootFiles lists files or directories of the repository, relative to
+	// its root, that are vendored along with Subdir at the same position
+	// relative to it, so imports reaching up to shared files at the root
+	// resolve. They are part of the checksum of the package.
+	RootFiles []string
 }
 
 // json representation of Git (for compatiblity with old format)
 type jsonGit struct {
-	Remote string `json:"remote"`
-	Subdir string `json:"subdir"`
+	Remote          string   `json:"remote"`
+	Subdir          string   `json:"subdir"`
+	Subdirs         []string `json:"subdirs,omitempty"`
+	RootJsonnetfile bool     `json:"rootJsonnetfile,omitempty"`
+	TagKeyring      string   `json:"tagKeyring,omitempty"`
+	Backend         string   `json:"backend,omitempty"`
+	PreReleases     bool     `json:"preReleases,omitempty"`
+	ProtocolVersion string   `json:"protocolVersion,omitempty"`
+	Include         []string `json:"include,omitempty"`
+	RootFiles       []string `json:"rootFiles,omitempty"`
+	Timeout         string   `json:"timeout,omitempty"`
+	Retries         *int     `json:"retries,omitempty"`
+	Object          string   `json:"object,omitempty"`
 }
 
 // MarshalJSON takes care of translating between Git and jsonGit
@@ -52,6 +140,22 @@ func (gs *Git) MarshalJSON() ([]byte, error) {
 	j := jsonGit{
 		Remote: gs.Remote(),
 		Subdir: strings.TrimPrefix(gs.Subdir, "/"),
+
+		RootJsonnetfile: gs.RootJsonnetfile,
+		TagKeyring:      gs.TagKeyring,
+		Backend:         gs.Backend,
+		PreReleases:     gs.PreReleases,
+		ProtocolVersion: gs.ProtocolVersion,
+		Include:         gs.Include,
+		RootFiles:       gs.RootFiles,
+		Retries:         gs.Retries,
+		Object:          gs.Object,
+	}
+	for _, s := range gs.Subdirs {
+		j.Subdirs = append(j.Subdirs, strings.TrimPrefix(s, "/"))
+	}
+	if gs.Timeout != 0 {
+		j.Timeout = gs.Timeout.String()
 	}
 	return json.Marshal(j)
 }
@@ -66,6 +170,13 @@ func (gs *Git) UnmarshalJSON(data []byte) error {
 	if j.Subdir != "" {
 		gs.Subdir = "/" + strings.TrimPrefix(j.Subdir, "/")
 	}
+	gs.Subdirs = nil
+	for _, s := range j.Subdirs {
+		if s = strings.Trim(s, "/"); s != "" {
+			s = "/" + s
+		}
+		gs.Subdirs = append(gs.Subdirs, s)
+	}
 
 	tmp := parseGit(j.Remote)
 	if tmp == nil {
@@ -75,6 +186,22 @@ func (gs *Git) UnmarshalJSON(data []byte) error {
 	gs.User = tmp.Source.GitSource.User
 	gs.Repo = tmp.Source.GitSource.Repo
 	gs.Scheme = tmp.Source.GitSource.Scheme
+	gs.RootJsonnetfile = j.RootJsonnetfile
+	gs.TagKeyring = j.TagKeyring
+	gs.Backend = j.Backend
+	gs.PreReleases = j.PreReleases
+	gs.ProtocolVersion = j.ProtocolVersion
+	gs.Include = j.Include
+	gs.RootFiles = j.RootFiles
+	gs.Retries = j.Retries
+	gs.Object = j.Object
+	if j.Timeout != "" {
+		timeout, err := time.ParseDuration(j.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout of git source `%s`: %w", j.Remote, err)
+		}
+		gs.Timeout = timeout
+	}
 	return nil
 }
 
@@ -14,7 +14,9 @@
 package deps
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -252,3 +254,39 @@ func TestParseGit(t *testing.T) {
 		})
 	}
 }
+
+func TestGitTimeoutRetriesJSON(t *testing.T) {
+	var g Git
+	require.NoError(t, json.Unmarshal([]byte(`{"remote": "https://github.com/user/repo.git", "timeout": "1m30s", "retries": 0}`), &g))
+	assert.Equal(t, 90*time.Second, g.Timeout)
+	require.NotNil(t, g.Retries)
+	assert.Equal(t, 0, *g.Retries)
+
+	b, err := json.Marshal(&g)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"remote": "https://github.com/user/repo.git", "subdir": "", "timeout": "1m30s", "retries": 0}`, string(b))
+
+	err = json.Unmarshal([]byte(`{"remote": "https://github.com/user/repo.git", "timeout": "soon"}`), &g)
+	assert.ErrorContains(t, err, "invalid timeout")
+}
+
+func TestGitObjectJSON(t *testing.T) {
+	var g Git
+	require.NoError(t, json.Unmarshal([]byte(`{"remote": "https://github.com/user/repo.git", "object": "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}`), &g))
+	assert.Equal(t, "4b825dc642cb6eb9a060e54bf8d69288fbee4904", g.Object)
+
+	b, err := json.Marshal(&g)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"remote": "https://github.com/user/repo.git", "subdir": "", "object": "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}`, string(b))
+}
+
+func TestGitSubdirsJSON(t *testing.T) {
+	var g Git
+	require.NoError(t, json.Unmarshal([]byte(`{"remote": "https://github.com/user/repo.git", "subdirs": ["lib/", "/jsonnet", ""]}`), &g))
+	assert.Equal(t, []string{"/lib", "/jsonnet", ""}, g.Subdirs)
+	assert.Equal(t, "github.com/user/repo", g.Name())
+
+	b, err := json.Marshal(&g)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"remote": "https://github.com/user/repo.git", "subdir": "", "subdirs": ["lib", "jsonnet", ""]}`, string(b))
+}
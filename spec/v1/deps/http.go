@@ -0,0 +1,87 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deps
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ArchiveExtensions are the file extensions of the archives an HTTP source
+// may point to
+var ArchiveExtensions = []string{".tar.gz", ".tgz", ".zip"}
+
+// HTTP is an archive of the package downloaded from a plain URL, like a
+// tarball on an artifact server. The digest of the archive is the version
+// of the dependency.
+type HTTP struct {
+	// URL of the archive, a gzipped tarball or a zip file
+	URL string `json:"url"`
+	// Digest is the expected sha256 digest of the archive ("sha256:<hex>").
+	// Optional, the digest is locked either way.
+	Digest string `json:"digest,omitempty"`
+	// Path overrides the name of the package, which defaults to the host and
+	// path of the URL without the extension. Useful if the URL contains the
+	// version of the archive. It must be a clean relative path, as the
+	// package is installed there below vendor.
+	Path string `json:"path,omitempty"`
+}
+
+// Name returns Path, or the URL in a go-like format (example.com/pkgs/foo)
+func (h *HTTP) Name() string {
+	if h.Path != "" {
+		return h.Path
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return ""
+	}
+	return path.Join(u.Hostname(), TrimArchiveExtension(u.Path))
+}
+
+// LegacyName returns the last element of the name
+func (h *HTTP) LegacyName() string {
+	return path.Base(h.Name())
+}
+
+// parseHTTP parses URLs of archives, like https://example.com/foo.tar.gz
+func parseHTTP(uri string) *Dependency {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || ArchiveExtension(u.Path) == "" {
+		return nil
+	}
+	return &Dependency{
+		Source: Source{
+			HTTPSource: &HTTP{URL: uri},
+		},
+	}
+}
+
+// ArchiveExtension returns the extension of the archive file p, or "" if it
+// is not a supported one
+func ArchiveExtension(p string) string {
+	for _, ext := range ArchiveExtensions {
+		if strings.HasSuffix(p, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// TrimArchiveExtension returns p without its archive extension
+func TrimArchiveExtension(p string) string {
+	return strings.TrimSuffix(p, ArchiveExtension(p))
+}
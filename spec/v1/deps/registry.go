@@ -0,0 +1,31 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deps
+
+import (
+	"path"
+)
+
+// Registry refers to a package by its short name in a registry index, which
+// resolves it to a concrete source before anything is installed. The lock
+// records the concrete source.
+type Registry struct {
+	Name string `json:"name"`
+}
+
+// LegacyName returns the last element of the short name
+func (r *Registry) LegacyName() string {
+	return path.Base(r.Name)
+}
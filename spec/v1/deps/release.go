@@ -0,0 +1,44 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deps
+
+import (
+	"path"
+)
+
+// ReleaseHost is the host of the repositories of release sources
+const ReleaseHost = "github.com"
+
+// Release is an asset attached to a GitHub release, usually a prebuilt
+// bundle. The tag of the release is the version of the dependency.
+type Release struct {
+	// Repo the release belongs to (<user>/<repo>)
+	Repo string `json:"repo"`
+	// Asset is the file name of the asset, a gzipped tarball
+	Asset string `json:"asset"`
+	// Digest is the sha256 digest of the asset ("sha256:<hex>"). Recorded in
+	// the lock, a different asset uploaded under the same name fails.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Name returns the repository in a go-like format (github.com/user/repo)
+func (r *Release) Name() string {
+	return path.Join(ReleaseHost, r.Repo)
+}
+
+// LegacyName returns the name of the repository
+func (r *Release) LegacyName() string {
+	return path.Base(r.Repo)
+}
@@ -31,6 +31,11 @@ type JsonnetFile struct {
 
 	// Symlink files to old location
 	LegacyImports bool
+
+	// Resolver is the version of the resolution behavior to use, so a
+	// jsonnetfile keeps resolving the same way under newer releases of jb.
+	// Zero means the original behavior.
+	Resolver uint
 }
 
 // New returns a new JsonnetFile with the dependencies map initialized
@@ -41,12 +46,23 @@ func New() JsonnetFile {
 	}
 }
 
+// FromDependencies returns a new JsonnetFile of the given dependencies, keyed
+// by their names, for jsonnetfiles built in memory instead of loaded from disk
+func FromDependencies(list ...deps.Dependency) JsonnetFile {
+	jf := New()
+	for _, d := range list {
+		jf.Dependencies.Set(d.Name(), d)
+	}
+	return jf
+}
+
 // jsonFile is the json representation of a JsonnetFile, which is different for
 // compatibility reasons.
 type jsonFile struct {
 	Version       uint              `json:"version"`
 	Dependencies  []deps.Dependency `json:"dependencies"`
 	LegacyImports bool              `json:"legacyImports"`
+	Resolver      uint              `json:"resolver,omitempty"`
 }
 
 // UnmarshalJSON unmarshals a `jsonFile`'s json into a JsonnetFile
@@ -64,6 +80,7 @@ func (jf *JsonnetFile) UnmarshalJSON(data []byte) error {
 	}
 
 	jf.LegacyImports = s.LegacyImports
+	jf.Resolver = s.Resolver
 
 	return nil
 }
@@ -74,6 +91,7 @@ func (jf JsonnetFile) MarshalJSON() ([]byte, error) {
 
 	s.Version = Version
 	s.LegacyImports = jf.LegacyImports
+	s.Resolver = jf.Resolver
 
 	for _, k := range jf.Dependencies.Keys() {
 		d, _ := jf.Dependencies.Get(k)
@@ -116,3 +116,93 @@ func TestRemarshal(t *testing.T) {
 
 	assert.Equal(t, jf, dst)
 }
+
+// TestResolver checks that the resolver version is only written if declared
+func TestResolver(t *testing.T) {
+	jf := New()
+	data, err := json.Marshal(jf)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "resolver")
+
+	jf.Resolver = 2
+	data, err = json.Marshal(jf)
+	require.NoError(t, err)
+
+	var dst JsonnetFile
+	require.NoError(t, json.Unmarshal(data, &dst))
+	assert.Equal(t, uint(2), dst.Resolver)
+}
+
+func TestFromDependencies(t *testing.T) {
+	a := deps.Dependency{Source: deps.Source{GitSource: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "a", Repo: "b"}}, Version: "master"}
+	b := deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "lib/c"}}}
+
+	jf := FromDependencies(a, b)
+	assert.Equal(t, []string{"github.com/a/b", "c"}, jf.Dependencies.Keys())
+	got, _ := jf.Dependencies.Get("github.com/a/b")
+	assert.Equal(t, a, got)
+	assert.True(t, jf.LegacyImports)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, testData().Validate())
+
+	jf := New()
+	git := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "a", Repo: "b", Backend: "svn", ProtocolVersion: "3"}
+	jf.Dependencies.Set("git", deps.Dependency{Source: deps.Source{GitSource: git}, Profiles: []string{"dev", " "}})
+	jf.Dependencies.Set("both", deps.Dependency{Source: deps.Source{GitSource: git, LocalSource: &deps.Local{Directory: "b"}}})
+	jf.Dependencies.Set("", deps.Dependency{})
+	object := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "a", Repo: "c", Subdir: "/x", Subdirs: []string{"/lib"}, Backend: deps.GitBackendHTTP, Object: "HEAD"}
+	jf.Dependencies.Set("object", deps.Dependency{Source: deps.Source{GitSource: object}})
+	jf.Dependencies.Set("local", deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{}}, TrustedSum: "sum", Fallbacks: []string{"v1"}})
+	jf.Dependencies.Set("release", deps.Dependency{Source: deps.Source{ReleaseSource: &deps.Release{Repo: "a"}}})
+	jf.Dependencies.Set("registry", deps.Dependency{Source: deps.Source{RegistrySource: &deps.Registry{}}})
+	jf.Dependencies.Set("mixed", deps.Dependency{Source: deps.Source{GitSource: git, ReleaseSource: &deps.Release{Repo: "a/b", Asset: "x.tar.gz"}}, Version: "v1"})
+	rootFiles := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "a", Repo: "d", Subdir: "/lib/x", RootFiles: []string{"config.libsonnet", "../up", "lib"}}
+	jf.Dependencies.Set("rootFiles", deps.Dependency{Source: deps.Source{GitSource: rootFiles}})
+	jf.Dependencies.Set("http", deps.Dependency{Source: deps.Source{HTTPSource: &deps.HTTP{URL: "ftp://example.com/a.tar.gz", Digest: "abc"}}, Version: "v1"})
+	jf.Dependencies.Set("httpFile", deps.Dependency{Source: deps.Source{HTTPSource: &deps.HTTP{URL: "https://example.com/a.rar"}}})
+	for _, p := range []string{"/abs", "a/../../b", "../b", "a//b", "."} {
+		jf.Dependencies.Set("httpPath "+p, deps.Dependency{Source: deps.Source{HTTPSource: &deps.HTTP{URL: "https://example.com/a.tar.gz", Path: p}}})
+	}
+	jf.Dependencies.Set("httpPathOk", deps.Dependency{Source: deps.Source{HTTPSource: &deps.HTTP{URL: "https://example.com/a.tar.gz", Path: "example.com/pkgs/a"}}})
+	jf.Dependencies.Set("httpBackendSSH", deps.Dependency{Source: deps.Source{GitSource: &deps.Git{Scheme: deps.GitSchemeSSH, Host: "github.com", User: "a", Repo: "e", Backend: deps.GitBackendHTTP}}})
+	jf.Dependencies.Set("httpBackendHost", deps.Dependency{Source: deps.Source{GitSource: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "gitea.example.com", User: "a", Repo: "f", Backend: deps.GitBackendHTTP}}})
+	jf.Dependencies.Set("httpBackendGitLab", deps.Dependency{Source: deps.Source{GitSource: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "gitlab.com", User: "a/b", Repo: "g", Backend: deps.GitBackendHTTP}}})
+
+	err := jf.Validate()
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []string{
+		"dependency git: empty profile name",
+		"dependency git: unknown git backend 'svn'",
+		"dependency git: unknown git protocol version '3'",
+		"dependency both: both a git and a local source set",
+		"dependency #3: no source set",
+		"dependency object: both subdir and subdirs set",
+		"dependency object: subdirs can't be used with a git object",
+		"dependency object: invalid git object 'HEAD'",
+		"dependency object: git objects require the exec backend",
+		"dependency local: local source without directory",
+		"dependency local: trustedSum can't be used with a local source",
+		"dependency local: fallbacks can't be used with a local source",
+		"dependency release: invalid release repository 'a', expected <user>/<repo>",
+		"dependency release: release source without asset",
+		"dependency release: release source without version, it must be the release tag",
+		"dependency registry: registry source without name",
+		"dependency mixed: more than one source set",
+		"dependency rootFiles: invalid root file '../up', it must be a clean path relative to the repository root",
+		"dependency rootFiles: root file 'lib' overlaps subdir 'lib/x'",
+		"dependency http: invalid http source url 'ftp://example.com/a.tar.gz'",
+		"dependency http: invalid http source digest 'abc', expected sha256:<hex>",
+		"dependency http: invalid http source version 'v1', it must be the digest of the archive",
+		"dependency httpFile: http source url 'https://example.com/a.rar' is no archive, expected one of .tar.gz, .tgz, .zip",
+		"dependency httpPath /abs: invalid http source path '/abs', it must be a clean relative path",
+		"dependency httpPath a/../../b: invalid http source path 'a/../../b', it must be a clean relative path",
+		"dependency httpPath ../b: invalid http source path '../b', it must be a clean relative path",
+		"dependency httpPath a//b: invalid http source path 'a//b', it must be a clean relative path",
+		"dependency httpPath .: invalid http source path '.', it must be a clean relative path",
+		"dependency httpBackendSSH: the http backend requires an https remote",
+		"dependency httpBackendHost: the http backend doesn't support 'gitea.example.com', only github.com and gitlab.com",
+	}, verr.Problems)
+}
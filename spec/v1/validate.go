@@ -0,0 +1,209 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/v1/deps"
+)
+
+// objectPattern matches full SHA-1 and SHA-256 object IDs
+var objectPattern = regexp.MustCompile("^([0-9a-f]{40}|[0-9a-f]{64})$")
+
+// digestPattern matches sha256 digests of archives
+var digestPattern = regexp.MustCompile("^sha256:[0-9a-f]{64}$")
+
+// ValidationError lists everything wrong with a JsonnetFile
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid jsonnetfile:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the JsonnetFile for mistakes that would otherwise only
+// surface during resolution, like dependencies without or with multiple
+// sources. All problems are reported at once as a ValidationError.
+func (jf JsonnetFile) Validate() error {
+	problems := []string{}
+	for i, k := range jf.Dependencies.Keys() {
+		d, _ := jf.Dependencies.Get(k)
+		name := k
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		for _, p := range validateDependency(d) {
+			problems = append(problems, fmt.Sprintf("dependency %s: %s", name, p))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func validateDependency(d deps.Dependency) []string {
+	problems := []string{}
+	for _, p := range d.Profiles {
+		if strings.TrimSpace(p) == "" {
+			problems = append(problems, "empty profile name")
+			break
+		}
+	}
+
+	git, local, release, archive, registry := d.Source.GitSource, d.Source.LocalSource, d.Source.ReleaseSource, d.Source.HTTPSource, d.Source.RegistrySource
+	sources := 0
+	for _, set := range []bool{git != nil, local != nil, release != nil, archive != nil, registry != nil} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return append(problems, "no source set")
+	case git != nil && local != nil:
+		return append(problems, "both a git and a local source set")
+	case sources > 1:
+		return append(problems, "more than one source set")
+	case registry != nil:
+		if registry.Name == "" {
+			problems = append(problems, "registry source without name")
+		}
+		return problems
+	case release != nil:
+		if parts := strings.Split(release.Repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problems = append(problems, fmt.Sprintf("invalid release repository '%s', expected <user>/<repo>", release.Repo))
+		}
+		if release.Asset == "" {
+			problems = append(problems, "release source without asset")
+		}
+		if d.Version == "" {
+			problems = append(problems, "release source without version, it must be the release tag")
+		}
+		return problems
+	case archive != nil:
+		return append(problems, validateHTTP(archive, d.Version)...)
+	case local != nil:
+		if local.Directory == "" {
+			problems = append(problems, "local source without directory")
+		}
+		if d.TrustedSum != "" {
+			problems = append(problems, "trustedSum can't be used with a local source")
+		}
+		if len(d.Fallbacks) > 0 {
+			problems = append(problems, "fallbacks can't be used with a local source")
+		}
+		return problems
+	}
+
+	if git.Host == "" || git.User == "" || git.Repo == "" {
+		problems = append(problems, "git source without remote")
+	}
+	switch git.Backend {
+	case "", deps.GitBackendExec:
+	case deps.GitBackendHTTP:
+		if git.Scheme != deps.GitSchemeHTTPS {
+			problems = append(problems, "the http backend requires an https remote")
+		} else if !git.HTTPBackendSupported() {
+			problems = append(problems, fmt.Sprintf("the http backend doesn't support '%s', only %s", git.Host, strings.Join(deps.HTTPBackendHosts, " and ")))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown git backend '%s'", git.Backend))
+	}
+	if git.Timeout < 0 {
+		problems = append(problems, "negative timeout")
+	}
+	if git.Retries != nil && *git.Retries < 0 {
+		problems = append(problems, "negative retries")
+	}
+	if git.Subdir != "" && len(git.Subdirs) > 0 {
+		problems = append(problems, "both subdir and subdirs set")
+	}
+	if git.Object != "" {
+		if len(git.Subdirs) > 0 {
+			problems = append(problems, "subdirs can't be used with a git object")
+		}
+		if !objectPattern.MatchString(git.Object) {
+			problems = append(problems, fmt.Sprintf("invalid git object '%s'", git.Object))
+		}
+		if git.Backend == deps.GitBackendHTTP {
+			problems = append(problems, "git objects require the exec backend")
+		}
+	}
+	if len(git.RootFiles) > 0 {
+		problems = append(problems, validateRootFiles(git)...)
+	}
+	switch git.ProtocolVersion {
+	case "", "0", "1", "2":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown git protocol version '%s'", git.ProtocolVersion))
+	}
+	return problems
+}
+
+// validateRootFiles checks that all RootFiles are clean paths inside the
+// repository and beside Subdir
+func validateRootFiles(git *deps.Git) []string {
+	if git.Subdir == "" {
+		return []string{"rootFiles require a subdir"}
+	}
+	if git.Object != "" {
+		return []string{"rootFiles can't be used with a git object"}
+	}
+
+	problems := []string{}
+	subdir := strings.Trim(git.Subdir, "/")
+	for _, f := range git.RootFiles {
+		switch {
+		case f == "" || path.IsAbs(f) || path.Clean(f) != f || f == ".." || strings.HasPrefix(f, "../"):
+			problems = append(problems, fmt.Sprintf("invalid root file '%s', it must be a clean path relative to the repository root", f))
+		case strings.HasPrefix(subdir+"/", f+"/") || strings.HasPrefix(f+"/", subdir+"/"):
+			problems = append(problems, fmt.Sprintf("root file '%s' overlaps subdir '%s'", f, subdir))
+		}
+	}
+	return problems
+}
+
+// validateHTTP checks an HTTP source. Its version is the digest of the
+// archive, if set.
+func validateHTTP(h *deps.HTTP, version string) []string {
+	problems := []string{}
+	u, err := url.Parse(h.URL)
+	switch {
+	case h.URL == "":
+		problems = append(problems, "http source without url")
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		problems = append(problems, fmt.Sprintf("invalid http source url '%s'", h.URL))
+	case deps.ArchiveExtension(u.Path) == "":
+		problems = append(problems, fmt.Sprintf("http source url '%s' is no archive, expected one of %s", h.URL, strings.Join(deps.ArchiveExtensions, ", ")))
+	}
+	if h.Digest != "" && !digestPattern.MatchString(h.Digest) {
+		problems = append(problems, fmt.Sprintf("invalid http source digest '%s', expected sha256:<hex>", h.Digest))
+	}
+	if version != "" && !digestPattern.MatchString(version) {
+		problems = append(problems, fmt.Sprintf("invalid http source version '%s', it must be the digest of the archive", version))
+	}
+	if p := h.Path; p != "" && (p == "." || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../")) {
+		problems = append(problems, fmt.Sprintf("invalid http source path '%s', it must be a clean relative path", p))
+	}
+	return problems
+}